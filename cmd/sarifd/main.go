@@ -15,6 +15,9 @@ import (
 	"github.com/sarifsystems/sarif/core/apphost"
 	"github.com/sarifsystems/sarif/services/auth"
 	"github.com/sarifsystems/sarif/services/commands"
+	"github.com/sarifsystems/sarif/services/content"
+	"github.com/sarifsystems/sarif/services/devices"
+	"github.com/sarifsystems/sarif/services/email"
 	"github.com/sarifsystems/sarif/services/events"
 	"github.com/sarifsystems/sarif/services/hostscan"
 	"github.com/sarifsystems/sarif/services/js"
@@ -27,6 +30,7 @@ import (
 	"github.com/sarifsystems/sarif/services/nlparser"
 	"github.com/sarifsystems/sarif/services/nlquery"
 	"github.com/sarifsystems/sarif/services/pushgateway"
+	"github.com/sarifsystems/sarif/services/reasoner"
 	"github.com/sarifsystems/sarif/services/scheduler"
 	"github.com/sarifsystems/sarif/services/scrobbler"
 	"github.com/sarifsystems/sarif/services/spotify"
@@ -44,6 +48,9 @@ func main() {
 
 	srv.RegisterModule(auth.Module)
 	srv.RegisterModule(commands.Module)
+	srv.RegisterModule(content.Module)
+	srv.RegisterModule(devices.Module)
+	srv.RegisterModule(email.Module)
 	srv.RegisterModule(events.Module)
 	srv.RegisterModule(hostscan.Module)
 	srv.RegisterModule(know.Module)
@@ -56,6 +63,7 @@ func main() {
 	srv.RegisterModule(nlparser.Module)
 	srv.RegisterModule(nlquery.Module)
 	srv.RegisterModule(pushgateway.Module)
+	srv.RegisterModule(reasoner.Module)
 	srv.RegisterModule(scheduler.Module)
 	srv.RegisterModule(scrobbler.Module)
 	srv.RegisterModule(spotify.Module)
@@ -71,6 +79,7 @@ func main() {
 	srv.HostConfig.EnabledModules = []string{
 		"auth",
 		"commands",
+		"content",
 		"events",
 		"know",
 		"location",
@@ -80,6 +89,7 @@ func main() {
 		"natural",
 		"nlparser",
 		"nlquery",
+		"reasoner",
 		"scheduler",
 		"vdir",
 		"web",
@@ -0,0 +1,102 @@
+// Copyright (C) 2019 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/sarifsystems/sarif/sarif"
+	"github.com/sarifsystems/sarif/services/auth"
+	"github.com/sarifsystems/sarif/transports/sfproto"
+)
+
+const usageEnroll = `Usage: tars [OPTION]... enroll OTP [DEVICE]
+Request a client certificate from the certificate authority of sarifd.
+
+Creates a new key and sends a certificate request for DEVICE (default:
+the hostname) together with a one-time password. The signed certificate
+and key are stored next to the config and used for the "dial" connection
+from now on. The authority that verifies the server is kept as is.
+
+The one-time password is created with auth/new/otp on a trusted device.
+It is also used to authenticate the connection, so it is best limited to
+the "auth/enroll" scope.
+To enroll a device again, the password has to be issued for it with
+"device" set to its name. Device names may only contain letters, digits,
+"-", "_" and ".".
+
+    Example: Enroll this machine as "laptop"
+        tars enroll otp/std:123456 laptop
+`
+
+const (
+	enrollCertFile = "device.crt"
+	enrollKeyFile  = "device.key"
+)
+
+func (app *App) Enroll() {
+	if flag.NArg() <= 1 {
+		app.Log.Fatal("Please specify a one-time password.")
+	}
+	otp := flag.Arg(1)
+	device := flag.Arg(2)
+	if device == "" {
+		var err error
+		device, err = os.Hostname()
+		app.Must(err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	app.Must(err)
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: device},
+	}, key)
+	app.Must(err)
+
+	client, err := app.ClientDial(sarif.ClientInfo{
+		Name: device + "/tars/" + sarif.GenerateId(),
+		Auth: otp,
+	})
+	app.Must(err)
+
+	msg := sarif.CreateMessage("auth/enroll", auth.EnrollPayload{
+		Otp: otp,
+		Csr: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr})),
+	})
+	reply, ok := <-client.Request(msg)
+	if !ok {
+		app.Log.Fatal("No reply received.")
+	}
+	if !reply.IsAction("auth/enrolled") {
+		app.Log.Fatal("Enrollment failed: ", reply.Text)
+	}
+	var enrolled auth.EnrolledPayload
+	app.Must(reply.DecodePayload(&enrolled))
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	app.Must(err)
+	dir := app.App.Config.Dir()
+	cfg := sfproto.NetConfig{}
+	app.App.Config.Get("dial", &cfg)
+	cfg.Certificate = filepath.Join(dir, enrollCertFile)
+	cfg.Key = filepath.Join(dir, enrollKeyFile)
+	app.Must(ioutil.WriteFile(cfg.Key, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	app.Must(ioutil.WriteFile(cfg.Certificate, []byte(enrolled.Certificate), 0644))
+	app.Must(app.App.Config.Set("dial", cfg))
+
+	fmt.Println(enrolled.Text())
+	fmt.Println("Certificate stored in", cfg.Certificate)
+}
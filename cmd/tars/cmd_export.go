@@ -0,0 +1,522 @@
+// Copyright (C) 2019 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sarifsystems/sarif/services/schema/store"
+)
+
+var (
+	exportOutput   = flag.String("o", "", "export: write to file instead of stdout")
+	exportFormat   = flag.String("format", "", "export/import: jsonl or tar (default: guessed from file name)")
+	importConflict = flag.String("conflict", "skip", "import: policy for existing documents (skip, overwrite, newer)")
+	exportResume   = flag.Bool("resume", false, "export/import: continue an interrupted run")
+)
+
+const usageExport = `Usage: tars [OPTION]... export [COLLECTION]...
+Stream all documents of the store into a backup.
+
+Without arguments, all collections are exported. The backup is written
+as JSONL with one {"collection", "key", "value"} object per line, or as a
+tarball (-format tar, or an -o file ending in .tar, .tar.gz or .tgz)
+containing a manifest.json and one JSONL file per collection.
+
+With -resume, an interrupted JSONL export to a file is continued after
+the last document it contains.
+
+    Example: Back up everything
+        tars -o backup.tar.gz export
+
+    Example: Export the events collection as JSONL
+        tars export events > events.jsonl
+`
+
+const usageImport = `Usage: tars [OPTION]... import [FILE]
+Replay a backup created by "tars export" into the store.
+
+Reads JSONL from stdin if no file is given. The -conflict option decides
+what happens to documents that already exist:
+
+    skip        keep the existing document (default)
+    overwrite   always replace it
+    newer       replace it if the imported document has a newer
+                updated_at, time or timestamp field
+
+With -resume, records imported by a previous run are skipped. Progress is
+tracked in FILE.progress and removed after a successful import.
+
+    Example: Restore a backup, replacing outdated documents
+        tars -conflict newer import backup.tar.gz
+`
+
+const (
+	backupJSONL = "jsonl"
+	backupTar   = "tar"
+
+	exportPageSize = 500
+	importBatch    = 100
+)
+
+type backupRecord struct {
+	Collection string          `json:"collection"`
+	Key        string          `json:"key"`
+	Value      json.RawMessage `json:"value"`
+}
+
+type backupManifest struct {
+	Version     int            `json:"version"`
+	Created     time.Time      `json:"created"`
+	Collections map[string]int `json:"collections"`
+}
+
+func backupFormat(name string) string {
+	if *exportFormat != "" {
+		return *exportFormat
+	}
+	name = strings.ToLower(name)
+	if strings.HasSuffix(name, ".tar") || strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".tgz") {
+		return backupTar
+	}
+	return backupJSONL
+}
+
+func isGzipped(name string) bool {
+	name = strings.ToLower(name)
+	return strings.HasSuffix(name, ".gz") || strings.HasSuffix(name, ".tgz")
+}
+
+// progress prints a single updating status line to stderr.
+type progress struct {
+	label string
+	n     int
+	last  time.Time
+}
+
+func (p *progress) Add(n int) {
+	p.n += n
+	if time.Since(p.last) > 200*time.Millisecond {
+		p.last = time.Now()
+		fmt.Fprintf(os.Stderr, "\r%s: %d", p.label, p.n)
+	}
+}
+
+func (p *progress) Done() {
+	fmt.Fprintf(os.Stderr, "\r%s: %d\n", p.label, p.n)
+}
+
+func (app *App) Export() {
+	st := store.New(app.NewClient())
+
+	cols := flag.Args()[1:]
+	if len(cols) == 0 {
+		var err error
+		if cols, err = st.Collections(); err != nil {
+			app.Log.Fatal("Could not list collections, please name them as arguments: ", err)
+		}
+	}
+	sort.Strings(cols)
+
+	format := backupFormat(*exportOutput)
+	if format != backupJSONL && format != backupTar {
+		app.Log.Fatal("Unknown format: ", format)
+	}
+	if *exportResume && (format != backupJSONL || *exportOutput == "") {
+		app.Log.Fatal("Resuming is only supported for JSONL exports to a file.")
+	}
+
+	var after *backupRecord
+	var out io.Writer = os.Stdout
+	if *exportOutput != "" {
+		mode := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		if *exportResume {
+			var err error
+			if after, err = lastRecord(*exportOutput); err != nil {
+				app.Log.Fatal(err)
+			}
+			mode = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		}
+		f, err := os.OpenFile(*exportOutput, mode, 0600)
+		app.Must(err)
+		defer f.Close()
+		out = f
+	}
+
+	if format == backupTar {
+		app.Must(app.exportTar(st, cols, out, isGzipped(*exportOutput)))
+		return
+	}
+
+	w := bufio.NewWriter(out)
+	for _, col := range cols {
+		if after != nil && col < after.Collection {
+			continue
+		}
+		scan := store.Scan{Limit: exportPageSize}
+		if after != nil && col == after.Collection {
+			scan.Start = after.Key
+		}
+		_, err := exportCollection(st, col, scan, w, func(key string) bool {
+			return after != nil && col == after.Collection && key == after.Key
+		})
+		app.Must(err)
+	}
+	app.Must(w.Flush())
+}
+
+// exportCollection writes all documents of a collection as JSONL records.
+func exportCollection(st *store.Store, col string, scan store.Scan, w io.Writer, skip func(key string) bool) (int, error) {
+	p := &progress{label: col}
+	enc := json.NewEncoder(w)
+	err := st.ScanAll(col, scan, func(key string, value json.RawMessage) error {
+		if skip != nil && skip(key) {
+			return nil
+		}
+		p.Add(1)
+		return enc.Encode(backupRecord{col, key, value})
+	})
+	p.Done()
+	return p.n, err
+}
+
+// lastRecord reads the last complete record of an existing JSONL export.
+// A partially written line at the end is left over from an interrupted
+// export and truncated, so that the export continues right after the last
+// complete record.
+func lastRecord(path string) (*backupRecord, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var last *backupRecord
+	var offset int64
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				return last, f.Truncate(offset)
+			}
+			return last, nil
+		} else if err != nil {
+			return nil, err
+		}
+		offset += int64(len(line))
+
+		var rec backupRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return nil, errors.New("cannot resume, export is corrupted: " + err.Error())
+		}
+		last = &rec
+	}
+}
+
+// exportTar spools each collection to a temporary file, since tar headers
+// need the size up front, and assembles them with a manifest.
+func (app *App) exportTar(st *store.Store, cols []string, out io.Writer, gz bool) error {
+	if gz {
+		zw := gzip.NewWriter(out)
+		defer zw.Close()
+		out = zw
+	}
+	tw := tar.NewWriter(out)
+	defer tw.Close()
+
+	man := backupManifest{
+		Version:     1,
+		Created:     time.Now(),
+		Collections: make(map[string]int),
+	}
+	files := make(map[string]*os.File)
+	defer func() {
+		for _, f := range files {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+	for _, col := range cols {
+		f, err := ioutil.TempFile("", "tars-export-")
+		if err != nil {
+			return err
+		}
+		files[col] = f
+		w := bufio.NewWriter(f)
+		n, err := exportCollection(st, col, store.Scan{Limit: exportPageSize}, w, nil)
+		if err != nil {
+			return err
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		man.Collections[col] = n
+	}
+
+	raw, err := json.MarshalIndent(man, "", "    ")
+	if err != nil {
+		return err
+	}
+	if err := writeTarFile(tw, "manifest.json", int64(len(raw)), bytes.NewReader(raw)); err != nil {
+		return err
+	}
+	for _, col := range cols {
+		f := files[col]
+		size, err := f.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if err := writeTarFile(tw, col+".jsonl", size, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeTarFile(tw *tar.Writer, name string, size int64, r io.Reader) error {
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    0600,
+		Size:    size,
+		ModTime: time.Now(),
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := io.CopyN(tw, r, size)
+	return err
+}
+
+// backupReader returns all records of a JSONL or tar backup in order.
+type backupReader struct {
+	tr    *tar.Reader
+	lines *bufio.Scanner
+}
+
+func newBackupReader(r io.Reader, format string) *backupReader {
+	br := &backupReader{}
+	if format == backupTar {
+		br.tr = tar.NewReader(r)
+	} else {
+		br.setLines(r)
+	}
+	return br
+}
+
+func (br *backupReader) setLines(r io.Reader) {
+	br.lines = bufio.NewScanner(r)
+	br.lines.Buffer(make([]byte, 64*1024), 64*1024*1024)
+}
+
+func (br *backupReader) Next() (*backupRecord, error) {
+	for {
+		if br.lines != nil {
+			for br.lines.Scan() {
+				line := bytes.TrimSpace(br.lines.Bytes())
+				if len(line) == 0 {
+					continue
+				}
+				var rec backupRecord
+				if err := json.Unmarshal(line, &rec); err != nil {
+					return nil, err
+				}
+				if rec.Collection == "" || rec.Key == "" {
+					return nil, errors.New("invalid record: missing collection or key")
+				}
+				return &rec, nil
+			}
+			if err := br.lines.Err(); err != nil {
+				return nil, err
+			}
+			br.lines = nil
+		}
+		if br.tr == nil {
+			return nil, io.EOF
+		}
+
+		hdr, err := br.tr.Next()
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag == tar.TypeReg && strings.HasSuffix(hdr.Name, ".jsonl") {
+			br.setLines(br.tr)
+		}
+	}
+}
+
+type importState struct {
+	Done int `json:"done"`
+}
+
+func (app *App) Import() {
+	st := store.New(app.NewClient())
+
+	switch *importConflict {
+	case "skip", "overwrite", "newer":
+	default:
+		app.Log.Fatal("Unknown conflict policy: ", *importConflict)
+	}
+
+	var in io.Reader = os.Stdin
+	name, stateFile := "", ""
+	if flag.NArg() > 1 {
+		name = flag.Arg(1)
+		stateFile = name + ".progress"
+		f, err := os.Open(name)
+		app.Must(err)
+		defer f.Close()
+		in = f
+	} else if *exportResume {
+		app.Log.Fatal("Resuming is only supported when importing from a file.")
+	}
+	if isGzipped(name) {
+		zr, err := gzip.NewReader(in)
+		app.Must(err)
+		defer zr.Close()
+		in = zr
+	}
+
+	var state importState
+	if *exportResume {
+		if raw, err := ioutil.ReadFile(stateFile); err == nil {
+			app.Must(json.Unmarshal(raw, &state))
+		} else if !os.IsNotExist(err) {
+			app.Log.Fatal(err)
+		}
+	}
+	saveState := func() {
+		if stateFile == "" {
+			return
+		}
+		raw, _ := json.Marshal(state)
+		app.Must(ioutil.WriteFile(stateFile, raw, 0600))
+	}
+
+	r := newBackupReader(in, backupFormat(name))
+	p := &progress{label: "imported"}
+	skipped := 0
+	pos := 0
+	batch := make([]*backupRecord, 0, importBatch)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		n, err := importRecords(st, batch, *importConflict)
+		app.Must(err)
+		p.Add(n)
+		skipped += len(batch) - n
+		state.Done += len(batch)
+		saveState()
+		batch = batch[:0]
+	}
+
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		app.Must(err)
+		pos++
+		if pos <= state.Done {
+			continue
+		}
+		batch = append(batch, rec)
+		if len(batch) >= importBatch {
+			flush()
+		}
+	}
+	flush()
+	p.Done()
+	if skipped > 0 {
+		fmt.Fprintf(os.Stderr, "skipped: %d\n", skipped)
+	}
+	if stateFile != "" {
+		os.Remove(stateFile)
+	}
+}
+
+// importRecords writes a batch of records according to the conflict policy
+// and returns the number of written documents.
+func importRecords(st *store.Store, recs []*backupRecord, policy string) (int, error) {
+	var existing []*struct {
+		Value []byte `json:"value"`
+	}
+	if policy != "overwrite" {
+		cmds := make([]store.Command, len(recs))
+		for i, rec := range recs {
+			cmds[i] = store.Command{Type: "get", Key: rec.Collection + "/" + rec.Key}
+		}
+		if err := st.Batch(cmds, &existing); err != nil {
+			return 0, err
+		}
+	}
+
+	cmds := make([]store.Command, 0, len(recs))
+	for i, rec := range recs {
+		if i < len(existing) && existing[i] != nil {
+			if policy == "skip" || !isNewer(rec.Value, existing[i].Value) {
+				continue
+			}
+		}
+		cmds = append(cmds, store.Command{Type: "put", Key: rec.Collection + "/" + rec.Key, Value: rec.Value})
+	}
+	if len(cmds) == 0 {
+		return 0, nil
+	}
+	var results []interface{}
+	return len(cmds), st.Batch(cmds, &results)
+}
+
+var timestampFields = []string{"updated_at", "time", "timestamp"}
+
+func documentTime(raw []byte) (time.Time, bool) {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return time.Time{}, false
+	}
+	for _, field := range timestampFields {
+		if s, ok := doc[field].(string); ok {
+			if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+				return t, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// isNewer checks if the imported document should replace the existing one.
+// Existing documents without timestamp are replaced, imported documents
+// without timestamp never replace anything.
+func isNewer(imported, existing []byte) bool {
+	ti, ok := documentTime(imported)
+	if !ok {
+		return false
+	}
+	te, ok := documentTime(existing)
+	if !ok {
+		return true
+	}
+	return ti.After(te)
+}
@@ -0,0 +1,69 @@
+// Copyright (C) 2019 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLastRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tars-export-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "backup.jsonl")
+
+	if rec, err := lastRecord(path); rec != nil || err != nil {
+		t.Error("expected missing export to start from scratch:", rec, err)
+	}
+
+	complete := `{"collection":"events","key":"a","value":1}
+{"collection":"events","key":"b","value":{"x":2}}
+`
+	tests := []struct {
+		Data string
+		Key  string
+	}{
+		{"", ""},
+		{complete, "b"},
+		{complete + `{"collection":"events","key":"c","val`, "b"},
+		{complete + `{"collection":"events","key":"c","value":3}`, "b"},
+		{`{"collection":"ev`, ""},
+	}
+	for _, test := range tests {
+		if err := ioutil.WriteFile(path, []byte(test.Data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		rec, err := lastRecord(path)
+		if err != nil {
+			t.Errorf("lastRecord(%q): %v", test.Data, err)
+			continue
+		}
+		if (rec == nil && test.Key != "") || (rec != nil && rec.Key != test.Key) {
+			t.Errorf("lastRecord(%q) = %v, expected key %q", test.Data, rec, test.Key)
+		}
+
+		data, _ := ioutil.ReadFile(path)
+		expected := complete
+		if test.Key == "" {
+			expected = ""
+		}
+		if string(data) != expected {
+			t.Errorf("lastRecord(%q) left %q", test.Data, data)
+		}
+	}
+
+	if err := ioutil.WriteFile(path, []byte("garbage\n"+complete), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lastRecord(path); err == nil {
+		t.Error("expected corrupted export to fail")
+	}
+}
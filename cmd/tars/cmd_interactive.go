@@ -6,11 +6,16 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"flag"
 	"io"
 	"log"
+	"os"
+	"path"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/chzyer/readline"
@@ -23,41 +28,69 @@ import (
 var profile = flag.Bool("profile", false, "interactive: print elapsed time for requests")
 var printJson = flag.Bool("json", false, "interactive: print replies as json")
 
+const usageInteractive = `Meta commands in interactive mode:
+
+    .sub ACTION[:DEVICE]   print all matching messages inline
+    .unsub ACTION[:DEVICE] cancel a subscription
+    .json                  compose raw JSON messages instead of natural text
+    .natural               switch back to natural text
+    .discover              refresh the list of actions for tab completion
+    .help                  show this help
+
+Lines starting with "." or "/" followed by an action are sent as simple
+messages, e.g. ".ping @mydevice" or "/event/new value=3".
+`
+
+type repl struct {
+	app    *App
+	client sarif.Client
+	rl     *readline.Instance
+	comp   *completer
+
+	mutex      sync.Mutex
+	pings      map[string]time.Time
+	subs       map[subscription]bool
+	discoverId string
+
+	jsonMode bool
+	jsonBuf  bytes.Buffer
+}
+
 func (app *App) Interactive() {
-	client := app.NewClient()
+	r := &repl{
+		app:    app,
+		client: app.NewClient(),
+		comp:   newCompleter(),
+		pings:  make(map[string]time.Time),
+		subs:   make(map[subscription]bool),
+	}
+	for rule := range natural.DefaultRules {
+		r.comp.AddRule(rule)
+	}
+
+	if app.Config.HistoryFile != "" {
+		if err := os.MkdirAll(path.Dir(app.Config.HistoryFile), 0700); err != nil {
+			log.Fatal(err)
+		}
+	}
 	rl, err := readline.NewEx(&readline.Config{
-		Prompt:      color.BlueString("say » "),
-		HistoryFile: app.Config.HistoryFile,
+		Prompt:            color.BlueString("say » "),
+		HistoryFile:       app.Config.HistoryFile,
+		HistorySearchFold: true,
+		AutoComplete:      r.comp,
 	})
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer rl.Close()
+	r.rl = rl
 	app.Log.SetOutput(rl.Stderr())
 	log.SetOutput(rl.Stderr())
 	color.Output = ansicolor.NewAnsiColorWriter(rl.Stderr())
 
-	pings := make(map[string]time.Time)
-
 	// Subscribe to all replies and print them to stdout
-	app.Must(client.Subscribe("", "self", func(msg sarif.Message) {
-		text := msg.Text
-		if *printJson {
-			raw, _ := json.MarshalIndent(msg, "", "    ")
-			text = string(raw)
-		} else if text == "" {
-			text = natural.FormatSimple(msg)
-		}
-
-		if msg.IsAction("err") {
-			text = color.RedString(text)
-		}
-
-		if sent, ok := pings[msg.CorrId]; ok {
-			text += color.YellowString("[%.1fms]", time.Since(sent).Seconds()*1e3)
-		}
-		log.Println(color.GreenString(" « ") + strings.Replace(text, "\n", "\n   ", -1))
-	}))
+	app.Must(r.client.Subscribe("", "self", r.handleReply))
+	go r.discover()
 
 	// Interactive mode sends all lines from stdin.
 	for {
@@ -66,25 +99,261 @@ func (app *App) Interactive() {
 			if err == io.EOF {
 				return
 			}
+			if err == readline.ErrInterrupt {
+				r.jsonBuf.Reset()
+				continue
+			}
 			log.Fatal(err)
 		}
 		if len(line) == 0 {
 			continue
 		}
+		if r.handleMeta(line) {
+			continue
+		}
+
+		if r.jsonMode {
+			r.handleJSONLine(line)
+			continue
+		}
 
 		msg, ok := natural.ParseSimple(line)
 		if !ok {
 			// Publish natural message
 			msg = sarif.Message{
-				Id:     sarif.GenerateId(),
 				Action: "natural/handle",
 				Text:   line,
 			}
 		}
+		r.publish(msg)
+	}
+}
+
+func (r *repl) publish(msg sarif.Message) {
+	if msg.Id == "" {
+		msg.Id = sarif.GenerateId()
+	}
+	if *profile {
+		r.mutex.Lock()
+		r.pings[msg.Id] = time.Now()
+		r.mutex.Unlock()
+	}
+	r.app.Must(r.client.Publish(msg))
+}
+
+func (r *repl) formatMessage(msg sarif.Message) string {
+	text := msg.Text
+	if *printJson || r.jsonMode {
+		raw, _ := json.MarshalIndent(msg, "", "    ")
+		text = string(raw)
+	} else if text == "" {
+		text = natural.FormatSimple(msg)
+	}
+
+	if msg.IsAction("err") {
+		text = color.RedString(text)
+	}
+	return strings.Replace(text, "\n", "\n   ", -1)
+}
+
+func (r *repl) handleReply(msg sarif.Message) {
+	r.mutex.Lock()
+	isDiscovery := r.discoverId != "" && msg.CorrId == r.discoverId
+	sent, isPing := r.pings[msg.CorrId]
+	r.mutex.Unlock()
+
+	if isDiscovery {
+		var info sarif.DiscoverInfo
+		if err := msg.DecodePayload(&info); err == nil {
+			r.comp.AddActions(info.Actions...)
+		}
+		return
+	}
+
+	text := r.formatMessage(msg)
+	if isPing {
+		text += color.YellowString("[%.1fms]", time.Since(sent).Seconds()*1e3)
+	}
+	log.Println(color.GreenString(" « ") + text)
+}
+
+func (r *repl) handleSub(msg sarif.Message) {
+	if msg.Destination == r.client.DeviceId() {
+		// Already printed as reply.
+		return
+	}
+	r.mutex.Lock()
+	active := false
+	for sub := range r.subs {
+		if sub.Matches(msg) {
+			active = true
+		}
+	}
+	r.mutex.Unlock()
+	if !active {
+		return
+	}
+
+	prefix := color.MagentaString(" » %s %s: ", msg.Source, msg.Action)
+	log.Println(prefix + r.formatMessage(msg))
+}
+
+// discover collects all actions on the network for tab completion.
+func (r *repl) discover() {
+	msg := sarif.CreateMessage("proto/discover", nil)
+	r.mutex.Lock()
+	r.discoverId = msg.Id
+	r.mutex.Unlock()
+	if err := r.client.Publish(msg); err != nil {
+		r.app.Log.Warnln("discover:", err)
+	}
+
+	reply, ok := <-r.client.Request(sarif.CreateMessage("natural/rules", nil))
+	if !ok {
+		return
+	}
+	var rules natural.SentenceRuleSet
+	if err := reply.DecodePayload(&rules); err == nil {
+		for rule := range rules {
+			r.comp.AddRule(rule)
+		}
+	}
+}
+
+func (r *repl) setJSONMode(enabled bool) {
+	r.jsonMode = enabled
+	r.jsonBuf.Reset()
+	if enabled {
+		r.rl.SetPrompt(color.BlueString("json » "))
+	} else {
+		r.rl.SetPrompt(color.BlueString("say » "))
+	}
+}
+
+func (r *repl) handleMeta(line string) bool {
+	cmd, arg := line, ""
+	if i := strings.Index(line, " "); i >= 0 {
+		cmd, arg = line[0:i], strings.TrimSpace(line[i+1:])
+	}
+
+	switch cmd {
+	case ".sub":
+		if arg == "" {
+			log.Println(color.RedString("Please specify an action to subscribe to."))
+			return true
+		}
+		action, device := splitSubscription(arg)
+		r.mutex.Lock()
+		r.subs[subscription{action, device}] = true
+		r.mutex.Unlock()
+		if err := r.client.Subscribe(action, device, r.handleSub); err != nil {
+			log.Println(color.RedString(err.Error()))
+		}
+		log.Println(color.YellowString("Subscribed to %s", arg))
+	case ".unsub":
+		if arg == "" {
+			log.Println(color.RedString("Please specify an action to unsubscribe from."))
+			return true
+		}
+		action, device := splitSubscription(arg)
+		r.mutex.Lock()
+		delete(r.subs, subscription{action, device})
+		r.mutex.Unlock()
+		if err := r.client.Unsubscribe(action, device); err != nil {
+			log.Println(color.RedString(err.Error()))
+			return true
+		}
+		log.Println(color.YellowString("Unsubscribed from %s", arg))
+	case ".json":
+		r.setJSONMode(true)
+		log.Println(color.YellowString(`Enter messages as JSON, e.g. {"action": "ping"}. Type .natural to leave.`))
+	case ".natural":
+		r.setJSONMode(false)
+	case ".discover":
+		go r.discover()
+	case ".help":
+		log.Print(usageInteractive)
+	default:
+		return false
+	}
+	return true
+}
+
+// subscription is an active .sub of the session.
+type subscription struct {
+	Action string
+	Device string
+}
+
+// Matches reports whether a message falls under the subscription.
+func (s subscription) Matches(msg sarif.Message) bool {
+	return msg.IsAction(s.Action) && (s.Device == "" || msg.Destination == s.Device)
+}
+
+// splitSubscription splits the ACTION[:DEVICE] argument of .sub and .unsub.
+func splitSubscription(arg string) (action, device string) {
+	if parts := strings.SplitN(arg, ":", 2); len(parts) == 2 {
+		return parts[0], parts[1]
+	}
+	return arg, ""
+}
+
+// handleJSONLine collects lines until they form a complete JSON message,
+// validates it and publishes it.
+func (r *repl) handleJSONLine(line string) {
+	r.jsonBuf.WriteString(line)
+	r.jsonBuf.WriteString("\n")
+	raw := bytes.TrimSpace(r.jsonBuf.Bytes())
+	if !json.Valid(raw) {
+		if !jsonIncomplete(raw) {
+			log.Println(color.RedString("Invalid JSON, discarding message."))
+			r.jsonBuf.Reset()
+			r.rl.SetPrompt(color.BlueString("json » "))
+			return
+		}
+		r.rl.SetPrompt(color.BlueString("   ... "))
+		return
+	}
+	r.jsonBuf.Reset()
+	r.rl.SetPrompt(color.BlueString("json » "))
+
+	msg, err := parseJSONMessage(raw)
+	if err != nil {
+		log.Println(color.RedString(err.Error()))
+		return
+	}
+	r.publish(msg)
+}
 
-		if *profile {
-			pings[msg.Id] = time.Now()
+// jsonIncomplete checks if the data could still become valid JSON with
+// more input, e.g. because of unbalanced braces.
+func jsonIncomplete(raw []byte) bool {
+	var v interface{}
+	err := json.Unmarshal(raw, &v)
+	if err == nil {
+		return false
+	}
+	_, isSyntax := err.(*json.SyntaxError)
+	return isSyntax && strings.Contains(err.Error(), "unexpected end of JSON input")
+}
+
+// parseJSONMessage strictly decodes a sarif message, rejecting unknown
+// fields and payloads that are not JSON objects or arrays.
+func parseJSONMessage(raw []byte) (sarif.Message, error) {
+	var msg sarif.Message
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&msg); err != nil {
+		return msg, errors.New("Invalid message: " + err.Error() +
+			` (known fields: sarif, id, action, src, dst, p, corr, text)`)
+	}
+	if msg.Action == "" {
+		return msg, errors.New("Invalid message: missing action")
+	}
+	if p := bytes.TrimSpace(msg.Payload.Raw); len(p) > 0 && !bytes.Equal(p, []byte("null")) {
+		if p[0] != '{' && p[0] != '[' {
+			return msg, errors.New("Invalid message: payload must be a JSON object or array")
 		}
-		app.Must(client.Publish(msg))
 	}
+	return msg, nil
 }
@@ -0,0 +1,96 @@
+// Copyright (C) 2019 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sarifsystems/sarif/sarif"
+)
+
+func TestParseJSONMessage(t *testing.T) {
+	tests := []struct {
+		Raw    string
+		Action string
+		Err    string
+	}{
+		{`{"action": "ping", "dst": "phone"}`, "ping", ""},
+		{`{"action": "event/new", "p": {"value": 3}, "text": "hi"}`, "event/new", ""},
+		{`{"action": "event/new", "p": [1, 2]}`, "event/new", ""},
+		{`{"action": "event/new", "p": null}`, "event/new", ""},
+		{`{"action": "event/new", "p": 3}`, "", "payload must be a JSON object or array"},
+		{`{"action": "event/new", "p": "text"}`, "", "payload must be a JSON object or array"},
+		{`{"dst": "phone"}`, "", "missing action"},
+		{`{"action": "ping", "destination": "phone"}`, "", "known fields"},
+		{`[1, 2]`, "", "Invalid message"},
+	}
+	for _, test := range tests {
+		msg, err := parseJSONMessage([]byte(test.Raw))
+		if test.Err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.Err) {
+				t.Errorf("parseJSONMessage(%s): expected error %q, got %v", test.Raw, test.Err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseJSONMessage(%s): %v", test.Raw, err)
+			continue
+		}
+		if msg.Action != test.Action {
+			t.Errorf("parseJSONMessage(%s): unexpected action %q", test.Raw, msg.Action)
+		}
+	}
+}
+
+func TestJSONIncomplete(t *testing.T) {
+	for raw, incomplete := range map[string]bool{
+		`{"action": "ping"`:         true,
+		`{"action": "ping", "p": [`: true,
+		`{"action": "ping"}`:        false,
+		`{"action": ping}`:          false,
+		`}`:                         false,
+	} {
+		if jsonIncomplete([]byte(raw)) != incomplete {
+			t.Errorf("jsonIncomplete(%s) = %v", raw, !incomplete)
+		}
+	}
+}
+
+func TestSplitSubscription(t *testing.T) {
+	for arg, expected := range map[string][2]string{
+		"location":         {"location", ""},
+		"location:phone":   {"location", "phone"},
+		"location:phone:x": {"location", "phone:x"},
+	} {
+		action, device := splitSubscription(arg)
+		if action != expected[0] || device != expected[1] {
+			t.Errorf("splitSubscription(%q) = %q, %q", arg, action, device)
+		}
+	}
+}
+
+func TestSubscriptionMatches(t *testing.T) {
+	tests := []struct {
+		Sub         subscription
+		Action      string
+		Destination string
+		Matches     bool
+	}{
+		{subscription{"location", ""}, "location/update", "", true},
+		{subscription{"location", ""}, "location/update", "phone", true},
+		{subscription{"location", "phone"}, "location/update", "phone", true},
+		{subscription{"location", "phone"}, "location/update", "", false},
+		{subscription{"location", "phone"}, "location/update", "laptop", false},
+		{subscription{"location", ""}, "locations", "", false},
+	}
+	for _, test := range tests {
+		msg := sarif.Message{Action: test.Action, Destination: test.Destination}
+		if got := test.Sub.Matches(msg); got != test.Matches {
+			t.Errorf("%v.Matches(%s to %q) = %v", test.Sub, test.Action, test.Destination, got)
+		}
+	}
+}
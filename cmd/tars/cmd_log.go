@@ -7,18 +7,115 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
 	"log"
+	"strings"
+	"time"
 
 	"github.com/sarifsystems/sarif/core"
+	"github.com/sarifsystems/sarif/pkg/util"
 	"github.com/sarifsystems/sarif/sarif"
 )
 
+var (
+	logSince  = flag.String("since", "", "log: show logged messages since a time or duration ago (e.g. 2h)")
+	logUntil  = flag.String("until", "", "log: only show history until a time or duration ago")
+	logSource = flag.String("source", "", "log: only show messages from this device")
+	logGrep   = flag.String("grep", "", "log: only show messages containing this text")
+	logLimit  = flag.Int("limit", 100, "log: maximum number of history entries")
+	logFollow = flag.Bool("follow", true, "log: keep printing new messages")
+)
+
+const usageLog = `Usage: tars [OPTION]... log [ACTION]
+Print messages on the sarif network as JSON.
+
+With -since, the history stored by the logger service is shown first.
+Messages can be filtered by an action prefix, -source and -grep.
+
+    Example: Show all errors of the last day and exit
+        tars -since 24h -follow=false log log/err
+
+    Example: Follow messages of a device containing "battery"
+        tars -source phone -grep battery log
+`
+
+// parseSince accepts both absolute times and durations into the past.
+func parseSince(s string) time.Time {
+	if s == "" {
+		return time.Time{}
+	}
+	if d, err := util.ParseDuration(s); err == nil {
+		return time.Now().Add(-d)
+	}
+	return util.ParseTime(s, time.Now())
+}
+
+type logEntry struct {
+	Time time.Time `json:"time"`
+	sarif.Message
+}
+
 func (app *App) CmdLog() {
 	client := app.NewClient()
-	app.Must(client.Subscribe("", "", func(msg sarif.Message) {
-		body, err := json.Marshal(msg)
+	action := ""
+	if flag.NArg() > 1 {
+		action = flag.Arg(1)
+	}
+
+	matches := func(msg sarif.Message) bool {
+		if action != "" && !msg.IsAction(action) {
+			return false
+		}
+		if *logSource != "" && msg.Source != *logSource {
+			return false
+		}
+		if *logGrep != "" {
+			text := strings.ToLower(*logGrep)
+			if !strings.Contains(strings.ToLower(msg.Text), text) &&
+				!strings.Contains(strings.ToLower(string(msg.Payload.Raw)), text) {
+				return false
+			}
+		}
+		return true
+	}
+	print := func(v interface{}) {
+		body, err := json.Marshal(v)
 		app.Must(err)
 		log.Println(string(body))
+	}
+
+	if *logSince != "" {
+		req := sarif.CreateMessage("log/query", map[string]interface{}{
+			"after":  parseSince(*logSince),
+			"before": parseSince(*logUntil),
+			"source": *logSource,
+			"action": action,
+			"text":   *logGrep,
+			"limit":  *logLimit,
+		})
+		reply, ok := <-client.Request(req)
+		if !ok {
+			app.Log.Fatal("No reply from the logger service.")
+		}
+		if reply.IsAction("err") {
+			app.Log.Fatal(reply.Text)
+		}
+		var result struct {
+			Entries []logEntry `json:"entries"`
+		}
+		app.Must(reply.DecodePayload(&result))
+		for _, e := range result.Entries {
+			print(e)
+		}
+	}
+	if !*logFollow {
+		return
+	}
+
+	app.Must(client.Subscribe("", "", func(msg sarif.Message) {
+		if matches(msg) {
+			print(msg)
+		}
 	}))
 
 	core.WaitUntilInterrupt()
@@ -13,9 +13,13 @@ import (
 	"strings"
 
 	"github.com/sarifsystems/sarif/pkg/content"
+	"github.com/sarifsystems/sarif/pkg/schema"
 	"github.com/sarifsystems/sarif/sarif"
 )
 
+// Larger uploads are stored on the content service and referenced by hash.
+const maxInlineContent = 32 * 1024
+
 func (app *App) Down() {
 	client := app.NewClient()
 	if flag.NArg() <= 1 {
@@ -51,7 +55,18 @@ func (app *App) Up() {
 	pl := ContentPayload{
 		Content: content.PutData(in),
 	}
-	if strings.HasPrefix(pl.Content.Type, "text/") {
+	if len(in) > maxInlineContent {
+		ct, err := content.Put(schema.Content{
+			Url:  "sha256:",
+			Type: pl.Content.Type,
+			Data: in,
+		})
+		if err != nil {
+			app.Log.Fatal(err)
+		}
+		pl.Content = ct
+	}
+	if strings.HasPrefix(pl.Content.Type, "text/") && len(in) <= maxInlineContent {
 		msg.Text = string(in)
 	}
 	if err := msg.EncodePayload(pl); err != nil {
@@ -0,0 +1,114 @@
+// Copyright (C) 2019 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+var metaCommands = []string{
+	".sub ",
+	".unsub ",
+	".json",
+	".natural",
+	".discover",
+	".help",
+}
+
+// completer completes meta commands, discovered action names and the
+// fixed parts of natural sentence rules.
+type completer struct {
+	mutex   sync.Mutex
+	actions map[string]struct{}
+	phrases map[string]struct{}
+}
+
+func newCompleter() *completer {
+	return &completer{
+		actions: make(map[string]struct{}),
+		phrases: make(map[string]struct{}),
+	}
+}
+
+func (c *completer) AddActions(actions ...string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, a := range actions {
+		c.actions[a] = struct{}{}
+	}
+}
+
+// AddRule adds the literal beginning of a sentence rule such as
+// "remind me in [duration] to [text]".
+func (c *completer) AddRule(rule string) {
+	if i := strings.Index(rule, "["); i >= 0 {
+		rule = rule[0:i]
+	}
+	if strings.TrimSpace(rule) == "" || strings.HasPrefix(rule, "#") {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.phrases[strings.ToLower(rule)] = struct{}{}
+}
+
+func sortedKeys(m map[string]struct{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (c *completer) Actions() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return sortedKeys(c.actions)
+}
+
+func complete(prefix string, candidates []string) [][]rune {
+	lower := strings.ToLower(prefix)
+	found := make([][]rune, 0)
+	for _, cand := range candidates {
+		if len(cand) > len(prefix) && strings.HasPrefix(strings.ToLower(cand), lower) {
+			found = append(found, []rune(cand[len(prefix):]))
+		}
+	}
+	return found
+}
+
+// Do implements readline.AutoCompleter.
+func (c *completer) Do(line []rune, pos int) ([][]rune, int) {
+	text := string(line[0:pos])
+
+	if strings.HasPrefix(text, ".sub ") || strings.HasPrefix(text, ".unsub ") {
+		i := strings.Index(text, " ")
+		arg := strings.TrimLeft(text[i:], " ")
+		return complete(arg, c.Actions()), len(arg)
+	}
+
+	if strings.HasPrefix(text, ".") || strings.HasPrefix(text, "/") {
+		if strings.Contains(text, " ") {
+			return nil, 0
+		}
+		cands := make([]string, 0)
+		if text[0] == '.' {
+			cands = append(cands, metaCommands...)
+		}
+		for _, a := range c.Actions() {
+			cands = append(cands, text[0:1]+a+" ")
+		}
+		return complete(text, cands), len(text)
+	}
+
+	c.mutex.Lock()
+	phrases := sortedKeys(c.phrases)
+	c.mutex.Unlock()
+	return complete(text, phrases), len(text)
+}
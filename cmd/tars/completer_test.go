@@ -0,0 +1,54 @@
+// Copyright (C) 2019 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCompleter(t *testing.T) {
+	c := newCompleter()
+	c.AddActions("location/update", "location/list", "ping", "ping")
+	c.AddRule("remind me in [duration] to [text]")
+	c.AddRule("Record [text]")
+	c.AddRule("[text]")
+	c.AddRule("# comment")
+
+	if actions := c.Actions(); !reflect.DeepEqual(actions, []string{"location/list", "location/update", "ping"}) {
+		t.Error("unexpected actions:", actions)
+	}
+
+	tests := []struct {
+		Line   string
+		Found  []string
+		Length int
+	}{
+		{".su", []string{"b "}, 3},
+		{".", []string{"sub ", "unsub ", "json", "natural", "discover", "help", "location/list ", "location/update ", "ping "}, 1},
+		{".loc", []string{"ation/list ", "ation/update "}, 4},
+		{"/location/u", []string{"pdate "}, 11},
+		{"/ping ", nil, 0},
+		{".sub loc", []string{"ation/list", "ation/update"}, 3},
+		{".unsub  pi", []string{"ng"}, 2},
+		{"rem", []string{"ind me in "}, 3},
+		{"Rec", []string{"ord "}, 3},
+		{"xyz", []string{}, 3},
+	}
+	for _, test := range tests {
+		found, length := c.Do([]rune(test.Line), len(test.Line))
+		var strs []string
+		if found != nil {
+			strs = make([]string, len(found))
+			for i, f := range found {
+				strs[i] = string(f)
+			}
+		}
+		if !reflect.DeepEqual(strs, test.Found) || length != test.Length {
+			t.Errorf("Do(%q) = %q, %d; expected %q, %d", test.Line, strs, length, test.Found, test.Length)
+		}
+	}
+}
@@ -13,7 +13,9 @@ import (
 	"os"
 
 	"github.com/sarifsystems/sarif/core"
+	"github.com/sarifsystems/sarif/pkg/content"
 	"github.com/sarifsystems/sarif/sarif"
+	contentservice "github.com/sarifsystems/sarif/services/content"
 )
 
 func main() {
@@ -56,12 +58,15 @@ func New() *App {
 
 	app.Commands = []Command{
 		{"help", app.Help, ""},
-		{"log", app.CmdLog, ""},
-		{"interactive", app.Interactive, ""},
+		{"log", app.CmdLog, usageLog},
+		{"interactive", app.Interactive, usageInteractive},
 		{"cat", app.Cat, usageCat},
 		{"down", app.Down, ""},
 		{"up", app.Up, ""},
 		{"edit", app.Edit, ""},
+		{"export", app.Export, usageExport},
+		{"import", app.Import, usageImport},
+		{"enroll", app.Enroll, usageEnroll},
 	}
 
 	return app
@@ -72,6 +77,11 @@ func (app *App) NewClient() sarif.Client {
 		Name: "tars/" + sarif.GenerateId(),
 	})
 	app.Must(err)
+
+	// Fetch and store large content lazily through the content service.
+	blobs := contentservice.NewClient(c)
+	content.Register("sha256", blobs)
+	content.Register("blob", blobs)
 	return c
 }
 
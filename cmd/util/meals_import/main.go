@@ -0,0 +1,173 @@
+// Copyright (C) 2019 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// Imports products and servings from a legacy meals SQL database into the
+// store service.
+package main
+
+import (
+	"flag"
+	"strconv"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/jinzhu/gorm"
+	_ "github.com/lib/pq"
+	"github.com/sarifsystems/sarif/core"
+	"github.com/sarifsystems/sarif/sarif"
+	"github.com/sarifsystems/sarif/services/meals"
+)
+
+var (
+	driver = flag.String("driver", "mysql", "database driver of the legacy meals database")
+	source = flag.String("source", "", "data source name of the legacy meals database")
+)
+
+// legacyProduct is a row of the legacy products table. The columns are
+// spelled out, since the current model uses string ids.
+type legacyProduct struct {
+	Id            int64   `gorm:"column:id"`
+	RefId         int64   `gorm:"column:ref_id"`
+	Name          string  `gorm:"column:name"`
+	Code          string  `gorm:"column:code"`
+	ServingWeight float64 `gorm:"column:serving_weight"`
+	ServingVolume float64 `gorm:"column:serving_volume"`
+
+	Weight        float64 `gorm:"column:weight"`
+	Volume        float64 `gorm:"column:volume"`
+	Energy        float64 `gorm:"column:energy"`
+	Fat           float64 `gorm:"column:fat"`
+	Carbohydrates float64 `gorm:"column:carbohydrates"`
+	Sugar         float64 `gorm:"column:sugar"`
+	Protein       float64 `gorm:"column:protein"`
+	Salt          float64 `gorm:"column:salt"`
+	Water         float64 `gorm:"column:water"`
+
+	CreatedAt time.Time `gorm:"column:created_at"`
+	UpdatedAt time.Time `gorm:"column:updated_at"`
+}
+
+func (legacyProduct) TableName() string {
+	return "meals_products"
+}
+
+func (lp legacyProduct) Product() meals.Product {
+	return meals.Product{
+		Id:            legacyId(lp.Id),
+		RefId:         lp.RefId,
+		Name:          lp.Name,
+		Code:          lp.Code,
+		ServingWeight: meals.Weight(lp.ServingWeight),
+		ServingVolume: meals.Volume(lp.ServingVolume),
+		Stats: meals.Stats{
+			Weight:        meals.Weight(lp.Weight),
+			Volume:        meals.Volume(lp.Volume),
+			Energy:        meals.Energy(lp.Energy),
+			Fat:           meals.Weight(lp.Fat),
+			Carbohydrates: meals.Weight(lp.Carbohydrates),
+			Sugar:         meals.Weight(lp.Sugar),
+			Protein:       meals.Weight(lp.Protein),
+			Salt:          meals.Weight(lp.Salt),
+			Water:         meals.Volume(lp.Water),
+		},
+		CreatedAt: lp.CreatedAt,
+		UpdatedAt: lp.UpdatedAt,
+	}
+}
+
+// legacyServing is a row of the legacy servings table.
+type legacyServing struct {
+	Id           int64     `gorm:"column:id"`
+	RefId        int64     `gorm:"column:ref_id"`
+	Name         string    `gorm:"column:name"`
+	AmountWeight float64   `gorm:"column:amount_weight"`
+	AmountVolume float64   `gorm:"column:amount_volume"`
+	Time         time.Time `gorm:"column:time"`
+	ProductId    int64     `gorm:"column:product_id"`
+}
+
+func (legacyServing) TableName() string {
+	return "meals_servings"
+}
+
+func (ls legacyServing) Serving() meals.Serving {
+	return meals.Serving{
+		Id:           legacyId(ls.Id),
+		RefId:        ls.RefId,
+		Name:         ls.Name,
+		AmountWeight: meals.Weight(ls.AmountWeight),
+		AmountVolume: meals.Volume(ls.AmountVolume),
+		Time:         ls.Time,
+	}
+}
+
+func legacyId(id int64) string {
+	return "sql-" + strconv.FormatInt(id, 10)
+}
+
+// servingStore is the part of the meals service used by the import.
+type servingStore interface {
+	PutProduct(p *meals.Product) error
+	PutServing(sv *meals.Serving) error
+}
+
+// importLegacy copies all products and servings from db to srv and returns
+// the number of imported products and servings.
+func importLegacy(db *gorm.DB, srv servingStore) (int, int, error) {
+	var products []legacyProduct
+	if err := db.Find(&products).Error; err != nil {
+		return 0, 0, err
+	}
+	byId := make(map[int64]*meals.Product)
+	for _, lp := range products {
+		p := lp.Product()
+		if err := srv.PutProduct(&p); err != nil {
+			return 0, 0, err
+		}
+		byId[lp.Id] = &p
+	}
+
+	var servings []legacyServing
+	if err := db.Order("time ASC").Find(&servings).Error; err != nil {
+		return len(products), 0, err
+	}
+	for i, ls := range servings {
+		sv := ls.Serving()
+		sv.Product = byId[ls.ProductId]
+		if sv.Time.IsZero() {
+			sv.Time = time.Now()
+		}
+		if err := srv.PutServing(&sv); err != nil {
+			return len(products), i, err
+		}
+	}
+	return len(products), len(servings), nil
+}
+
+func main() {
+	app := core.NewApp("sarif", "tars")
+	app.Init()
+	defer app.Close()
+
+	if *source == "" {
+		app.Log.Fatal("Please specify the legacy database with -source.")
+	}
+	db, err := gorm.Open(*driver, *source)
+	app.Must(err)
+	defer db.Close()
+
+	c, err := app.ClientDial(sarif.ClientInfo{
+		Name: "meals_import/" + sarif.GenerateId(),
+	})
+	app.Must(err)
+	srv := meals.NewService(&meals.Dependencies{
+		Config: app.Config.Section("meals"),
+		Client: c,
+	})
+
+	np, ns, err := importLegacy(db, srv)
+	app.Log.Infof("imported %d products and %d servings", np, ns)
+	app.Must(err)
+}
@@ -0,0 +1,124 @@
+// Copyright (C) 2019 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/sarifsystems/sarif/services/meals"
+)
+
+// fixture is the schema of the legacy meals tables, as created by gorm.
+const fixture = `
+CREATE TABLE meals_products (
+	id integer primary key autoincrement,
+	ref_id bigint,
+	name varchar(255),
+	code varchar(255),
+	serving_weight real,
+	serving_volume real,
+	weight real,
+	volume real,
+	energy real,
+	fat real,
+	carbohydrates real,
+	sugar real,
+	protein real,
+	salt real,
+	water real,
+	created_at datetime,
+	updated_at datetime
+);
+CREATE TABLE meals_servings (
+	id integer primary key autoincrement,
+	ref_id bigint,
+	name varchar(255),
+	amount_weight real,
+	amount_volume real,
+	time datetime,
+	product_id bigint
+);
+INSERT INTO meals_products (id, name, code, serving_weight, weight, energy, protein, created_at)
+	VALUES (7, 'Oats', '4000000000001', 40, 100, 1550000, 13, '2016-03-01 08:00:00');
+INSERT INTO meals_products (id, name, serving_volume, volume, energy)
+	VALUES (9, 'Milk', 200, 100, 270000);
+INSERT INTO meals_servings (id, name, amount_weight, time, product_id)
+	VALUES (3, 'Oats', 80, '2016-03-02 07:30:00', 7);
+INSERT INTO meals_servings (id, name, amount_volume, time, product_id)
+	VALUES (2, 'Milk', 250, '2016-03-01 07:30:00', 9);
+`
+
+type recordingStore struct {
+	products []meals.Product
+	servings []meals.Serving
+}
+
+func (r *recordingStore) PutProduct(p *meals.Product) error {
+	r.products = append(r.products, *p)
+	return nil
+}
+
+func (r *recordingStore) PutServing(sv *meals.Serving) error {
+	r.servings = append(r.servings, *sv)
+	return nil
+}
+
+func TestImportLegacy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "meals-import-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := gorm.Open("sqlite3", filepath.Join(dir, "meals.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.Exec(fixture).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	var r recordingStore
+	np, ns, err := importLegacy(db, &r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if np != 2 || ns != 2 {
+		t.Fatalf("imported %d products and %d servings", np, ns)
+	}
+
+	oats := r.products[0]
+	if oats.Id != "sql-7" || oats.Name != "Oats" || oats.Code != "4000000000001" {
+		t.Errorf("unexpected product: %+v", oats)
+	}
+	if oats.ServingWeight != 40 || oats.Weight != 100 || oats.Protein != 13 {
+		t.Errorf("unexpected product stats: %+v", oats)
+	}
+	if !oats.CreatedAt.Equal(time.Date(2016, 3, 1, 8, 0, 0, 0, time.UTC)) {
+		t.Error("unexpected creation time", oats.CreatedAt)
+	}
+	if r.products[1].Id != "sql-9" || r.products[1].ServingVolume != 200 {
+		t.Errorf("unexpected product: %+v", r.products[1])
+	}
+
+	milk, oatmeal := r.servings[0], r.servings[1]
+	if milk.Id != "sql-2" || milk.AmountVolume != 250 || milk.Product == nil || milk.Product.Id != "sql-9" {
+		t.Errorf("unexpected serving: %+v", milk)
+	}
+	if oatmeal.Id != "sql-3" || oatmeal.AmountWeight != 80 || oatmeal.Product == nil || oatmeal.Product.Id != "sql-7" {
+		t.Errorf("unexpected serving: %+v", oatmeal)
+	}
+	if !oatmeal.Time.Equal(time.Date(2016, 3, 2, 7, 30, 0, 0, time.UTC)) {
+		t.Error("unexpected serving time", oatmeal.Time)
+	}
+}
@@ -30,6 +30,8 @@ type App struct {
 	Config        *Config
 	Log           *Logger
 	ClientFactory sarif.ClientFactory
+
+	keys localKeys
 }
 
 func NewApp(appName, moduleName string) *App {
@@ -99,7 +101,7 @@ func (app *App) InitClientFactory() (err error) {
 		return err
 	}
 
-	if u.Scheme == "amqp" {
+	if u.Scheme == "amqp" || u.Scheme == "amqps" {
 		app.ClientFactory = amqp.NewClientFactory(cfg)
 	} else {
 		app.ClientFactory = sfproto.NewClientFactory(cfg)
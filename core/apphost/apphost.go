@@ -62,6 +62,7 @@ func (s *AppHost) InitClient() {
 		Name: s.HostConfig.Name + "/sarifd",
 	})
 	s.Must(err)
+	s.Must(s.EnableEncryption(c))
 	s.Client = c
 }
 
@@ -89,6 +90,7 @@ func (s *AppHost) SetupInjector(inj *inject.Injector, name string) {
 		Name: cname,
 	})
 	s.Must(err)
+	s.Must(s.EnableEncryption(c))
 
 	inj.Factory(func() sarif.ClientFactory {
 		return s.ClientFactory
@@ -0,0 +1,79 @@
+// Copyright (C) 2019 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package core
+
+import (
+	"sync"
+
+	"github.com/sarifsystems/sarif/sarif"
+)
+
+// KeysConfig configures end-to-end encryption of directed messages.
+type KeysConfig struct {
+	Enabled bool
+	// Private holds the keys of local clients by device id.
+	Private map[string]string `json:",omitempty"`
+	// Trusted pins public keys of remote devices. Messages to devices
+	// without a pinned key are not sealed. Keys of local clients are
+	// trusted automatically.
+	Trusted map[string]string `json:",omitempty"`
+}
+
+// localKeys tracks the clients of an app with encryption enabled, so that
+// they can trust each other.
+type localKeys struct {
+	mu      sync.Mutex
+	clients []sarif.Client
+}
+
+// EnableEncryption loads or creates the key of a client and enables
+// end-to-end encryption, if configured.
+func (app *App) EnableEncryption(c sarif.Client) error {
+	app.keys.mu.Lock()
+	defer app.keys.mu.Unlock()
+
+	var cfg KeysConfig
+	if err, _ := app.Config.Get("keys", &cfg); err != nil || !cfg.Enabled {
+		return err
+	}
+
+	for device, public := range cfg.Trusted {
+		key, err := sarif.ParsePublicKey(public)
+		if err != nil {
+			app.Log.Warnf("[core] invalid trusted key of %s: %v", device, err)
+			continue
+		}
+		c.TrustKey(device, key)
+	}
+	for device, private := range cfg.Private {
+		if key, err := sarif.ParseKeyPair(private); err == nil && device != c.DeviceId() {
+			c.TrustKey(device, key.Public)
+		}
+	}
+
+	var key sarif.KeyPair
+	var err error
+	if private, ok := cfg.Private[c.DeviceId()]; ok {
+		key, err = sarif.ParseKeyPair(private)
+	} else {
+		key, err = sarif.GenerateKeyPair()
+		if err == nil {
+			if cfg.Private == nil {
+				cfg.Private = make(map[string]string)
+			}
+			cfg.Private[c.DeviceId()] = key.PrivateString()
+			err = app.Config.Set("keys", cfg)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	for _, other := range app.keys.clients {
+		other.TrustKey(c.DeviceId(), key.Public)
+	}
+	app.keys.clients = append(app.keys.clients, c)
+	return c.EnableEncryption(key)
+}
@@ -8,14 +8,19 @@ package server
 
 import (
 	"fmt"
+	"net/url"
 	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/sarifsystems/sarif/core"
 	"github.com/sarifsystems/sarif/pkg/inject"
 	"github.com/sarifsystems/sarif/sarif"
 	"github.com/sarifsystems/sarif/services"
+	"github.com/sarifsystems/sarif/services/auth"
 	config "github.com/sarifsystems/sarif/services/schema"
+	"github.com/sarifsystems/sarif/transports/amqp"
+	"github.com/sarifsystems/sarif/transports/mqtt"
 	"github.com/sarifsystems/sarif/transports/sfproto"
 )
 
@@ -35,6 +40,7 @@ type Config struct {
 	Listen         []*sfproto.NetConfig
 	Bridges        []*sfproto.NetConfig
 	Gateways       []*sfproto.NetConfig
+	Mqtt           []*mqtt.Config
 	EnabledModules []string
 	BaseModules    []string
 }
@@ -93,8 +99,19 @@ func (s *Server) InitBroker() error {
 	if err != nil {
 		return err
 	}
+	if err := s.EnableEncryption(client); err != nil {
+		return err
+	}
 	s.Client = client
 
+	// Keep revoked client certificates in sync with the auth service
+	s.Client.Subscribe("auth/crl", "", s.handleCRL)
+	go func() {
+		if msg, ok := <-s.Client.Request(sarif.CreateMessage("auth/get/crl", nil)); ok {
+			s.handleCRL(msg)
+		}
+	}()
+
 	cfg := &s.ServerConfig
 	if _, ok := s.Config.Get("server", cfg); !ok {
 		if len(cfg.Listen) == 0 {
@@ -108,10 +125,14 @@ func (s *Server) InitBroker() error {
 
 	// Listen on connections
 	for _, cfg := range cfg.Listen {
-		go func(cfg *sfproto.NetConfig) {
+		go func(cfg sfproto.NetConfig) {
+			// Accept devices enrolled by the auth service
+			if cfg.ClientAuthority == "" {
+				cfg.ClientAuthority = filepath.Join(s.Config.Dir(), auth.AuthorityFile)
+			}
 			s.Log.Infoln("[server] listening on", cfg.Address)
-			s.Must(s.Broker.Listen(cfg))
-		}(cfg)
+			s.Must(s.Broker.Listen(&cfg))
+		}(*cfg)
 	}
 
 	// Setup bridges
@@ -119,7 +140,7 @@ func (s *Server) InitBroker() error {
 		go func(cfg *sfproto.NetConfig) {
 			for {
 				s.Log.Infoln("[server] bridging to ", cfg.Address)
-				conn, err := sfproto.RawDial(cfg)
+				conn, err := dialBroker(cfg)
 				if err == nil {
 					err = s.Broker.ListenOnBridge(conn)
 				}
@@ -134,7 +155,7 @@ func (s *Server) InitBroker() error {
 		go func(cfg *sfproto.NetConfig) {
 			for {
 				s.Log.Infoln("[server] gateway to ", cfg.Address)
-				conn, err := sfproto.RawDial(cfg)
+				conn, err := dialBroker(cfg)
 				if err == nil {
 					err = s.Broker.ListenOnGateway(conn)
 				}
@@ -144,19 +165,68 @@ func (s *Server) InitBroker() error {
 		}(cfg)
 	}
 
+	// Setup MQTT bridges
+	for _, cfg := range cfg.Mqtt {
+		go func(cfg *mqtt.Config) {
+			for {
+				s.Log.Infoln("[server] mqtt bridge to ", cfg.Address)
+				conn, err := mqtt.Dial(cfg)
+				if err == nil {
+					err = s.Broker.ListenOnConn(conn)
+				}
+				s.Log.Errorln("[server] mqtt bridge error:", err)
+				time.Sleep(5 * time.Second)
+			}
+		}(cfg)
+	}
+
 	return nil
 }
 
-func (s *Server) SetupInjector(inj *inject.Injector, name string) {
-	cname := name
-	if s.ServerConfig.Name != "" {
-		cname = s.ServerConfig.Name + "/" + name
+// handleCRL applies revocation lists of the local auth service, which is
+// the authority for client certificates.
+func (s *Server) handleCRL(msg sarif.Message) {
+	if msg.Source != s.serviceDevice(auth.Module.Name) {
+		s.Log.Warnln("[server] ignoring revocation list of", msg.Source)
+		return
+	}
+	var crl struct {
+		Serials []string `json:"serials"`
+	}
+	if err := msg.DecodePayload(&crl); err != nil {
+		s.Log.Warnln("[server] invalid revocation list:", err)
+		return
+	}
+	s.Broker.SetRevokedCertificates(crl.Serials)
+}
+
+// dialBroker connects to another broker, either directly or through an AMQP
+// exchange.
+func dialBroker(cfg *sfproto.NetConfig) (sfproto.Conn, error) {
+	u, err := url.Parse(cfg.Address)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "amqp" || u.Scheme == "amqps" {
+		return amqp.DialGateway(cfg)
 	}
+	return sfproto.RawDial(cfg)
+}
 
+// serviceDevice returns the device id of a service running on this server.
+func (s *Server) serviceDevice(name string) string {
+	if s.ServerConfig.Name == "" {
+		return name
+	}
+	return s.ServerConfig.Name + "/" + name
+}
+
+func (s *Server) SetupInjector(inj *inject.Injector, name string) {
 	c, err := s.Broker.NewClient(sarif.ClientInfo{
-		Name: cname,
+		Name: s.serviceDevice(name),
 	})
 	s.Must(err)
+	s.Must(s.EnableEncryption(c))
 
 	inj.Instance(s.Broker)
 	inj.Factory(func() sarif.ClientFactory {
@@ -19,9 +19,11 @@ require (
 	github.com/davecgh/go-spew v1.1.1
 	github.com/ddliu/motto v0.3.1
 	github.com/denisenkom/go-mssqldb v0.0.0-20190924004331-208c0a498538 // indirect
+	github.com/eclipse/paho.mqtt.golang v1.2.0
 	github.com/elastic/go-elasticsearch/v7 v7.3.0
 	github.com/fatih/color v1.7.0
 	github.com/fhs/gompd v2.0.0+incompatible
+	github.com/fsnotify/fsnotify v1.4.7
 	github.com/go-sql-driver/mysql v1.4.1
 	github.com/godbus/dbus v4.1.0+incompatible
 	github.com/gogo/protobuf v1.3.1 // indirect
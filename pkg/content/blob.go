@@ -0,0 +1,244 @@
+// Copyright (C) 2019 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package content
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sarifsystems/sarif/pkg/schema"
+)
+
+var (
+	ErrInvalidHash  = errors.New("Invalid blob hash")
+	ErrBlobNotFound = errors.New("Blob not found")
+)
+
+// BlobInfo describes a blob stored in a content-addressed store.
+type BlobInfo struct {
+	Hash string `json:"hash"`
+	Url  string `json:"url"`
+	Size int64  `json:"size"`
+	Type string `json:"type,omitempty"`
+}
+
+// BlobStore is a content-addressed directory, storing each blob under
+// its SHA-256 hash.
+type BlobStore struct {
+	Dir string
+}
+
+func NewBlobStore(dir string) (*BlobStore, error) {
+	if err := os.MkdirAll(filepath.Join(dir, "tmp"), 0700); err != nil {
+		return nil, err
+	}
+	return &BlobStore{dir}, nil
+}
+
+// BlobUrl returns the canonical content url for a hash.
+func BlobUrl(hash string) string {
+	return "sha256:" + hash
+}
+
+// ParseBlobUrl extracts the hash from "sha256:<hex>" or "blob:<hex>" urls.
+func ParseBlobUrl(u string) (string, error) {
+	switch scheme(u) {
+	case "sha256", "blob":
+	default:
+		return "", ErrInvalidHash
+	}
+	hash := strings.ToLower(u[strings.Index(u, ":")+1:])
+	hash = strings.TrimPrefix(hash, "sha256:")
+	if !isValidHash(hash) {
+		return "", ErrInvalidHash
+	}
+	return hash, nil
+}
+
+func isValidHash(hash string) bool {
+	if len(hash) != 2*sha256.Size {
+		return false
+	}
+	_, err := hex.DecodeString(hash)
+	return err == nil
+}
+
+func (s *BlobStore) path(hash string) string {
+	return filepath.Join(s.Dir, hash[0:2], hash[2:])
+}
+
+// Create starts a new blob that is committed once all data is written.
+func (s *BlobStore) Create() (*BlobWriter, error) {
+	f, err := ioutil.TempFile(filepath.Join(s.Dir, "tmp"), "blob-")
+	if err != nil {
+		return nil, err
+	}
+	return &BlobWriter{
+		store: s,
+		file:  f,
+		hash:  sha256.New(),
+	}, nil
+}
+
+// Store writes data into the store and returns its description.
+func (s *BlobStore) Store(data []byte) (BlobInfo, error) {
+	w, err := s.Create()
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	if _, err := w.Write(data); err != nil {
+		w.Abort()
+		return BlobInfo{}, err
+	}
+	return w.Commit()
+}
+
+// Open returns a reader for the blob with the given hash.
+func (s *BlobStore) Open(hash string) (*os.File, error) {
+	if !isValidHash(hash) {
+		return nil, ErrInvalidHash
+	}
+	f, err := os.Open(s.path(hash))
+	if os.IsNotExist(err) {
+		return nil, ErrBlobNotFound
+	}
+	return f, err
+}
+
+// ReadAt reads up to len(p) bytes of the blob, starting at offset.
+func (s *BlobStore) ReadAt(hash string, p []byte, offset int64) (int, error) {
+	f, err := s.Open(hash)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return f.ReadAt(p, offset)
+}
+
+// Stat returns size and type information about a stored blob.
+func (s *BlobStore) Stat(hash string) (BlobInfo, error) {
+	f, err := s.Open(hash)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return BlobInfo{}, err
+	}
+
+	head := make([]byte, 512)
+	n, err := f.Read(head)
+	if err != nil && err != io.EOF {
+		return BlobInfo{}, err
+	}
+	return BlobInfo{
+		Hash: hash,
+		Url:  BlobUrl(hash),
+		Size: fi.Size(),
+		Type: DetectContentType(head[0:n]),
+	}, nil
+}
+
+func (s *BlobStore) Has(hash string) bool {
+	if !isValidHash(hash) {
+		return false
+	}
+	_, err := os.Stat(s.path(hash))
+	return err == nil
+}
+
+func (s *BlobStore) Get(c schema.Content) (schema.Content, error) {
+	hash, err := ParseBlobUrl(c.Url)
+	if err != nil {
+		return c, err
+	}
+	f, err := s.Open(hash)
+	if err != nil {
+		return c, err
+	}
+	defer f.Close()
+	if c.Data, err = ioutil.ReadAll(f); err != nil {
+		return c, err
+	}
+	if c.Type == "" {
+		c.Type = DetectContentType(c.Data)
+	}
+	return c, nil
+}
+
+func (s *BlobStore) Put(c schema.Content) (schema.Content, error) {
+	info, err := s.Store(c.Data)
+	if err != nil {
+		return c, err
+	}
+	if c.Type == "" {
+		c.Type = info.Type
+	}
+	return schema.Content{
+		Url:  info.Url,
+		Type: c.Type,
+		Name: c.Name,
+	}, nil
+}
+
+// BlobWriter writes a new blob into a temporary file and moves it to its
+// content address on commit.
+type BlobWriter struct {
+	store *BlobStore
+	file  *os.File
+	hash  hash.Hash
+	size  int64
+}
+
+func (w *BlobWriter) Write(p []byte) (int, error) {
+	n, err := w.file.Write(p)
+	w.hash.Write(p[0:n])
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *BlobWriter) Size() int64 {
+	return w.size
+}
+
+// Hash returns the hash of the data written so far.
+func (w *BlobWriter) Hash() string {
+	return hex.EncodeToString(w.hash.Sum(nil))
+}
+
+// Commit finishes the blob and makes it available under its hash.
+func (w *BlobWriter) Commit() (BlobInfo, error) {
+	defer os.Remove(w.file.Name())
+	if err := w.file.Close(); err != nil {
+		return BlobInfo{}, err
+	}
+
+	hash := w.Hash()
+	target := w.store.path(hash)
+	if !w.store.Has(hash) {
+		if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+			return BlobInfo{}, err
+		}
+		if err := os.Rename(w.file.Name(), target); err != nil {
+			return BlobInfo{}, err
+		}
+	}
+	return w.store.Stat(hash)
+}
+
+// Abort discards all written data.
+func (w *BlobWriter) Abort() error {
+	w.file.Close()
+	return os.Remove(w.file.Name())
+}
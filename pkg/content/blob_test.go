@@ -0,0 +1,62 @@
+// Copyright (C) 2019 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package content
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/sarifsystems/sarif/pkg/schema"
+)
+
+func TestBlobStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sarif-blobs-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewBlobStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := s.Store([]byte("hello world"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const exp = "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9"
+	if info.Hash != exp {
+		t.Error("unexpected hash:", info.Hash)
+	}
+	if info.Url != "sha256:"+exp || info.Size != 11 || info.Type != "text/plain" {
+		t.Error("unexpected info:", info)
+	}
+
+	got, err := s.Get(schema.Content{Url: "blob:" + exp})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got.Data) != "hello world" {
+		t.Error("unexpected data:", string(got.Data))
+	}
+
+	buf := make([]byte, 5)
+	if _, err := s.ReadAt(exp, buf, 6); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "world" {
+		t.Error("unexpected chunk:", string(buf))
+	}
+
+	if _, err := s.Stat(exp[1:] + "0"); err != ErrBlobNotFound {
+		t.Error("expected not found, got", err)
+	}
+	if _, err := ParseBlobUrl("sha256:xyz"); err != ErrInvalidHash {
+		t.Error("expected invalid hash, got", err)
+	}
+}
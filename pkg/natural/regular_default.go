@@ -6,7 +6,7 @@
 package natural
 
 var DefaultRules = SentenceRuleSet{
-	"ping": "ping",
+	"ping":                               "ping",
 	"associate [sentence] with [action]": "natural/learn",
 	"parse [text]":                       "natural/parse",
 
@@ -15,4 +15,24 @@ var DefaultRules = SentenceRuleSet{
 	"#[_action] [text]":  "tagged",
 
 	"remind me in [duration] to [text]": "schedule",
+
+	"what is on my agenda": "vdir/agenda",
+	"show my todos":        "vdir/todo/list",
+	"add todo [summary]":   "vdir/todo/new",
+
+	"find contact [query]": "vdir/card/search",
+	"who is [query]":       "vdir/card/search",
+	"add contact [name]":   "vdir/card/new",
+
+	"play something by [artist]": "mpd/play",
+	"play music by [artist]":     "mpd/play",
+	"play the album [album]":     "mpd/play",
+	"what is playing":            "music/status",
+	"pause the music":            "music/pause",
+	"next song":                  "music/next",
+	"previous song":              "music/prev",
+
+	"wake up [device]":   "devices/wake",
+	"shut down [device]": "devices/shutdown",
+	"suspend [device]":   "devices/suspend",
 }
@@ -0,0 +1,231 @@
+// Copyright (C) 2019 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// Package openfoodfacts reads product data dumps from https://openfoodfacts.org
+package openfoodfacts
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	FormatCSV   = "csv"
+	FormatJSONL = "jsonl"
+)
+
+// Product contains the fields of a dump entry relevant for nutrition tracking.
+// All nutriment values are given per 100g or 100ml.
+type Product struct {
+	Code            string
+	Name            string
+	Brands          string
+	Quantity        string
+	ServingSize     string
+	ServingQuantity float64
+
+	EnergyKJ      float64
+	Fat           float64
+	Carbohydrates float64
+	Sugars        float64
+	Proteins      float64
+	Salt          float64
+}
+
+// FullName returns the product name including its brand.
+func (p Product) FullName() string {
+	if p.Brands == "" {
+		return p.Name
+	}
+	brand := strings.TrimSpace(strings.Split(p.Brands, ",")[0])
+	if strings.Contains(strings.ToLower(p.Name), strings.ToLower(brand)) {
+		return p.Name
+	}
+	return p.Name + " (" + brand + ")"
+}
+
+// IsLiquid guesses if the product quantity is given as a volume.
+func (p Product) IsLiquid() bool {
+	q := strings.ToLower(p.Quantity + " " + p.ServingSize)
+	return strings.Contains(q, "ml") || strings.Contains(q, " l") || strings.Contains(q, "cl")
+}
+
+// RecordError reports a malformed entry of a dump. Reading can continue
+// with the next entry.
+type RecordError struct {
+	Line int
+	Err  error
+}
+
+func (e *RecordError) Error() string {
+	return fmt.Sprintf("openfoodfacts: line %d: %v", e.Line, e.Err)
+}
+
+// Reader reads products from a CSV (tab-separated) or JSONL dump.
+type Reader struct {
+	format string
+
+	csv     *csv.Reader
+	columns map[string]int
+
+	lines *bufio.Scanner
+	line  int
+}
+
+// NewReader creates a reader for the given dump format.
+func NewReader(r io.Reader, format string) (*Reader, error) {
+	switch format {
+	case FormatCSV:
+		cr := csv.NewReader(r)
+		cr.Comma = '\t'
+		cr.LazyQuotes = true
+		cr.FieldsPerRecord = -1
+		header, err := cr.Read()
+		if err != nil {
+			return nil, err
+		}
+		cols := make(map[string]int)
+		for i, name := range header {
+			cols[strings.TrimSpace(name)] = i
+		}
+		if _, ok := cols["code"]; !ok {
+			return nil, errors.New("openfoodfacts: csv header has no code column")
+		}
+		return &Reader{format: format, csv: cr, columns: cols}, nil
+	case FormatJSONL:
+		sc := bufio.NewScanner(r)
+		sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		return &Reader{format: format, lines: sc}, nil
+	}
+	return nil, errors.New("openfoodfacts: unknown format " + format)
+}
+
+// DetectFormat guesses the dump format from a file name.
+func DetectFormat(name string) string {
+	name = strings.TrimSuffix(strings.ToLower(name), ".gz")
+	if strings.HasSuffix(name, ".jsonl") || strings.HasSuffix(name, ".json") {
+		return FormatJSONL
+	}
+	return FormatCSV
+}
+
+// Next returns the next product in the dump or io.EOF.
+func (r *Reader) Next() (*Product, error) {
+	if r.format == FormatCSV {
+		return r.nextCSV()
+	}
+	return r.nextJSON()
+}
+
+func (r *Reader) nextCSV() (*Product, error) {
+	rec, err := r.csv.Read()
+	if perr, ok := err.(*csv.ParseError); ok {
+		return nil, &RecordError{perr.Line, perr.Err}
+	}
+	if err != nil {
+		return nil, err
+	}
+	get := func(col string) string {
+		if i, ok := r.columns[col]; ok && i < len(rec) {
+			return strings.TrimSpace(rec[i])
+		}
+		return ""
+	}
+	num := func(col string) float64 {
+		v, _ := strconv.ParseFloat(get(col), 64)
+		return v
+	}
+
+	p := &Product{
+		Code:            get("code"),
+		Name:            get("product_name"),
+		Brands:          get("brands"),
+		Quantity:        get("quantity"),
+		ServingSize:     get("serving_size"),
+		ServingQuantity: num("serving_quantity"),
+
+		EnergyKJ:      num("energy_100g"),
+		Fat:           num("fat_100g"),
+		Carbohydrates: num("carbohydrates_100g"),
+		Sugars:        num("sugars_100g"),
+		Proteins:      num("proteins_100g"),
+		Salt:          num("salt_100g"),
+	}
+	if p.EnergyKJ == 0 {
+		p.EnergyKJ = num("energy-kj_100g")
+	}
+	if p.EnergyKJ == 0 {
+		p.EnergyKJ = num("energy-kcal_100g") * 4.184
+	}
+	return p, nil
+}
+
+type jsonProduct struct {
+	Code            string                 `json:"code"`
+	Name            string                 `json:"product_name"`
+	Brands          string                 `json:"brands"`
+	Quantity        string                 `json:"quantity"`
+	ServingSize     string                 `json:"serving_size"`
+	ServingQuantity interface{}            `json:"serving_quantity"`
+	Nutriments      map[string]interface{} `json:"nutriments"`
+}
+
+func toFloat(v interface{}) float64 {
+	switch n := v.(type) {
+	case float64:
+		return n
+	case string:
+		f, _ := strconv.ParseFloat(n, 64)
+		return f
+	}
+	return 0
+}
+
+func (r *Reader) nextJSON() (*Product, error) {
+	for r.lines.Scan() {
+		r.line++
+		line := r.lines.Bytes()
+		if len(strings.TrimSpace(string(line))) == 0 {
+			continue
+		}
+		var jp jsonProduct
+		if err := json.Unmarshal(line, &jp); err != nil {
+			return nil, &RecordError{r.line, err}
+		}
+		n := jp.Nutriments
+		p := &Product{
+			Code:            jp.Code,
+			Name:            strings.TrimSpace(jp.Name),
+			Brands:          jp.Brands,
+			Quantity:        jp.Quantity,
+			ServingSize:     jp.ServingSize,
+			ServingQuantity: toFloat(jp.ServingQuantity),
+
+			EnergyKJ:      toFloat(n["energy_100g"]),
+			Fat:           toFloat(n["fat_100g"]),
+			Carbohydrates: toFloat(n["carbohydrates_100g"]),
+			Sugars:        toFloat(n["sugars_100g"]),
+			Proteins:      toFloat(n["proteins_100g"]),
+			Salt:          toFloat(n["salt_100g"]),
+		}
+		if p.EnergyKJ == 0 {
+			p.EnergyKJ = toFloat(n["energy-kj_100g"])
+		}
+		if p.EnergyKJ == 0 {
+			p.EnergyKJ = toFloat(n["energy-kcal_100g"]) * 4.184
+		}
+		return p, nil
+	}
+	if err := r.lines.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
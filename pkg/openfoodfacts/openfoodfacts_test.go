@@ -0,0 +1,102 @@
+// Copyright (C) 2019 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package openfoodfacts
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+const testCSV = "code\tproduct_name\tbrands\tquantity\tserving_quantity\tenergy_100g\tfat_100g\tproteins_100g\n" +
+	"3017620422003\tNutella\tFerrero\t400 g\t15\t2252\t30.9\t6.3\n" +
+	"5449000000996\tCoca-Cola\tCoca-Cola\t330 ml\t330\t180\t0\t0\n"
+
+const testJSONL = `{"code":"3017620422003","product_name":"Nutella","brands":"Ferrero","serving_quantity":"15","nutriments":{"energy_100g":2252,"fat_100g":30.9}}
+
+{"code":"4000417025005","product_name":"Ritter Sport","brands":"Ritter","serving_quantity":"","nutriments":{"energy-kcal_100g":"500"}}
+`
+
+func readAll(t *testing.T, data, format string) []*Product {
+	r, err := NewReader(strings.NewReader(data), format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ps []*Product
+	for {
+		p, err := r.Next()
+		if err == io.EOF {
+			return ps
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		ps = append(ps, p)
+	}
+}
+
+func TestReadCSV(t *testing.T) {
+	ps := readAll(t, testCSV, FormatCSV)
+	if len(ps) != 2 {
+		t.Fatal("expected 2 products, got", len(ps))
+	}
+	if ps[0].Code != "3017620422003" || ps[0].EnergyKJ != 2252 || ps[0].ServingQuantity != 15 {
+		t.Error("unexpected product:", ps[0])
+	}
+	if ps[0].FullName() != "Nutella (Ferrero)" {
+		t.Error("unexpected name:", ps[0].FullName())
+	}
+	if ps[1].FullName() != "Coca-Cola" || !ps[1].IsLiquid() {
+		t.Error("unexpected product:", ps[1])
+	}
+}
+
+func TestReadJSONL(t *testing.T) {
+	ps := readAll(t, testJSONL, FormatJSONL)
+	if len(ps) != 2 {
+		t.Fatal("expected 2 products, got", len(ps))
+	}
+	if ps[0].Fat != 30.9 || ps[0].ServingQuantity != 15 {
+		t.Error("unexpected product:", ps[0])
+	}
+	if ps[1].EnergyKJ != 2092 {
+		t.Error("unexpected energy:", ps[1].EnergyKJ)
+	}
+}
+
+func TestReadJSONLBadRecord(t *testing.T) {
+	data := `{"code":"1","product_name":"A","serving_quantity":12.5}
+{"code":"2","product_name":
+{"code":3,"product_name":"C"}
+{"code":"4","product_name":"D","serving_quantity":null}
+`
+	r, err := NewReader(strings.NewReader(data), FormatJSONL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var codes []string
+	var lines []int
+	for {
+		p, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if rerr, ok := err.(*RecordError); ok {
+			lines = append(lines, rerr.Line)
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		codes = append(codes, p.Code)
+	}
+	if len(codes) != 2 || codes[0] != "1" || codes[1] != "4" {
+		t.Error("unexpected products:", codes)
+	}
+	if len(lines) != 2 || lines[0] != 2 || lines[1] != 3 {
+		t.Error("unexpected bad records:", lines)
+	}
+}
@@ -5,15 +5,124 @@
 
 package schema
 
-import "time"
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sarifsystems/sarif/sarif"
+)
 
 type MusicInfo struct {
 	IsPlaying bool      `json:"is_playing"`
 	Device    string    `json:"device,omitempty"`
+	Player    string    `json:"player,omitempty"`
 	Time      time.Time `json:"time,omitempty"`
 
 	Artist   string `json:"artist,omitempty"`
 	Album    string `json:"album,omitempty"`
 	Track    string `json:"track,omitempty"`
 	Duration int    `json:"duration,omitempty"`
+	Position int    `json:"position,omitempty"`
+	Volume   int    `json:"volume,omitempty"`
+}
+
+func (i MusicInfo) Text() string {
+	if i.Track == "" {
+		if i.Player != "" {
+			return i.Player + " is not playing anything."
+		}
+		return "Nothing is playing."
+	}
+
+	s := i.Track
+	if i.Artist != "" {
+		s += " by " + i.Artist
+	}
+	if !i.IsPlaying {
+		s += " (paused)"
+	}
+	return s
+}
+
+// ShouldScrobble reports whether a track has been listened to long enough
+// to be scrobbled, following the Last.fm rules.
+func (i MusicInfo) ShouldScrobble(played time.Duration) bool {
+	if i.Track == "" || i.Duration < 30 {
+		return false
+	}
+	return played >= time.Duration(i.Duration)*time.Second/2 || played >= 4*time.Minute
+}
+
+// MusicCommand is the payload of the music/* control actions.
+type MusicCommand struct {
+	Player   string `json:"player,omitempty"`
+	Position int    `json:"position,omitempty"`
+	Volume   int    `json:"volume,omitempty"`
+	Relative bool   `json:"relative,omitempty"`
+}
+
+// DecodeMusicCommand reads the music/* payload. The target player can either
+// be given in the payload or as action suffix, e.g. "music/play/vlc".
+func DecodeMusicCommand(msg sarif.Message) MusicCommand {
+	var cmd MusicCommand
+	msg.DecodePayload(&cmd)
+	if cmd.Player == "" {
+		if parts := strings.SplitN(msg.Action, "/", 3); len(parts) == 3 {
+			cmd.Player = parts[2]
+		}
+	}
+	return cmd
+}
+
+// DefaultMusicPlayer handles music/* requests without a player as long as
+// no player has been active.
+const DefaultMusicPlayer = "mpd"
+
+// ActiveMusicPlayer remembers the most recently active player across all
+// music backends. Requests without a player are only handled by it, so
+// that a single backend acts and replies.
+type ActiveMusicPlayer struct {
+	mu   sync.Mutex
+	name string
+}
+
+// Update records the player of a started or changed track.
+func (a *ActiveMusicPlayer) Update(info MusicInfo) {
+	if !info.IsPlaying || info.Player == "" {
+		return
+	}
+	a.mu.Lock()
+	a.name = info.Player
+	a.mu.Unlock()
+}
+
+// Name returns the most recently active player or DefaultMusicPlayer.
+func (a *ActiveMusicPlayer) Name() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.name == "" {
+		return DefaultMusicPlayer
+	}
+	return a.name
+}
+
+// Target returns the player that should handle a command.
+func (a *ActiveMusicPlayer) Target(cmd MusicCommand) string {
+	if cmd.Player != "" {
+		return cmd.Player
+	}
+	return a.Name()
+}
+
+// HandleEvent updates the active player from music/started and
+// music/changed events of all backends.
+func (a *ActiveMusicPlayer) HandleEvent(msg sarif.Message) {
+	if !msg.IsAction("music/started") && !msg.IsAction("music/changed") {
+		return
+	}
+	var info MusicInfo
+	if err := msg.DecodePayload(&info); err == nil {
+		a.Update(info)
+	}
 }
@@ -0,0 +1,73 @@
+// Copyright (C) 2017 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package schema_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sarifsystems/sarif/pkg/schema"
+	"github.com/sarifsystems/sarif/sarif"
+)
+
+func TestMusicInfoShouldScrobble(t *testing.T) {
+	tests := []struct {
+		Info   schema.MusicInfo
+		Played time.Duration
+		Expect bool
+	}{
+		{schema.MusicInfo{Track: "Short", Duration: 20}, 20 * time.Second, false},
+		{schema.MusicInfo{Track: "Song", Duration: 200}, 60 * time.Second, false},
+		{schema.MusicInfo{Track: "Song", Duration: 200}, 100 * time.Second, true},
+		{schema.MusicInfo{Track: "Long", Duration: 3600}, 5 * time.Minute, true},
+		{schema.MusicInfo{Duration: 200}, 200 * time.Second, false},
+	}
+
+	for _, test := range tests {
+		if got := test.Info.ShouldScrobble(test.Played); got != test.Expect {
+			t.Errorf("%s played %s: expected %v, got %v", test.Info.Track, test.Played, test.Expect, got)
+		}
+	}
+}
+
+func TestDecodeMusicCommand(t *testing.T) {
+	tests := []struct {
+		Action  string
+		Payload interface{}
+		Player  string
+	}{
+		{"music/play", nil, ""},
+		{"music/play/vlc", nil, "vlc"},
+		{"music/play/vlc", schema.MusicCommand{Player: "mpd"}, "mpd"},
+		{"music/volume", schema.MusicCommand{Volume: 10}, ""},
+	}
+	for _, test := range tests {
+		cmd := schema.DecodeMusicCommand(sarif.CreateMessage(test.Action, test.Payload))
+		if cmd.Player != test.Player {
+			t.Errorf("%s %v: expected player %q, got %q", test.Action, test.Payload, test.Player, cmd.Player)
+		}
+	}
+}
+
+func TestActiveMusicPlayer(t *testing.T) {
+	var a schema.ActiveMusicPlayer
+	if name := a.Target(schema.MusicCommand{}); name != schema.DefaultMusicPlayer {
+		t.Errorf("expected default player, got %q", name)
+	}
+
+	a.HandleEvent(sarif.CreateMessage("music/started", schema.MusicInfo{IsPlaying: true, Player: "vlc"}))
+	if name := a.Target(schema.MusicCommand{}); name != "vlc" {
+		t.Errorf("expected started player, got %q", name)
+	}
+	a.HandleEvent(sarif.CreateMessage("music/stopped", schema.MusicInfo{Player: "mpd"}))
+	a.Update(schema.MusicInfo{IsPlaying: false, Player: "spotify"})
+	if name := a.Target(schema.MusicCommand{}); name != "vlc" {
+		t.Errorf("expected stopped players to be ignored, got %q", name)
+	}
+	if name := a.Target(schema.MusicCommand{Player: "mpd"}); name != "mpd" {
+		t.Errorf("expected explicit player, got %q", name)
+	}
+}
@@ -0,0 +1,36 @@
+// Copyright (C) 2017 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package schema
+
+const (
+	UrgencyLow      = "low"
+	UrgencyNormal   = "normal"
+	UrgencyCritical = "critical"
+)
+
+// Notification is a message shown to the user, e.g. on the desktop.
+// When one of the actions is chosen, its reply action and payload are sent
+// back to the sender of the notification.
+type Notification struct {
+	Title   string `json:"title,omitempty"`
+	Body    string `json:"body,omitempty"`
+	Icon    string `json:"icon,omitempty"`
+	Urgency string `json:"urgency,omitempty"`
+	Timeout string `json:"timeout,omitempty"`
+
+	Actions     []Action     `json:"actions,omitempty"`
+	Attachments []Attachment `json:"attachments,omitempty"`
+}
+
+func (n Notification) Text() string {
+	if n.Body == "" {
+		return n.Title
+	}
+	if n.Title == "" {
+		return n.Body
+	}
+	return n.Title + ": " + n.Body
+}
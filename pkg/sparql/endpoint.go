@@ -85,7 +85,8 @@ type ResourceResponse struct {
 
 type Resource struct {
 	Type     string `json:"type"`
-	DataType string `json:"datatype"`
+	DataType string `json:"datatype,omitempty"`
+	Lang     string `json:"xml:lang,omitempty"`
 	Value    string `json:"value"`
 }
 
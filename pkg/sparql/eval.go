@@ -0,0 +1,417 @@
+// Copyright (C) 2017 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package sparql
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Graph is a source of RDF statements that can be queried locally.
+type Graph interface {
+	// Match returns all statements matching the pattern, nil matching
+	// any term.
+	Match(subj, pred, obj *Resource) ([]Statement, error)
+}
+
+// MemoryGraph is a simple in-memory graph.
+type MemoryGraph struct {
+	mutex      sync.RWMutex
+	statements []Statement
+	index      map[string]struct{}
+}
+
+func NewMemoryGraph(stmts ...Statement) *MemoryGraph {
+	g := &MemoryGraph{
+		index: make(map[string]struct{}),
+	}
+	g.Add(stmts...)
+	return g
+}
+
+func (g *MemoryGraph) Add(stmts ...Statement) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	for _, st := range stmts {
+		key := st.String()
+		if _, ok := g.index[key]; ok {
+			continue
+		}
+		g.index[key] = struct{}{}
+		g.statements = append(g.statements, st)
+	}
+}
+
+func (g *MemoryGraph) Statements() []Statement {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+	return append([]Statement(nil), g.statements...)
+}
+
+func (g *MemoryGraph) Match(subj, pred, obj *Resource) ([]Statement, error) {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+	result := make([]Statement, 0)
+	for _, st := range g.statements {
+		if st.Matches(subj, pred, obj) {
+			result = append(result, st)
+		}
+	}
+	return result, nil
+}
+
+type evaluator struct {
+	graph Graph
+}
+
+func (e evaluator) group(g *group, rows []Row) ([]Row, error) {
+	var err error
+	for _, el := range g.Elements {
+		switch el := el.(type) {
+		case Pattern:
+			if rows, err = e.pattern(el, rows); err != nil {
+				return nil, err
+			}
+		case optional:
+			result := make([]Row, 0, len(rows))
+			for _, row := range rows {
+				sub, err := e.group(el.group, []Row{row})
+				if err != nil {
+					return nil, err
+				}
+				if len(sub) == 0 {
+					result = append(result, row)
+				}
+				result = append(result, sub...)
+			}
+			rows = result
+		}
+	}
+
+	if len(g.Filters) == 0 {
+		return rows, nil
+	}
+	result := make([]Row, 0, len(rows))
+	for _, row := range rows {
+		keep := true
+		for _, f := range g.Filters {
+			v, ok := f.eval(row)
+			if !ok || !effectiveBool(v) {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			result = append(result, row)
+		}
+	}
+	return result, nil
+}
+
+func resolveNode(n Node, row Row) *Resource {
+	if !n.IsVar() {
+		t := n.Term
+		return &t
+	}
+	if v, ok := row[n.Var]; ok {
+		return &v
+	}
+	return nil
+}
+
+func bindNode(n Node, v Resource, row Row) bool {
+	if !n.IsVar() {
+		return true
+	}
+	if existing, ok := row[n.Var]; ok {
+		return existing.Equal(v)
+	}
+	row[n.Var] = v
+	return true
+}
+
+func (e evaluator) pattern(pat Pattern, rows []Row) ([]Row, error) {
+	result := make([]Row, 0)
+	for _, row := range rows {
+		stmts, err := e.graph.Match(
+			resolveNode(pat.Subject, row),
+			resolveNode(pat.Predicate, row),
+			resolveNode(pat.Object, row),
+		)
+		if err != nil {
+			return nil, err
+		}
+		for _, st := range stmts {
+			r := make(Row, len(row)+3)
+			for k, v := range row {
+				r[k] = v
+			}
+			if bindNode(pat.Subject, st.Subject, r) &&
+				bindNode(pat.Predicate, st.Predicate, r) &&
+				bindNode(pat.Object, st.Object, r) {
+				result = append(result, r)
+			}
+		}
+	}
+	return result, nil
+}
+
+// expr is a FILTER expression. Evaluation returns false if the expression
+// raised an error, e.g. by referencing an unbound variable.
+type expr interface {
+	eval(row Row) (Resource, bool)
+}
+
+type varExpr string
+
+func (e varExpr) eval(row Row) (Resource, bool) {
+	v, ok := row[string(e)]
+	return v, ok
+}
+
+type constExpr struct {
+	Resource
+}
+
+func (e constExpr) eval(row Row) (Resource, bool) {
+	return e.Resource, true
+}
+
+type notExpr struct {
+	e expr
+}
+
+func (e notExpr) eval(row Row) (Resource, bool) {
+	v, ok := e.e.eval(row)
+	if !ok {
+		return v, false
+	}
+	return boolean(!effectiveBool(v)), true
+}
+
+type binaryExpr struct {
+	op   string
+	l, r expr
+}
+
+func (e binaryExpr) eval(row Row) (Resource, bool) {
+	l, lok := e.l.eval(row)
+	switch e.op {
+	case "&&":
+		if lok && !effectiveBool(l) {
+			return boolean(false), true
+		}
+		r, rok := e.r.eval(row)
+		if rok && !effectiveBool(r) {
+			return boolean(false), true
+		}
+		return boolean(true), lok && rok
+	case "||":
+		if lok && effectiveBool(l) {
+			return boolean(true), true
+		}
+		r, rok := e.r.eval(row)
+		if rok && effectiveBool(r) {
+			return boolean(true), true
+		}
+		return boolean(false), lok && rok
+	}
+
+	r, rok := e.r.eval(row)
+	if !lok || !rok {
+		return Resource{}, false
+	}
+	c, ok := compare(l, r)
+	if !ok {
+		if e.op == "=" {
+			return boolean(l.Equal(r)), true
+		}
+		if e.op == "!=" {
+			return boolean(!l.Equal(r)), true
+		}
+		return Resource{}, false
+	}
+	switch e.op {
+	case "=":
+		return boolean(c == 0), true
+	case "!=":
+		return boolean(c != 0), true
+	case "<":
+		return boolean(c < 0), true
+	case "<=":
+		return boolean(c <= 0), true
+	case ">":
+		return boolean(c > 0), true
+	case ">=":
+		return boolean(c >= 0), true
+	}
+	return Resource{}, false
+}
+
+func isNumeric(r Resource) bool {
+	switch r.DataType {
+	case XSDInteger, XSDDecimal, XSDDouble,
+		"http://www.w3.org/2001/XMLSchema#int",
+		"http://www.w3.org/2001/XMLSchema#long",
+		"http://www.w3.org/2001/XMLSchema#float":
+		return true
+	}
+	return false
+}
+
+// compare orders two terms if they are comparable: numbers by value,
+// plain literals lexically.
+func compare(l, r Resource) (int, bool) {
+	if !l.IsLiteral() || !r.IsLiteral() {
+		return 0, false
+	}
+	if isNumeric(l) || isNumeric(r) {
+		a, aerr := strconv.ParseFloat(l.Value, 64)
+		b, berr := strconv.ParseFloat(r.Value, 64)
+		if aerr != nil || berr != nil {
+			return 0, false
+		}
+		switch {
+		case a < b:
+			return -1, true
+		case a > b:
+			return 1, true
+		}
+		return 0, true
+	}
+	if l.Lang != r.Lang || l.DataType != r.DataType {
+		return 0, false
+	}
+	return strings.Compare(l.Value, r.Value), true
+}
+
+func boolean(b bool) Resource {
+	return Resource{Type: TypeLiteral, Value: strconv.FormatBool(b), DataType: XSDBoolean}
+}
+
+func effectiveBool(r Resource) bool {
+	if !r.IsLiteral() {
+		return true
+	}
+	switch {
+	case r.DataType == XSDBoolean:
+		return r.Value == "true" || r.Value == "1"
+	case isNumeric(r):
+		f, err := strconv.ParseFloat(r.Value, 64)
+		return err == nil && f != 0
+	}
+	return r.Value != ""
+}
+
+type callExpr struct {
+	name string
+	args []expr
+}
+
+var functions = map[string]func(row Row, args []expr) (Resource, bool){
+	"bound": func(row Row, args []expr) (Resource, bool) {
+		if len(args) != 1 {
+			return Resource{}, false
+		}
+		v, ok := args[0].(varExpr)
+		if !ok {
+			return Resource{}, false
+		}
+		_, bound := row[string(v)]
+		return boolean(bound), true
+	},
+	"lang": stringFunc(1, func(vs []Resource) (Resource, bool) {
+		return Literal(vs[0].Lang), vs[0].IsLiteral()
+	}),
+	"langmatches": stringFunc(2, func(vs []Resource) (Resource, bool) {
+		tag, rng := strings.ToLower(vs[0].Value), strings.ToLower(vs[1].Value)
+		// Local facts seldom carry language tags, so untagged literals
+		// match any language range.
+		if tag == "" || rng == "*" {
+			return boolean(true), true
+		}
+		return boolean(tag == rng || strings.HasPrefix(tag, rng+"-")), true
+	}),
+	"str": stringFunc(1, func(vs []Resource) (Resource, bool) {
+		return Literal(vs[0].Value), true
+	}),
+	"lcase": stringFunc(1, func(vs []Resource) (Resource, bool) {
+		return Literal(strings.ToLower(vs[0].Value)), true
+	}),
+	"ucase": stringFunc(1, func(vs []Resource) (Resource, bool) {
+		return Literal(strings.ToUpper(vs[0].Value)), true
+	}),
+	"contains": stringFunc(2, func(vs []Resource) (Resource, bool) {
+		return boolean(strings.Contains(vs[0].Value, vs[1].Value)), true
+	}),
+	"strstarts": stringFunc(2, func(vs []Resource) (Resource, bool) {
+		return boolean(strings.HasPrefix(vs[0].Value, vs[1].Value)), true
+	}),
+	"strends": stringFunc(2, func(vs []Resource) (Resource, bool) {
+		return boolean(strings.HasSuffix(vs[0].Value, vs[1].Value)), true
+	}),
+	"isiri": stringFunc(1, func(vs []Resource) (Resource, bool) {
+		return boolean(vs[0].Type == TypeURI), true
+	}),
+	"isuri": stringFunc(1, func(vs []Resource) (Resource, bool) {
+		return boolean(vs[0].Type == TypeURI), true
+	}),
+	"isblank": stringFunc(1, func(vs []Resource) (Resource, bool) {
+		return boolean(vs[0].Type == TypeBlank), true
+	}),
+	"isliteral": stringFunc(1, func(vs []Resource) (Resource, bool) {
+		return boolean(vs[0].IsLiteral()), true
+	}),
+	"regex": func(row Row, args []expr) (Resource, bool) {
+		if len(args) < 2 || len(args) > 3 {
+			return Resource{}, false
+		}
+		vs, ok := evalArgs(row, args)
+		if !ok {
+			return Resource{}, false
+		}
+		pattern := vs[1].Value
+		if len(vs) == 3 && strings.Contains(vs[2].Value, "i") {
+			pattern = "(?i)" + pattern
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return Resource{}, false
+		}
+		return boolean(re.MatchString(vs[0].Value)), true
+	},
+}
+
+func evalArgs(row Row, args []expr) ([]Resource, bool) {
+	vs := make([]Resource, len(args))
+	for i, a := range args {
+		v, ok := a.eval(row)
+		if !ok {
+			return nil, false
+		}
+		vs[i] = v
+	}
+	return vs, true
+}
+
+func stringFunc(n int, f func(vs []Resource) (Resource, bool)) func(row Row, args []expr) (Resource, bool) {
+	return func(row Row, args []expr) (Resource, bool) {
+		if len(args) != n {
+			return Resource{}, false
+		}
+		vs, ok := evalArgs(row, args)
+		if !ok {
+			return Resource{}, false
+		}
+		return f(vs)
+	}
+}
+
+func (e callExpr) eval(row Row) (Resource, bool) {
+	return functions[e.name](row, e.args)
+}
@@ -0,0 +1,163 @@
+// Copyright (C) 2017 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package sparql
+
+import (
+	"fmt"
+	"strings"
+)
+
+type tokenType int
+
+const (
+	tokEOF tokenType = iota
+	tokIRI
+	tokString
+	tokVar
+	tokName
+	tokAt
+	tokPunct
+)
+
+type token struct {
+	typ tokenType
+	val string
+	pos int
+}
+
+func (t token) String() string {
+	switch t.typ {
+	case tokEOF:
+		return "end of input"
+	case tokIRI:
+		return "<" + t.val + ">"
+	case tokString:
+		return `"` + t.val + `"`
+	case tokVar:
+		return "?" + t.val
+	case tokAt:
+		return "@" + t.val
+	}
+	return "'" + t.val + "'"
+}
+
+func errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("sparql: "+format, args...)
+}
+
+func isNameChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		c == '_' || c == '-' || c == ':' || c == '.' || c == '%' || c >= 0x80
+}
+
+func isNameStart(c byte) bool {
+	return isNameChar(c) && c != '.' && c != '-' && c != '%'
+}
+
+var punctuation = []string{"^^", "&&", "||", "!=", "<=", ">=", "{", "}", "(", ")", "[", "]", ".", ",", ";", "*", "=", "<", ">", "!", "+", "-", "/"}
+
+// lex splits SPARQL and Turtle documents into tokens.
+func lex(s string) ([]token, error) {
+	toks := make([]token, 0)
+	i := 0
+	for i < len(s) {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+			continue
+
+		case c == '#':
+			for i < len(s) && s[i] != '\n' {
+				i++
+			}
+			continue
+
+		case c == '<':
+			if end := strings.IndexByte(s[i:], '>'); end > 1 && !strings.ContainsAny(s[i+1:i+end], " \t\n\"<{}") {
+				toks = append(toks, token{tokIRI, s[i+1 : i+end], i})
+				i += end + 1
+				continue
+			}
+
+		case c == '"' || c == '\'':
+			q := string(c)
+			if strings.HasPrefix(s[i:], q+q+q) {
+				q = q + q + q
+			}
+			j := i + len(q)
+			for {
+				if j >= len(s) {
+					return nil, errorf("unterminated string at offset %d", i)
+				}
+				if s[j] == '\\' {
+					j += 2
+					continue
+				}
+				if strings.HasPrefix(s[j:], q) {
+					break
+				}
+				if len(q) == 1 && s[j] == '\n' {
+					return nil, errorf("unterminated string at offset %d", i)
+				}
+				j++
+			}
+			v, err := unescapeString(s[i+len(q) : j])
+			if err != nil {
+				return nil, err
+			}
+			toks = append(toks, token{tokString, v, i})
+			i = j + len(q)
+			continue
+
+		case (c == '?' || c == '$') && i+1 < len(s) && isNameChar(s[i+1]):
+			j := i + 1
+			for j < len(s) && isNameChar(s[j]) && s[j] != '.' && s[j] != ':' {
+				j++
+			}
+			toks = append(toks, token{tokVar, s[i+1 : j], i})
+			i = j
+			continue
+
+		case c == '@':
+			j := i + 1
+			for j < len(s) && (isNameChar(s[j]) && s[j] != ':' && s[j] != '.') {
+				j++
+			}
+			toks = append(toks, token{tokAt, s[i+1 : j], i})
+			i = j
+			continue
+
+		case (c == '-' || c == '+') && i+1 < len(s) && s[i+1] >= '0' && s[i+1] <= '9',
+			isNameStart(c):
+			j := i + 1
+			for j < len(s) && isNameChar(s[j]) {
+				j++
+			}
+			// A trailing dot terminates the statement.
+			for j > i+1 && s[j-1] == '.' {
+				j--
+			}
+			toks = append(toks, token{tokName, s[i:j], i})
+			i = j
+			continue
+		}
+
+		found := false
+		for _, p := range punctuation {
+			if strings.HasPrefix(s[i:], p) {
+				toks = append(toks, token{tokPunct, p, i})
+				i += len(p)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, errorf("unexpected character %q at offset %d", c, i)
+		}
+	}
+	return append(toks, token{tokEOF, "", len(s)}), nil
+}
@@ -0,0 +1,238 @@
+// Copyright (C) 2017 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package sparql
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// LocalEndpoint evaluates a subset of SPARQL against a local graph:
+// basic graph patterns, OPTIONAL, FILTER, DISTINCT, LIMIT and OFFSET.
+type LocalEndpoint struct {
+	Graph Graph
+}
+
+func NewLocalEndpoint(g Graph) *LocalEndpoint {
+	return &LocalEndpoint{
+		Graph: g,
+	}
+}
+
+func (p *LocalEndpoint) Query() *Query {
+	return New(p)
+}
+
+// SelectResponse mirrors the SPARQL 1.1 JSON results format.
+type SelectResponse struct {
+	Head struct {
+		Vars []string `json:"vars"`
+	} `json:"head"`
+	Results struct {
+		Bindings []Row `json:"bindings"`
+	} `json:"results"`
+}
+
+// Exec runs a SELECT or DESCRIBE query and returns a *SelectResponse or
+// the RDF/JSON description respectively.
+func (p *LocalEndpoint) Exec(q string) (interface{}, error) {
+	pq, err := parseQuery(q)
+	if err != nil {
+		return nil, err
+	}
+	if pq.Type == "describe" {
+		return p.describe(pq)
+	}
+	return p.selectRows(pq)
+}
+
+func (p *LocalEndpoint) Select(q string, result interface{}) error {
+	pq, err := parseQuery(q)
+	if err != nil {
+		return err
+	}
+	if pq.Type != "select" {
+		return errorf("expected SELECT query")
+	}
+	r, err := p.selectRows(pq)
+	if err != nil {
+		return err
+	}
+	return convert(r, result)
+}
+
+func (p *LocalEndpoint) selectRows(pq *parsedQuery) (*SelectResponse, error) {
+	rows, err := evaluator{p.Graph}.group(pq.Where, []Row{{}})
+	if err != nil {
+		return nil, err
+	}
+
+	r := &SelectResponse{}
+	r.Head.Vars = pq.Vars
+	if len(r.Head.Vars) == 0 {
+		r.Head.Vars = collectVars(pq.Where)
+	}
+	r.Results.Bindings = make([]Row, 0, len(rows))
+	seen := make(map[string]struct{})
+	skipped := 0
+	for _, row := range rows {
+		if pq.Limit > 0 && len(r.Results.Bindings) >= pq.Limit {
+			break
+		}
+		proj := make(Row, len(r.Head.Vars))
+		for _, v := range r.Head.Vars {
+			if b, ok := row[v]; ok {
+				proj[v] = b
+			}
+		}
+		if pq.Distinct {
+			key := rowKey(r.Head.Vars, proj)
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+		}
+		if skipped < pq.Offset {
+			skipped++
+			continue
+		}
+		r.Results.Bindings = append(r.Results.Bindings, proj)
+	}
+	return r, nil
+}
+
+// Describe returns all statements about the described resources in the
+// RDF/JSON format, a map of subjects to predicates to objects.
+func (p *LocalEndpoint) Describe(q string, result interface{}) error {
+	pq, err := parseQuery(q)
+	if err != nil {
+		return err
+	}
+	if pq.Type != "describe" {
+		return errorf("expected DESCRIBE query")
+	}
+	desc, err := p.describe(pq)
+	if err != nil {
+		return err
+	}
+	return convert(desc, result)
+}
+
+// Description maps subjects to predicates to objects, as in RDF/JSON.
+type Description map[string]map[string][]Resource
+
+func (p *LocalEndpoint) describe(pq *parsedQuery) (Description, error) {
+	rows, err := evaluator{p.Graph}.group(pq.Where, []Row{{}})
+	if err != nil {
+		return nil, err
+	}
+	nodes := pq.Describe
+	if len(nodes) == 0 {
+		for _, v := range collectVars(pq.Where) {
+			nodes = append(nodes, Node{Var: v})
+		}
+	}
+	resources := make([]Resource, 0)
+	for _, n := range nodes {
+		if !n.IsVar() {
+			resources = append(resources, n.Term)
+			continue
+		}
+		for _, row := range rows {
+			if v, ok := row[n.Var]; ok && !v.IsLiteral() {
+				resources = append(resources, v)
+			}
+		}
+	}
+
+	desc := make(Description)
+	for _, res := range resources {
+		key := res.Value
+		if res.Type == TypeBlank {
+			key = "_:" + key
+		}
+		if _, ok := desc[key]; ok {
+			continue
+		}
+		r := res
+		stmts, err := p.Graph.Match(&r, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		preds := make(map[string][]Resource)
+		for _, st := range stmts {
+			preds[st.Predicate.Value] = append(preds[st.Predicate.Value], st.Object)
+		}
+		desc[key] = preds
+	}
+	return desc, nil
+}
+
+func convert(v interface{}, result interface{}) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, result)
+}
+
+func rowKey(vars []string, row Row) string {
+	parts := make([]string, len(vars))
+	for i, v := range vars {
+		if b, ok := row[v]; ok {
+			parts[i] = b.String()
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// collectVars lists all named variables of a group in order of appearance.
+func collectVars(g *group) []string {
+	vars := make([]string, 0)
+	seen := make(map[string]struct{})
+	add := func(n Node) {
+		if !n.IsVar() || strings.HasPrefix(n.Var, "_") {
+			return
+		}
+		if _, ok := seen[n.Var]; !ok {
+			seen[n.Var] = struct{}{}
+			vars = append(vars, n.Var)
+		}
+	}
+	var walk func(g *group)
+	walk = func(g *group) {
+		for _, el := range g.Elements {
+			switch el := el.(type) {
+			case Pattern:
+				add(el.Subject)
+				add(el.Predicate)
+				add(el.Object)
+			case optional:
+				walk(el.group)
+			}
+		}
+	}
+	walk(g)
+	return vars
+}
+
+// SortedPrefixes returns the prefix names of a namespace map, longest
+// namespace first so that compaction prefers the most specific prefix.
+func SortedPrefixes(ns map[string]string) []string {
+	names := make([]string, 0, len(ns))
+	for p := range ns {
+		names = append(names, p)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, b := ns[names[i]], ns[names[j]]
+		if len(a) != len(b) {
+			return len(a) > len(b)
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
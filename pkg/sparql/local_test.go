@@ -0,0 +1,145 @@
+// Copyright (C) 2017 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package sparql
+
+import (
+	"strings"
+	"testing"
+)
+
+const testData = `
+@prefix ex: <http://example.org/> .
+
+ex:alice a foaf:Person ;
+	foaf:name "Alice" ;
+	foaf:age 32 ;
+	foaf:knows ex:bob, ex:carol .
+
+ex:bob a foaf:Person ;
+	foaf:name "Bob"@en ;
+	foaf:age 25 .
+
+ex:carol a foaf:Person ;
+	foaf:age 41 .
+`
+
+func testEndpoint(t *testing.T) *LocalEndpoint {
+	stmts, _, err := ParseTurtle(strings.NewReader(testData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewLocalEndpoint(NewMemoryGraph(stmts...))
+}
+
+func TestLocalSelect(t *testing.T) {
+	ep := testEndpoint(t)
+
+	tests := []struct {
+		query    string
+		expected []string
+	}{
+		{
+			`PREFIX ex: <http://example.org/>
+			SELECT ?name WHERE { ex:alice foaf:knows ?p . ?p foaf:name ?name }`,
+			[]string{"Bob"},
+		},
+		{
+			`PREFIX ex: <http://example.org/>
+			SELECT ?p ?name WHERE {
+				ex:alice foaf:knows ?p .
+				OPTIONAL { ?p foaf:name ?name }
+			}`,
+			[]string{"http://example.org/bob Bob", "http://example.org/carol"},
+		},
+		{
+			`SELECT ?p WHERE { ?p foaf:age ?age . FILTER (?age > 30 && ?age < 40) }`,
+			[]string{"http://example.org/alice"},
+		},
+		{
+			`SELECT ?p WHERE { ?p a foaf:Person . OPTIONAL { ?p foaf:name ?n } FILTER (!bound(?n)) }`,
+			[]string{"http://example.org/carol"},
+		},
+		{
+			`SELECT ?n WHERE { ?p foaf:name ?n . FILTER regex(?n, "^a", "i") }`,
+			[]string{"Alice"},
+		},
+		{
+			`SELECT DISTINCT ?t WHERE { ?p a ?t } LIMIT 5`,
+			[]string{"http://xmlns.com/foaf/0.1/Person"},
+		},
+		{
+			`SELECT ?p WHERE { ?p a foaf:Person } LIMIT 2`,
+			[]string{"http://example.org/alice", "http://example.org/bob"},
+		},
+	}
+
+	for _, test := range tests {
+		var r SelectResponse
+		if err := ep.Select(test.query, &r); err != nil {
+			t.Errorf("%s: %s", test.query, err)
+			continue
+		}
+		got := make([]string, 0)
+		for _, row := range r.Results.Bindings {
+			vals := make([]string, 0)
+			for _, v := range r.Head.Vars {
+				if b, ok := row[v]; ok {
+					vals = append(vals, b.Value)
+				}
+			}
+			got = append(got, strings.Join(vals, " "))
+		}
+		if strings.Join(got, "|") != strings.Join(test.expected, "|") {
+			t.Errorf("%s:\nexpected %v\ngot %v", test.query, test.expected, got)
+		}
+	}
+}
+
+func TestLocalQueryBuilder(t *testing.T) {
+	ep := testEndpoint(t)
+
+	var r ResourceResponse
+	err := ep.Query().
+		Prefix("ex", "http://example.org/").
+		Where("ex:bob", "foaf:name", "?name").
+		FilterLang("?name", "EN").
+		Limit(1).
+		Exec(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Results.Bindings) != 1 || r.Results.Bindings[0]["name"].Value != "Bob" {
+		t.Errorf("unexpected result: %v", r.Results.Bindings)
+	}
+}
+
+func TestLocalDescribe(t *testing.T) {
+	ep := testEndpoint(t)
+
+	var r map[string]map[string][]Resource
+	if err := ep.Query().Prefix("ex", "http://example.org/").Describe("ex:bob").Exec(&r); err != nil {
+		t.Fatal(err)
+	}
+	bob := r["http://example.org/bob"]
+	if len(bob) != 3 || bob["http://xmlns.com/foaf/0.1/age"][0].Value != "25" {
+		t.Errorf("unexpected description: %v", r)
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	queries := []string{
+		`SELECT WHERE { ?s ?p ?o }`,
+		`SELECT * WHERE { ?s ?p ?o`,
+		`SELECT * WHERE { ?s unknown:p ?o }`,
+		`SELECT * WHERE { ?s ?p ?o FILTER nosuchfunc(?o) }`,
+		`CONSTRUCT { ?s ?p ?o }`,
+	}
+	for _, q := range queries {
+		if _, err := parseQuery(q); err == nil {
+			t.Errorf("%s: expected error", q)
+		}
+	}
+}
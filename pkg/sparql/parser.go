@@ -0,0 +1,524 @@
+// Copyright (C) 2017 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package sparql
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Node is a term in a triple pattern, either a variable or a fixed term.
+type Node struct {
+	Var  string
+	Term Resource
+}
+
+func (n Node) IsVar() bool {
+	return n.Var != ""
+}
+
+// Pattern is a triple pattern of a basic graph pattern.
+type Pattern struct {
+	Subject, Predicate, Object Node
+}
+
+// group is a group graph pattern: a sequence of triple patterns and
+// optional groups, constrained by filters.
+type group struct {
+	Elements []interface{}
+	Filters  []expr
+}
+
+type optional struct {
+	*group
+}
+
+type parsedQuery struct {
+	Type     string
+	Vars     []string
+	Describe []Node
+	Distinct bool
+	Where    *group
+	Limit    int
+	Offset   int
+}
+
+type parser struct {
+	toks     []token
+	pos      int
+	prefixes map[string]string
+	base     string
+	blanks   int
+
+	// In queries, blank nodes act as variables.
+	query bool
+}
+
+func newParser(s string) (*parser, error) {
+	toks, err := lex(s)
+	if err != nil {
+		return nil, err
+	}
+	return &parser{
+		toks:     toks,
+		prefixes: make(map[string]string),
+	}, nil
+}
+
+func (p *parser) peek() token {
+	return p.toks[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.toks[p.pos]
+	if t.typ != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) isPunct(v string) bool {
+	t := p.peek()
+	return t.typ == tokPunct && t.val == v
+}
+
+func (p *parser) isKeyword(kw string) bool {
+	t := p.peek()
+	return t.typ == tokName && strings.EqualFold(t.val, kw)
+}
+
+func (p *parser) accept(v string) bool {
+	if p.isPunct(v) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *parser) acceptKeyword(kw string) bool {
+	if p.isKeyword(kw) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(v string) error {
+	if !p.accept(v) {
+		return p.unexpected("'" + v + "'")
+	}
+	return nil
+}
+
+func (p *parser) unexpected(want string) error {
+	t := p.peek()
+	return errorf("expected %s, got %s at offset %d", want, t, t.pos)
+}
+
+// prefix parses the remainder of a PREFIX or @prefix directive.
+func (p *parser) prefix() error {
+	name := p.next()
+	if name.typ != tokName || !strings.HasSuffix(name.val, ":") {
+		return errorf("invalid prefix declaration at offset %d", name.pos)
+	}
+	iri := p.next()
+	if iri.typ != tokIRI {
+		return errorf("expected IRI in prefix declaration at offset %d", iri.pos)
+	}
+	p.prefixes[strings.TrimSuffix(name.val, ":")] = p.resolve(iri.val)
+	return nil
+}
+
+func (p *parser) resolve(iri string) string {
+	if p.base == "" || strings.Contains(iri, ":") {
+		return iri
+	}
+	return p.base + iri
+}
+
+func (p *parser) expandName(name string) (string, error) {
+	i := strings.Index(name, ":")
+	if i < 0 {
+		return "", errorf("unknown keyword '%s'", name)
+	}
+	prefix, local := name[0:i], name[i+1:]
+	if uri, ok := p.prefixes[prefix]; ok {
+		return uri + local, nil
+	}
+	if uri, ok := CommonPrefixes[prefix]; ok {
+		return uri + local, nil
+	}
+	return "", errorf("unknown prefix '%s'", prefix)
+}
+
+func (p *parser) freshBlank() Node {
+	p.blanks++
+	id := "b" + strconv.Itoa(p.blanks)
+	if p.query {
+		return Node{Var: "_" + id}
+	}
+	return Node{Term: Blank(id)}
+}
+
+// term parses a single RDF term or variable.
+func (p *parser) term() (Node, error) {
+	t := p.next()
+	switch t.typ {
+	case tokVar:
+		if !p.query {
+			return Node{}, errorf("unexpected variable %s at offset %d", t, t.pos)
+		}
+		return Node{Var: t.val}, nil
+	case tokIRI:
+		return Node{Term: URI(p.resolve(t.val))}, nil
+	case tokString:
+		lit := Literal(t.val)
+		if n := p.peek(); n.typ == tokAt {
+			lit.Lang = p.next().val
+		} else if p.accept("^^") {
+			dt, err := p.term()
+			if err != nil {
+				return Node{}, err
+			}
+			if dt.Term.Type != TypeURI {
+				return Node{}, errorf("invalid datatype at offset %d", t.pos)
+			}
+			lit.DataType = dt.Term.Value
+		}
+		return Node{Term: lit}, nil
+	case tokName:
+		return p.name(t)
+	}
+	return Node{}, errorf("expected term, got %s at offset %d", t, t.pos)
+}
+
+func (p *parser) name(t token) (Node, error) {
+	v := t.val
+	switch {
+	case v == "a":
+		return Node{Term: URI(RDFType)}, nil
+	case v == "true" || v == "false":
+		return Node{Term: Resource{Type: TypeLiteral, Value: v, DataType: XSDBoolean}}, nil
+	case strings.HasPrefix(v, "_:"):
+		if p.query {
+			return Node{Var: v}, nil
+		}
+		return Node{Term: Blank(v[2:])}, nil
+	}
+	if _, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return Node{Term: Resource{Type: TypeLiteral, Value: v, DataType: XSDInteger}}, nil
+	}
+	if _, err := strconv.ParseFloat(v, 64); err == nil {
+		dt := XSDDecimal
+		if strings.ContainsAny(v, "eE") {
+			dt = XSDDouble
+		}
+		return Node{Term: Resource{Type: TypeLiteral, Value: v, DataType: dt}}, nil
+	}
+
+	uri, err := p.expandName(v)
+	if err != nil {
+		return Node{}, err
+	}
+	return Node{Term: URI(uri)}, nil
+}
+
+// subject parses a triple subject, which may be a blank node property list.
+func (p *parser) subject(out *[]Pattern) (Node, error) {
+	if p.accept("[") {
+		n := p.freshBlank()
+		if p.accept("]") {
+			return n, nil
+		}
+		if err := p.predicateObjects(n, out); err != nil {
+			return n, err
+		}
+		return n, p.expect("]")
+	}
+	if p.isPunct("(") {
+		return Node{}, errorf("collections are not supported (offset %d)", p.peek().pos)
+	}
+	return p.term()
+}
+
+// predicateObjects parses a predicate-object list for the given subject.
+func (p *parser) predicateObjects(subj Node, out *[]Pattern) error {
+	for {
+		pred, err := p.term()
+		if err != nil {
+			return err
+		}
+		for {
+			obj, err := p.subject(out)
+			if err != nil {
+				return err
+			}
+			*out = append(*out, Pattern{subj, pred, obj})
+			if !p.accept(",") {
+				break
+			}
+		}
+		if !p.accept(";") {
+			return nil
+		}
+		// Allow trailing semicolons.
+		for p.accept(";") {
+		}
+		if p.isPunct(".") || p.isPunct("]") || p.isPunct("}") || p.peek().typ == tokEOF {
+			return nil
+		}
+	}
+}
+
+// triples parses a subject followed by its predicate-object list.
+func (p *parser) triples(out *[]Pattern) error {
+	subj, err := p.subject(out)
+	if err != nil {
+		return err
+	}
+	if p.isPunct(".") || p.isPunct("}") {
+		if len(*out) > 0 {
+			// Stand-alone blank node property list
+			return nil
+		}
+	}
+	return p.predicateObjects(subj, out)
+}
+
+func parseQuery(s string) (*parsedQuery, error) {
+	p, err := newParser(s)
+	if err != nil {
+		return nil, err
+	}
+	p.query = true
+
+	for {
+		if p.acceptKeyword("PREFIX") {
+			if err := p.prefix(); err != nil {
+				return nil, err
+			}
+		} else if p.acceptKeyword("BASE") {
+			t := p.next()
+			if t.typ != tokIRI {
+				return nil, errorf("expected IRI after BASE at offset %d", t.pos)
+			}
+			p.base = t.val
+		} else {
+			break
+		}
+	}
+
+	q := &parsedQuery{}
+	switch {
+	case p.acceptKeyword("SELECT"):
+		q.Type = "select"
+		q.Distinct = p.acceptKeyword("DISTINCT") || p.acceptKeyword("REDUCED")
+		if !p.accept("*") {
+			for p.peek().typ == tokVar {
+				q.Vars = append(q.Vars, p.next().val)
+			}
+			if len(q.Vars) == 0 {
+				return nil, p.unexpected("variables or '*'")
+			}
+		}
+	case p.acceptKeyword("DESCRIBE"):
+		q.Type = "describe"
+		if !p.accept("*") {
+			for !p.isKeyword("WHERE") && !p.isPunct("{") && p.peek().typ != tokEOF {
+				n, err := p.term()
+				if err != nil {
+					return nil, err
+				}
+				q.Describe = append(q.Describe, n)
+			}
+		}
+	default:
+		return nil, p.unexpected("SELECT or DESCRIBE")
+	}
+
+	hasWhere := p.acceptKeyword("WHERE")
+	if hasWhere || p.isPunct("{") {
+		g, err := p.group()
+		if err != nil {
+			return nil, err
+		}
+		q.Where = g
+	} else {
+		q.Where = &group{}
+	}
+
+	for p.peek().typ != tokEOF {
+		var v *int
+		if p.acceptKeyword("LIMIT") {
+			v = &q.Limit
+		} else if p.acceptKeyword("OFFSET") {
+			v = &q.Offset
+		} else {
+			return nil, p.unexpected("LIMIT, OFFSET or end of query")
+		}
+		t := p.next()
+		n, err := strconv.Atoi(t.val)
+		if t.typ != tokName || err != nil || n < 0 {
+			return nil, errorf("invalid number %s at offset %d", t, t.pos)
+		}
+		*v = n
+	}
+	return q, nil
+}
+
+func (p *parser) group() (*group, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	g := &group{}
+	for !p.accept("}") {
+		switch {
+		case p.peek().typ == tokEOF:
+			return nil, p.unexpected("'}'")
+		case p.accept("."):
+		case p.acceptKeyword("OPTIONAL"):
+			opt, err := p.group()
+			if err != nil {
+				return nil, err
+			}
+			g.Elements = append(g.Elements, optional{opt})
+		case p.acceptKeyword("FILTER"):
+			e, err := p.constraint()
+			if err != nil {
+				return nil, err
+			}
+			g.Filters = append(g.Filters, e)
+		default:
+			var pats []Pattern
+			if err := p.triples(&pats); err != nil {
+				return nil, err
+			}
+			for _, pat := range pats {
+				g.Elements = append(g.Elements, pat)
+			}
+		}
+	}
+	return g, nil
+}
+
+func (p *parser) constraint() (expr, error) {
+	if p.accept("(") {
+		e, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		return e, p.expect(")")
+	}
+	t := p.next()
+	if t.typ != tokName || !p.isPunct("(") {
+		return nil, errorf("expected constraint after FILTER at offset %d", t.pos)
+	}
+	return p.call(t.val)
+}
+
+func (p *parser) expression() (expr, error) {
+	l, err := p.conjunction()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||") {
+		r, err := p.conjunction()
+		if err != nil {
+			return nil, err
+		}
+		l = binaryExpr{"||", l, r}
+	}
+	return l, nil
+}
+
+func (p *parser) conjunction() (expr, error) {
+	l, err := p.relational()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&") {
+		r, err := p.relational()
+		if err != nil {
+			return nil, err
+		}
+		l = binaryExpr{"&&", l, r}
+	}
+	return l, nil
+}
+
+func (p *parser) relational() (expr, error) {
+	l, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range []string{"=", "!=", "<=", ">=", "<", ">"} {
+		if p.accept(op) {
+			r, err := p.unary()
+			if err != nil {
+				return nil, err
+			}
+			return binaryExpr{op, l, r}, nil
+		}
+	}
+	return l, nil
+}
+
+func (p *parser) unary() (expr, error) {
+	if p.accept("!") {
+		e, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return notExpr{e}, nil
+	}
+	return p.primary()
+}
+
+func (p *parser) primary() (expr, error) {
+	if p.accept("(") {
+		e, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		return e, p.expect(")")
+	}
+	t := p.peek()
+	if t.typ == tokName && p.toks[p.pos+1].typ == tokPunct && p.toks[p.pos+1].val == "(" {
+		p.next()
+		return p.call(t.val)
+	}
+	n, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+	if n.IsVar() {
+		return varExpr(n.Var), nil
+	}
+	return constExpr{n.Term}, nil
+}
+
+func (p *parser) call(name string) (expr, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	c := callExpr{name: strings.ToLower(name)}
+	if _, ok := functions[c.name]; !ok {
+		return nil, errorf("unsupported function '%s'", name)
+	}
+	for !p.accept(")") {
+		if len(c.args) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		e, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		c.args = append(c.args, e)
+	}
+	return c, nil
+}
@@ -7,6 +7,7 @@ package sparql
 
 import (
 	"errors"
+	"strconv"
 	"strings"
 )
 
@@ -32,6 +33,7 @@ type Query struct {
 	prefixes map[string]string
 	fields   []string
 	where    []string
+	limit    int
 }
 
 func New(ep ...Endpoint) *Query {
@@ -100,10 +102,18 @@ func (q *Query) String() string {
 		}
 		s += " }"
 	}
+	if q.limit > 0 {
+		s += " LIMIT " + strconv.Itoa(q.limit)
+	}
 
 	return s
 }
 
+func (q *Query) Limit(n int) *Query {
+	q.limit = n
+	return q
+}
+
 func (q *Query) Select(fields ...string) *Query {
 	q.qtype = "select"
 	q.fields = append(q.fields, fields...)
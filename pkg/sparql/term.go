@@ -0,0 +1,144 @@
+// Copyright (C) 2017 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package sparql
+
+import (
+	"strconv"
+	"strings"
+)
+
+const (
+	TypeURI     = "uri"
+	TypeLiteral = "literal"
+	TypeBlank   = "bnode"
+
+	XSDString  = "http://www.w3.org/2001/XMLSchema#string"
+	XSDBoolean = "http://www.w3.org/2001/XMLSchema#boolean"
+	XSDInteger = "http://www.w3.org/2001/XMLSchema#integer"
+	XSDDecimal = "http://www.w3.org/2001/XMLSchema#decimal"
+	XSDDouble  = "http://www.w3.org/2001/XMLSchema#double"
+	RDFType    = "http://www.w3.org/1999/02/22-rdf-syntax-ns#type"
+)
+
+func URI(v string) Resource {
+	return Resource{Type: TypeURI, Value: v}
+}
+
+func Literal(v string) Resource {
+	return Resource{Type: TypeLiteral, Value: v}
+}
+
+func Blank(v string) Resource {
+	return Resource{Type: TypeBlank, Value: v}
+}
+
+func (r Resource) IsLiteral() bool {
+	return r.Type == TypeLiteral || r.Type == "typed-literal"
+}
+
+// Equal compares two terms, treating the legacy "typed-literal" type
+// as a literal.
+func (r Resource) Equal(o Resource) bool {
+	if r.IsLiteral() != o.IsLiteral() {
+		return false
+	}
+	if !r.IsLiteral() && r.Type != o.Type {
+		return false
+	}
+	return r.Value == o.Value && strings.EqualFold(r.Lang, o.Lang) && r.DataType == o.DataType
+}
+
+// String returns the term in N-Triples notation.
+func (r Resource) String() string {
+	switch {
+	case r.Type == TypeURI:
+		return "<" + r.Value + ">"
+	case r.Type == TypeBlank:
+		return "_:" + r.Value
+	}
+	s := `"` + escapeString(r.Value) + `"`
+	if r.Lang != "" {
+		s += "@" + r.Lang
+	} else if r.DataType != "" && r.DataType != XSDString {
+		s += "^^<" + r.DataType + ">"
+	}
+	return s
+}
+
+// Statement is a single RDF triple.
+type Statement struct {
+	Subject   Resource `json:"subject"`
+	Predicate Resource `json:"predicate"`
+	Object    Resource `json:"object"`
+}
+
+func (s Statement) String() string {
+	return s.Subject.String() + " " + s.Predicate.String() + " " + s.Object.String() + " ."
+}
+
+// Matches reports whether the statement matches the pattern, nil meaning
+// any term.
+func (s Statement) Matches(subj, pred, obj *Resource) bool {
+	if subj != nil && !subj.Equal(s.Subject) {
+		return false
+	}
+	if pred != nil && !pred.Equal(s.Predicate) {
+		return false
+	}
+	if obj != nil && !obj.Equal(s.Object) {
+		return false
+	}
+	return true
+}
+
+func escapeString(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+	return r.Replace(s)
+}
+
+func unescapeString(s string) (string, error) {
+	if !strings.Contains(s, `\`) {
+		return s, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '\\' || i+1 >= len(s) {
+			b.WriteByte(c)
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'u', 'U':
+			n := 4
+			if s[i] == 'U' {
+				n = 8
+			}
+			if i+1+n > len(s) {
+				return "", errorf("invalid escape sequence in %q", s)
+			}
+			v, err := strconv.ParseUint(s[i+1:i+1+n], 16, 32)
+			if err != nil {
+				return "", errorf("invalid escape sequence in %q", s)
+			}
+			b.WriteRune(rune(v))
+			i += n
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String(), nil
+}
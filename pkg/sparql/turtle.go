@@ -0,0 +1,181 @@
+// Copyright (C) 2017 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package sparql
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strings"
+)
+
+// ParseTurtle reads statements from a Turtle document. Since N-Triples is a
+// subset of Turtle, it reads N-Triples as well. Collections are not
+// supported. The declared prefixes are returned alongside the statements.
+func ParseTurtle(r io.Reader) ([]Statement, map[string]string, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	p, err := newParser(string(data))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stmts := make([]Statement, 0)
+	for p.peek().typ != tokEOF {
+		if t := p.peek(); t.typ == tokAt {
+			p.next()
+			switch t.val {
+			case "prefix":
+				if err := p.prefix(); err != nil {
+					return nil, nil, err
+				}
+			case "base":
+				iri := p.next()
+				if iri.typ != tokIRI {
+					return nil, nil, errorf("expected IRI after @base at offset %d", iri.pos)
+				}
+				p.base = iri.val
+			default:
+				return nil, nil, errorf("unknown directive @%s at offset %d", t.val, t.pos)
+			}
+			if err := p.expect("."); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		if p.acceptKeyword("PREFIX") {
+			if err := p.prefix(); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		if p.acceptKeyword("BASE") {
+			iri := p.next()
+			if iri.typ != tokIRI {
+				return nil, nil, errorf("expected IRI after BASE at offset %d", iri.pos)
+			}
+			p.base = iri.val
+			continue
+		}
+
+		var pats []Pattern
+		if err := p.triples(&pats); err != nil {
+			return nil, nil, err
+		}
+		if err := p.expect("."); err != nil {
+			return nil, nil, err
+		}
+		for _, pat := range pats {
+			stmts = append(stmts, Statement{pat.Subject.Term, pat.Predicate.Term, pat.Object.Term})
+		}
+	}
+	return stmts, p.prefixes, nil
+}
+
+// WriteNTriples writes the statements in N-Triples format.
+func WriteNTriples(w io.Writer, stmts []Statement) error {
+	bw := bufio.NewWriter(w)
+	for _, st := range stmts {
+		if _, err := bw.WriteString(st.String() + "\n"); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+var (
+	reLocalName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_\-]*$`)
+	reInteger   = regexp.MustCompile(`^[+-]?[0-9]+$`)
+)
+
+// compactIRI abbreviates an IRI using the given prefixes, if possible.
+func compactIRI(iri string, ns map[string]string, order []string) (string, bool) {
+	for _, p := range order {
+		uri := ns[p]
+		if strings.HasPrefix(iri, uri) {
+			local := strings.TrimPrefix(iri, uri)
+			if local == "" || reLocalName.MatchString(local) {
+				return p + ":" + local, true
+			}
+		}
+	}
+	return "", false
+}
+
+// WriteTurtle writes the statements in Turtle format, grouped by subject
+// and abbreviated with the given prefixes. Only prefixes actually used are
+// declared.
+func WriteTurtle(w io.Writer, stmts []Statement, ns map[string]string) error {
+	order := SortedPrefixes(ns)
+	used := make(map[string]bool)
+	format := func(r Resource) string {
+		if r.Type == TypeURI {
+			if r.Value == RDFType {
+				return "a"
+			}
+			if c, ok := compactIRI(r.Value, ns, order); ok {
+				used[c[0:strings.Index(c, ":")]] = true
+				return c
+			}
+		}
+		if r.IsLiteral() && r.DataType == XSDInteger && reInteger.MatchString(r.Value) {
+			return r.Value
+		}
+		if r.IsLiteral() && r.DataType == XSDBoolean && (r.Value == "true" || r.Value == "false") {
+			return r.Value
+		}
+		if r.IsLiteral() && r.DataType != "" && r.Lang == "" && r.DataType != XSDString {
+			dt := "<" + r.DataType + ">"
+			if c, ok := compactIRI(r.DataType, ns, order); ok {
+				used[c[0:strings.Index(c, ":")]] = true
+				dt = c
+			}
+			return `"` + escapeString(r.Value) + `"^^` + dt
+		}
+		return r.String()
+	}
+
+	var body strings.Builder
+	subjects := make([]string, 0)
+	bySubject := make(map[string][]Statement)
+	for _, st := range stmts {
+		key := st.Subject.String()
+		if _, ok := bySubject[key]; !ok {
+			subjects = append(subjects, key)
+		}
+		bySubject[key] = append(bySubject[key], st)
+	}
+	for _, key := range subjects {
+		group := bySubject[key]
+		body.WriteString(format(group[0].Subject))
+		for i, st := range group {
+			if i > 0 {
+				if st.Predicate.Equal(group[i-1].Predicate) {
+					body.WriteString(" ,\n\t\t" + format(st.Object))
+					continue
+				}
+				body.WriteString(" ;")
+			}
+			body.WriteString("\n\t" + format(st.Predicate) + " " + format(st.Object))
+		}
+		body.WriteString(" .\n\n")
+	}
+
+	bw := bufio.NewWriter(w)
+	for _, p := range order {
+		if used[p] {
+			bw.WriteString("@prefix " + p + ": <" + ns[p] + "> .\n")
+		}
+	}
+	if len(used) > 0 {
+		bw.WriteString("\n")
+	}
+	bw.WriteString(body.String())
+	return bw.Flush()
+}
@@ -0,0 +1,79 @@
+// Copyright (C) 2017 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package sparql
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestParseNTriples(t *testing.T) {
+	doc := `<http://example.org/a> <http://example.org/p> "line\nbreak \"quoted\""@en .
+<http://example.org/a> <http://example.org/q> "5"^^<http://www.w3.org/2001/XMLSchema#integer> .
+_:b1 <http://example.org/p> <http://example.org/a> .
+`
+	stmts, _, err := ParseTurtle(strings.NewReader(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stmts) != 3 {
+		t.Fatalf("expected 3 statements, got %d", len(stmts))
+	}
+	if o := stmts[0].Object; o.Value != "line\nbreak \"quoted\"" || o.Lang != "en" {
+		t.Errorf("unexpected literal: %+v", o)
+	}
+	if o := stmts[1].Object; o.DataType != XSDInteger {
+		t.Errorf("unexpected datatype: %+v", o)
+	}
+	if s := stmts[2].Subject; s.Type != TypeBlank || s.Value != "b1" {
+		t.Errorf("unexpected blank node: %+v", s)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteNTriples(&buf, stmts); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != doc {
+		t.Errorf("roundtrip mismatch:\n%s", buf.String())
+	}
+}
+
+func TestTurtleRoundtrip(t *testing.T) {
+	stmts, prefixes, err := ParseTurtle(strings.NewReader(testData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stmts) != 10 {
+		t.Fatalf("expected 10 statements, got %d", len(stmts))
+	}
+
+	ns := map[string]string{"foaf": CommonPrefixes["foaf"]}
+	for p, uri := range prefixes {
+		ns[p] = uri
+	}
+	var buf bytes.Buffer
+	if err := WriteTurtle(&buf, stmts, ns); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	if !strings.Contains(out, "ex:alice\n\ta foaf:Person ;") || !strings.Contains(out, "foaf:age 25 .") {
+		t.Errorf("unexpected output:\n%s", out)
+	}
+
+	again, _, err := ParseTurtle(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(again) != len(stmts) {
+		t.Fatalf("expected %d statements, got %d", len(stmts), len(again))
+	}
+	for i := range stmts {
+		if stmts[i].String() != again[i].String() {
+			t.Errorf("statement %d: %s != %s", i, stmts[i], again[i])
+		}
+	}
+}
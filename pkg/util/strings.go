@@ -0,0 +1,98 @@
+// Copyright (C) 2019 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package util
+
+import (
+	"strings"
+	"unicode"
+)
+
+// NormalizeWords splits a text into lowercase words of letters and digits.
+func NormalizeWords(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// WordScore rates how well a normalized query word matches a word of a
+// text: exact matches score 1, prefixes 0.9 and similar spellings up to 0.9.
+func WordScore(query, word string) float64 {
+	if word == query {
+		return 1
+	} else if strings.HasPrefix(word, query) {
+		return 0.9
+	} else if sim := Similarity(query, word); sim >= 0.7 {
+		return sim * 0.9
+	}
+	return 0
+}
+
+// MatchWords rates how well the query words match a text between 0 and 1.
+// Every query word contributes its best match against a word of the text.
+// It also returns the number of words in the text that are not the best
+// match of any query word.
+func MatchWords(query []string, text string) (float64, int) {
+	words := NormalizeWords(text)
+	if len(query) == 0 || len(words) == 0 {
+		return 0, 0
+	}
+
+	total := 0.0
+	used := make(map[int]bool)
+	for _, qw := range query {
+		best, bestIdx := 0.0, -1
+		for i, w := range words {
+			if score := WordScore(qw, w); score > best {
+				best, bestIdx = score, i
+			}
+		}
+		if bestIdx >= 0 {
+			used[bestIdx] = true
+		}
+		total += best
+	}
+	return total / float64(len(query)), len(words) - len(used)
+}
+
+// Similarity returns a normalized edit similarity between 0 and 1.
+func Similarity(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	max := len(ra)
+	if len(rb) > max {
+		max = len(rb)
+	}
+	if max == 0 {
+		return 1
+	}
+	return 1 - float64(levenshtein(ra, rb))/float64(max)
+}
+
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = minInt(minInt(prev[j]+1, curr[j-1]+1), prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,43 @@
+// Copyright (C) 2019 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package util
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeWords(t *testing.T) {
+	words := NormalizeWords("Oat-Flakes, 500g (Müsli)")
+	if !reflect.DeepEqual(words, []string{"oat", "flakes", "500g", "müsli"}) {
+		t.Error("unexpected words:", words)
+	}
+}
+
+func TestMatchWords(t *testing.T) {
+	tests := []struct {
+		Query     string
+		Text      string
+		Score     float64
+		Unmatched int
+	}{
+		{"oat flakes", "Oat Flakes", 1, 0},
+		{"oat", "Oat Flakes", 1, 1},
+		{"fla", "Oat Flakes", 0.9, 1},
+		{"oats", "Oat Flakes", 0.675, 1},
+		{"oat milk", "Oat Flakes", 0.5, 1},
+		{"milk", "Oat Flakes", 0, 2},
+		{"", "Oat Flakes", 0, 0},
+		{"oat", "", 0, 0},
+	}
+	for _, test := range tests {
+		score, unmatched := MatchWords(NormalizeWords(test.Query), test.Text)
+		if math.Abs(score-test.Score) > 1e-9 || unmatched != test.Unmatched {
+			t.Errorf("MatchWords(%q, %q) = %v, %d; expected %v, %d", test.Query, test.Text, score, unmatched, test.Score, test.Unmatched)
+		}
+	}
+}
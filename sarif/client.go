@@ -14,6 +14,10 @@ type Client interface {
 
 	Publish(msg Message) error
 	Subscribe(action, device string, h func(Message)) error
+	Unsubscribe(action, device string) error
+	RegisterSchema(s ActionSchema)
+	EnableEncryption(k KeyPair) error
+	TrustKey(device string, public []byte)
 
 	SetRequestTimeout(timeout time.Duration)
 	Request(msg Message) <-chan Message
@@ -32,6 +36,12 @@ type ClientInfo struct {
 	LastSeen     time.Time `json:"last_seen,omitempty"`
 }
 
+// DiscoverInfo is the reply of a client to a plain "proto/discover" request.
+type DiscoverInfo struct {
+	Actions []string       `json:"actions"`
+	Schemas []ActionSchema `json:"schemas,omitempty"`
+}
+
 type ClientFactory interface {
 	NewClient(ci ClientInfo) (Client, error)
 }
@@ -6,6 +6,7 @@
 package sarif
 
 import (
+	"bytes"
 	"errors"
 	"strings"
 	"sync"
@@ -34,12 +35,20 @@ type defaultClient struct {
 	RequestTimeout   time.Duration
 	HandleConcurrent bool
 
-	conn    Connection
-	handler func(Message)
-	subs    []subscription
+	conn       Connection
+	handler    func(Message)
+	subs       []subscription
+	subMutex   sync.RWMutex
+	registered bool
+	schemas    []ActionSchema
 
 	reqMutex *sync.Mutex
 	requests map[string]chan Message
+
+	keyPair   *KeyPair
+	keyMutex  sync.RWMutex
+	keys      map[string][]byte
+	announced map[string]bool
 }
 
 func NewClient(ci ClientInfo) Client {
@@ -50,8 +59,10 @@ func NewClient(ci ClientInfo) Client {
 
 		subs: make([]subscription, 0),
 
-		reqMutex: &sync.Mutex{},
-		requests: make(map[string]chan Message),
+		reqMutex:  &sync.Mutex{},
+		requests:  make(map[string]chan Message),
+		keys:      make(map[string][]byte),
+		announced: make(map[string]bool),
 	}
 	return c
 }
@@ -66,18 +77,39 @@ func (c *defaultClient) Connect(conn Connection) error {
 	if c.Info.Auth != "" {
 		c.Publish(CreateMessage("proto/hi", c.Info))
 	}
-	for _, sub := range c.subs {
-		c.Subscribe(sub.Action, sub.Device, sub.Handler)
+	// On reconnect, the handlers are registered already and only the
+	// subscriptions at the broker need to be restored.
+	for _, sub := range c.subscriptions() {
+		if err := c.conn.Subscribe(c.deviceId, sub.Action, sub.Device); err != nil {
+			return err
+		}
+	}
+	if !c.registered {
+		if err := c.registerDefaults(); err != nil {
+			return err
+		}
+		c.registered = true
 	}
+
+	go c.listen()
+	return nil
+}
+
+func (c *defaultClient) registerDefaults() error {
 	if err := c.Subscribe("", c.deviceId, nil); err != nil {
 		return err
 	}
 	if err := c.Subscribe("ping", "", c.handlePing); err != nil {
 		return err
 	}
+	c.internalSubscribe("proto/discover", "", c.handleDiscoverAll)
+	return c.conn.Subscribe(c.deviceId, "proto/discover", "")
+}
 
-	go c.listen()
-	return nil
+func (c *defaultClient) subscriptions() []subscription {
+	c.subMutex.RLock()
+	defer c.subMutex.RUnlock()
+	return append([]subscription(nil), c.subs...)
 }
 
 func (c *defaultClient) Disconnect() error {
@@ -120,15 +152,37 @@ func (c *defaultClient) fillMessage(msg *Message) {
 
 func (c *defaultClient) Publish(msg Message) error {
 	c.fillMessage(&msg)
+	if msg.Destination != "" && !msg.IsSealed() && !msg.IsAction("proto") {
+		if key, ok := c.publicKey(msg.Destination); ok {
+			sealed, err := msg.Seal(key)
+			if err != nil {
+				return err
+			}
+			msg = sealed
+		}
+	}
 	return c.conn.Publish(msg)
 }
 
 func (c *defaultClient) handle(msg Message) {
+	if msg.IsSealed() {
+		key := c.ownKey()
+		if key == nil {
+			return
+		}
+		opened, err := msg.Open(*key)
+		if err != nil {
+			c.Log("warn", "could not open sealed message from "+msg.Source+": "+err.Error())
+			return
+		}
+		msg = opened
+	}
+
 	if ok := c.resolveRequest(msg.CorrId, msg); ok {
 		return
 	}
 
-	for _, s := range c.subs {
+	for _, s := range c.subscriptions() {
 		if s.Matches(msg) && s.Handler != nil {
 			s.Handler(msg)
 		}
@@ -139,10 +193,31 @@ func (c *defaultClient) handlePing(msg Message) {
 	c.Reply(msg, CreateMessage("ack", nil))
 }
 
+// handleDiscoverAll answers a plain "proto/discover" request with all
+// actions the client is subscribed to.
+func (c *defaultClient) handleDiscoverAll(msg Message) {
+	if msg.Action != "proto/discover" {
+		return
+	}
+
+	seen := make(map[string]bool)
+	actions := make([]string, 0)
+	for _, s := range c.subscriptions() {
+		if s.Action == "" || seen[s.Action] || strings.HasPrefix(s.Action, "proto/") {
+			continue
+		}
+		seen[s.Action] = true
+		actions = append(actions, s.Action)
+	}
+	c.Reply(msg, CreateMessage("proto/discovered", DiscoverInfo{actions, c.schemas}))
+}
+
 func (c *defaultClient) internalSubscribe(action, device string, h func(Message)) {
 	if device == "" && action != "" {
 		c.internalSubscribe(action, c.deviceId, h)
 	}
+	c.subMutex.Lock()
+	defer c.subMutex.Unlock()
 	c.subs = append(c.subs, subscription{
 		action,
 		device,
@@ -164,6 +239,124 @@ func (c *defaultClient) Subscribe(action, device string, h func(Message)) error
 	return nil
 }
 
+// Unsubscribe removes the handlers of a subscription and cancels it at the
+// broker. Like Subscribe, an empty device includes messages directed to the
+// client itself.
+func (c *defaultClient) Unsubscribe(action, device string) error {
+	if device == "self" {
+		device = c.DeviceId()
+	}
+	c.subMutex.Lock()
+	subs := make([]subscription, 0, len(c.subs))
+	for _, sub := range c.subs {
+		if sub.Action == action && (sub.Device == device || (device == "" && sub.Device == c.deviceId)) {
+			continue
+		}
+		subs = append(subs, sub)
+	}
+	c.subs = subs
+	c.subMutex.Unlock()
+
+	if device == "" && action != "" {
+		if err := c.Publish(CreateMessage("proto/unsub", subscription{action, c.deviceId, nil})); err != nil {
+			return err
+		}
+	}
+	return c.Publish(CreateMessage("proto/unsub", subscription{action, device, nil}))
+}
+
+// RegisterSchema documents the payloads of an action handled by the client.
+// Schemas are announced with the reply to "proto/discover".
+func (c *defaultClient) RegisterSchema(s ActionSchema) {
+	for i, existing := range c.schemas {
+		if existing.Action == s.Action {
+			c.schemas[i] = s
+			return
+		}
+	}
+	c.schemas = append(c.schemas, s)
+}
+
+// EnableEncryption announces the public key of the client in "proto/keys".
+// From then on, directed messages to devices with a trusted key are sealed.
+func (c *defaultClient) EnableEncryption(k KeyPair) error {
+	if c.conn == nil {
+		return errors.New("client is not connected")
+	}
+	c.keyMutex.Lock()
+	c.keyPair = &k
+	c.keyMutex.Unlock()
+	if err := c.Subscribe("proto/keys", "", c.handleKeys); err != nil {
+		return err
+	}
+	return c.announceKey()
+}
+
+func (c *defaultClient) ownKey() *KeyPair {
+	c.keyMutex.RLock()
+	defer c.keyMutex.RUnlock()
+	return c.keyPair
+}
+
+func (c *defaultClient) announceKey() error {
+	return c.Publish(CreateMessage("proto/keys", KeysPayload{map[string]string{
+		c.deviceId: c.ownKey().PublicString(),
+	}}))
+}
+
+// TrustKey pins the public key of a device. Only pinned keys are used
+// for sealing, announced keys are never trusted on their own.
+func (c *defaultClient) TrustKey(device string, public []byte) {
+	c.keyMutex.Lock()
+	defer c.keyMutex.Unlock()
+	c.keys[device] = public
+}
+
+func (c *defaultClient) publicKey(device string) ([]byte, bool) {
+	c.keyMutex.RLock()
+	defer c.keyMutex.RUnlock()
+	key, ok := c.keys[device]
+	return key, ok
+}
+
+// handleKeys checks the key a device announces for itself against the
+// pinned one. Since any connection can announce keys, unpinned ones are
+// only logged, so that they can be verified and pinned out of band.
+// When a device shows up, the own key is announced again so that it
+// learns about this device as well.
+func (c *defaultClient) handleKeys(msg Message) {
+	if msg.Action != "proto/keys" || msg.Source == c.deviceId {
+		return
+	}
+	var p KeysPayload
+	if err := msg.DecodePayload(&p); err != nil {
+		return
+	}
+	public, ok := p.Keys[msg.Source]
+	if !ok {
+		return
+	}
+	key, err := ParsePublicKey(public)
+	if err != nil {
+		return
+	}
+
+	c.keyMutex.Lock()
+	known, pinned := c.keys[msg.Source]
+	first := !c.announced[msg.Source]
+	c.announced[msg.Source] = true
+	c.keyMutex.Unlock()
+
+	if !pinned && first {
+		c.Log("info", "ignoring untrusted key of "+msg.Source+": "+public)
+	} else if pinned && !bytes.Equal(known, key) {
+		c.Log("warn", "ignoring changed key of "+msg.Source)
+	}
+	if first {
+		c.announceKey()
+	}
+}
+
 func (c *defaultClient) Reply(orig, reply Message) error {
 	return c.Publish(orig.Reply(reply))
 }
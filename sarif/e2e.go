@@ -0,0 +1,226 @@
+// Copyright (C) 2019 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package sarif
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+)
+
+var (
+	ErrNotSealed   = errors.New("message is not sealed")
+	ErrInvalidSeal = errors.New("could not open sealed message")
+)
+
+var keyEncoding = base64.RawURLEncoding
+
+// KeyPair is the end-to-end encryption key of a device. Directed messages
+// to the device are sealed with its public key, so that only the device
+// itself can read their text and payload.
+type KeyPair struct {
+	Public  []byte
+	private []byte
+}
+
+func GenerateKeyPair() (KeyPair, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return KeyPair{}, err
+	}
+	return KeyPair{
+		Public:  elliptic.Marshal(elliptic.P256(), key.X, key.Y),
+		private: key.D.Bytes(),
+	}, nil
+}
+
+// ParseKeyPair restores a key pair from its PrivateString.
+func ParseKeyPair(private string) (KeyPair, error) {
+	d, err := keyEncoding.DecodeString(private)
+	if err != nil {
+		return KeyPair{}, err
+	}
+	curve := elliptic.P256()
+	if len(d) == 0 || new(big.Int).SetBytes(d).Cmp(curve.Params().N) >= 0 {
+		return KeyPair{}, errors.New("invalid private key")
+	}
+	x, y := curve.ScalarBaseMult(d)
+	return KeyPair{
+		Public:  elliptic.Marshal(curve, x, y),
+		private: d,
+	}, nil
+}
+
+func (k KeyPair) PrivateString() string {
+	return keyEncoding.EncodeToString(k.private)
+}
+
+func (k KeyPair) PublicString() string {
+	return keyEncoding.EncodeToString(k.Public)
+}
+
+// ParsePublicKey decodes and checks a public key as announced in
+// "proto/keys".
+func ParsePublicKey(public string) ([]byte, error) {
+	pub, err := keyEncoding.DecodeString(public)
+	if err != nil {
+		return nil, err
+	}
+	if x, _ := elliptic.Unmarshal(elliptic.P256(), pub); x == nil {
+		return nil, errors.New("invalid public key")
+	}
+	return pub, nil
+}
+
+func hkdfSha256(salt, ikm, info []byte, length int) []byte {
+	mac := hmac.New(sha256.New, salt)
+	mac.Write(ikm)
+	prk := mac.Sum(nil)
+
+	mac = hmac.New(sha256.New, prk)
+	mac.Write(info)
+	mac.Write([]byte{1})
+	return mac.Sum(nil)[:length]
+}
+
+func sealKey(shared, ephemeral, recipient []byte) (cipher.AEAD, error) {
+	info := append([]byte("sarif sealed box\x00"), ephemeral...)
+	info = append(info, recipient...)
+	block, err := aes.NewCipher(hkdfSha256(nil, shared, info, 32))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// SealBox encrypts data for the owner of a public key with a new ephemeral
+// key. The sender stays anonymous, only the recipient can open the box.
+// The additional data is authenticated, but not encrypted.
+func SealBox(data, additional, recipient []byte) ([]byte, error) {
+	curve := elliptic.P256()
+	rx, ry := elliptic.Unmarshal(curve, recipient)
+	if rx == nil {
+		return nil, errors.New("invalid public key")
+	}
+	ephemeral, err := GenerateKeyPair()
+	if err != nil {
+		return nil, err
+	}
+	sx, _ := curve.ScalarMult(rx, ry, ephemeral.private)
+	shared := make([]byte, 32)
+	sxb := sx.Bytes()
+	copy(shared[32-len(sxb):], sxb)
+
+	aead, err := sealKey(shared, ephemeral.Public, recipient)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	box := append([]byte{}, ephemeral.Public...)
+	box = append(box, nonce...)
+	return aead.Seal(box, nonce, data, additional), nil
+}
+
+// OpenBox decrypts a box created by SealBox for the public key of k.
+func (k KeyPair) OpenBox(box, additional []byte) ([]byte, error) {
+	curve := elliptic.P256()
+	pubLen := len(k.Public)
+	if len(box) < pubLen {
+		return nil, ErrInvalidSeal
+	}
+	ex, ey := elliptic.Unmarshal(curve, box[:pubLen])
+	if ex == nil {
+		return nil, ErrInvalidSeal
+	}
+	sx, _ := curve.ScalarMult(ex, ey, k.private)
+	shared := make([]byte, 32)
+	sxb := sx.Bytes()
+	copy(shared[32-len(sxb):], sxb)
+
+	aead, err := sealKey(shared, box[:pubLen], k.Public)
+	if err != nil {
+		return nil, err
+	}
+	box = box[pubLen:]
+	if len(box) < aead.NonceSize() {
+		return nil, ErrInvalidSeal
+	}
+	data, err := aead.Open(nil, box[:aead.NonceSize()], box[aead.NonceSize():], additional)
+	if err != nil {
+		return nil, ErrInvalidSeal
+	}
+	return data, nil
+}
+
+// sealedContent is the encrypted part of a sealed message.
+type sealedContent struct {
+	Text    string  `json:"text,omitempty"`
+	Payload Partial `json:"p,omitempty"`
+}
+
+// sealHeader binds the sealed content to the routing fields of the message,
+// so that brokers cannot replay it in a different context.
+func (m Message) sealHeader() []byte {
+	return []byte(strings.Join([]string{m.Id, m.Action, m.Source, m.Destination, m.CorrId}, "\x00"))
+}
+
+func (m Message) IsSealed() bool {
+	return len(m.Sealed) > 0
+}
+
+// Seal encrypts text and payload of the message for the owner of a public
+// key. The routing fields stay readable, so brokers can still dispatch it.
+func (m Message) Seal(recipient []byte) (Message, error) {
+	data, err := json.Marshal(sealedContent{m.Text, m.Payload})
+	if err != nil {
+		return m, err
+	}
+	box, err := SealBox(data, m.sealHeader(), recipient)
+	if err != nil {
+		return m, err
+	}
+	m.Sealed = box
+	m.Text = ""
+	m.Payload = Partial{}
+	return m, nil
+}
+
+// Open decrypts a sealed message.
+func (m Message) Open(k KeyPair) (Message, error) {
+	if !m.IsSealed() {
+		return m, ErrNotSealed
+	}
+	data, err := k.OpenBox(m.Sealed, m.sealHeader())
+	if err != nil {
+		return m, err
+	}
+	var content sealedContent
+	if err := json.Unmarshal(data, &content); err != nil {
+		return m, err
+	}
+	m.Sealed = nil
+	m.Text = content.Text
+	m.Payload = content.Payload
+	return m, nil
+}
+
+// KeysPayload announces public keys of devices in "proto/keys".
+type KeysPayload struct {
+	Keys map[string]string `json:"keys"`
+}
@@ -0,0 +1,86 @@
+// Copyright (C) 2019 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package sarif
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSealBox(t *testing.T) {
+	key, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, err := ParseKeyPair(key.PrivateString())
+	if err != nil || !bytes.Equal(restored.Public, key.Public) {
+		t.Fatal("could not restore key pair:", err)
+	}
+	if pub, err := ParsePublicKey(key.PublicString()); err != nil || !bytes.Equal(pub, key.Public) {
+		t.Fatal("could not parse public key:", err)
+	}
+
+	box, err := SealBox([]byte("hello"), []byte("header"), key.Public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(box, []byte("hello")) {
+		t.Error("box contains plaintext")
+	}
+	data, err := restored.OpenBox(box, []byte("header"))
+	if err != nil || string(data) != "hello" {
+		t.Fatalf("unexpected content %q: %v", data, err)
+	}
+
+	other, _ := GenerateKeyPair()
+	if _, err := other.OpenBox(box, []byte("header")); err != ErrInvalidSeal {
+		t.Error("expected other key to fail, got", err)
+	}
+	if _, err := key.OpenBox(box, []byte("other header")); err != ErrInvalidSeal {
+		t.Error("expected changed header to fail, got", err)
+	}
+	if _, err := key.OpenBox(box[:10], nil); err != ErrInvalidSeal {
+		t.Error("expected truncated box to fail, got", err)
+	}
+}
+
+func TestSealMessage(t *testing.T) {
+	key, _ := GenerateKeyPair()
+	msg := CreateMessage("diary/add", map[string]string{"entry": "secret"})
+	msg.Source = "phone"
+	msg.Destination = "home"
+	msg.Text = "secret text"
+
+	sealed, err := msg.Seal(key.Public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sealed.IsSealed() || sealed.Text != "" || sealed.Payload.Raw != nil {
+		t.Fatalf("unexpected sealed message: %+v", sealed)
+	}
+	if sealed.Action != msg.Action || sealed.Destination != msg.Destination {
+		t.Error("routing fields changed")
+	}
+
+	opened, err := sealed.Open(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p map[string]string
+	opened.DecodePayload(&p)
+	if opened.IsSealed() || opened.Text != msg.Text || p["entry"] != "secret" {
+		t.Errorf("unexpected opened message: %+v", opened)
+	}
+
+	// Brokers cannot redirect sealed content to a different action
+	sealed.Action = "diary/delete"
+	if _, err := sealed.Open(key); err != ErrInvalidSeal {
+		t.Error("expected changed action to fail, got", err)
+	}
+	if _, err := msg.Open(key); err != ErrNotSealed {
+		t.Error("expected plain message to fail, got", err)
+	}
+}
@@ -22,6 +22,9 @@ type Message struct {
 	Payload     Partial `json:"p,omitempty"`
 	CorrId      string  `json:"corr,omitempty"`
 	Text        string  `json:"text,omitempty"`
+
+	// Sealed holds text and payload encrypted for the destination device.
+	Sealed []byte `json:"sealed,omitempty"`
 }
 
 func CreateMessage(action string, payload interface{}) Message {
@@ -0,0 +1,80 @@
+// Copyright (C) 2019 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package sarif
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+type schemaBase struct {
+	Time time.Time `json:"time,omitempty"`
+}
+
+type schemaTest struct {
+	schemaBase
+	Name    string            `json:"name"`
+	Count   int               `json:"count,omitempty"`
+	Tags    []string          `json:"tags"`
+	Labels  map[string]string `json:"labels"`
+	Reply   Message           `json:"reply"`
+	Ignored string            `json:"-"`
+	private string
+}
+
+func TestSchemaOf(t *testing.T) {
+	s := SchemaOf(&schemaTest{})
+	if s.Type != "object" || len(s.Properties) != 6 {
+		t.Fatalf("unexpected schema: %+v", s)
+	}
+	tests := map[string]string{
+		"time":   "string",
+		"name":   "string",
+		"count":  "integer",
+		"tags":   "array",
+		"labels": "object",
+		"reply":  "object",
+	}
+	for name, typ := range tests {
+		if p := s.Properties[name]; p == nil || p.Type != typ {
+			t.Errorf("%s: expected %s, got %+v", name, typ, p)
+		}
+	}
+	if s.Properties["time"].Format != "date-time" || s.Properties["tags"].Items.Type != "string" {
+		t.Error("unexpected nested schemas")
+	}
+	if p := s.Properties["reply"].Properties["p"]; p == nil || p.Type != "" {
+		t.Errorf("expected payload to accept anything, got %+v", p)
+	}
+}
+
+func TestSchemaValidate(t *testing.T) {
+	s := SchemaOf(schemaTest{})
+	s.Required = []string{"name"}
+
+	tests := map[string]bool{
+		`{"name": "a", "count": 3, "tags": ["x"], "time": "2019-01-02T15:04:05Z"}`: true,
+		`{"name": "a", "labels": {"x": "y"}, "reply": {"p": [1, 2]}}`:              true,
+		`{"name": "a", "unknown": true}`:                                           true,
+		`{"count": 3}`:                                                             false,
+		`{"name": 3}`:                                                              false,
+		`{"name": "a", "count": 1.5}`:                                              false,
+		`{"name": "a", "tags": "x"}`:                                               false,
+		`{"name": "a", "labels": {"x": 1}}`:                                        false,
+		`{"name": "a", "time": "yesterday"}`:                                       false,
+		`[]`:                                                                       false,
+	}
+	for doc, valid := range tests {
+		var v interface{}
+		if err := json.Unmarshal([]byte(doc), &v); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Validate(v); (err == nil) != valid {
+			t.Errorf("%s: expected valid=%v, got %v", doc, valid, err)
+		}
+	}
+}
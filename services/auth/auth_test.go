@@ -0,0 +1,343 @@
+// Copyright (C) 2019 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sarifsystems/sarif/sarif"
+	"github.com/sarifsystems/sarif/transports/sfproto"
+)
+
+type testConfig struct {
+	data []byte
+	dir  string
+}
+
+func (c *testConfig) Exists() bool { return c.data != nil }
+func (c *testConfig) Set(v interface{}) error {
+	var err error
+	c.data, err = json.Marshal(v)
+	return err
+}
+func (c *testConfig) Get(v interface{}) (error, bool) {
+	if c.data == nil {
+		return nil, false
+	}
+	return json.Unmarshal(c.data, v), true
+}
+func (c *testConfig) Dir() string { return c.dir }
+
+func request(t *testing.T, c sarif.Client, action string, p interface{}) sarif.Message {
+	select {
+	case reply := <-c.Request(sarif.CreateMessage(action, p)):
+		return reply
+	case <-time.After(time.Second):
+		t.Fatal("no reply to", action)
+	}
+	return sarif.Message{}
+}
+
+func TestTokens(t *testing.T) {
+	cfg := &testConfig{}
+	cfg.Set(Config{Tokens: map[string]bool{"oldtoken": true}})
+
+	broker := sfproto.NewBroker()
+	client, err := broker.NewClient(sarif.ClientInfo{Name: "auth"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewService(&Dependencies{Config: cfg, Client: client})
+	if err := s.Enable(); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(cfg.data), "oldtoken") {
+		t.Error("plain token was persisted:", string(cfg.data))
+	}
+	if tok, ok := s.Validate("oldtoken"); !ok || tok.Label != "migrated" {
+		t.Error("legacy token was not migrated:", tok)
+	}
+
+	user, err := broker.NewClient(sarif.ClientInfo{Name: "user"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Issue a scoped token
+	reply := request(t, user, "auth/new/token", TokenPayload{
+		Label:   "widget",
+		Scopes:  []string{"location"},
+		Expires: "1h",
+	})
+	var gen GeneratedPayload
+	if err := reply.DecodePayload(&gen); err != nil || reply.Action != "auth/generated" {
+		t.Fatal("unexpected reply", reply, err)
+	}
+	if gen.Auth == "" || gen.Hash != "" || gen.ExpiresAt.IsZero() {
+		t.Errorf("unexpected token: %+v", gen)
+	}
+	if strings.Contains(string(cfg.data), gen.Auth) {
+		t.Error("plain token was persisted:", string(cfg.data))
+	}
+
+	reply = request(t, user, "auth/validate", ValidatePayload{gen.Auth, "location/update"})
+	var valid Token
+	reply.DecodePayload(&valid)
+	if reply.Action != "auth/valid" || valid.Id != gen.Id || valid.LastUsed.IsZero() {
+		t.Error("unexpected validation", reply, valid)
+	}
+	reply = request(t, user, "auth/validate", ValidatePayload{gen.Auth, "mail/send"})
+	if reply.Action != "auth/invalid" {
+		t.Error("expected token to be out of scope, got", reply)
+	}
+	reply = request(t, user, "auth/validate", ValidatePayload{Token: "unknown"})
+	if reply.Action != "auth/invalid" {
+		t.Error("expected unknown token to be invalid, got", reply)
+	}
+
+	// Clients with scoped tokens may not manage credentials
+	widget, err := broker.NewClient(sarif.ClientInfo{Name: "widget"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	widget.Publish(sarif.CreateMessage("proto/hi", sarif.ClientInfo{Auth: gen.Auth}))
+	for i := 0; s.isTrusted(sarif.Message{Source: "widget"}); i++ {
+		if i > 100 {
+			t.Fatal("expected scoped client to be remembered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for _, action := range []string{"auth/validate", "auth/list", "auth/revoke", "auth/new/token"} {
+		if reply := request(t, widget, action, ValidatePayload{Token: gen.Auth}); reply.Action != "err/forbidden" {
+			t.Error("expected scoped client to be rejected for", action, "got", reply)
+		}
+	}
+	if s.isTrusted(sarif.Message{Source: "widget/sub"}) || !s.isTrusted(sarif.Message{Source: "user"}) {
+		t.Error("unexpected trust of scoped clients")
+	}
+
+	// One-time passwords are short-lived
+	reply = request(t, user, "auth/new/otp", TokenPayload{Expires: "24h"})
+	var otp GeneratedPayload
+	reply.DecodePayload(&otp)
+	if !strings.HasPrefix(otp.Auth, otpPrefix) || otp.ExpiresAt.After(time.Now().Add(otpExpiry)) {
+		t.Errorf("unexpected otp: %+v", otp)
+	}
+
+	// Expired tokens are rejected and pruned
+	s.mu.Lock()
+	s.Config.ApiTokens[HashToken(otp.Auth)].ExpiresAt = time.Now().Add(-time.Second)
+	s.mu.Unlock()
+	if _, ok := s.Validate(otp.Auth); ok {
+		t.Error("expected otp to be expired")
+	}
+
+	reply = request(t, user, "auth/list", nil)
+	var list listPayload
+	reply.DecodePayload(&list)
+	if reply.Action != "auth/listed" || len(list.Tokens) != 2 {
+		t.Fatal("unexpected list", reply)
+	}
+	if list.Tokens[0].Label != "migrated" || list.Tokens[1].Id != gen.Id {
+		t.Errorf("unexpected tokens: %+v", list.Tokens)
+	}
+
+	// Revocation
+	reply = request(t, user, "auth/revoke", RevokePayload{gen.Id})
+	if reply.Action != "auth/revoked" {
+		t.Fatal("unexpected reply", reply)
+	}
+	if _, ok := s.Validate(gen.Auth); ok {
+		t.Error("expected revoked token to be invalid")
+	}
+	reply = request(t, user, "auth/revoke", RevokePayload{gen.Id})
+	if reply.IsAction("auth/revoked") {
+		t.Error("expected second revocation to fail")
+	}
+}
+
+func TestTokenAllows(t *testing.T) {
+	tok := Token{Scopes: []string{"location", "ping"}}
+	for action, allowed := range map[string]bool{
+		"location":        true,
+		"location/update": true,
+		"ping":            true,
+		"locations":       false,
+		"mail/send":       false,
+	} {
+		if tok.Allows(action) != allowed {
+			t.Errorf("Allows(%q) = %v", action, !allowed)
+		}
+	}
+	if !(Token{}).Allows("anything") {
+		t.Error("expected unscoped token to allow everything")
+	}
+}
+
+func newCSR(t *testing.T, device string) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: device},
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}))
+}
+
+func TestEnroll(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sarif-auth-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	broker := sfproto.NewBroker()
+	client, err := broker.NewClient(sarif.ClientInfo{Name: "auth"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewService(&Dependencies{Config: &testConfig{dir: dir}, Client: client})
+	if err := s.Enable(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, AuthorityFile)); err != nil {
+		t.Fatal("authority was not created:", err)
+	}
+
+	user, err := broker.NewClient(sarif.ClientInfo{Name: "user"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	crls := make(chan CRLPayload, 10)
+	user.Subscribe("auth/crl", "", func(msg sarif.Message) {
+		var crl CRLPayload
+		msg.DecodePayload(&crl)
+		crls <- crl
+	})
+
+	enroll := func(device, otpDevice string) sarif.Message {
+		var otp GeneratedPayload
+		request(t, user, "auth/new/otp", TokenPayload{Scopes: []string{"auth/enroll"}, Device: otpDevice}).DecodePayload(&otp)
+		return request(t, user, "auth/enroll", EnrollPayload{otp.Auth, newCSR(t, device)})
+	}
+
+	// Long-lived tokens are not accepted
+	var tok GeneratedPayload
+	request(t, user, "auth/new/token", nil).DecodePayload(&tok)
+	if reply := request(t, user, "auth/enroll", EnrollPayload{tok.Auth, newCSR(t, "laptop")}); reply.Action != "auth/invalid" {
+		t.Error("expected token to be rejected, got", reply)
+	}
+
+	// A one-time password can only be used once
+	var otp GeneratedPayload
+	request(t, user, "auth/new/otp", TokenPayload{Scopes: []string{"auth/enroll"}}).DecodePayload(&otp)
+	if reply := request(t, user, "auth/enroll", EnrollPayload{otp.Auth, "invalid"}); reply.IsAction("auth/enrolled") {
+		t.Fatal("expected invalid request to be rejected, got", reply)
+	}
+	if reply := request(t, user, "auth/enroll", EnrollPayload{otp.Auth, newCSR(t, "phone")}); reply.Action != "auth/enrolled" {
+		t.Fatal("expected otp to survive a bad request, got", reply)
+	}
+	results := make(chan bool, 2)
+	request(t, user, "auth/new/otp", TokenPayload{Scopes: []string{"auth/enroll"}}).DecodePayload(&otp)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, ok := s.consumeOtp(otp.Auth, "auth/enroll", "tablet")
+			results <- ok
+		}()
+	}
+	if first, second := <-results, <-results; first == second {
+		t.Errorf("expected otp to be consumed exactly once, got %v and %v", first, second)
+	}
+
+	for _, device := range []string{"laptop/tars", "laptop:1", "lap top", "*"} {
+		if reply := enroll(device, ""); reply.Action != "err/badrequest" {
+			t.Errorf("expected device id %q to be rejected, got %v", device, reply)
+		}
+	}
+
+	reply := enroll("laptop", "")
+	var enrolled EnrolledPayload
+	if err := reply.DecodePayload(&enrolled); err != nil || reply.Action != "auth/enrolled" {
+		t.Fatal("unexpected reply", reply, err)
+	}
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM([]byte(enrolled.Authority))
+	block, _ := pem.Decode([]byte(enrolled.Certificate))
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cert.Verify(x509.VerifyOptions{
+		Roots:     roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}); err != nil {
+		t.Error("certificate does not verify:", err)
+	}
+	if cert.Subject.CommonName != "laptop" || sfproto.CertificateSerial(cert) != enrolled.Serial {
+		t.Errorf("unexpected certificate: %v %s", cert.Subject, enrolled.Serial)
+	}
+
+	// Enrolling again needs a password for the device and revokes the
+	// old certificate
+	if reply := enroll("laptop", ""); reply.Action != "auth/invalid" {
+		t.Error("expected enrolled device to be rejected, got", reply)
+	}
+	if reply := enroll("laptop", "phone"); reply.Action != "auth/invalid" {
+		t.Error("expected password of another device to be rejected, got", reply)
+	}
+	reply = enroll("laptop", "laptop")
+	if reply.Action != "auth/enrolled" {
+		t.Fatal("unexpected reply", reply)
+	}
+	var crl CRLPayload
+	for len(crl.Serials) == 0 {
+		select {
+		case crl = <-crls:
+		case <-time.After(time.Second):
+			t.Fatal("no revocation list published")
+		}
+	}
+	if len(crl.Serials) != 1 || crl.Serials[0] != enrolled.Serial {
+		t.Errorf("unexpected revocation list: %v", crl.Serials)
+	}
+
+	if reply := request(t, user, "auth/revoke", RevokePayload{"laptop"}); reply.Action != "auth/revoked" {
+		t.Fatal("unexpected reply", reply)
+	}
+	reply = request(t, user, "auth/get/crl", nil)
+	reply.DecodePayload(&crl)
+	if len(crl.Serials) != 2 {
+		t.Errorf("unexpected revocation list: %v", crl.Serials)
+	}
+	if certs := s.ListCertificates(); len(certs) != 3 || !certs[2].Revoked() {
+		t.Errorf("unexpected certificates: %v", certs)
+	}
+
+	// The authority is reused on restart
+	s2 := NewService(&Dependencies{Config: &testConfig{dir: dir}, Client: client})
+	if err := s2.Enable(); err != nil {
+		t.Fatal(err)
+	}
+	if s2.ca == nil || !s2.ca.cert.Equal(s.ca.cert) {
+		t.Error("expected authority to be loaded")
+	}
+}
@@ -0,0 +1,283 @@
+// Copyright (C) 2019 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/sarifsystems/sarif/transports/sfproto"
+)
+
+const (
+	// AuthorityFile is the root certificate in the config directory.
+	// Brokers trust it for client certificates of enrolled devices.
+	AuthorityFile = "ca.crt"
+	caKeyFile     = "ca.key"
+
+	caValidity   = 10 * 365 * 24 * time.Hour
+	certValidity = 365 * 24 * time.Hour
+)
+
+// Certificate records a client certificate signed by the authority.
+type Certificate struct {
+	Serial    string    `json:"serial"`
+	Device    string    `json:"device"`
+	IssuedAt  time.Time `json:"issued_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	RevokedAt time.Time `json:"revoked_at,omitempty"`
+}
+
+func (c Certificate) Revoked() bool {
+	return !c.RevokedAt.IsZero()
+}
+
+func (c Certificate) String() string {
+	s := "certificate " + c.Serial + " for " + c.Device
+	if c.Revoked() {
+		return s + ", revoked " + c.RevokedAt.Format(time.RFC3339)
+	}
+	return s + ", expires " + c.ExpiresAt.Format(time.RFC3339)
+}
+
+// authority is the root certificate that signs client certificates of
+// enrolled devices.
+type authority struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+// loadAuthority reads the root certificate from dir, creating it if it
+// does not exist yet.
+func loadAuthority(dir string) (*authority, error) {
+	if dir == "" {
+		return nil, errors.New("no config directory")
+	}
+	certPath, keyPath := filepath.Join(dir, AuthorityFile), filepath.Join(dir, caKeyFile)
+	certPem, err := ioutil.ReadFile(certPath)
+	if os.IsNotExist(err) {
+		return createAuthority(certPath, keyPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+	keyPem, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &authority{pem: certPem}
+	block, _ := pem.Decode(certPem)
+	if block == nil {
+		return nil, errors.New("invalid certificate in " + certPath)
+	}
+	if a.cert, err = x509.ParseCertificate(block.Bytes); err != nil {
+		return nil, err
+	}
+	block, _ = pem.Decode(keyPem)
+	if block == nil {
+		return nil, errors.New("invalid key in " + keyPath)
+	}
+	if a.key, err = x509.ParseECPrivateKey(block.Bytes); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func createAuthority(certPath, keyPath string) (*authority, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "sarif root"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &authority{
+		cert: cert,
+		key:  key,
+		pem:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	if err := ioutil.WriteFile(keyPath, keyPem, 0600); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(certPath, a.pem, 0644); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func randomSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+// parseCSR decodes a PEM encoded certificate request and returns the
+// device id from its common name.
+func parseCSR(csrPem string) (*x509.CertificateRequest, string, error) {
+	block, _ := pem.Decode([]byte(csrPem))
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, "", errors.New("expected PEM encoded certificate request")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, "", err
+	}
+	device := csr.Subject.CommonName
+	if !validDeviceId(device) {
+		return nil, "", errors.New("invalid device id '" + device + "' in common name")
+	}
+	return csr, device, nil
+}
+
+// validDeviceId checks that a device id is a single hostname-like label,
+// since brokers and topics use separators such as "/" to address clients
+// of a device.
+func validDeviceId(device string) bool {
+	if device == "" {
+		return false
+	}
+	for _, r := range device {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune("-_.", r) {
+			return false
+		}
+	}
+	return true
+}
+
+// sign issues a client certificate for the device of a request.
+func (a *authority) sign(csr *x509.CertificateRequest, device string, now time.Time) (Certificate, []byte, error) {
+	serial, err := randomSerial()
+	if err != nil {
+		return Certificate{}, nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: device},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(certValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, a.cert, csr.PublicKey, a.key)
+	if err != nil {
+		return Certificate{}, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return Certificate{}, nil, err
+	}
+
+	c := Certificate{
+		Serial:    sfproto.CertificateSerial(cert),
+		Device:    device,
+		IssuedAt:  now,
+		ExpiresAt: cert.NotAfter,
+	}
+	return c, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), nil
+}
+
+// revocationList returns the serials of revoked certificates that have not
+// expired yet. The caller has to hold the lock.
+func (s *Service) revocationList() []string {
+	serials := make([]string, 0)
+	for _, c := range s.Config.Certificates {
+		if c.Revoked() {
+			serials = append(serials, c.Serial)
+		}
+	}
+	return serials
+}
+
+// pruneCertificates removes expired certificates, since they are rejected
+// by TLS anyway. The caller has to hold the lock.
+func (s *Service) pruneCertificates(now time.Time) {
+	for serial, c := range s.Config.Certificates {
+		if now.After(c.ExpiresAt) {
+			delete(s.Config.Certificates, serial)
+		}
+	}
+}
+
+// revokeCertificates revokes all valid certificates with the given serial
+// or device id. The caller has to hold the lock.
+func (s *Service) revokeCertificates(id string, now time.Time) int {
+	n := 0
+	for _, c := range s.Config.Certificates {
+		if !c.Revoked() && (c.Serial == id || c.Device == id) {
+			c.RevokedAt = now
+			n++
+		}
+	}
+	return n
+}
+
+// isEnrolled checks if a device holds a valid certificate. The caller has
+// to hold the lock.
+func (s *Service) isEnrolled(device string, now time.Time) bool {
+	for _, c := range s.Config.Certificates {
+		if c.Device == device && !c.Revoked() && now.Before(c.ExpiresAt) {
+			return true
+		}
+	}
+	return false
+}
+
+// ListCertificates returns all unexpired certificates, oldest first.
+func (s *Service) ListCertificates() []Certificate {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pruneCertificates(time.Now())
+	certs := make([]Certificate, 0, len(s.Config.Certificates))
+	for _, c := range s.Config.Certificates {
+		certs = append(certs, *c)
+	}
+	sort.Slice(certs, func(i, j int) bool {
+		return certs[i].IssuedAt.Before(certs[j].IssuedAt)
+	})
+	return certs
+}
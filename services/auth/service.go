@@ -3,14 +3,19 @@
 // Use of this source code is governed by an MIT-style
 // license that can be found in the LICENSE file.
 
-// Service auth provides token and challenge based auth.
+// Service auth provides token and challenge based auth and acts as
+// certificate authority for enrolled devices.
 package auth
 
 import (
 	"crypto/rand"
+	"errors"
+	"fmt"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/sarifsystems/sarif/pkg/util"
 	"github.com/sarifsystems/sarif/sarif"
 	"github.com/sarifsystems/sarif/services"
 )
@@ -31,29 +36,66 @@ type Service struct {
 	cfg    services.Config
 	sarif.Client
 
-	SessionTokens map[string]time.Time
+	mu sync.Mutex
+	ca *authority
+	// scoped holds the clients that authenticated with a scoped token.
+	scoped map[string]bool
 }
 
 type Config struct {
-	Tokens map[string]bool
+	// Tokens holds plain tokens of older versions. They are migrated to
+	// ApiTokens on start.
+	Tokens map[string]bool `json:",omitempty"`
+
+	// ApiTokens maps token hashes to their details.
+	ApiTokens map[string]*Token
+
+	// Certificates maps serials to client certificates signed by the
+	// authority.
+	Certificates map[string]*Certificate
 }
 
 func NewService(deps *Dependencies) *Service {
 	s := &Service{
-		Client:        deps.Client,
-		cfg:           deps.Config,
-		SessionTokens: make(map[string]time.Time),
+		Client: deps.Client,
+		cfg:    deps.Config,
+		scoped: make(map[string]bool),
 	}
 	return s
 }
 
 func (s *Service) Enable() error {
-	s.Config.Tokens = make(map[string]bool)
 	s.cfg.Get(&s.Config)
+	if s.Config.ApiTokens == nil {
+		s.Config.ApiTokens = make(map[string]*Token)
+	}
+	if s.Config.Certificates == nil {
+		s.Config.Certificates = make(map[string]*Certificate)
+	}
+	if len(s.Config.Tokens) > 0 {
+		for tok := range s.Config.Tokens {
+			s.issue(tok, Token{Label: "migrated"})
+		}
+		s.Config.Tokens = nil
+		s.cfg.Set(s.Config)
+	}
 
 	s.Subscribe("proto/hi", "", s.handleAuthRequest)
 	s.Subscribe("auth/new/token", "", s.handleAuthToken)
 	s.Subscribe("auth/new/otp", "", s.handleAuthOtp)
+	s.Subscribe("auth/validate", "", s.handleAuthValidate)
+	s.Subscribe("auth/validate", "self", s.handleAuthValidate)
+	s.Subscribe("auth/revoke", "", s.handleAuthRevoke)
+	s.Subscribe("auth/list", "", s.handleAuthList)
+
+	var err error
+	if s.ca, err = loadAuthority(s.cfg.Dir()); err != nil {
+		s.Log("warn", "certificate authority disabled: "+err.Error())
+	} else {
+		s.Subscribe("auth/enroll", "", s.handleAuthEnroll)
+	}
+	s.Subscribe("auth/get/crl", "", s.handleGetCRL)
+	s.publishCRL()
 	return nil
 }
 
@@ -64,39 +106,300 @@ func (s *Service) handleAuthRequest(msg sarif.Message) {
 		return
 	}
 
-	authed := false
 	auths := strings.Split(ci.Auth, ",")
 	for _, auth := range auths {
-		if s.Config.Tokens[auth] || s.SessionTokens[auth].After(time.Now()) {
-			authed = true
+		if t, ok := s.Validate(auth); ok {
+			s.mu.Lock()
+			if len(t.Scopes) > 0 {
+				s.scoped[msg.Source] = true
+			} else {
+				delete(s.scoped, msg.Source)
+			}
+			s.mu.Unlock()
+			s.Reply(msg, sarif.CreateMessage("proto/allow", t.Public()))
+			return
 		}
 	}
-	if !authed {
-		return
+}
+
+// isTrusted checks that a message does not originate from a client that
+// authenticated with a scoped token. The broker ensures that these clients
+// only publish under their own name.
+func (s *Service) isTrusted(msg sarif.Message) bool {
+	if msg.Source == "" {
+		return false
 	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name := range s.scoped {
+		if msg.Source == name || strings.HasPrefix(msg.Source, name+"/") {
+			return false
+		}
+	}
+	return true
+}
 
-	s.Reply(msg, sarif.CreateMessage("proto/allow", nil))
+// checkTrusted replies with an error if the sender of a message may not
+// manage credentials.
+func (s *Service) checkTrusted(msg sarif.Message) bool {
+	if s.isTrusted(msg) {
+		return true
+	}
+	s.Log("warn", msg.Source+" is not allowed to publish "+msg.Action)
+	s.Reply(msg, sarif.Message{
+		Action: "err/forbidden",
+		Text:   "Only trusted clients may publish " + msg.Action + ".",
+	})
+	return false
 }
 
-func (s *Service) handleAuthToken(msg sarif.Message) {
-	tok := "token/std:" + sarif.GenerateId() + sarif.GenerateId() + sarif.GenerateId()
-	s.Config.Tokens[tok] = true
-	s.cfg.Set(s.Config)
+type TokenPayload struct {
+	Label  string   `json:"label,omitempty"`
+	Scopes []string `json:"scopes,omitempty"`
+	// Expires is the duration after which the token becomes invalid,
+	// e.g. "12h" or "30d".
+	Expires string `json:"expires,omitempty"`
+	// Device restricts a one-time password to enrolling this device. It
+	// is required to enroll a device again.
+	Device string `json:"device,omitempty"`
+}
+
+type GeneratedPayload struct {
+	Auth string `json:"auth"`
+	Token
+}
+
+func (p GeneratedPayload) Text() string {
+	return "Generated token " + p.Token.String() + ": " + p.Auth
+}
 
-	s.Reply(msg, sarif.CreateMessage("auth/generated", sarif.ClientInfo{
-		Auth: tok,
+func (s *Service) generate(msg sarif.Message, secret string, defaultExpiry time.Duration) {
+	var p TokenPayload
+	if err := msg.DecodePayload(&p); err != nil {
+		s.ReplyBadRequest(msg, err)
+		return
+	}
+	t := Token{
+		Label:  p.Label,
+		Scopes: p.Scopes,
+		Device: p.Device,
+	}
+	expiry := defaultExpiry
+	if p.Expires != "" {
+		var err error
+		if expiry, err = util.ParseDuration(p.Expires); err != nil || expiry <= 0 {
+			s.ReplyBadRequest(msg, errors.New("invalid expiry "+p.Expires))
+			return
+		}
+		if defaultExpiry > 0 && expiry > defaultExpiry {
+			expiry = defaultExpiry
+		}
+	}
+	if expiry > 0 {
+		t.ExpiresAt = time.Now().Add(expiry)
+	}
+
+	s.mu.Lock()
+	t = s.issue(secret, t)
+	s.mu.Unlock()
+
+	s.Reply(msg, sarif.CreateMessage("auth/generated", GeneratedPayload{
+		Auth:  secret,
+		Token: t.Public(),
 	}))
 }
 
+func (s *Service) handleAuthToken(msg sarif.Message) {
+	if !s.checkTrusted(msg) {
+		return
+	}
+	s.generate(msg, tokenPrefix+sarif.GenerateId()+sarif.GenerateId()+sarif.GenerateId(), 0)
+}
+
 func (s *Service) handleAuthOtp(msg sarif.Message) {
-	tok := "otp/std:" + GenerateDigits()
-	s.SessionTokens[tok] = time.Now().Add(time.Minute)
+	if !s.checkTrusted(msg) {
+		return
+	}
+	s.generate(msg, otpPrefix+GenerateDigits(), otpExpiry)
+}
+
+type ValidatePayload struct {
+	Token string `json:"token"`
+	// Action optionally checks if the token may publish the action.
+	Action string `json:"action,omitempty"`
+}
+
+func (s *Service) handleAuthValidate(msg sarif.Message) {
+	if !s.checkTrusted(msg) {
+		return
+	}
+	var p ValidatePayload
+	if err := msg.DecodePayload(&p); err != nil {
+		s.ReplyBadRequest(msg, err)
+		return
+	}
+
+	t, ok := s.Validate(p.Token)
+	if !ok {
+		s.Reply(msg, sarif.Message{Action: "auth/invalid", Text: "Invalid or expired token."})
+		return
+	}
+	if p.Action != "" && !t.Allows(p.Action) {
+		s.Reply(msg, sarif.Message{Action: "auth/invalid", Text: "Token is not allowed to publish " + p.Action + "."})
+		return
+	}
+	s.Reply(msg, sarif.CreateMessage("auth/valid", t.Public()))
+}
+
+type RevokePayload struct {
+	Id string `json:"id"`
+}
 
-	s.Reply(msg, sarif.CreateMessage("auth/generated", sarif.ClientInfo{
-		Auth: tok,
+func (s *Service) handleAuthRevoke(msg sarif.Message) {
+	if !s.checkTrusted(msg) {
+		return
+	}
+	var p RevokePayload
+	if err := msg.DecodePayload(&p); err != nil {
+		s.ReplyBadRequest(msg, err)
+		return
+	}
+	if s.Revoke(p.Id) {
+		s.Reply(msg, sarif.Message{Action: "auth/revoked", Text: "Token " + p.Id + " revoked."})
+		return
+	}
+
+	// Otherwise revoke certificates by serial or device id
+	s.mu.Lock()
+	n := s.revokeCertificates(p.Id, time.Now())
+	if n > 0 {
+		s.cfg.Set(s.Config)
+	}
+	s.mu.Unlock()
+	if n == 0 {
+		s.ReplyBadRequest(msg, errors.New("unknown token or certificate "+p.Id))
+		return
+	}
+	s.publishCRL()
+	s.Reply(msg, sarif.Message{Action: "auth/revoked", Text: "Certificate " + p.Id + " revoked."})
+}
+
+type EnrollPayload struct {
+	// Otp is a one-time password from auth/new/otp.
+	Otp string `json:"otp"`
+	// Csr is a PEM encoded certificate request. Its common name becomes
+	// the device id.
+	Csr string `json:"csr"`
+}
+
+type EnrolledPayload struct {
+	Device      string    `json:"device"`
+	Serial      string    `json:"serial"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	Certificate string    `json:"certificate"`
+	Authority   string    `json:"authority"`
+}
+
+func (p EnrolledPayload) Text() string {
+	return "Enrolled " + p.Device + " with certificate " + p.Serial + ", expires " + p.ExpiresAt.Format(time.RFC3339)
+}
+
+func (s *Service) handleAuthEnroll(msg sarif.Message) {
+	var p EnrollPayload
+	if err := msg.DecodePayload(&p); err != nil {
+		s.ReplyBadRequest(msg, err)
+		return
+	}
+	csr, device, err := parseCSR(p.Csr)
+	if err != nil {
+		s.ReplyBadRequest(msg, err)
+		return
+	}
+	if _, ok := s.consumeOtp(p.Otp, "auth/enroll", device); !ok {
+		s.Reply(msg, sarif.Message{Action: "auth/invalid", Text: "Invalid or expired one-time password for " + device + "."})
+		return
+	}
+
+	// Enrolling a device again replaces its previous certificates.
+	now := time.Now()
+	s.mu.Lock()
+	c, certPem, err := s.ca.sign(csr, device, now)
+	if err == nil {
+		s.pruneCertificates(now)
+		revoked := s.revokeCertificates(device, now)
+		s.Config.Certificates[c.Serial] = &c
+		s.cfg.Set(s.Config)
+		if revoked > 0 {
+			defer s.publishCRL()
+		}
+	}
+	s.mu.Unlock()
+	if err != nil {
+		s.ReplyInternalError(msg, err)
+		return
+	}
+
+	s.Reply(msg, sarif.CreateMessage("auth/enrolled", EnrolledPayload{
+		Device:      device,
+		Serial:      c.Serial,
+		ExpiresAt:   c.ExpiresAt,
+		Certificate: string(certPem),
+		Authority:   string(s.ca.pem),
 	}))
 }
 
+type CRLPayload struct {
+	// Serials lists the revoked certificates in hex.
+	Serials []string `json:"serials"`
+}
+
+func (p CRLPayload) Text() string {
+	return fmt.Sprintf("%d revoked certificates.", len(p.Serials))
+}
+
+func (s *Service) crl() CRLPayload {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return CRLPayload{s.revocationList()}
+}
+
+// publishCRL announces the revoked certificates to all brokers.
+func (s *Service) publishCRL() {
+	if err := s.Publish(sarif.CreateMessage("auth/crl", s.crl())); err != nil {
+		s.Log("warn", "could not publish revocation list: "+err.Error())
+	}
+}
+
+func (s *Service) handleGetCRL(msg sarif.Message) {
+	s.Reply(msg, sarif.CreateMessage("auth/crl", s.crl()))
+}
+
+type listPayload struct {
+	Tokens       []Token       `json:"tokens"`
+	Certificates []Certificate `json:"certificates,omitempty"`
+}
+
+func (p listPayload) Text() string {
+	if len(p.Tokens)+len(p.Certificates) == 0 {
+		return "No tokens."
+	}
+	lines := make([]string, 0, len(p.Tokens)+len(p.Certificates))
+	for _, t := range p.Tokens {
+		lines = append(lines, t.String())
+	}
+	for _, c := range p.Certificates {
+		lines = append(lines, c.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (s *Service) handleAuthList(msg sarif.Message) {
+	if !s.checkTrusted(msg) {
+		return
+	}
+	s.Reply(msg, sarif.CreateMessage("auth/listed", listPayload{s.List(), s.ListCertificates()}))
+}
+
 func GenerateDigits() string {
 	const num = "123456789"
 	var bytes = make([]byte, 6)
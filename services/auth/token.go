@@ -0,0 +1,187 @@
+// Copyright (C) 2019 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sarifsystems/sarif/sarif"
+)
+
+const (
+	tokenPrefix = "token/std:"
+	otpPrefix   = "otp/std:"
+	otpExpiry   = time.Minute
+
+	// lastUsedResolution limits how often usage of a token is persisted.
+	lastUsedResolution = time.Minute
+)
+
+// Token is an issued credential. Only the hash of the secret is kept.
+type Token struct {
+	Id     string   `json:"id"`
+	Hash   string   `json:"hash,omitempty"`
+	Label  string   `json:"label,omitempty"`
+	Scopes []string `json:"scopes,omitempty"`
+	// Device restricts a one-time password to enrolling this device.
+	Device    string    `json:"device,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+	LastUsed  time.Time `json:"last_used,omitempty"`
+}
+
+func HashToken(tok string) string {
+	sum := sha256.Sum256([]byte(tok))
+	return hex.EncodeToString(sum[:])
+}
+
+func (t Token) Expired(now time.Time) bool {
+	return !t.ExpiresAt.IsZero() && now.After(t.ExpiresAt)
+}
+
+// Allows checks if the scopes of the token cover an action. Tokens without
+// scopes allow everything.
+func (t Token) Allows(action string) bool {
+	if len(t.Scopes) == 0 {
+		return true
+	}
+	msg := sarif.Message{Action: action}
+	for _, scope := range t.Scopes {
+		if msg.IsAction(scope) {
+			return true
+		}
+	}
+	return false
+}
+
+// Public returns the token without its hash.
+func (t Token) Public() Token {
+	t.Hash = ""
+	return t
+}
+
+func (t Token) String() string {
+	s := t.Id
+	if t.Label != "" {
+		s += " (" + t.Label + ")"
+	}
+	if len(t.Scopes) > 0 {
+		s += " for " + strings.Join(t.Scopes, ", ")
+	}
+	if t.Device != "" {
+		s += ", enrolls " + t.Device
+	}
+	if !t.ExpiresAt.IsZero() {
+		s += ", expires " + t.ExpiresAt.Format(time.RFC3339)
+	}
+	if !t.LastUsed.IsZero() {
+		s += ", last used " + t.LastUsed.Format(time.RFC3339)
+	}
+	return s
+}
+
+// issue creates a new token with the given secret. The caller has to
+// hold the lock.
+func (s *Service) issue(secret string, t Token) Token {
+	now := time.Now()
+	s.prune(now)
+	t.Id = sarif.GenerateId()
+	t.Hash = HashToken(secret)
+	t.CreatedAt = now
+	s.Config.ApiTokens[t.Hash] = &t
+	s.cfg.Set(s.Config)
+	return t
+}
+
+// prune removes expired tokens. The caller has to hold the lock.
+func (s *Service) prune(now time.Time) {
+	for hash, t := range s.Config.ApiTokens {
+		if t.Expired(now) {
+			delete(s.Config.ApiTokens, hash)
+		}
+	}
+}
+
+// Validate looks up a token and records its usage.
+func (s *Service) Validate(secret string) (Token, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	t, ok := s.Config.ApiTokens[HashToken(secret)]
+	if !ok {
+		return Token{}, false
+	}
+	if t.Expired(now) {
+		s.prune(now)
+		s.cfg.Set(s.Config)
+		return Token{}, false
+	}
+	if now.Sub(t.LastUsed) > lastUsedResolution {
+		t.LastUsed = now
+		s.cfg.Set(s.Config)
+	}
+	return *t, true
+}
+
+// consumeOtp validates a one-time password for an action on a device and
+// removes it in the same step, so that it cannot be used twice. Devices that
+// are enrolled already only accept passwords that were issued for them.
+func (s *Service) consumeOtp(secret, action, device string) (Token, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hash := HashToken(secret)
+	t, ok := s.Config.ApiTokens[hash]
+	if !ok || !strings.HasPrefix(secret, otpPrefix) {
+		return Token{}, false
+	}
+	now := time.Now()
+	if t.Expired(now) || !t.Allows(action) {
+		return Token{}, false
+	}
+	if t.Device != device && (t.Device != "" || s.isEnrolled(device, now)) {
+		return Token{}, false
+	}
+	delete(s.Config.ApiTokens, hash)
+	s.cfg.Set(s.Config)
+	return *t, true
+}
+
+// Revoke removes the token with the given id.
+func (s *Service) Revoke(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for hash, t := range s.Config.ApiTokens {
+		if t.Id == id {
+			delete(s.Config.ApiTokens, hash)
+			s.cfg.Set(s.Config)
+			return true
+		}
+	}
+	return false
+}
+
+// List returns all valid tokens, oldest first.
+func (s *Service) List() []Token {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.prune(time.Now())
+	tokens := make([]Token, 0, len(s.Config.ApiTokens))
+	for _, t := range s.Config.ApiTokens {
+		tokens = append(tokens, t.Public())
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].CreatedAt.Before(tokens[j].CreatedAt)
+	})
+	return tokens
+}
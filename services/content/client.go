@@ -0,0 +1,173 @@
+// Copyright (C) 2019 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package content
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+
+	"github.com/sarifsystems/sarif/pkg/content"
+	"github.com/sarifsystems/sarif/pkg/schema"
+	"github.com/sarifsystems/sarif/sarif"
+)
+
+var (
+	ErrNotFound = errors.New("Blob not found.")
+	ErrNoReply  = errors.New("no reply received.")
+)
+
+// Client transfers blobs from and to a remote content service in chunks.
+// It implements content.Provider, so it can be registered for the
+// sha256 scheme on devices that fetch attachments lazily.
+type Client struct {
+	client    sarif.Client
+	ChunkSize int
+	Service   string
+
+	// Cache optionally keeps downloaded blobs locally.
+	Cache *content.BlobStore
+}
+
+func NewClient(c sarif.Client) *Client {
+	return &Client{
+		client:    c,
+		ChunkSize: DefaultChunkSize,
+	}
+}
+
+func checkErr(reply sarif.Message, ok bool) error {
+	if !ok {
+		return ErrNoReply
+	}
+	if reply.IsAction("err/notfound") {
+		return ErrNotFound
+	}
+	if reply.IsAction("err") {
+		return errors.New(reply.Text)
+	}
+	return nil
+}
+
+func (c *Client) request(action string, pl interface{}) (sarif.Message, error) {
+	req := sarif.CreateMessage(action, pl)
+	req.Destination = c.Service
+	reply, ok := <-c.client.Request(req)
+	return reply, checkErr(reply, ok)
+}
+
+// Upload stores data on the content service.
+func (c *Client) Upload(data []byte) (content.BlobInfo, error) {
+	var info content.BlobInfo
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	if reply, err := c.request("content/stat/"+hash, nil); err == nil {
+		return info, reply.DecodePayload(&info)
+	}
+
+	chunk := PutChunk{Hash: hash}
+	for {
+		size := c.ChunkSize
+		if rest := len(data) - int(chunk.Offset); rest <= size {
+			size, chunk.Final = rest, true
+		}
+		chunk.Data = data[chunk.Offset : chunk.Offset+int64(size)]
+
+		reply, err := c.request("content/put", chunk)
+		if err != nil {
+			return info, err
+		}
+		if chunk.Final {
+			return info, reply.DecodePayload(&info)
+		}
+
+		var status UploadStatus
+		if err := reply.DecodePayload(&status); err != nil {
+			return info, err
+		}
+		chunk.Upload, chunk.Offset = status.Upload, status.Offset
+		if status.ChunkSize > 0 && status.ChunkSize < c.ChunkSize {
+			c.ChunkSize = status.ChunkSize
+		}
+	}
+}
+
+// Download fetches the complete blob with the given hash.
+func (c *Client) Download(hash string) ([]byte, error) {
+	if c.Cache != nil && c.Cache.Has(hash) {
+		got, err := c.Cache.Get(schema.Content{Url: content.BlobUrl(hash)})
+		return got.Data, err
+	}
+
+	var buf bytes.Buffer
+	req := GetChunk{Hash: hash, Length: c.ChunkSize}
+	for {
+		reply, err := c.request("content/get", req)
+		if err != nil {
+			return nil, err
+		}
+		var chunk Chunk
+		if err := reply.DecodePayload(&chunk); err != nil {
+			return nil, err
+		}
+		buf.Write(chunk.Data)
+		req.Offset += int64(len(chunk.Data))
+		if chunk.EOF || len(chunk.Data) == 0 {
+			break
+		}
+	}
+
+	sum := sha256.Sum256(buf.Bytes())
+	if hex.EncodeToString(sum[:]) != hash {
+		return nil, errors.New("Downloaded blob does not match hash " + hash)
+	}
+	if c.Cache != nil {
+		if _, err := c.Cache.Store(buf.Bytes()); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// Stat returns information about a remote blob.
+func (c *Client) Stat(hash string) (content.BlobInfo, error) {
+	var info content.BlobInfo
+	reply, err := c.request("content/stat/"+hash, nil)
+	if err != nil {
+		return info, err
+	}
+	return info, reply.DecodePayload(&info)
+}
+
+func (c *Client) Get(ct schema.Content) (schema.Content, error) {
+	hash, err := content.ParseBlobUrl(ct.Url)
+	if err != nil {
+		return ct, err
+	}
+	if ct.Data, err = c.Download(hash); err != nil {
+		return ct, err
+	}
+	if ct.Type == "" {
+		ct.Type = content.DetectContentType(ct.Data)
+	}
+	return ct, nil
+}
+
+func (c *Client) Put(ct schema.Content) (schema.Content, error) {
+	info, err := c.Upload(ct.Data)
+	if err != nil {
+		return ct, err
+	}
+	if ct.Type == "" {
+		ct.Type = info.Type
+	}
+	return schema.Content{
+		Url:  info.Url,
+		Type: ct.Type,
+		Name: ct.Name,
+	}, nil
+}
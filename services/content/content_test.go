@@ -0,0 +1,136 @@
+// Copyright (C) 2019 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package content
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/sarifsystems/sarif/sarif"
+	"github.com/sarifsystems/sarif/transports/sfproto"
+)
+
+type testConfig struct {
+	dir string
+}
+
+func (testConfig) Exists() bool                    { return false }
+func (testConfig) Set(v interface{}) error         { return nil }
+func (testConfig) Get(v interface{}) (error, bool) { return nil, false }
+func (c testConfig) Dir() string                   { return c.dir }
+
+func newTestService(t *testing.T) (*Service, *Client, func()) {
+	dir, err := ioutil.TempDir("", "sarif-content-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	broker := sfproto.NewBroker()
+	sc, err := broker.NewClient(sarif.ClientInfo{Name: "content"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewService(&Dependencies{Config: testConfig{dir}, Client: sc})
+	if err := s.Enable(); err != nil {
+		t.Fatal(err)
+	}
+	s.Cfg.ChunkSize = 16
+
+	cc, err := broker.NewClient(sarif.ClientInfo{Name: "user"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewClient(cc)
+	c.ChunkSize = 10
+	return s, c, func() { os.RemoveAll(dir) }
+}
+
+func hashOf(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestServiceRoundTrip(t *testing.T) {
+	s, c, cleanup := newTestService(t)
+	defer cleanup()
+
+	data := []byte("The quick brown fox jumps over the lazy dog, twice and again.")
+	info, err := c.Upload(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Hash != hashOf(data) || info.Size != int64(len(data)) {
+		t.Errorf("unexpected info: %+v", info)
+	}
+	if !s.Blobs.Has(info.Hash) {
+		t.Error("blob was not stored")
+	}
+
+	stat, err := c.Stat(info.Hash)
+	if err != nil || stat.Size != info.Size {
+		t.Errorf("unexpected stat %+v: %v", stat, err)
+	}
+
+	// Downloads are chunked by the smaller chunk size of the service
+	c.ChunkSize = 1000
+	got, err := c.Download(info.Hash)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Errorf("unexpected download %q: %v", got, err)
+	}
+
+	// Uploading again is answered by stat
+	if again, err := c.Upload(data); err != nil || again.Hash != info.Hash {
+		t.Errorf("unexpected second upload %+v: %v", again, err)
+	}
+
+	if _, err := c.Stat(hashOf([]byte("missing"))); err != ErrNotFound {
+		t.Error("expected not found, got", err)
+	}
+	if _, err := c.Download(hashOf([]byte("missing"))); err != ErrNotFound {
+		t.Error("expected not found, got", err)
+	}
+}
+
+func TestServiceHashMismatch(t *testing.T) {
+	s, c, cleanup := newTestService(t)
+	defer cleanup()
+
+	data := []byte("some data")
+	reply, err := c.request("content/put", PutChunk{
+		Data:  data,
+		Final: true,
+		Hash:  hashOf([]byte("other data")),
+	})
+	if err == nil || !reply.IsAction("err/badrequest") {
+		t.Fatal("expected mismatch to be rejected, got", reply)
+	}
+	if s.Blobs.Has(hashOf(data)) {
+		t.Error("blob with wrong hash was stored")
+	}
+	if tmp, _ := ioutil.ReadDir(s.Cfg.Dir + "/tmp"); len(tmp) != 0 {
+		t.Error("temporary data was not discarded")
+	}
+}
+
+func TestServiceMaxSize(t *testing.T) {
+	s, c, cleanup := newTestService(t)
+	defer cleanup()
+	s.Cfg.MaxSize = 25
+
+	data := bytes.Repeat([]byte("x"), 30)
+	if _, err := c.Upload(data); err == nil {
+		t.Fatal("expected upload to exceed maximum size")
+	}
+	if s.Blobs.Has(hashOf(data)) {
+		t.Error("oversized blob was stored")
+	}
+	if len(s.uploads) != 0 {
+		t.Error("aborted upload was not removed")
+	}
+}
@@ -0,0 +1,283 @@
+// Copyright (C) 2019 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// Service content provides a content-addressed blob store to the sarif network.
+package content
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sarifsystems/sarif/pkg/content"
+	"github.com/sarifsystems/sarif/sarif"
+	"github.com/sarifsystems/sarif/services"
+)
+
+var Module = &services.Module{
+	Name:        "content",
+	Version:     "1.0",
+	NewInstance: NewService,
+}
+
+const (
+	DefaultChunkSize = 256 * 1024
+	DefaultMaxSize   = 1024 * 1024 * 1024
+)
+
+var uploadTimeout = 10 * time.Minute
+
+type Config struct {
+	Dir       string
+	ChunkSize int
+	// MaxSize limits the size of a single upload in bytes.
+	MaxSize int64
+}
+
+type Dependencies struct {
+	Config services.Config
+	Client sarif.Client
+}
+
+type Service struct {
+	Config services.Config
+	Cfg    Config
+	Blobs  *content.BlobStore
+	sarif.Client
+
+	mutex   sync.Mutex
+	uploads map[string]*upload
+}
+
+type upload struct {
+	*content.BlobWriter
+	LastActivity time.Time
+}
+
+func NewService(deps *Dependencies) *Service {
+	s := &Service{
+		Config:  deps.Config,
+		Client:  deps.Client,
+		uploads: make(map[string]*upload),
+	}
+	return s
+}
+
+func (s *Service) Enable() (err error) {
+	if dir := s.Config.Dir(); dir != "" {
+		s.Cfg.Dir = dir + "/blobs"
+	}
+	s.Cfg.ChunkSize = DefaultChunkSize
+	s.Cfg.MaxSize = DefaultMaxSize
+	s.Config.Get(&s.Cfg)
+	if s.Cfg.Dir == "" {
+		return errors.New("content: no blob directory set in config!")
+	}
+
+	if s.Blobs, err = content.NewBlobStore(s.Cfg.Dir); err != nil {
+		return err
+	}
+	content.Register("sha256", s.Blobs)
+	content.Register("blob", s.Blobs)
+
+	s.Subscribe("content/put", "", s.handlePut)
+	s.Subscribe("content/get", "", s.handleGet)
+	s.Subscribe("content/stat", "", s.handleStat)
+	return nil
+}
+
+func (s *Service) Disable() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for id, u := range s.uploads {
+		u.Abort()
+		delete(s.uploads, id)
+	}
+	return nil
+}
+
+// PutChunk is a single part of a chunked upload. The first chunk of an
+// upload is sent without an upload id, the service assigns one in its reply.
+type PutChunk struct {
+	Upload string `json:"upload,omitempty"`
+	Offset int64  `json:"offset"`
+	Data   []byte `json:"data,omitempty"`
+	Final  bool   `json:"final,omitempty"`
+	Hash   string `json:"hash,omitempty"`
+}
+
+// UploadStatus is the reply to a non-final chunk.
+type UploadStatus struct {
+	Upload    string `json:"upload"`
+	Offset    int64  `json:"offset"`
+	ChunkSize int    `json:"chunk_size"`
+}
+
+// GetChunk requests a range of a blob.
+type GetChunk struct {
+	Hash   string `json:"hash,omitempty"`
+	Offset int64  `json:"offset"`
+	Length int    `json:"length,omitempty"`
+}
+
+// Chunk is a range of blob data.
+type Chunk struct {
+	Hash   string `json:"hash"`
+	Offset int64  `json:"offset"`
+	Size   int64  `json:"size"`
+	Data   []byte `json:"data"`
+	EOF    bool   `json:"eof,omitempty"`
+}
+
+func (s *Service) expireUploads() {
+	for id, u := range s.uploads {
+		if time.Since(u.LastActivity) > uploadTimeout {
+			u.Abort()
+			delete(s.uploads, id)
+		}
+	}
+}
+
+func (s *Service) handlePut(msg sarif.Message) {
+	var p PutChunk
+	if err := msg.DecodePayload(&p); err != nil {
+		s.ReplyBadRequest(msg, err)
+		return
+	}
+	if p.Data == nil && msg.Text != "" && p.Upload == "" {
+		p.Data, p.Final = []byte(msg.Text), true
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.expireUploads()
+
+	u, ok := s.uploads[p.Upload]
+	if p.Upload == "" {
+		w, err := s.Blobs.Create()
+		if err != nil {
+			s.ReplyInternalError(msg, err)
+			return
+		}
+		p.Upload = sarif.GenerateId()
+		u = &upload{BlobWriter: w}
+		s.uploads[p.Upload] = u
+	} else if !ok {
+		s.ReplyBadRequest(msg, errors.New("Unknown upload "+p.Upload))
+		return
+	}
+	u.LastActivity = time.Now()
+
+	if p.Offset != u.Size() {
+		s.ReplyBadRequest(msg, errors.New("Unexpected chunk offset"))
+		return
+	}
+	if s.Cfg.MaxSize > 0 && u.Size()+int64(len(p.Data)) > s.Cfg.MaxSize {
+		u.Abort()
+		delete(s.uploads, p.Upload)
+		s.ReplyBadRequest(msg, fmt.Errorf("Upload exceeds maximum size of %d bytes", s.Cfg.MaxSize))
+		return
+	}
+	if _, err := u.Write(p.Data); err != nil {
+		u.Abort()
+		delete(s.uploads, p.Upload)
+		s.ReplyInternalError(msg, err)
+		return
+	}
+
+	if !p.Final {
+		s.Reply(msg, sarif.CreateMessage("content/uploading", UploadStatus{
+			Upload:    p.Upload,
+			Offset:    u.Size(),
+			ChunkSize: s.Cfg.ChunkSize,
+		}))
+		return
+	}
+
+	delete(s.uploads, p.Upload)
+	if hash := u.Hash(); p.Hash != "" && !strings.EqualFold(p.Hash, hash) {
+		u.Abort()
+		s.ReplyBadRequest(msg, errors.New("Hash mismatch, got "+hash))
+		return
+	}
+	info, err := u.Commit()
+	if err != nil {
+		s.ReplyInternalError(msg, err)
+		return
+	}
+	s.Reply(msg, sarif.CreateMessage("content/stored", info))
+}
+
+func hashFromAction(msg sarif.Message, prefix, hash string) string {
+	if hash != "" {
+		return hash
+	}
+	return strings.TrimPrefix(msg.ActionSuffix(prefix), "sha256:")
+}
+
+func (s *Service) replyNotFound(msg sarif.Message, hash string) {
+	s.Reply(msg, sarif.Message{
+		Action: "err/notfound",
+		Text:   "Blob " + hash + " not found.",
+	})
+}
+
+func (s *Service) handleGet(msg sarif.Message) {
+	var p GetChunk
+	if err := msg.DecodePayload(&p); err != nil {
+		s.ReplyBadRequest(msg, err)
+		return
+	}
+	p.Hash = hashFromAction(msg, "content/get", p.Hash)
+	if p.Length <= 0 || p.Length > s.Cfg.ChunkSize {
+		p.Length = s.Cfg.ChunkSize
+	}
+
+	info, err := s.Blobs.Stat(p.Hash)
+	if err == content.ErrBlobNotFound {
+		s.replyNotFound(msg, p.Hash)
+		return
+	} else if err != nil {
+		s.ReplyBadRequest(msg, err)
+		return
+	}
+
+	buf := make([]byte, p.Length)
+	n, err := s.Blobs.ReadAt(p.Hash, buf, p.Offset)
+	if err != nil && err != io.EOF {
+		s.ReplyInternalError(msg, err)
+		return
+	}
+
+	s.Reply(msg, sarif.CreateMessage("content/chunk", Chunk{
+		Hash:   info.Hash,
+		Offset: p.Offset,
+		Size:   info.Size,
+		Data:   buf[0:n],
+		EOF:    p.Offset+int64(n) >= info.Size,
+	}))
+}
+
+func (s *Service) handleStat(msg sarif.Message) {
+	var p GetChunk
+	if err := msg.DecodePayload(&p); err != nil {
+		s.ReplyBadRequest(msg, err)
+		return
+	}
+	p.Hash = hashFromAction(msg, "content/stat", p.Hash)
+
+	info, err := s.Blobs.Stat(p.Hash)
+	if err == content.ErrBlobNotFound {
+		s.replyNotFound(msg, p.Hash)
+		return
+	} else if err != nil {
+		s.ReplyBadRequest(msg, err)
+		return
+	}
+	s.Reply(msg, sarif.CreateMessage("content/info", info))
+}
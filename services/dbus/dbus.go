@@ -5,7 +5,19 @@
 
 package dbus
 
-import "github.com/godbus/dbus"
+import (
+	"time"
+
+	"github.com/godbus/dbus"
+)
+
+const notificationsIface = "org.freedesktop.Notifications"
+
+var urgencies = map[string]byte{
+	"low":      0,
+	"normal":   1,
+	"critical": 2,
+}
 
 type NotificationObject struct {
 	dbus.BusObject
@@ -17,15 +29,58 @@ func NewNotificationObject(conn *dbus.Conn) *NotificationObject {
 	}
 }
 
+// Notification is a desktop notification as understood by the
+// freedesktop notification spec. Actions are pairs of key and label.
+// A zero timeout uses the server default, a negative one never expires.
+type Notification struct {
+	Summary string
+	Body    string
+	Icon    string
+	Urgency string
+	Timeout time.Duration
+	Actions []string
+}
+
 func (o *NotificationObject) Notify(summary, body string) error {
-	method := "org.freedesktop.Notifications.Notify"
+	_, err := o.Send(Notification{
+		Summary: summary,
+		Body:    body,
+		Timeout: 5 * time.Second,
+	})
+	return err
+}
+
+// Send shows the notification and returns its id, which is referenced by
+// the ActionInvoked and NotificationClosed signals.
+func (o *NotificationObject) Send(n Notification) (uint32, error) {
+	method := notificationsIface + ".Notify"
 	name := "kipp"
 	replacesId := uint32(0)
-	icon := ""
-	actions := []string{}
+	actions := n.Actions
+	if actions == nil {
+		actions = []string{}
+	}
 	hints := map[string]dbus.Variant{}
-	timeout := int32(5000)
+	if u, ok := urgencies[n.Urgency]; ok {
+		hints["urgency"] = dbus.MakeVariant(u)
+	}
+	timeout := int32(-1)
+	if n.Timeout > 0 {
+		timeout = int32(n.Timeout / time.Millisecond)
+	} else if n.Timeout < 0 {
+		timeout = 0
+	}
+
+	var id uint32
+	c := o.Call(method, 0, name, replacesId, n.Icon, n.Summary, n.Body, actions, hints, timeout)
+	err := c.Store(&id)
+	return id, err
+}
 
-	c := o.Call(method, 0, name, replacesId, icon, summary, body, actions, hints, timeout)
-	return c.Err
+// Capabilities returns the optional features supported by the server,
+// such as "actions" or "body-markup".
+func (o *NotificationObject) Capabilities() ([]string, error) {
+	var caps []string
+	err := o.Call(notificationsIface+".GetCapabilities", 0).Store(&caps)
+	return caps, err
 }
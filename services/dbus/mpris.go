@@ -7,50 +7,206 @@ package dbus
 
 import (
 	"strings"
+	"time"
 
-	"github.com/davecgh/go-spew/spew"
 	"github.com/godbus/dbus"
+	"github.com/sarifsystems/sarif/pkg/schema"
+)
+
+const (
+	mprisPrefix = "org.mpris.MediaPlayer2."
+	mprisPath   = "/org/mpris/MediaPlayer2"
+	mprisPlayer = "org.mpris.MediaPlayer2.Player"
 )
 
 type MprisPlayer struct {
 	Id       string `json:"-"`
+	Bus      string `json:"bus"`
 	Name     string `json:"name"`
 	Identity string `json:"identity"`
 	Status   string `json:"status"`
 
-	Title  string `json:"title,omitempty"`
-	Artist string `json:"artist,omitempty"`
-	Album  string `json:"album,omitempty"`
-	Url    string `json:"url,omitempty"`
+	Title   string          `json:"title,omitempty"`
+	Artist  string          `json:"artist,omitempty"`
+	Album   string          `json:"album,omitempty"`
+	Url     string          `json:"url,omitempty"`
+	TrackId dbus.ObjectPath `json:"-"`
+	Length  int64           `json:"-"`
+
+	Started    time.Time     `json:"-"`
+	Played     time.Duration `json:"-"`
+	Resumed    time.Time     `json:"-"`
+	LastActive time.Time     `json:"-"`
+
+	obj dbus.BusObject
+}
+
+func NewMprisPlayer(conn *dbus.Conn, id, bus string) *MprisPlayer {
+	return &MprisPlayer{
+		Id:  id,
+		Bus: bus,
+		obj: conn.Object(id, mprisPath),
+	}
 }
 
-func (p *MprisPlayer) UpdateProperties(props map[string]dbus.Variant) {
-	changed := false
+// Fetch reads the identity and current playback state of the player.
+func (p *MprisPlayer) Fetch() error {
+	if v, err := p.obj.GetProperty("org.mpris.MediaPlayer2.Identity"); err == nil {
+		p.Identity, _ = v.Value().(string)
+	}
+	if v, err := p.obj.GetProperty("org.mpris.MediaPlayer2.DesktopEntry"); err == nil {
+		p.Name, _ = v.Value().(string)
+	}
+
+	props := make(map[string]dbus.Variant)
+	c := p.obj.Call("org.freedesktop.DBus.Properties.GetAll", 0, mprisPlayer)
+	if err := c.Store(&props); err != nil {
+		return err
+	}
+	p.UpdateProperties(props)
+	return nil
+}
+
+// UpdateProperties applies changed player properties and reports whether
+// a different track is now loaded.
+func (p *MprisPlayer) UpdateProperties(props map[string]dbus.Variant) (trackChanged bool) {
 	if v, ok := props["PlaybackStatus"]; ok {
-		p.Status = strings.ToLower(v.Value().(string))
-		changed = true
+		status, _ := v.Value().(string)
+		p.Status = strings.ToLower(status)
 	}
 	if v, ok := props["Metadata"]; ok {
-		m := v.Value().(map[string]dbus.Variant)
-		if vv, ok := m["xesam:title"]; ok {
-			p.Title = vv.Value().(string)
-			changed = true
-		}
-		if vv, ok := m["xesam:url"]; ok {
-			p.Url = vv.Value().(string)
-			changed = true
+		m, _ := v.Value().(map[string]dbus.Variant)
+		prev := *p
+		switch id := m["mpris:trackid"].Value().(type) {
+		case dbus.ObjectPath:
+			p.TrackId = id
+		case string:
+			p.TrackId = dbus.ObjectPath(id)
+		default:
+			p.TrackId = ""
 		}
-		if vv, ok := m["xesam:artist"]; ok {
-			p.Artist = vv.Value().([]string)[0]
-			changed = true
+		p.Title, _ = m["xesam:title"].Value().(string)
+		p.Url, _ = m["xesam:url"].Value().(string)
+		p.Album, _ = m["xesam:album"].Value().(string)
+		p.Artist = ""
+		if artists, ok := m["xesam:artist"].Value().([]string); ok && len(artists) > 0 {
+			p.Artist = artists[0]
 		}
-		if vv, ok := m["xesam:album"]; ok {
-			p.Album = vv.Value().(string)
-			changed = true
+		p.Length = toInt64(m["mpris:length"].Value())
+		trackChanged = p.TrackId != prev.TrackId ||
+			p.Title != prev.Title || p.Artist != prev.Artist
+	}
+	return trackChanged
+}
+
+func (p *MprisPlayer) IsPlaying() bool {
+	return p.Status == "playing"
+}
+
+// Matches reports whether the player is known under the given name,
+// either by desktop entry, identity or bus name.
+func (p *MprisPlayer) Matches(name string) bool {
+	name = strings.ToLower(name)
+	return name == strings.ToLower(p.Name) ||
+		name == strings.ToLower(p.Identity) ||
+		name == strings.ToLower(strings.TrimPrefix(p.Bus, mprisPrefix))
+}
+
+func (p *MprisPlayer) Info() schema.MusicInfo {
+	name := p.Name
+	if name == "" {
+		name = strings.TrimPrefix(p.Bus, mprisPrefix)
+	}
+	return schema.MusicInfo{
+		IsPlaying: p.IsPlaying(),
+		Player:    name,
+		Time:      p.Started,
+
+		Artist:   p.Artist,
+		Album:    p.Album,
+		Track:    p.Title,
+		Duration: int(p.Length / 1e6),
+	}
+}
+
+// FetchPosition reads the current position and volume into info, as players
+// do not signal changes to them.
+func (p *MprisPlayer) FetchPosition(info *schema.MusicInfo) {
+	if v, err := p.obj.GetProperty(mprisPlayer + ".Position"); err == nil {
+		info.Position = int(toInt64(v.Value()) / 1e6)
+	}
+	if vol, err := p.volume(); err == nil {
+		info.Volume = int(vol*100 + 0.5)
+	}
+}
+
+func (p *MprisPlayer) Play() error {
+	return p.obj.Call(mprisPlayer+".Play", 0).Err
+}
+
+func (p *MprisPlayer) Pause() error {
+	return p.obj.Call(mprisPlayer+".Pause", 0).Err
+}
+
+func (p *MprisPlayer) Next() error {
+	return p.obj.Call(mprisPlayer+".Next", 0).Err
+}
+
+func (p *MprisPlayer) Previous() error {
+	return p.obj.Call(mprisPlayer+".Previous", 0).Err
+}
+
+// Seek moves to the given position in seconds, or by the given offset if
+// relative is set.
+func (p *MprisPlayer) Seek(track dbus.ObjectPath, pos int, relative bool) error {
+	usec := int64(pos) * 1e6
+	if relative {
+		return p.obj.Call(mprisPlayer+".Seek", 0, usec).Err
+	}
+	return p.obj.Call(mprisPlayer+".SetPosition", 0, track, usec).Err
+}
+
+// SetVolume sets the volume in percent, or changes it by the given amount
+// if relative is set.
+func (p *MprisPlayer) SetVolume(vol int, relative bool) error {
+	v := float64(vol) / 100
+	if relative {
+		cur, err := p.volume()
+		if err != nil {
+			return err
 		}
+		v += cur
 	}
+	if v < 0 {
+		v = 0
+	} else if v > 1 {
+		v = 1
+	}
+	return p.obj.Call("org.freedesktop.DBus.Properties.Set", 0,
+		mprisPlayer, "Volume", dbus.MakeVariant(v)).Err
+}
+
+func (p *MprisPlayer) volume() (float64, error) {
+	v, err := p.obj.GetProperty(mprisPlayer + ".Volume")
+	if err != nil {
+		return 0, err
+	}
+	vol, _ := v.Value().(float64)
+	return vol, nil
+}
 
-	if changed {
-		spew.Dump(p)
+func toInt64(v interface{}) int64 {
+	switch n := v.(type) {
+	case int64:
+		return n
+	case uint64:
+		return int64(n)
+	case int32:
+		return int64(n)
+	case uint32:
+		return int64(n)
+	case float64:
+		return int64(n)
 	}
+	return 0
 }
@@ -0,0 +1,199 @@
+// Copyright (C) 2017 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package dbus
+
+import (
+	"html"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/godbus/dbus"
+	"github.com/sarifsystems/sarif/pkg/schema"
+	"github.com/sarifsystems/sarif/pkg/util"
+	"github.com/sarifsystems/sarif/sarif"
+)
+
+// pendingTimeout is how long an unanswered notification with actions is
+// remembered if the server never reports it as closed.
+const pendingTimeout = 24 * time.Hour
+
+// pendingNotification links a shown notification to the message that
+// requested it, so that invoked actions can be replied to.
+type pendingNotification struct {
+	Msg     sarif.Message
+	Actions []schema.Action
+	Created time.Time
+}
+
+func (s *Service) handleNotify(msg sarif.Message) {
+	var pl schema.Notification
+	msg.DecodePayload(&pl)
+	if pl.Title == "" && pl.Body == "" {
+		pl.Title = msg.Text
+	}
+
+	o := NewNotificationObject(s.Session)
+	caps, err := o.Capabilities()
+	if err != nil {
+		s.Log.Errorln("[dbus] notify capabilities:", err)
+	}
+
+	n := Notification{
+		Summary: pl.Title,
+		Body:    pl.Body,
+		Icon:    pl.Icon,
+		Urgency: pl.Urgency,
+	}
+	if hasCapability(caps, "body-markup") {
+		n.Body = html.EscapeString(n.Body)
+		n.Body = joinLines(n.Body, renderAttachments(pl.Attachments, true))
+	} else {
+		n.Body = joinLines(n.Body, renderAttachments(pl.Attachments, false))
+	}
+	if pl.Timeout == "never" {
+		n.Timeout = -1
+	} else if pl.Timeout != "" {
+		if n.Timeout, err = util.ParseDuration(pl.Timeout); err != nil {
+			s.ReplyBadRequest(msg, err)
+			return
+		}
+	}
+	if hasCapability(caps, "actions") {
+		for i, a := range pl.Actions {
+			n.Actions = append(n.Actions, strconv.Itoa(i), a.Name)
+		}
+	}
+
+	id, err := o.Send(n)
+	if err != nil {
+		s.Log.Errorln("[dbus] notify err:", err)
+		return
+	}
+	if len(n.Actions) > 0 {
+		s.addPending(id, pendingNotification{msg, pl.Actions, time.Now()})
+	}
+}
+
+func (s *Service) addPending(id uint32, p pendingNotification) {
+	s.notifyMutex.Lock()
+	defer s.notifyMutex.Unlock()
+
+	for id, p := range s.pending {
+		if time.Since(p.Created) > pendingTimeout {
+			delete(s.pending, id)
+		}
+	}
+	s.pending[id] = p
+}
+
+func (s *Service) popPending(id uint32) (pendingNotification, bool) {
+	s.notifyMutex.Lock()
+	defer s.notifyMutex.Unlock()
+
+	p, ok := s.pending[id]
+	delete(s.pending, id)
+	return p, ok
+}
+
+// handleActionInvoked replies to the original notify request with the
+// reply action and payload of the chosen notification action.
+func (s *Service) handleActionInvoked(v *dbus.Signal) {
+	id, _ := v.Body[0].(uint32)
+	key, _ := v.Body[1].(string)
+	p, ok := s.popPending(id)
+	if !ok {
+		return
+	}
+
+	i, err := strconv.Atoi(key)
+	if err != nil || i < 0 || i >= len(p.Actions) {
+		return
+	}
+	a := p.Actions[i]
+
+	action := a.Reply
+	if action == "" {
+		action = "notify/action"
+	}
+	reply := sarif.CreateMessage(action, a.Payload)
+	reply.Text = a.Name
+	s.Reply(p.Msg, reply)
+}
+
+func (s *Service) handleNotificationClosed(v *dbus.Signal) {
+	id, _ := v.Body[0].(uint32)
+	s.popPending(id)
+}
+
+func hasCapability(caps []string, c string) bool {
+	for _, v := range caps {
+		if v == c {
+			return true
+		}
+	}
+	return false
+}
+
+func joinLines(a, b string) string {
+	if a == "" {
+		return b
+	}
+	if b == "" {
+		return a
+	}
+	return a + "\n" + b
+}
+
+// renderAttachments formats attachments for the notification body, using
+// the basic markup subset of the notification spec if supported.
+func renderAttachments(atts []schema.Attachment, markup bool) string {
+	esc := func(s string) string { return s }
+	bold := esc
+	italic := esc
+	link := func(text, href string) string { return text }
+	if markup {
+		esc = html.EscapeString
+		bold = func(s string) string { return "<b>" + s + "</b>" }
+		italic = func(s string) string { return "<i>" + s + "</i>" }
+		link = func(text, href string) string {
+			return `<a href="` + html.EscapeString(href) + `">` + text + "</a>"
+		}
+	}
+
+	lines := make([]string, 0)
+	for _, a := range atts {
+		if a.Pretext != "" {
+			lines = append(lines, esc(a.Pretext))
+		}
+		if a.AuthorName != "" {
+			author := esc(a.AuthorName)
+			if a.AuthorLink != "" {
+				author = link(author, a.AuthorLink)
+			}
+			lines = append(lines, italic(author))
+		}
+		if a.Title != "" {
+			title := bold(esc(a.Title))
+			if a.TitleLink != "" {
+				title = link(title, a.TitleLink)
+			}
+			lines = append(lines, title)
+		}
+		if a.Text != "" {
+			lines = append(lines, esc(a.Text))
+		} else if a.Title == "" && len(a.Fields) == 0 && a.Fallback != "" {
+			lines = append(lines, esc(a.Fallback))
+		}
+		for _, f := range a.Fields {
+			lines = append(lines, bold(esc(f.Title)+":")+" "+esc(f.Value))
+		}
+		if a.Footer != "" {
+			lines = append(lines, italic(esc(a.Footer)))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
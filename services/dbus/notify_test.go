@@ -0,0 +1,47 @@
+// Copyright (C) 2017 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package dbus
+
+import (
+	"testing"
+
+	"github.com/sarifsystems/sarif/pkg/schema"
+)
+
+func TestRenderAttachments(t *testing.T) {
+	atts := []schema.Attachment{
+		{
+			Title:     "Rock & Roll",
+			TitleLink: "http://example.com/?a=1&b=2",
+			Text:      "Tonight <live>",
+		},
+		{
+			Fields: []schema.AttachmentField{
+				{Title: "Venue", Value: "Club"},
+			},
+			Footer: "via sarif",
+		},
+		{Fallback: "Image of a cat."},
+	}
+
+	exp := `<a href="http://example.com/?a=1&amp;b=2"><b>Rock &amp; Roll</b></a>
+Tonight &lt;live&gt;
+<b>Venue:</b> Club
+<i>via sarif</i>
+Image of a cat.`
+	if got := renderAttachments(atts, true); got != exp {
+		t.Errorf("unexpected markup:\n%s\nexpected:\n%s", got, exp)
+	}
+
+	exp = `Rock & Roll
+Tonight <live>
+Venue: Club
+via sarif
+Image of a cat.`
+	if got := renderAttachments(atts, false); got != exp {
+		t.Errorf("unexpected text:\n%s\nexpected:\n%s", got, exp)
+	}
+}
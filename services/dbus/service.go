@@ -7,7 +7,12 @@
 package dbus
 
 import (
+	"strings"
+	"sync"
+	"time"
+
 	"github.com/godbus/dbus"
+	"github.com/sarifsystems/sarif/pkg/schema"
 	"github.com/sarifsystems/sarif/sarif"
 	"github.com/sarifsystems/sarif/services"
 	"github.com/sarifsystems/sarif/transports/sfproto"
@@ -30,7 +35,12 @@ type Service struct {
 	System  *dbus.Conn
 	sarif.Client
 
-	Players map[string]*MprisPlayer
+	Players      map[string]*MprisPlayer
+	playersMutex sync.Mutex
+	active       schema.ActiveMusicPlayer
+
+	pending     map[uint32]pendingNotification
+	notifyMutex sync.Mutex
 }
 
 func NewService(deps *Dependencies) *Service {
@@ -39,6 +49,7 @@ func NewService(deps *Dependencies) *Service {
 		Client: deps.Client,
 
 		Players: make(map[string]*MprisPlayer),
+		pending: make(map[uint32]pendingNotification),
 	}
 	return s
 }
@@ -56,15 +67,20 @@ func (s *Service) Enable() (err error) {
 
 	s.Subscribe("notify", "", s.handleNotify)
 	s.Subscribe("poweroff", "", s.handlePowerOff)
+	s.Subscribe("power/shutdown", "self", s.handlePower)
+	s.Subscribe("power/suspend", "self", s.handlePower)
 
-	return s.setupSignals()
-}
+	s.Subscribe("music/play", "", s.handleMusic(s.play))
+	s.Subscribe("music/pause", "", s.handleMusic(s.pause))
+	s.Subscribe("music/next", "", s.handleMusic(s.next))
+	s.Subscribe("music/prev", "", s.handleMusic(s.prev))
+	s.Subscribe("music/seek", "", s.handleMusic(s.seek))
+	s.Subscribe("music/volume", "", s.handleMusic(s.volume))
+	s.Subscribe("music/status", "", s.handleMusicStatus)
+	s.Subscribe("music/started", "", s.active.HandleEvent)
+	s.Subscribe("music/changed", "", s.active.HandleEvent)
 
-func (s *Service) handleNotify(msg sarif.Message) {
-	n := NewNotificationObject(s.Session)
-	if err := n.Notify(msg.Text, msg.Text); err != nil {
-		s.Log.Errorln("[dbus] notify err:", err)
-	}
+	return s.setupSignals()
 }
 
 func (s *Service) handlePowerOff(msg sarif.Message) {
@@ -74,15 +90,59 @@ func (s *Service) handlePowerOff(msg sarif.Message) {
 	}
 }
 
+// handlePower serves power actions addressed to this host, usually
+// forwarded by the devices service.
+func (s *Service) handlePower(msg sarif.Message) {
+	o := NewLogindObject(s.System)
+	f, text := o.PowerOff, "Shutting down."
+	if msg.IsAction("power/suspend") {
+		f, text = o.Suspend, "Suspending."
+	}
+
+	// Reply first, the connection may not survive the power action.
+	reply := sarif.CreateMessage("ack/"+msg.Action, nil)
+	reply.Text = text
+	s.Reply(msg, reply)
+	if err := f(); err != nil {
+		s.Log.Errorln("[dbus] "+msg.Action+" err:", err)
+	}
+}
+
 func (s *Service) setupSignals() error {
-	c := s.Session.BusObject().Call("org.freedesktop.DBus.AddMatch", 0, "type='signal',interface='org.freedesktop.DBus.Properties',member='PropertiesChanged',path='/org/mpris/MediaPlayer2'")
-	if c.Err != nil {
-		return c.Err
+	rules := []string{
+		"type='signal',interface='org.freedesktop.DBus.Properties',member='PropertiesChanged',path='/org/mpris/MediaPlayer2'",
+		"type='signal',sender='org.freedesktop.DBus',interface='org.freedesktop.DBus',member='NameOwnerChanged'",
+		"type='signal',interface='org.freedesktop.Notifications',path='/org/freedesktop/Notifications'",
+	}
+	for _, rule := range rules {
+		c := s.Session.BusObject().Call("org.freedesktop.DBus.AddMatch", 0, rule)
+		if c.Err != nil {
+			return c.Err
+		}
 	}
 
 	ch := make(chan *dbus.Signal, 10)
 	s.Session.Signal(ch)
 	go s.handleSignals(ch)
+	return s.discoverPlayers()
+}
+
+func (s *Service) discoverPlayers() error {
+	var names []string
+	if err := s.Session.BusObject().Call("org.freedesktop.DBus.ListNames", 0).Store(&names); err != nil {
+		return err
+	}
+	for _, name := range names {
+		if !strings.HasPrefix(name, mprisPrefix) {
+			continue
+		}
+		var owner string
+		if err := s.Session.BusObject().Call("org.freedesktop.DBus.GetNameOwner", 0, name).Store(&owner); err != nil {
+			s.Log.Errorln("[dbus] mpris owner:", err)
+			continue
+		}
+		s.addPlayer(owner, name)
+	}
 	return nil
 }
 
@@ -90,33 +150,198 @@ func (s *Service) handleSignals(ch chan *dbus.Signal) {
 	for v := range ch {
 		switch v.Name {
 		case "org.freedesktop.DBus.Properties.PropertiesChanged":
+			if iface, _ := v.Body[0].(string); iface != mprisPlayer {
+				continue
+			}
 			props := v.Body[1].(map[string]dbus.Variant)
-			p := s.getPlayer(v.Sender)
-			p.UpdateProperties(props)
+			s.updatePlayer(v.Sender, props)
+		case "org.freedesktop.Notifications.ActionInvoked":
+			s.handleActionInvoked(v)
+		case "org.freedesktop.Notifications.NotificationClosed":
+			s.handleNotificationClosed(v)
+		case "org.freedesktop.DBus.NameOwnerChanged":
+			name, _ := v.Body[0].(string)
+			if !strings.HasPrefix(name, mprisPrefix) {
+				continue
+			}
+			if old, _ := v.Body[1].(string); old != "" {
+				s.removePlayer(old)
+			}
+			if owner, _ := v.Body[2].(string); owner != "" {
+				// Fetching blocks on replies, which must not stall the signal loop.
+				go s.addPlayer(owner, name)
+			}
+		}
+	}
+}
+
+func (s *Service) addPlayer(id, bus string) {
+	p := NewMprisPlayer(s.Session, id, bus)
+	if err := p.Fetch(); err != nil {
+		s.Log.Errorln("[dbus] mpris fetch:", err)
+	}
+	now := time.Now()
+	p.Started = now
+	if p.IsPlaying() {
+		p.Resumed = now
+		p.LastActive = now
+	}
+
+	info := p.Info()
+
+	s.playersMutex.Lock()
+	s.Players[id] = p
+	s.playersMutex.Unlock()
+
+	if info.IsPlaying {
+		s.active.Update(info)
+		s.Publish(sarif.CreateMessage("music/started", info))
+	}
+}
+
+func (s *Service) removePlayer(id string) {
+	s.playersMutex.Lock()
+	p, ok := s.Players[id]
+	delete(s.Players, id)
+	s.playersMutex.Unlock()
+	if !ok {
+		return
+	}
+
+	if p.IsPlaying() {
+		if info := scrobbleInfo(p, p.Played+time.Since(p.Resumed)); info != nil {
+			s.Publish(sarif.CreateMessage("music/scrobble", info))
 		}
+		p.Status = "stopped"
+		s.Publish(sarif.CreateMessage("music/stopped", p.Info()))
 	}
 }
 
-func (s *Service) getPlayer(id string) *MprisPlayer {
+func (s *Service) updatePlayer(id string, props map[string]dbus.Variant) {
+	s.playersMutex.Lock()
 	p, ok := s.Players[id]
 	if !ok {
-		p = &MprisPlayer{Id: id}
-		s.Players[id] = p
-
-		obj := s.Session.Object(id, "/org/mpris/MediaPlayer2")
-		v, err := obj.GetProperty("org.mpris.MediaPlayer2.Identity")
-		if err != nil {
-			s.Log.Errorln(err)
-		} else {
-			p.Identity = v.Value().(string)
+		s.playersMutex.Unlock()
+		go s.addPlayer(id, id)
+		return
+	}
+
+	now := time.Now()
+	prev := *p
+	if prev.IsPlaying() {
+		p.Played += now.Sub(prev.Resumed)
+	}
+	var scrobble *schema.MusicInfo
+	changed := p.UpdateProperties(props)
+	if changed {
+		scrobble = scrobbleInfo(&prev, p.Played)
+		p.Started = now
+		p.Played = 0
+	} else if p.Status == "stopped" && prev.Status != "stopped" {
+		scrobble = scrobbleInfo(p, p.Played)
+		p.Played = 0
+	}
+	if p.IsPlaying() {
+		p.Resumed = now
+		p.LastActive = now
+	}
+	info := p.Info()
+	s.playersMutex.Unlock()
+
+	if scrobble != nil {
+		s.Publish(sarif.CreateMessage("music/scrobble", scrobble))
+	}
+	s.active.Update(info)
+	switch {
+	case info.IsPlaying && !prev.IsPlaying():
+		s.Publish(sarif.CreateMessage("music/started", info))
+	case info.IsPlaying && changed:
+		s.Publish(sarif.CreateMessage("music/changed", info))
+	case !info.IsPlaying && prev.IsPlaying():
+		s.Publish(sarif.CreateMessage("music/stopped", info))
+	}
+}
+
+// scrobbleInfo returns the track info of the player if it was played long
+// enough to be scrobbled.
+func scrobbleInfo(p *MprisPlayer, played time.Duration) *schema.MusicInfo {
+	if info := p.Info(); info.ShouldScrobble(played) {
+		return &info
+	}
+	return nil
+}
+
+// findPlayer returns the player that should handle a command. Without a
+// player in the command, the most recently active player of all backends
+// is chosen, which may belong to another backend.
+func (s *Service) findPlayer(cmd schema.MusicCommand) *MprisPlayer {
+	name := s.active.Target(cmd)
+	s.playersMutex.Lock()
+	defer s.playersMutex.Unlock()
+
+	var found *MprisPlayer
+	for _, p := range s.Players {
+		if p.Matches(name) && (found == nil || p.LastActive.After(found.LastActive)) {
+			found = p
+		}
+	}
+	return found
+}
+
+func (s *Service) handleMusic(f func(*MprisPlayer, schema.MusicCommand) error) func(sarif.Message) {
+	return func(msg sarif.Message) {
+		cmd := schema.DecodeMusicCommand(msg)
+		p := s.findPlayer(cmd)
+		if p == nil {
+			// Leave the request to other music backends.
+			return
 		}
-		v, err = obj.GetProperty("org.mpris.MediaPlayer2.DesktopEntry")
-		if err != nil {
-			s.Log.Errorln(err)
-		} else {
-			p.Name = v.Value().(string)
+
+		if err := f(p, cmd); err != nil {
+			s.ReplyInternalError(msg, err)
+			return
 		}
+		s.Reply(msg, sarif.CreateMessage("ack/"+msg.Action, nil))
 	}
+}
+
+func (s *Service) handleMusicStatus(msg sarif.Message) {
+	cmd := schema.DecodeMusicCommand(msg)
+	p := s.findPlayer(cmd)
+	if p == nil {
+		return
+	}
+
+	s.playersMutex.Lock()
+	info := p.Info()
+	s.playersMutex.Unlock()
+	p.FetchPosition(&info)
+	s.Reply(msg, sarif.CreateMessage("music/status", info))
+}
+
+func (s *Service) play(p *MprisPlayer, cmd schema.MusicCommand) error {
+	return p.Play()
+}
+
+func (s *Service) pause(p *MprisPlayer, cmd schema.MusicCommand) error {
+	return p.Pause()
+}
+
+func (s *Service) next(p *MprisPlayer, cmd schema.MusicCommand) error {
+	return p.Next()
+}
+
+func (s *Service) prev(p *MprisPlayer, cmd schema.MusicCommand) error {
+	return p.Previous()
+}
+
+func (s *Service) seek(p *MprisPlayer, cmd schema.MusicCommand) error {
+	s.playersMutex.Lock()
+	track := p.TrackId
+	s.playersMutex.Unlock()
+	return p.Seek(track, cmd.Position, cmd.Relative)
+}
 
-	return p
+func (s *Service) volume(p *MprisPlayer, cmd schema.MusicCommand) error {
+	return p.SetVolume(cmd.Volume, cmd.Relative)
 }
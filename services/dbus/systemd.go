@@ -20,3 +20,7 @@ func NewLogindObject(conn *dbus.Conn) *LogindObject {
 func (o *LogindObject) PowerOff() error {
 	return o.Call("org.freedesktop.login1.Manager.PowerOff", 0, false).Err
 }
+
+func (o *LogindObject) Suspend() error {
+	return o.Call("org.freedesktop.login1.Manager.Suspend", 0, false).Err
+}
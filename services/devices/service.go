@@ -0,0 +1,150 @@
+// Copyright (C) 2014 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// Service devices wakes up and powers off other computers.
+package devices
+
+import (
+	"errors"
+
+	"github.com/sarifsystems/sarif/sarif"
+	"github.com/sarifsystems/sarif/services"
+	"github.com/sarifsystems/sarif/services/hostscan"
+)
+
+var Module = &services.Module{
+	Name:        "devices",
+	Version:     "1.0",
+	NewInstance: NewService,
+}
+
+type Device struct {
+	Mac       string
+	Broadcast string
+	// Control is the sarif device on the host that handles power
+	// actions, usually the dbus service of its kipp instance,
+	// e.g. "desktop/dbus".
+	Control string
+}
+
+type Config struct {
+	Devices map[string]Device
+}
+
+type Dependencies struct {
+	Config services.Config
+	Client sarif.Client
+}
+
+type Service struct {
+	Cfg Config
+	cfg services.Config
+	sarif.Client
+}
+
+func NewService(deps *Dependencies) *Service {
+	return &Service{
+		Cfg: Config{
+			Devices: map[string]Device{},
+		},
+		cfg:    deps.Config,
+		Client: deps.Client,
+	}
+}
+
+func (s *Service) Enable() error {
+	s.cfg.Get(&s.Cfg)
+
+	s.Subscribe("devices/wake", "", s.handleWake)
+	s.Subscribe("devices/shutdown", "", s.handlePower("devices/shutdown", "power/shutdown"))
+	s.Subscribe("devices/suspend", "", s.handlePower("devices/suspend", "power/suspend"))
+	return nil
+}
+
+type DevicePayload struct {
+	Device string `json:"device,omitempty"`
+}
+
+// deviceName reads the target either from the action suffix,
+// e.g. "devices/wake/desktop", or from the payload.
+func deviceName(msg sarif.Message, action string) string {
+	if name := msg.ActionSuffix(action); name != "" {
+		return name
+	}
+	var p DevicePayload
+	msg.DecodePayload(&p)
+	if p.Device == "" {
+		return msg.Text
+	}
+	return p.Device
+}
+
+func (s *Service) handleWake(msg sarif.Message) {
+	name := deviceName(msg, "devices/wake")
+	if name == "" {
+		s.ReplyBadRequest(msg, errors.New("Please specify a device to wake."))
+		return
+	}
+
+	dev := s.Cfg.Devices[name]
+	if dev.Mac == "" {
+		mac, err := s.lookupMac(name)
+		if err != nil {
+			s.ReplyBadRequest(msg, err)
+			return
+		}
+		dev.Mac = mac
+	}
+
+	if err := Wake(dev.Mac, dev.Broadcast); err != nil {
+		s.ReplyInternalError(msg, err)
+		return
+	}
+	reply := sarif.CreateMessage("devices/woken/"+name, dev)
+	reply.Text = "Sent wake up call to " + name + "."
+	s.Reply(msg, reply)
+}
+
+// lookupMac asks hostscan for the MAC address it last saw for a host.
+func (s *Service) lookupMac(name string) (string, error) {
+	req := sarif.CreateMessage("devices/fetch_last_status", hostscan.HostRequest{Host: name})
+	reply, ok := <-s.Request(req)
+	if !ok || reply.IsAction("err") {
+		return "", errors.New("No MAC address known for " + name + ".")
+	}
+
+	var host hostscan.Host
+	reply.DecodePayload(&host)
+	if host.Mac == "" {
+		return "", errors.New("No MAC address known for " + name + ".")
+	}
+	return host.Mac, nil
+}
+
+// handlePower forwards a power action to the sarif instance on the
+// target host and relays its answer.
+func (s *Service) handlePower(prefix, action string) func(sarif.Message) {
+	return func(msg sarif.Message) {
+		name := deviceName(msg, prefix)
+		dev, ok := s.Cfg.Devices[name]
+		if !ok || dev.Control == "" {
+			s.ReplyBadRequest(msg, errors.New("No sarif instance known to control "+name+"."))
+			return
+		}
+
+		req := sarif.CreateMessage(action, nil)
+		req.Destination = dev.Control
+		reply, ok := <-s.Request(req)
+		if !ok {
+			s.ReplyInternalError(msg, errors.New(dev.Control+" did not respond."))
+			return
+		}
+		reply.Id = ""
+		reply.Source = ""
+		reply.Destination = ""
+		reply.CorrId = ""
+		s.Reply(msg, reply)
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright (C) 2014 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package devices
+
+import (
+	"bytes"
+	"errors"
+	"net"
+)
+
+// DefaultBroadcast is the address magic packets are sent to by default.
+const DefaultBroadcast = "255.255.255.255:9"
+
+// MagicPacket builds a Wake-on-LAN packet: six bytes of 0xff followed by
+// sixteen repetitions of the target MAC address.
+func MagicPacket(mac net.HardwareAddr) ([]byte, error) {
+	if len(mac) != 6 {
+		return nil, errors.New("Wake-on-LAN requires a 48-bit MAC address, got " + mac.String())
+	}
+
+	var buf bytes.Buffer
+	buf.Write(bytes.Repeat([]byte{0xff}, 6))
+	for i := 0; i < 16; i++ {
+		buf.Write(mac)
+	}
+	return buf.Bytes(), nil
+}
+
+// Wake broadcasts a magic packet for the given MAC address.
+func Wake(mac, broadcast string) error {
+	hw, err := net.ParseMAC(mac)
+	if err != nil {
+		return err
+	}
+	pkt, err := MagicPacket(hw)
+	if err != nil {
+		return err
+	}
+	if broadcast == "" {
+		broadcast = DefaultBroadcast
+	}
+	addr, err := net.ResolveUDPAddr("udp4", broadcast)
+	if err != nil {
+		return err
+	}
+
+	c, err := net.DialUDP("udp4", nil, addr)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	_, err = c.Write(pkt)
+	return err
+}
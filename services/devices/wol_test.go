@@ -0,0 +1,36 @@
+// Copyright (C) 2014 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package devices
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestMagicPacket(t *testing.T) {
+	mac, _ := net.ParseMAC("aa:bb:cc:dd:ee:ff")
+	pkt, err := MagicPacket(mac)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pkt) != 102 {
+		t.Fatal("expected 102 bytes, got", len(pkt))
+	}
+	if !bytes.Equal(pkt[:6], []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}) {
+		t.Error("missing sync stream:", pkt[:6])
+	}
+	for i := 0; i < 16; i++ {
+		if got := pkt[6+i*6 : 12+i*6]; !bytes.Equal(got, mac) {
+			t.Errorf("repetition %d: expected %s, got %x", i, mac, got)
+		}
+	}
+
+	long, _ := net.ParseMAC("00:00:00:00:fe:80:00:00:00:00:00:00:02:00:5e:10:00:00:00:01")
+	if _, err := MagicPacket(long); err == nil {
+		t.Error("expected error for non-48-bit address")
+	}
+}
@@ -0,0 +1,272 @@
+// Copyright (C) 2019 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package email
+
+import (
+	"io/ioutil"
+	"log"
+	"mime/quotedprintable"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sarifsystems/sarif/core"
+	"github.com/sarifsystems/sarif/pkg/content"
+	"github.com/sarifsystems/sarif/pkg/schema"
+	"github.com/sarifsystems/sarif/sarif"
+	"github.com/sarifsystems/sarif/transports/sfproto"
+)
+
+const testMail = "From: Alice <Alice@example.com>\r\n" +
+	"To: sarif@example.com\r\n" +
+	"Subject: =?utf-8?q?remind_me_to_call_mom_in_5_minutes?=\r\n" +
+	"Message-Id: <1234@example.com>\r\n" +
+	"Date: Mon, 02 Jan 2006 15:04:05 +0000\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/mixed; boundary=outer\r\n" +
+	"\r\n" +
+	"--outer\r\n" +
+	"Content-Type: multipart/alternative; boundary=inner\r\n" +
+	"\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"Content-Transfer-Encoding: quoted-printable\r\n" +
+	"\r\n" +
+	"Don't forget! =E2=98=8E\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/html; charset=utf-8\r\n" +
+	"\r\n" +
+	"<p>Don't forget!</p>\r\n" +
+	"--inner--\r\n" +
+	"--outer\r\n" +
+	"Content-Type: text/plain; name=\"note.txt\"\r\n" +
+	"Content-Disposition: attachment; filename=\"note.txt\"\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"aGVsbG8gd29y\r\nbGQ=\r\n" +
+	"--outer--\r\n"
+
+type testConfig struct{}
+
+func (testConfig) Exists() bool                    { return false }
+func (testConfig) Set(v interface{}) error         { return nil }
+func (testConfig) Get(v interface{}) (error, bool) { return nil, false }
+func (testConfig) Dir() string                     { return "" }
+
+func TestParseMail(t *testing.T) {
+	m, err := parseMail([]byte(testMail))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.From != "alice@example.com" || m.FromName != "Alice" || m.MessageId != "1234@example.com" {
+		t.Errorf("unexpected sender: %+v", m)
+	}
+	if m.Subject != "remind me to call mom in 5 minutes" {
+		t.Error("unexpected subject", m.Subject)
+	}
+	if m.Body != "Don't forget! ☎" || m.Html != "<p>Don't forget!</p>" {
+		t.Errorf("unexpected body: %q %q", m.Body, m.Html)
+	}
+	if len(m.Attachments) != 1 || m.Attachments[0].Name != "note.txt" || string(m.Attachments[0].Data) != "hello world" {
+		t.Errorf("unexpected attachments: %+v", m.Attachments)
+	}
+}
+
+func TestCommand(t *testing.T) {
+	s := &Service{Cfg: Config{
+		Senders:  []string{"alice@example.com", "*@family.org"},
+		Commands: []string{"remind me"},
+	}}
+
+	tests := []struct {
+		from, subject, body string
+		cmd                 string
+	}{
+		{"alice@example.com", "Remind me to buy milk", "", "Remind me to buy milk"},
+		{"bob@family.org", "Hi", "remind me to call\nthanks", "remind me to call"},
+		{"eve@example.com", "remind me to hack", "", ""},
+		{"alice@example.com", "Hello", "just saying hi", ""},
+	}
+	for _, test := range tests {
+		cmd, _ := s.command(Mail{From: test.from, Subject: test.subject, Body: test.body})
+		if cmd != test.cmd {
+			t.Errorf("%s %q: expected command %q, got %q", test.from, test.subject, test.cmd, cmd)
+		}
+	}
+}
+
+func TestRenderHtml(t *testing.T) {
+	msg := sarif.CreateMessage("mail/send", SendPayload{
+		To: []string{"bob@example.com"},
+		Attachments: []schema.Attachment{{
+			Title:     "Weather <today>",
+			TitleLink: "http://example.com/weather",
+			Fields:    []schema.AttachmentField{{Title: "Temperature", Value: "21°C"}},
+		}},
+	})
+	msg.Text = "Good morning!\nHere is your forecast."
+
+	o := composeMail(msg, "sarif@example.com", nil)
+	if o.Subject != "Good morning!" {
+		t.Error("unexpected subject", o.Subject)
+	}
+	html, err := renderHtml(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		`<a href="http://example.com/weather">Weather &lt;today&gt;</a>`,
+		`<th style="text-align: left; padding-right: 8px;">Temperature</th><td>21°C</td>`,
+	} {
+		if !strings.Contains(html, s) {
+			t.Errorf("expected html to contain %q:\n%s", s, html)
+		}
+	}
+}
+
+func expectMessage(t *testing.T, ch chan sarif.Message) sarif.Message {
+	select {
+	case msg := <-ch:
+		return msg
+	case <-time.After(3 * time.Second):
+		t.Fatal("no message received")
+	}
+	return sarif.Message{}
+}
+
+func expectMail(t *testing.T, ch chan testSmtpMail) testSmtpMail {
+	select {
+	case m := <-ch:
+		dec, _ := ioutil.ReadAll(quotedprintable.NewReader(strings.NewReader(m.Data)))
+		m.Data = string(dec)
+		return m
+	case <-time.After(3 * time.Second):
+		t.Fatal("no mail received")
+	}
+	return testSmtpMail{}
+}
+
+func TestService(t *testing.T) {
+	imap := newTestImapServer(t)
+	defer imap.Close()
+	smtp := newTestSmtpServer(t)
+	defer smtp.Close()
+	imap.Add(testMail)
+
+	broker := sfproto.NewBroker()
+	client, err := broker.NewClient(sarif.ClientInfo{Name: "email"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewService(&Dependencies{
+		Config:        testConfig{},
+		Client:        client,
+		ClientFactory: broker,
+		Log:           core.New(core.LogLevelWarn, log.New(ioutil.Discard, "", 0)),
+	})
+	s.Store = content.DataProvider
+	s.Cfg.Imap = ImapConfig{
+		Address:      imap.l.Addr().String(),
+		Username:     "user",
+		Password:     "secret",
+		Mailbox:      "INBOX",
+		PollInterval: 60,
+	}
+	s.Cfg.Smtp = SmtpConfig{
+		Address: smtp.l.Addr().String(),
+		From:    "sarif@example.com",
+	}
+	s.Cfg.Senders = []string{"alice@example.com"}
+
+	obs, err := broker.NewClient(sarif.ClientInfo{Name: "observer"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan sarif.Message, 10)
+	commands := make(chan sarif.Message, 10)
+	obs.Subscribe("mail/received", "", func(msg sarif.Message) { received <- msg })
+	obs.Subscribe("natural/handle", "", func(msg sarif.Message) { commands <- msg })
+
+	if err := s.Enable(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Disable()
+
+	// Incoming mail with attachment and command
+	msg := expectMessage(t, received)
+	var m Mail
+	if err := msg.DecodePayload(&m); err != nil {
+		t.Fatal(err)
+	}
+	if m.From != "alice@example.com" || len(m.Attachments) != 1 {
+		t.Fatalf("unexpected mail: %+v", m)
+	}
+	if a := m.Attachments[0]; a.Name != "note.txt" || !strings.HasPrefix(a.Url, "data:text/plain,") {
+		t.Errorf("unexpected attachment: %+v", a)
+	}
+
+	cmd := expectMessage(t, commands)
+	if cmd.Text != "remind me to call mom in 5 minutes" || cmd.Source != "email/alice@example.com" {
+		t.Errorf("unexpected command: %s from %s", cmd.Text, cmd.Source)
+	}
+	obs.Reply(cmd, sarif.Message{Action: "natural/handled", Text: "Reminder set."})
+
+	reply := expectMail(t, smtp.Mail)
+	if len(reply.To) != 1 || reply.To[0] != "alice@example.com" {
+		t.Error("unexpected recipient", reply.To)
+	}
+	for _, s := range []string{
+		"Subject: Re: remind me to call mom in 5 minutes",
+		"In-Reply-To: <1234@example.com>",
+		"Reminder set.",
+	} {
+		if !strings.Contains(reply.Data, s) {
+			t.Errorf("expected reply to contain %q:\n%s", s, reply.Data)
+		}
+	}
+	if !imap.Seen(1) {
+		t.Error("mail was not marked as seen")
+	}
+
+	// New mail should be announced through IDLE
+	imap.Add("From: bob@example.com\r\nSubject: Hello\r\n\r\nJust saying hi.\r\n")
+	msg = expectMessage(t, received)
+	if msg.Text != "New mail from bob@example.com: Hello" {
+		t.Error("unexpected message", msg.Text)
+	}
+	select {
+	case cmd := <-commands:
+		t.Error("unexpected command", cmd.Text)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Outgoing mail
+	send := sarif.CreateMessage("mail/send", SendPayload{
+		To:      []string{"bob@example.com"},
+		Subject: "Report",
+		Attachments: []schema.Attachment{{
+			Title: "Everything is fine",
+		}},
+	})
+	send.Text = "Your daily report."
+	r, ok := <-obs.Request(send)
+	if !ok || r.Action != "mail/sent" {
+		t.Fatal("unexpected reply", r.Action, r.Text)
+	}
+	out := expectMail(t, smtp.Mail)
+	if out.From != "sarif@example.com" || len(out.To) != 1 || out.To[0] != "bob@example.com" {
+		t.Error("unexpected envelope", out.From, out.To)
+	}
+	for _, s := range []string{
+		"Subject: Report",
+		"Content-Type: text/html; charset=utf-8",
+		"<strong>Everything is fine</strong>",
+	} {
+		if !strings.Contains(out.Data, s) {
+			t.Errorf("expected mail to contain %q:\n%s", s, out.Data)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+// Copyright (C) 2019 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package email
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// imapClient implements the small subset of IMAP4rev1 (RFC 3501) and IDLE
+// (RFC 2177) needed to fetch new mail.
+type imapClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+	tag  int
+	caps map[string]bool
+}
+
+// imapResponse is an untagged response line together with the literals
+// it contains.
+type imapResponse struct {
+	Text     string
+	Literals [][]byte
+}
+
+var (
+	reLiteral  = regexp.MustCompile(`\{(\d+)\}$`)
+	reFetchUid = regexp.MustCompile(`UID (\d+)`)
+)
+
+func dialImap(cfg ImapConfig) (*imapClient, error) {
+	var conn net.Conn
+	var err error
+	if cfg.TLS {
+		conn, err = tls.Dial("tcp", cfg.Address, nil)
+	} else {
+		conn, err = net.DialTimeout("tcp", cfg.Address, 30*time.Second)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := &imapClient{conn: conn, r: bufio.NewReader(conn)}
+	greeting, err := c.readResponse()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if !strings.HasPrefix(greeting.Text, "* OK") && !strings.HasPrefix(greeting.Text, "* PREAUTH") {
+		conn.Close()
+		return nil, errors.New("imap: unexpected greeting " + greeting.Text)
+	}
+	return c, nil
+}
+
+func quote(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `"`, `\"`, -1)
+	return `"` + s + `"`
+}
+
+// readResponse reads a single response line including all literals.
+func (c *imapClient) readResponse() (imapResponse, error) {
+	var resp imapResponse
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			return resp, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		resp.Text += line
+
+		m := reLiteral.FindStringSubmatch(line)
+		if m == nil {
+			return resp, nil
+		}
+		n, _ := strconv.Atoi(m[1])
+		lit := make([]byte, n)
+		if _, err := io.ReadFull(c.r, lit); err != nil {
+			return resp, err
+		}
+		resp.Literals = append(resp.Literals, lit)
+	}
+}
+
+// cmd sends a command and collects the untagged responses until its
+// completion.
+func (c *imapClient) cmd(format string, args ...interface{}) ([]imapResponse, error) {
+	c.tag++
+	tag := fmt.Sprintf("S%d", c.tag)
+	if _, err := fmt.Fprintf(c.conn, tag+" "+format+"\r\n", args...); err != nil {
+		return nil, err
+	}
+
+	resps := make([]imapResponse, 0)
+	for {
+		resp, err := c.readResponse()
+		if err != nil {
+			return resps, err
+		}
+		if !strings.HasPrefix(resp.Text, tag+" ") {
+			resps = append(resps, resp)
+			continue
+		}
+
+		status := strings.TrimPrefix(resp.Text, tag+" ")
+		if !strings.HasPrefix(status, "OK") {
+			return resps, errors.New("imap: " + status)
+		}
+		return resps, nil
+	}
+}
+
+func (c *imapClient) Capabilities() (map[string]bool, error) {
+	if c.caps != nil {
+		return c.caps, nil
+	}
+	resps, err := c.cmd("CAPABILITY")
+	if err != nil {
+		return nil, err
+	}
+	c.caps = make(map[string]bool)
+	for _, r := range resps {
+		if strings.HasPrefix(r.Text, "* CAPABILITY ") {
+			for _, cp := range strings.Fields(r.Text)[2:] {
+				c.caps[strings.ToUpper(cp)] = true
+			}
+		}
+	}
+	return c.caps, nil
+}
+
+func (c *imapClient) Login(user, pass string) error {
+	_, err := c.cmd("LOGIN %s %s", quote(user), quote(pass))
+	c.caps = nil
+	return err
+}
+
+func (c *imapClient) Select(mailbox string) error {
+	_, err := c.cmd("SELECT %s", quote(mailbox))
+	return err
+}
+
+// SearchUnseen returns the UIDs of all messages without the \Seen flag.
+func (c *imapClient) SearchUnseen() ([]uint32, error) {
+	resps, err := c.cmd("UID SEARCH UNSEEN")
+	if err != nil {
+		return nil, err
+	}
+	uids := make([]uint32, 0)
+	for _, r := range resps {
+		if !strings.HasPrefix(r.Text, "* SEARCH") {
+			continue
+		}
+		for _, f := range strings.Fields(r.Text)[2:] {
+			if uid, err := strconv.ParseUint(f, 10, 32); err == nil {
+				uids = append(uids, uint32(uid))
+			}
+		}
+	}
+	return uids, nil
+}
+
+// Fetch returns the raw message without marking it as seen.
+func (c *imapClient) Fetch(uid uint32) ([]byte, error) {
+	resps, err := c.cmd("UID FETCH %d (UID BODY.PEEK[])", uid)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range resps {
+		m := reFetchUid.FindStringSubmatch(r.Text)
+		if m == nil || m[1] != strconv.FormatUint(uint64(uid), 10) || len(r.Literals) == 0 {
+			continue
+		}
+		return r.Literals[0], nil
+	}
+	return nil, fmt.Errorf("imap: message %d not found", uid)
+}
+
+func (c *imapClient) MarkSeen(uid uint32) error {
+	_, err := c.cmd(`UID STORE %d +FLAGS.SILENT (\Seen)`, uid)
+	return err
+}
+
+// Idle waits until the server announces new messages or the timeout
+// expires.
+func (c *imapClient) Idle(timeout time.Duration) error {
+	c.tag++
+	tag := fmt.Sprintf("S%d", c.tag)
+	if _, err := fmt.Fprintf(c.conn, "%s IDLE\r\n", tag); err != nil {
+		return err
+	}
+	resp, err := c.readResponse()
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(resp.Text, "+") {
+		return errors.New("imap: idle rejected: " + resp.Text)
+	}
+
+	c.conn.SetReadDeadline(time.Now().Add(timeout))
+	for {
+		resp, err := c.readResponse()
+		if err != nil {
+			if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+				return err
+			}
+			break
+		}
+		if strings.HasSuffix(resp.Text, " EXISTS") {
+			break
+		}
+	}
+	c.conn.SetReadDeadline(time.Time{})
+
+	if _, err := io.WriteString(c.conn, "DONE\r\n"); err != nil {
+		return err
+	}
+	for {
+		resp, err := c.readResponse()
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(resp.Text, tag+" ") {
+			return nil
+		}
+	}
+}
+
+func (c *imapClient) Logout() error {
+	c.cmd("LOGOUT")
+	return c.conn.Close()
+}
@@ -0,0 +1,156 @@
+// Copyright (C) 2019 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package email
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/sarifsystems/sarif/pkg/schema"
+)
+
+// Mail is the payload of mail/received.
+type Mail struct {
+	MessageId   string           `json:"message_id,omitempty"`
+	From        string           `json:"from"`
+	FromName    string           `json:"from_name,omitempty"`
+	To          []string         `json:"to,omitempty"`
+	Subject     string           `json:"subject"`
+	Date        time.Time        `json:"date"`
+	Body        string           `json:"body,omitempty"`
+	Html        string           `json:"html,omitempty"`
+	Attachments []schema.Content `json:"attachments,omitempty"`
+}
+
+func (m Mail) Text() string {
+	from := m.FromName
+	if from == "" {
+		from = m.From
+	}
+	return "New mail from " + from + ": " + m.Subject
+}
+
+var wordDecoder = &mime.WordDecoder{
+	CharsetReader: func(charset string, input io.Reader) (io.Reader, error) {
+		// Go only knows UTF-8 and ASCII, fall back to the raw bytes for
+		// everything else.
+		return input, nil
+	},
+}
+
+func decodeHeader(s string) string {
+	if d, err := wordDecoder.DecodeHeader(s); err == nil {
+		return d
+	}
+	return s
+}
+
+// parseMail parses a raw RFC 5322 message. Attachments are returned with
+// their data, ready to be stored.
+func parseMail(raw []byte) (Mail, error) {
+	var m Mail
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return m, err
+	}
+
+	h := msg.Header
+	m.MessageId = strings.Trim(h.Get("Message-Id"), "<>")
+	m.Subject = decodeHeader(h.Get("Subject"))
+	if from, err := mail.ParseAddress(h.Get("From")); err == nil {
+		m.From = strings.ToLower(from.Address)
+		m.FromName = decodeHeader(from.Name)
+	}
+	if to, err := h.AddressList("To"); err == nil {
+		for _, a := range to {
+			m.To = append(m.To, a.Address)
+		}
+	}
+	if m.Date, err = h.Date(); err != nil {
+		m.Date = time.Now()
+	}
+
+	err = m.parsePart(h, msg.Body)
+	m.Body = strings.TrimSpace(m.Body)
+	return m, err
+}
+
+type header interface {
+	Get(key string) string
+}
+
+func (m *Mail) parsePart(h header, body io.Reader) error {
+	typ, params, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		typ, params = "text/plain", map[string]string{}
+	}
+
+	if strings.HasPrefix(typ, "multipart/") {
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			p, err := mr.NextPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err := m.parsePart(p.Header, p); err != nil {
+				return err
+			}
+		}
+	}
+
+	data, err := ioutil.ReadAll(decodeTransfer(h.Get("Content-Transfer-Encoding"), body))
+	if err != nil {
+		return err
+	}
+
+	disp, dparams, _ := mime.ParseMediaType(h.Get("Content-Disposition"))
+	name := dparams["filename"]
+	if name == "" {
+		name = params["name"]
+	}
+	if disp != "attachment" && name == "" {
+		switch typ {
+		case "text/plain":
+			if m.Body == "" {
+				m.Body = string(data)
+			}
+			return nil
+		case "text/html":
+			if m.Html == "" {
+				m.Html = string(data)
+			}
+			return nil
+		}
+	}
+
+	m.Attachments = append(m.Attachments, schema.Content{
+		Type: typ,
+		Name: decodeHeader(name),
+		Data: data,
+	})
+	return nil
+}
+
+func decodeTransfer(enc string, r io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(enc)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, r)
+	case "quoted-printable":
+		return quotedprintable.NewReader(r)
+	}
+	return r
+}
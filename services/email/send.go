@@ -0,0 +1,221 @@
+// Copyright (C) 2019 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package email
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/sarifsystems/sarif/pkg/schema"
+	"github.com/sarifsystems/sarif/sarif"
+)
+
+// SendPayload is the payload of mail/send. All fields are optional, the
+// text of the message is used as body and as fallback for the subject.
+type SendPayload struct {
+	To          []string            `json:"to,omitempty"`
+	Subject     string              `json:"subject,omitempty"`
+	InReplyTo   string              `json:"in_reply_to,omitempty"`
+	Attachments []schema.Attachment `json:"attachments,omitempty"`
+}
+
+// Outgoing is a composed mail ready to be sent.
+type Outgoing struct {
+	From        string
+	To          []string
+	Subject     string
+	InReplyTo   string
+	Text        string
+	Attachments []schema.Attachment
+}
+
+const maxSubjectLength = 78
+
+// subjectFromText uses the first line of a text as subject.
+func subjectFromText(text string) string {
+	s := strings.TrimSpace(text)
+	if i := strings.Index(s, "\n"); i >= 0 {
+		s = strings.TrimSpace(s[0:i])
+	}
+	if len(s) > maxSubjectLength {
+		s = strings.TrimSpace(s[0:maxSubjectLength-3]) + "..."
+	}
+	return s
+}
+
+func composeMail(msg sarif.Message, from string, defaultTo []string) Outgoing {
+	var p SendPayload
+	msg.DecodePayload(&p)
+
+	o := Outgoing{
+		From:        from,
+		To:          p.To,
+		Subject:     p.Subject,
+		InReplyTo:   p.InReplyTo,
+		Text:        msg.Text,
+		Attachments: p.Attachments,
+	}
+	if len(o.To) == 0 {
+		o.To = defaultTo
+	}
+	if o.Subject == "" {
+		o.Subject = subjectFromText(o.Text)
+	}
+	if o.Subject == "" && len(o.Attachments) > 0 {
+		o.Subject = o.Attachments[0].Title
+	}
+	return o
+}
+
+var htmlTemplate = template.Must(template.New("mail").Parse(`<!DOCTYPE html>
+<html><body style="font-family: sans-serif;">
+{{range .Paragraphs}}<p>{{.}}</p>
+{{end}}{{range .Attachments}}<div style="border-left: 4px solid {{if .Color}}{{.Color}}{{else}}#ccc{{end}}; padding: 4px 12px; margin: 12px 0;">
+{{if .Pretext}}<p>{{.Pretext}}</p>{{end}}
+{{if .AuthorName}}<div><em>{{if .AuthorLink}}<a href="{{.AuthorLink}}">{{.AuthorName}}</a>{{else}}{{.AuthorName}}{{end}}</em></div>{{end}}
+{{if .Title}}<div><strong>{{if .TitleLink}}<a href="{{.TitleLink}}">{{.Title}}</a>{{else}}{{.Title}}{{end}}</strong></div>{{end}}
+{{if .Text}}<p>{{.Text}}</p>{{else if and (not .Title) (not .Fields) .Fallback}}<p>{{.Fallback}}</p>{{end}}
+{{if .Fields}}<table>{{range .Fields}}<tr><th style="text-align: left; padding-right: 8px;">{{.Title}}</th><td>{{.Value}}</td></tr>{{end}}</table>{{end}}
+{{if .ImageUrl}}<img src="{{.ImageUrl}}" alt="{{.Title}}" style="max-width: 100%;"/>{{end}}
+{{if .Footer}}<div><small>{{.Footer}}</small></div>{{end}}
+</div>
+{{end}}</body></html>
+`))
+
+// renderHtml renders the text and attachments of a mail as HTML.
+func renderHtml(o Outgoing) (string, error) {
+	paragraphs := make([]string, 0)
+	for _, p := range strings.Split(o.Text, "\n\n") {
+		if p = strings.TrimSpace(p); p != "" {
+			paragraphs = append(paragraphs, p)
+		}
+	}
+
+	var buf bytes.Buffer
+	err := htmlTemplate.Execute(&buf, struct {
+		Paragraphs  []string
+		Attachments []schema.Attachment
+	}{paragraphs, o.Attachments})
+	return buf.String(), err
+}
+
+// renderPlain renders the text and attachments of a mail as plain text.
+func renderPlain(o Outgoing) string {
+	lines := make([]string, 0)
+	if o.Text != "" {
+		lines = append(lines, o.Text)
+	}
+	for _, a := range o.Attachments {
+		lines = append(lines, "")
+		if a.Pretext != "" {
+			lines = append(lines, a.Pretext)
+		}
+		if a.Title != "" {
+			title := a.Title
+			if a.TitleLink != "" {
+				title += " <" + a.TitleLink + ">"
+			}
+			lines = append(lines, title)
+		}
+		if a.Text != "" {
+			lines = append(lines, a.Text)
+		} else if a.Title == "" && len(a.Fields) == 0 && a.Fallback != "" {
+			lines = append(lines, a.Fallback)
+		}
+		for _, f := range a.Fields {
+			lines = append(lines, f.Title+": "+f.Value)
+		}
+		if a.ImageUrl != "" {
+			lines = append(lines, a.ImageUrl)
+		}
+		if a.Footer != "" {
+			lines = append(lines, a.Footer)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func writeQuotedPrintable(w *multipart.Writer, typ, body string) error {
+	pw, err := w.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {typ + "; charset=utf-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return err
+	}
+	qw := quotedprintable.NewWriter(pw)
+	if _, err := qw.Write([]byte(body)); err != nil {
+		return err
+	}
+	return qw.Close()
+}
+
+// Bytes encodes the mail as multipart/alternative message.
+func (o Outgoing) Bytes() ([]byte, error) {
+	html, err := renderHtml(o)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	domain := "localhost"
+	if i := strings.LastIndex(o.From, "@"); i >= 0 {
+		domain = o.From[i+1:]
+	}
+
+	h := []string{
+		"From: " + o.From,
+		"To: " + strings.Join(o.To, ", "),
+		"Subject: " + mime.QEncoding.Encode("utf-8", o.Subject),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"Message-Id: <" + sarif.GenerateId() + "@" + domain + ">",
+		"MIME-Version: 1.0",
+		"Content-Type: multipart/alternative; boundary=" + w.Boundary(),
+	}
+	if o.InReplyTo != "" {
+		h = append(h, "In-Reply-To: <"+o.InReplyTo+">", "References: <"+o.InReplyTo+">")
+	}
+	for _, line := range h {
+		fmt.Fprintf(&buf, "%s\r\n", line)
+	}
+	buf.WriteString("\r\n")
+
+	if err := writeQuotedPrintable(w, "text/plain", renderPlain(o)); err != nil {
+		return nil, err
+	}
+	if err := writeQuotedPrintable(w, "text/html", html); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// sendMail delivers a mail over SMTP.
+func sendMail(cfg SmtpConfig, o Outgoing) error {
+	body, err := o.Bytes()
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if cfg.Username != "" {
+		host, _, _ := net.SplitHostPort(cfg.Address)
+		auth = smtp.PlainAuth("", cfg.Username, cfg.Password, host)
+	}
+	return smtp.SendMail(cfg.Address, auth, o.From, o.To, body)
+}
@@ -0,0 +1,270 @@
+// Copyright (C) 2019 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package email
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testImapServer is a single-mailbox IMAP stand-in that understands the
+// commands issued by imapClient.
+type testImapServer struct {
+	l net.Listener
+
+	mu       sync.Mutex
+	messages []*testImapMessage
+	nextUid  uint32
+	idlers   map[chan struct{}]struct{}
+}
+
+type testImapMessage struct {
+	Uid  uint32
+	Raw  string
+	Seen bool
+}
+
+func newTestImapServer(t *testing.T) *testImapServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testImapServer{
+		l:       l,
+		nextUid: 1,
+		idlers:  make(map[chan struct{}]struct{}),
+	}
+	go s.serve()
+	return s
+}
+
+func (s *testImapServer) Close() {
+	s.l.Close()
+}
+
+// Add delivers a new message and notifies idling clients.
+func (s *testImapServer) Add(raw string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = append(s.messages, &testImapMessage{Uid: s.nextUid, Raw: raw})
+	s.nextUid++
+	for ch := range s.idlers {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (s *testImapServer) Seen(uid uint32) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, m := range s.messages {
+		if m.Uid == uid {
+			return m.Seen
+		}
+	}
+	return false
+}
+
+func (s *testImapServer) find(uid string) *testImapMessage {
+	n, _ := strconv.ParseUint(uid, 10, 32)
+	for _, m := range s.messages {
+		if m.Uid == uint32(n) {
+			return m
+		}
+	}
+	return nil
+}
+
+func (s *testImapServer) serve() {
+	for {
+		conn, err := s.l.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+var reImapCommand = regexp.MustCompile(`^(\S+) (.*)$`)
+
+func (s *testImapServer) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	fmt.Fprintf(conn, "* OK test server ready\r\n")
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		m := reImapCommand.FindStringSubmatch(strings.TrimRight(line, "\r\n"))
+		if m == nil {
+			fmt.Fprintf(conn, "* BAD invalid command\r\n")
+			continue
+		}
+		tag, cmd := m[1], m[2]
+		args := strings.Fields(cmd)
+
+		switch strings.ToUpper(args[0]) {
+		case "CAPABILITY":
+			fmt.Fprintf(conn, "* CAPABILITY IMAP4rev1 IDLE\r\n")
+		case "LOGIN":
+			if len(args) < 3 || args[1] != `"user"` || args[2] != `"secret"` {
+				fmt.Fprintf(conn, "%s NO invalid credentials\r\n", tag)
+				continue
+			}
+		case "SELECT":
+			s.mu.Lock()
+			fmt.Fprintf(conn, "* %d EXISTS\r\n", len(s.messages))
+			s.mu.Unlock()
+		case "NOOP":
+		case "LOGOUT":
+			fmt.Fprintf(conn, "* BYE\r\n%s OK LOGOUT completed\r\n", tag)
+			return
+		case "IDLE":
+			ch := make(chan struct{}, 1)
+			s.mu.Lock()
+			s.idlers[ch] = struct{}{}
+			s.mu.Unlock()
+			fmt.Fprintf(conn, "+ idling\r\n")
+
+			done := make(chan struct{})
+			go func() {
+				r.ReadString('\n')
+				close(done)
+			}()
+			select {
+			case <-ch:
+				s.mu.Lock()
+				fmt.Fprintf(conn, "* %d EXISTS\r\n", len(s.messages))
+				s.mu.Unlock()
+				<-done
+			case <-done:
+			}
+
+			s.mu.Lock()
+			delete(s.idlers, ch)
+			s.mu.Unlock()
+		case "UID":
+			s.mu.Lock()
+			switch strings.ToUpper(args[1]) {
+			case "SEARCH":
+				uids := make([]string, 0)
+				for _, m := range s.messages {
+					if !m.Seen {
+						uids = append(uids, strconv.Itoa(int(m.Uid)))
+					}
+				}
+				fmt.Fprintf(conn, "* SEARCH %s\r\n", strings.Join(uids, " "))
+			case "FETCH":
+				if msg := s.find(args[2]); msg != nil {
+					fmt.Fprintf(conn, "* %d FETCH (UID %d BODY[] {%d}\r\n%s)\r\n", msg.Uid, msg.Uid, len(msg.Raw), msg.Raw)
+				}
+			case "STORE":
+				if msg := s.find(args[2]); msg != nil {
+					msg.Seen = true
+				}
+			}
+			s.mu.Unlock()
+		default:
+			fmt.Fprintf(conn, "%s BAD unknown command\r\n", tag)
+			continue
+		}
+		fmt.Fprintf(conn, "%s OK completed\r\n", tag)
+	}
+}
+
+// testSmtpServer accepts all mail and passes it on to a channel.
+type testSmtpServer struct {
+	l    net.Listener
+	Mail chan testSmtpMail
+}
+
+type testSmtpMail struct {
+	From string
+	To   []string
+	Data string
+}
+
+func newTestSmtpServer(t *testing.T) *testSmtpServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testSmtpServer{l, make(chan testSmtpMail, 10)}
+	go s.serve()
+	return s
+}
+
+func (s *testSmtpServer) Close() {
+	s.l.Close()
+}
+
+func (s *testSmtpServer) serve() {
+	for {
+		conn, err := s.l.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *testSmtpServer) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	fmt.Fprintf(conn, "220 localhost ESMTP test\r\n")
+
+	var mail testSmtpMail
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		cmd := strings.ToUpper(line)
+
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			fmt.Fprintf(conn, "250 localhost\r\n")
+		case strings.HasPrefix(cmd, "MAIL FROM:"):
+			mail = testSmtpMail{From: strings.Trim(line[10:], "<>")}
+			fmt.Fprintf(conn, "250 OK\r\n")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			mail.To = append(mail.To, strings.Trim(line[8:], "<>"))
+			fmt.Fprintf(conn, "250 OK\r\n")
+		case cmd == "DATA":
+			fmt.Fprintf(conn, "354 go ahead\r\n")
+			var data []string
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data = append(data, strings.TrimPrefix(l, "."))
+			}
+			mail.Data = strings.Join(data, "")
+			s.Mail <- mail
+			fmt.Fprintf(conn, "250 OK\r\n")
+		case cmd == "QUIT":
+			fmt.Fprintf(conn, "221 bye\r\n")
+			return
+		default:
+			fmt.Fprintf(conn, "250 OK\r\n")
+		}
+	}
+}
@@ -0,0 +1,356 @@
+// Copyright (C) 2019 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// Service email receives mail over IMAP and sends messages over SMTP.
+package email
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sarifsystems/sarif/pkg/content"
+	"github.com/sarifsystems/sarif/sarif"
+	"github.com/sarifsystems/sarif/services"
+	contentservice "github.com/sarifsystems/sarif/services/content"
+	"github.com/sarifsystems/sarif/transports/sfproto"
+)
+
+var Module = &services.Module{
+	Name:        "email",
+	Version:     "1.0",
+	NewInstance: NewService,
+}
+
+type ImapConfig struct {
+	// Address of the server as host:port.
+	Address  string
+	TLS      bool
+	Username string
+	Password string
+	Mailbox  string
+	// PollInterval is the number of seconds between checks for new mail.
+	// Servers supporting IDLE notify earlier.
+	PollInterval int
+}
+
+type SmtpConfig struct {
+	Address  string
+	Username string
+	Password string
+	From     string
+}
+
+type Config struct {
+	Imap ImapConfig
+	Smtp SmtpConfig
+	// To is the default recipient of mail/send.
+	To []string
+	// Senders may issue commands by mail. Entries of the form "*@domain"
+	// allow a whole domain.
+	Senders []string
+	// Commands are prefixes of the subject or body of mails that are
+	// handled as natural language commands.
+	Commands []string
+}
+
+type Dependencies struct {
+	Config        services.Config
+	Client        sarif.Client
+	ClientFactory sarif.ClientFactory
+	Log           sfproto.Logger
+}
+
+type conversation struct {
+	Remote    string
+	Subject   string
+	MessageId string
+	Proto     sarif.Client
+}
+
+type Service struct {
+	Cfg Config
+	cfg services.Config
+	sarif.Client
+	ClientFactory sarif.ClientFactory
+	Log           sfproto.Logger
+
+	// Store keeps the attachments of received mail, by default on the
+	// content service.
+	Store content.Provider
+
+	mu            sync.Mutex
+	conversations map[string]*conversation
+	stop          chan struct{}
+	imap          *imapClient
+}
+
+func NewService(deps *Dependencies) *Service {
+	return &Service{
+		Cfg: Config{
+			Imap: ImapConfig{
+				Mailbox:      "INBOX",
+				PollInterval: 300,
+			},
+			Commands: []string{"remind me"},
+		},
+		cfg:           deps.Config,
+		Client:        deps.Client,
+		ClientFactory: deps.ClientFactory,
+		Log:           deps.Log,
+		Store:         contentservice.NewClient(deps.Client),
+		conversations: make(map[string]*conversation),
+	}
+}
+
+func (s *Service) Enable() error {
+	s.cfg.Get(&s.Cfg)
+
+	s.Subscribe("mail/send", "", s.handleSend)
+	if s.Cfg.Imap.Address != "" {
+		s.stop = make(chan struct{})
+		go s.run(s.stop)
+	}
+	return nil
+}
+
+func (s *Service) Disable() error {
+	if s.stop == nil {
+		return nil
+	}
+	close(s.stop)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.imap != nil {
+		s.imap.conn.Close()
+	}
+	return nil
+}
+
+var errStopped = errors.New("stopped")
+
+func (s *Service) run(stop chan struct{}) {
+	for {
+		err := s.poll(stop)
+		if err == errStopped {
+			return
+		}
+		s.Log.Errorln("[email] imap:", err)
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(time.Minute):
+		}
+	}
+}
+
+func (s *Service) poll(stop chan struct{}) error {
+	cfg := s.Cfg.Imap
+	c, err := dialImap(cfg)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.imap = c
+	s.mu.Unlock()
+	defer c.Logout()
+
+	if err := c.Login(cfg.Username, cfg.Password); err != nil {
+		return err
+	}
+	if err := c.Select(cfg.Mailbox); err != nil {
+		return err
+	}
+	caps, err := c.Capabilities()
+	if err != nil {
+		return err
+	}
+
+	interval := time.Duration(cfg.PollInterval) * time.Second
+	for {
+		if err := s.fetchNew(c); err != nil {
+			return err
+		}
+
+		select {
+		case <-stop:
+			return errStopped
+		default:
+		}
+		if caps["IDLE"] {
+			err = c.Idle(interval)
+		} else {
+			_, err = c.cmd("NOOP")
+			select {
+			case <-stop:
+			case <-time.After(interval):
+			}
+		}
+		select {
+		case <-stop:
+			return errStopped
+		default:
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// fetchNew publishes all unseen messages and marks them as seen.
+func (s *Service) fetchNew(c *imapClient) error {
+	uids, err := c.SearchUnseen()
+	if err != nil {
+		return err
+	}
+	for _, uid := range uids {
+		raw, err := c.Fetch(uid)
+		if err != nil {
+			return err
+		}
+		if m, err := parseMail(raw); err != nil {
+			s.Log.Warnln("[email] could not parse mail:", err)
+		} else {
+			s.handleMail(m)
+		}
+		if err := c.MarkSeen(uid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Service) handleMail(m Mail) {
+	s.Log.Debugln("[email] received mail from", m.From, m.Subject)
+	for i, a := range m.Attachments {
+		stored, err := s.Store.Put(a)
+		if err != nil {
+			s.Log.Errorln("[email] could not store attachment:", err)
+			continue
+		}
+		if stored.Name == "" {
+			stored.Name = a.Name
+		}
+		m.Attachments[i] = stored
+	}
+	s.Publish(sarif.CreateMessage("mail/received", m))
+
+	if text, ok := s.command(m); ok {
+		s.handleCommand(m, text)
+	}
+}
+
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[0:len(prefix)], prefix)
+}
+
+// senderAllowed checks the sender against the allowlist.
+func (cfg Config) senderAllowed(addr string) bool {
+	addr = strings.ToLower(addr)
+	for _, a := range cfg.Senders {
+		a = strings.ToLower(a)
+		if a == addr || (strings.HasPrefix(a, "*@") && strings.HasSuffix(addr, a[1:])) {
+			return true
+		}
+	}
+	return false
+}
+
+// command returns the command text of a mail from an allowed sender,
+// either from its subject or the first line of its body.
+func (s *Service) command(m Mail) (string, bool) {
+	if m.From == "" || !s.Cfg.senderAllowed(m.From) {
+		return "", false
+	}
+
+	body := m.Body
+	if i := strings.Index(body, "\n"); i >= 0 {
+		body = body[0:i]
+	}
+	for _, text := range []string{m.Subject, body} {
+		text = strings.TrimSpace(text)
+		for _, c := range s.Cfg.Commands {
+			if c != "" && hasPrefixFold(text, c) {
+				return text, true
+			}
+		}
+	}
+	return "", false
+}
+
+// handleCommand passes the command to natural/handle on behalf of the
+// sender. All replies are sent back as mail.
+func (s *Service) handleCommand(m Mail, text string) {
+	s.mu.Lock()
+	cv, ok := s.conversations[m.From]
+	if !ok {
+		client, err := s.ClientFactory.NewClient(sarif.ClientInfo{
+			Name: "email/" + m.From,
+		})
+		if err != nil {
+			s.mu.Unlock()
+			s.Log.Errorln("[email] new client:", err)
+			return
+		}
+		cv = &conversation{Remote: m.From, Proto: client}
+		client.Subscribe("", "self", func(msg sarif.Message) {
+			s.reply(cv, msg)
+		})
+		s.conversations[m.From] = cv
+	}
+	cv.Subject = m.Subject
+	cv.MessageId = m.MessageId
+	s.mu.Unlock()
+
+	cv.Proto.Publish(sarif.Message{
+		Action: "natural/handle",
+		Text:   text,
+	})
+}
+
+func (s *Service) reply(cv *conversation, msg sarif.Message) {
+	s.mu.Lock()
+	subject, inReplyTo := cv.Subject, cv.MessageId
+	s.mu.Unlock()
+
+	o := composeMail(msg, s.Cfg.Smtp.From, []string{cv.Remote})
+	o.To = []string{cv.Remote}
+	if subject != "" {
+		if !hasPrefixFold(subject, "Re:") {
+			subject = "Re: " + subject
+		}
+		o.Subject = subject
+		o.InReplyTo = inReplyTo
+	}
+	if err := sendMail(s.Cfg.Smtp, o); err != nil {
+		s.Log.Errorln("[email] reply:", err)
+	}
+}
+
+func (s *Service) handleSend(msg sarif.Message) {
+	if s.Cfg.Smtp.Address == "" {
+		s.ReplyInternalError(msg, errors.New("No SMTP server configured."))
+		return
+	}
+
+	o := composeMail(msg, s.Cfg.Smtp.From, s.Cfg.To)
+	if len(o.To) == 0 {
+		s.ReplyBadRequest(msg, errors.New("No recipient specified."))
+		return
+	}
+	if err := sendMail(s.Cfg.Smtp, o); err != nil {
+		s.ReplyInternalError(msg, err)
+		return
+	}
+
+	reply := sarif.CreateMessage("mail/sent", SendPayload{
+		To:      o.To,
+		Subject: o.Subject,
+	})
+	reply.Text = "Sent mail to " + strings.Join(o.To, ", ") + "."
+	s.Reply(msg, reply)
+}
@@ -54,6 +54,18 @@ func (s *Service) Enable() error {
 	s.Subscribe("event/list", "", s.handleEventList)
 	s.Subscribe("event/record", "", s.handleEventRecord)
 
+	s.RegisterSchema(sarif.ActionSchema{
+		Action:      "event/new",
+		Description: "Record a new event.",
+		Request:     sarif.SchemaOf(Event{}),
+	})
+	s.RegisterSchema(sarif.ActionSchema{
+		Action:      "event/last",
+		Description: "Find the last event matching a filter.",
+		Request:     &sarif.Schema{Type: "object"},
+		Response:    sarif.SchemaOf(Event{}),
+	})
+
 	var cfg Config
 	if !s.cfg.Exists() {
 		cfg.RecordedActions = map[string]bool{
@@ -10,22 +10,29 @@ import (
 	"errors"
 	"fmt"
 	"net"
-	"os/exec"
-	"regexp"
 	"strings"
+	"sync"
 	"time"
 )
 
-var re_nmap_hosts = regexp.MustCompile(`Host: ([\d\.]+) \(([\w\.\-]*)\)`)
-
 type Host struct {
 	Ip        string    `json:"ip"`
+	Mac       string    `json:"mac,omitempty"`
 	Name      string    `json:"name,omitempty"`
 	Time      time.Time `json:"time"`
 	UpdatedAt time.Time `json:"-"`
 	Status    string    `json:"status"`
 }
 
+// Key identifies the host by its MAC address, which unlike the IP
+// address stays the same across DHCP leases.
+func (h Host) Key() string {
+	if h.Mac != "" {
+		return h.Mac
+	}
+	return h.Ip
+}
+
 func (h Host) String() string {
 	n := h.Name
 	if n == "" {
@@ -36,72 +43,143 @@ func (h Host) String() string {
 
 type HostScan struct {
 	MinDownInterval time.Duration
-	status          map[string]Host
+	// Networks are probed for hosts, defaulting to all local private networks.
+	Networks []*net.IPNet
+	// Devices maps lowercase MAC addresses to friendly names.
+	Devices map[string]string
+	Prober  Prober
+
+	mutex  sync.Mutex
+	status map[string]Host
 }
 
 func New() *HostScan {
 	return &HostScan{
-		9 * time.Minute,
-		make(map[string]Host),
+		MinDownInterval: 9 * time.Minute,
+		Devices:         make(map[string]string),
+		Prober:          Prober{Wait: 3 * time.Second},
+		status:          make(map[string]Host),
 	}
 }
 
-func (h *HostScan) FindLocalNetwork() (string, error) {
-	addrs, err := net.InterfaceAddrs()
-	if err != nil {
-		return "", err
+func (h *HostScan) ScanCurrentHosts() ([]Host, error) {
+	nets := h.Networks
+	if len(nets) == 0 {
+		var err error
+		if nets, err = LocalNetworks(); err != nil {
+			return nil, err
+		}
 	}
-	for _, addr := range addrs {
-		a := addr.String()
-		if strings.HasPrefix(a, "192.168.") {
-			return a, nil
+
+	replied, probeErr := h.Prober.Probe(nets)
+	neighs, err := ReadNeighbors()
+	if err != nil && len(replied) == 0 {
+		if probeErr != nil {
+			return nil, probeErr
 		}
+		return nil, err
 	}
-	return "", nil
+
+	return h.collectHosts(nets, neighs, replied, localAddrs()), nil
 }
 
-func (h *HostScan) ScanCurrentHosts() ([]Host, error) {
-	addr, err := h.FindLocalNetwork()
-	if err != nil {
-		return nil, err
+// collectHosts merges reachable neighbors and hosts that answered the
+// probe, ignoring the addresses of this machine.
+func (h *HostScan) collectHosts(nets []*net.IPNet, neighs []Neighbor, replied []net.IP, local map[string]bool) []Host {
+	now := time.Now()
+	seen := make(map[string]bool)
+	hosts := make([]Host, 0)
+	add := func(ip net.IP, mac net.HardwareAddr) {
+		if seen[ip.String()] || local[ip.String()] {
+			return
+		}
+		seen[ip.String()] = true
+
+		host := Host{
+			Ip:        ip.String(),
+			Mac:       strings.ToLower(mac.String()),
+			Status:    "up",
+			Time:      now,
+			UpdatedAt: now,
+		}
+		host.Name = h.hostName(host)
+		hosts = append(hosts, host)
 	}
-	cmd := exec.Command("nmap", "-T3", "-sn", "-oG", "-", addr)
-	out, err := cmd.Output()
-	if err != nil {
-		return nil, err
+
+	for _, n := range neighs {
+		if n.Reachable && len(n.Mac) > 0 && inNetworks(nets, n.Ip) {
+			add(n.Ip, n.Mac)
+		}
+	}
+	for _, ip := range replied {
+		add(ip, nil)
 	}
+	return hosts
+}
 
-	matches := re_nmap_hosts.FindAllStringSubmatch(string(out), -1)
+func (h *HostScan) hostName(host Host) string {
+	if name, ok := h.Devices[host.Mac]; ok {
+		return name
+	}
+	if last, ok := h.status[host.Key()]; ok && last.Name != "" {
+		return last.Name
+	}
+	if names, err := net.LookupAddr(host.Ip); err == nil && len(names) > 0 {
+		return strings.TrimSuffix(names[0], ".")
+	}
+	return ""
+}
 
-	now := time.Now()
-	hosts := make([]Host, len(matches))
-	for i, match := range matches {
-		hosts[i].Ip = match[1]
-		hosts[i].Name = match[2]
-		hosts[i].Status = "up"
-		hosts[i].Time = now
-		hosts[i].UpdatedAt = now
+// inNetworks reports whether an IPv4 address is part of the scanned
+// networks. IPv6 neighbors are always considered local.
+func inNetworks(nets []*net.IPNet, ip net.IP) bool {
+	if ip.To4() == nil {
+		return !ip.IsLoopback() && !ip.IsMulticast()
+	}
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
 	}
+	return false
+}
 
-	return hosts, nil
+func localAddrs() map[string]bool {
+	local := make(map[string]bool)
+	addrs, _ := net.InterfaceAddrs()
+	for _, addr := range addrs {
+		if n, ok := addr.(*net.IPNet); ok {
+			local[n.IP.String()] = true
+		}
+	}
+	return local
 }
 
 func (h *HostScan) Update() ([]Host, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
 	curr, err := h.ScanCurrentHosts()
 	if err != nil {
 		return nil, err
 	}
+	return h.update(curr, time.Now()), nil
+}
 
-	now := time.Now()
+// update applies a scan result and returns the hosts whose status changed.
+// Hosts only go down after they were missing for MinDownInterval, so that
+// phones dozing off their wifi do not flap.
+func (h *HostScan) update(curr []Host, now time.Time) []Host {
 	changed := make([]Host, 0)
 	for _, host := range curr {
-		last, ok := h.status[host.Ip]
+		last, ok := h.status[host.Key()]
 		if !ok || last.Status == "down" {
-			h.status[host.Ip] = host
+			h.status[host.Key()] = host
 			changed = append(changed, host)
 		} else {
+			last.Ip = host.Ip
 			last.UpdatedAt = now
-			h.status[host.Ip] = last
+			h.status[host.Key()] = last
 		}
 	}
 	for _, last := range h.status {
@@ -109,17 +187,20 @@ func (h *HostScan) Update() ([]Host, error) {
 			last.Status = "down"
 			last.Time = now.Add(-h.MinDownInterval / 2)
 			last.UpdatedAt = now
-			h.status[last.Ip] = last
+			h.status[last.Key()] = last
 			changed = append(changed, last)
 		}
 	}
 
-	return changed, nil
+	return changed
 }
 
 func (h *HostScan) LastStatus(name string) (Host, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
 	for _, host := range h.status {
-		if host.Ip == name || host.Name == name || host.Name == name+".lan" {
+		if host.Ip == name || host.Mac == strings.ToLower(name) || host.Name == name || host.Name == name+".lan" {
 			return host, nil
 		}
 	}
@@ -132,6 +213,9 @@ func (h *HostScan) LastStatus(name string) (Host, error) {
 }
 
 func (h *HostScan) LastStatusAll() ([]Host, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
 	after := time.Now().AddDate(0, -1, 0)
 
 	hosts := make([]Host, 0)
@@ -0,0 +1,86 @@
+// Copyright (C) 2014 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package hostscan
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestUpdateDebounce(t *testing.T) {
+	h := New()
+	h.MinDownInterval = 10 * time.Minute
+	now := time.Now()
+	phone := Host{Ip: "192.168.1.20", Mac: "aa:bb:cc:dd:ee:ff", Status: "up", Time: now, UpdatedAt: now}
+
+	if changed := h.update([]Host{phone}, now); len(changed) != 1 || changed[0].Status != "up" {
+		t.Fatal("expected phone to come up, got", changed)
+	}
+
+	// Phone got a new lease, but it is still the same device.
+	moved := phone
+	moved.Ip = "192.168.1.21"
+	if changed := h.update([]Host{moved}, now.Add(time.Minute)); len(changed) != 0 {
+		t.Error("expected no change on new ip, got", changed)
+	}
+
+	if changed := h.update(nil, now.Add(5*time.Minute)); len(changed) != 0 {
+		t.Error("expected phone to be debounced, got", changed)
+	}
+
+	changed := h.update(nil, now.Add(12*time.Minute))
+	if len(changed) != 1 || changed[0].Status != "down" || changed[0].Ip != "192.168.1.21" {
+		t.Fatal("expected phone to go down, got", changed)
+	}
+}
+
+func TestHostAddrs(t *testing.T) {
+	tests := map[string][]string{
+		"192.168.1.0/30":  {"192.168.1.1", "192.168.1.2"},
+		"10.0.0.4/31":     {"10.0.0.4", "10.0.0.5"},
+		"172.16.3.7/32":   {"172.16.3.7"},
+		"192.168.2.17/29": {"192.168.2.17", "192.168.2.18", "192.168.2.19", "192.168.2.20", "192.168.2.21", "192.168.2.22"},
+	}
+
+	for cidr, exp := range tests {
+		_, n, _ := net.ParseCIDR(cidr)
+		addrs, err := hostAddrs(n)
+		if err != nil {
+			t.Fatal(cidr, err)
+		}
+		if len(addrs) != len(exp) {
+			t.Errorf("%s: expected %d addresses, got %v", cidr, len(exp), addrs)
+			continue
+		}
+		for i, ip := range addrs {
+			if ip.String() != exp[i] {
+				t.Errorf("%s: expected %s at %d, got %s", cidr, exp[i], i, ip)
+			}
+		}
+	}
+
+	_, n, _ := net.ParseCIDR("10.0.0.0/8")
+	if _, err := hostAddrs(n); err == nil {
+		t.Error("expected large network to be rejected")
+	}
+}
+
+func TestProbeTargetsSkipsLargeNetworks(t *testing.T) {
+	var nets []*net.IPNet
+	for _, cidr := range []string{"172.17.0.1/16", "192.168.1.0/30"} {
+		_, n, _ := net.ParseCIDR(cidr)
+		nets = append(nets, n)
+	}
+
+	targets, skipped := probeTargets(nets)
+	if len(skipped) != 1 {
+		t.Errorf("expected docker network to be skipped, got %v", skipped)
+	}
+	if len(targets) != 2 || targets[0].String() != "192.168.1.1" {
+		t.Errorf("expected small network to be probed, got %v", targets)
+	}
+}
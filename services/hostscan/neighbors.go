@@ -0,0 +1,16 @@
+// Copyright (C) 2014 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package hostscan
+
+import "net"
+
+// Neighbor is an entry of the kernel ARP / NDP neighbor table.
+type Neighbor struct {
+	Ip        net.IP
+	Mac       net.HardwareAddr
+	Interface int
+	Reachable bool
+}
@@ -0,0 +1,94 @@
+// Copyright (C) 2014 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package hostscan
+
+import (
+	"encoding/binary"
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	sizeofNdMsg = 12
+
+	ndaDst    = 1
+	ndaLLAddr = 2
+
+	nudReachable = 0x02
+	nudDelay     = 0x08
+)
+
+var nativeEndian binary.ByteOrder = binary.LittleEndian
+
+func init() {
+	i := uint16(1)
+	if (*[2]byte)(unsafe.Pointer(&i))[0] == 0 {
+		nativeEndian = binary.BigEndian
+	}
+}
+
+// ReadNeighbors dumps the kernel neighbor table via netlink.
+func ReadNeighbors() ([]Neighbor, error) {
+	tab, err := syscall.NetlinkRIB(syscall.RTM_GETNEIGH, syscall.AF_UNSPEC)
+	if err != nil {
+		return nil, err
+	}
+	msgs, err := syscall.ParseNetlinkMessage(tab)
+	if err != nil {
+		return nil, err
+	}
+	return parseNeighbors(msgs), nil
+}
+
+func parseNeighbors(msgs []syscall.NetlinkMessage) []Neighbor {
+	neighs := make([]Neighbor, 0)
+	for _, m := range msgs {
+		if m.Header.Type == syscall.NLMSG_DONE {
+			break
+		}
+		if m.Header.Type != syscall.RTM_NEWNEIGH || len(m.Data) < sizeofNdMsg {
+			continue
+		}
+
+		n := Neighbor{
+			Interface: int(int32(nativeEndian.Uint32(m.Data[4:8]))),
+		}
+		// Entries in delay state were in use recently and are only
+		// waiting for confirmation.
+		state := nativeEndian.Uint16(m.Data[8:10])
+		n.Reachable = state&(nudReachable|nudDelay) != 0
+
+		attrs := m.Data[sizeofNdMsg:]
+		for len(attrs) >= 4 {
+			l := int(nativeEndian.Uint16(attrs[0:2]))
+			typ := nativeEndian.Uint16(attrs[2:4])
+			if l < 4 || l > len(attrs) {
+				break
+			}
+			data := attrs[4:l]
+			switch typ {
+			case ndaDst:
+				n.Ip = net.IP(append([]byte(nil), data...))
+			case ndaLLAddr:
+				n.Mac = net.HardwareAddr(append([]byte(nil), data...))
+			}
+			next := rtaAlign(l)
+			if next > len(attrs) {
+				break
+			}
+			attrs = attrs[next:]
+		}
+		if n.Ip != nil {
+			neighs = append(neighs, n)
+		}
+	}
+	return neighs
+}
+
+func rtaAlign(l int) int {
+	return (l + syscall.RTA_ALIGNTO - 1) & ^(syscall.RTA_ALIGNTO - 1)
+}
@@ -0,0 +1,59 @@
+// Copyright (C) 2014 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package hostscan
+
+import (
+	"syscall"
+	"testing"
+)
+
+func rtAttr(typ uint16, data []byte) []byte {
+	b := make([]byte, rtaAlign(4+len(data)))
+	nativeEndian.PutUint16(b[0:2], uint16(4+len(data)))
+	nativeEndian.PutUint16(b[2:4], typ)
+	copy(b[4:], data)
+	return b
+}
+
+func ndMsg(family byte, state uint16, attrs ...[]byte) syscall.NetlinkMessage {
+	b := make([]byte, sizeofNdMsg)
+	b[0] = family
+	nativeEndian.PutUint32(b[4:8], 2)
+	nativeEndian.PutUint16(b[8:10], state)
+	for _, a := range attrs {
+		b = append(b, a...)
+	}
+	m := syscall.NetlinkMessage{Data: b}
+	m.Header.Type = syscall.RTM_NEWNEIGH
+	return m
+}
+
+func TestParseNeighbors(t *testing.T) {
+	mac := []byte{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff}
+	msgs := []syscall.NetlinkMessage{
+		ndMsg(syscall.AF_INET, nudReachable,
+			rtAttr(ndaDst, []byte{192, 168, 1, 20}),
+			rtAttr(ndaLLAddr, mac),
+		),
+		ndMsg(syscall.AF_INET, 0x20,
+			rtAttr(ndaDst, []byte{192, 168, 1, 21}),
+		),
+	}
+	msgs = append(msgs, syscall.NetlinkMessage{})
+	msgs[2].Header.Type = syscall.NLMSG_DONE
+
+	neighs := parseNeighbors(msgs)
+	if len(neighs) != 2 {
+		t.Fatal("expected 2 neighbors, got", neighs)
+	}
+	n := neighs[0]
+	if n.Ip.String() != "192.168.1.20" || n.Mac.String() != "aa:bb:cc:dd:ee:ff" || !n.Reachable || n.Interface != 2 {
+		t.Error("unexpected neighbor:", n)
+	}
+	if neighs[1].Reachable || neighs[1].Mac != nil {
+		t.Error("expected failed neighbor to be unreachable:", neighs[1])
+	}
+}
@@ -0,0 +1,16 @@
+// Copyright (C) 2014 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build !linux
+
+package hostscan
+
+import "errors"
+
+// ReadNeighbors is only implemented on Linux, elsewhere hosts are
+// detected by their ping replies alone.
+func ReadNeighbors() ([]Neighbor, error) {
+	return nil, errors.New("reading the neighbor table is not supported on this platform")
+}
@@ -0,0 +1,270 @@
+// Copyright (C) 2014 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package hostscan
+
+import (
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// maxProbeHosts limits the size of networks that are probed address by
+// address, so that a misconfigured /8 does not flood the network.
+const maxProbeHosts = 4096
+
+// LocalNetworks returns the private IPv4 networks of all interfaces that
+// are up. IPv6 neighbors are found via the all-nodes multicast group.
+func LocalNetworks() ([]*net.IPNet, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	nets := make([]*net.IPNet, 0)
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			n, ok := addr.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			if ip4 := n.IP.To4(); ip4 != nil && isPrivate(ip4) {
+				nets = append(nets, n)
+			}
+		}
+	}
+	return nets, nil
+}
+
+func isPrivate(ip net.IP) bool {
+	for _, cidr := range []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16"} {
+		_, n, _ := net.ParseCIDR(cidr)
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// hostAddrs enumerates all host addresses of an IPv4 network.
+func hostAddrs(n *net.IPNet) ([]net.IP, error) {
+	ip := n.IP.Mask(n.Mask).To4()
+	if ip == nil {
+		return nil, errors.New("only IPv4 networks can be probed by address: " + n.String())
+	}
+	ones, bits := n.Mask.Size()
+	size := 1 << uint(bits-ones)
+	if size > maxProbeHosts {
+		return nil, errors.New("network too large to probe: " + n.String())
+	}
+
+	start := uint32(ip[0])<<24 | uint32(ip[1])<<16 | uint32(ip[2])<<8 | uint32(ip[3])
+	first, last := 1, size-2
+	if size <= 2 {
+		// Point-to-point networks have no network and broadcast address.
+		first, last = 0, size-1
+	}
+	addrs := make([]net.IP, 0, size)
+	for i := first; i <= last; i++ {
+		v := start + uint32(i)
+		addrs = append(addrs, net.IPv4(byte(v>>24), byte(v>>16), byte(v>>8), byte(v)))
+	}
+	return addrs, nil
+}
+
+// Prober sends ICMP echo requests to populate the neighbor table and to
+// find hosts that answer directly.
+type Prober struct {
+	Wait time.Duration
+	// Log is called for networks that are skipped, e.g. because they are
+	// too large to probe address by address.
+	Log func(v ...interface{})
+}
+
+// probeTargets enumerates the host addresses of all networks that can be
+// probed, returning the skipped networks with the reason separately.
+func probeTargets(nets []*net.IPNet) ([]net.IP, []error) {
+	targets := make([]net.IP, 0)
+	var skipped []error
+	for _, n := range nets {
+		addrs, err := hostAddrs(n)
+		if err != nil {
+			skipped = append(skipped, err)
+			continue
+		}
+		targets = append(targets, addrs...)
+	}
+	return targets, skipped
+}
+
+// Probe pings all addresses of the given IPv4 networks and the IPv6
+// all-nodes group on every interface, returning the addresses that replied.
+// Networks too large to probe are skipped, their hosts can still be found
+// in the neighbor table. If ICMP sockets are not permitted, it falls back
+// to sending empty UDP packets, which still makes the kernel resolve neighbors.
+func (p Prober) Probe(nets []*net.IPNet) ([]net.IP, error) {
+	targets, skipped := probeTargets(nets)
+	for _, err := range skipped {
+		if p.Log != nil {
+			p.Log("[hostscan:probe] skipped:", err)
+		}
+	}
+
+	var mutex sync.Mutex
+	seen := make(map[string]net.IP)
+	found := func(ip net.IP) {
+		mutex.Lock()
+		seen[ip.String()] = ip
+		mutex.Unlock()
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		if err := p.ping4(targets, found); err != nil {
+			poke(targets)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		p.ping6AllNodes(found)
+	}()
+	wg.Wait()
+
+	ips := make([]net.IP, 0, len(seen))
+	for _, ip := range seen {
+		ips = append(ips, ip)
+	}
+	return ips, nil
+}
+
+func listenICMP(privileged, unprivileged, addr string) (*icmp.PacketConn, bool, error) {
+	if c, err := icmp.ListenPacket(unprivileged, addr); err == nil {
+		return c, true, nil
+	}
+	c, err := icmp.ListenPacket(privileged, addr)
+	return c, false, err
+}
+
+func (p Prober) ping4(targets []net.IP, found func(net.IP)) error {
+	c, udp, err := listenICMP("ip4:icmp", "udp4", "0.0.0.0")
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		readReplies(c, 1, ipv4.ICMPTypeEchoReply, found)
+	}()
+
+	for i, ip := range targets {
+		var dst net.Addr = &net.IPAddr{IP: ip}
+		if udp {
+			dst = &net.UDPAddr{IP: ip}
+		}
+		sendEcho(c, ipv4.ICMPTypeEcho, i, dst)
+	}
+
+	c.SetReadDeadline(time.Now().Add(p.Wait))
+	<-done
+	return nil
+}
+
+func (p Prober) ping6AllNodes(found func(net.IP)) error {
+	c, udp, err := listenICMP("ip6:ipv6-icmp", "udp6", "::")
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		readReplies(c, 58, ipv6.ICMPTypeEchoReply, found)
+	}()
+
+	ifaces, _ := net.Interfaces()
+	for i, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagMulticast == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		ip := net.ParseIP("ff02::1")
+		var dst net.Addr = &net.IPAddr{IP: ip, Zone: iface.Name}
+		if udp {
+			dst = &net.UDPAddr{IP: ip, Zone: iface.Name}
+		}
+		sendEcho(c, ipv6.ICMPTypeEchoRequest, i, dst)
+	}
+
+	c.SetReadDeadline(time.Now().Add(p.Wait))
+	<-done
+	return nil
+}
+
+func sendEcho(c *icmp.PacketConn, typ icmp.Type, seq int, dst net.Addr) error {
+	m := icmp.Message{
+		Type: typ,
+		Body: &icmp.Echo{
+			ID:   os.Getpid() & 0xffff,
+			Seq:  seq & 0xffff,
+			Data: []byte("sarif hostscan"),
+		},
+	}
+	b, err := m.Marshal(nil)
+	if err != nil {
+		return err
+	}
+	_, err = c.WriteTo(b, dst)
+	return err
+}
+
+func readReplies(c *icmp.PacketConn, proto int, typ icmp.Type, found func(net.IP)) {
+	buf := make([]byte, 1500)
+	for {
+		n, peer, err := c.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		m, err := icmp.ParseMessage(proto, buf[:n])
+		if err != nil || m.Type != typ {
+			continue
+		}
+		switch a := peer.(type) {
+		case *net.IPAddr:
+			found(a.IP)
+		case *net.UDPAddr:
+			found(a.IP)
+		}
+	}
+}
+
+// poke sends an empty UDP datagram to the discard port of every target.
+// Replies are irrelevant, the kernel has to resolve the neighbor first.
+func poke(targets []net.IP) {
+	for _, ip := range targets {
+		c, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: ip, Port: 9})
+		if err != nil {
+			continue
+		}
+		c.Write(nil)
+		c.Close()
+	}
+}
@@ -6,6 +6,8 @@
 package hostscan
 
 import (
+	"net"
+	"strings"
 	"time"
 
 	"github.com/sarifsystems/sarif/sarif"
@@ -19,28 +21,68 @@ var Module = &services.Module{
 	NewInstance: NewService,
 }
 
+type Config struct {
+	// Networks to probe in CIDR notation, e.g. "192.168.1.0/24".
+	// Defaults to all private networks of the local interfaces.
+	Networks []string
+	// Devices maps MAC addresses to friendly names.
+	Devices map[string]string
+
+	Interval        string
+	MinDownInterval string
+}
+
 type Dependencies struct {
+	Config services.Config
 	Log    sfproto.Logger
 	Client sarif.Client
 }
 
 type Service struct {
-	scan *HostScan
-	Log  sfproto.Logger
+	Cfg      Config
+	cfg      services.Config
+	scan     *HostScan
+	interval time.Duration
+	Log      sfproto.Logger
 	sarif.Client
 }
 
 func NewService(deps *Dependencies) *Service {
 	return &Service{
-		New(),
-		deps.Log,
-		deps.Client,
+		Cfg: Config{
+			Networks:        []string{},
+			Devices:         map[string]string{},
+			Interval:        "5m",
+			MinDownInterval: "7m",
+		},
+		cfg:    deps.Config,
+		scan:   New(),
+		Log:    deps.Log,
+		Client: deps.Client,
 	}
 }
 
-func (s *Service) Enable() error {
-	time.AfterFunc(5*time.Minute, s.scheduledUpdate)
-	s.scan.MinDownInterval = 7 * time.Minute
+func (s *Service) Enable() (err error) {
+	s.cfg.Get(&s.Cfg)
+	if s.interval, err = time.ParseDuration(s.Cfg.Interval); err != nil {
+		return err
+	}
+	if s.scan.MinDownInterval, err = time.ParseDuration(s.Cfg.MinDownInterval); err != nil {
+		return err
+	}
+	for _, cidr := range s.Cfg.Networks {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return err
+		}
+		s.scan.Networks = append(s.scan.Networks, n)
+	}
+	s.scan.Prober.Log = s.Log.Warnln
+	for mac, name := range s.Cfg.Devices {
+		s.scan.Devices[strings.ToLower(mac)] = name
+	}
+
+	time.AfterFunc(s.interval, s.scheduledUpdate)
 	if err := s.Subscribe("devices/force_update", "", s.HandleForceUpdate); err != nil {
 		return err
 	}
@@ -49,7 +91,7 @@ func (s *Service) Enable() error {
 
 func (s *Service) scheduledUpdate() {
 	s.Update()
-	time.AfterFunc(5*time.Minute, s.scheduledUpdate)
+	time.AfterFunc(s.interval, s.scheduledUpdate)
 }
 
 func (s *Service) Update() ([]Host, error) {
@@ -92,6 +134,10 @@ func (s *Service) HandleLastStatus(msg sarif.Message) {
 		s.Log.Debugln(host)
 		if err != nil {
 			s.Log.Warnln(err)
+			s.Reply(msg, sarif.Message{
+				Action: "err/notfound",
+				Text:   err.Error(),
+			})
 			return
 		}
 		s.Reply(msg, sarif.CreateMessage("devices/last_status", host))
@@ -0,0 +1,190 @@
+// Copyright (C) 2017 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package know
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/sarifsystems/sarif/sarif"
+)
+
+// AnswerPredicate is used for caching answers to questions that could not
+// be split into subject and predicate.
+const AnswerPredicate = "know:answer"
+
+// localTimeout bounds how long a query waits for the reasoner, so a missing
+// reasoner does not delay external lookups.
+const localTimeout = 2 * time.Second
+
+// Fact mirrors the facts stored by the reasoner service.
+type Fact struct {
+	Subject    string  `json:"subject"`
+	Predicate  string  `json:"predicate"`
+	Object     string  `json:"object"`
+	Source     string  `json:"source,omitempty"`
+	Confidence float64 `json:"confidence,omitempty"`
+}
+
+func (f Fact) String() string {
+	return f.Subject + " " + f.Predicate + " " + f.Object + " ."
+}
+
+type factResult struct {
+	Facts []Fact `json:"facts"`
+}
+
+var questionPatterns = []struct {
+	re       *regexp.Regexp
+	pred, sp int
+}{
+	{regexp.MustCompile(`^(?i)(?:what|who|where|when|which) (?:is|are|was|were) (?:the )?(.+?) of (?:the )?(.+)$`), 1, 2},
+	{regexp.MustCompile(`^(?i)(?:what|who|where|when|which) (?:is|are|was|were) (?:the )?(.+?)'s? (.+)$`), 2, 1},
+	{regexp.MustCompile(`^(?i)(?:the )?(.+?) of (?:the )?(.+)$`), 1, 2},
+	{regexp.MustCompile(`^(?i)(?:the )?(.+?)'s? (.+)$`), 2, 1},
+}
+
+// ParseQuestion splits a simple question like "what is the capital of
+// France?" or "France's capital" into subject and predicate.
+func ParseQuestion(q string) (subject, predicate string, ok bool) {
+	q = normalizeQuestion(q)
+	for _, p := range questionPatterns {
+		m := p.re.FindStringSubmatch(q)
+		if m == nil {
+			continue
+		}
+		subject, predicate = strings.TrimSpace(m[p.sp]), strings.TrimSpace(m[p.pred])
+		if subject != "" && predicate != "" {
+			return subject, predicate, true
+		}
+	}
+	return "", "", false
+}
+
+func normalizeQuestion(q string) string {
+	q = strings.TrimSpace(strings.TrimRight(q, ".?! "))
+	return strings.Join(strings.Fields(q), " ")
+}
+
+// queryFacts asks the reasoner for all facts matching subject and predicate,
+// resolving object URIs to their labels.
+func (s *Service) queryFacts(subject, predicate string) ([]Fact, error) {
+	req := sarif.CreateMessage("concepts/query", Fact{
+		Subject:   subject,
+		Predicate: predicate,
+	})
+	var reply sarif.Message
+	select {
+	case r, ok := <-s.Request(req):
+		if !ok {
+			return nil, nil
+		}
+		reply = r
+	case <-time.After(localTimeout):
+		return nil, nil
+	}
+	if reply.IsAction("err") {
+		return nil, errors.New(reply.Text)
+	}
+
+	var r factResult
+	if err := reply.DecodePayload(&r); err != nil {
+		return nil, err
+	}
+	labels := make(map[string]string)
+	for _, f := range r.Facts {
+		if f.Predicate == "rdfs:label" {
+			labels[f.Subject] = f.Object
+		}
+	}
+	facts := make([]Fact, 0, len(r.Facts))
+	for _, f := range r.Facts {
+		if f.Predicate == "rdfs:label" && predicate != "rdfs:label" {
+			continue
+		}
+		if l, ok := labels[f.Object]; ok {
+			f.Object = l
+		}
+		facts = append(facts, f)
+	}
+	return facts, nil
+}
+
+// askLocal tries to answer the query from facts in the local store.
+func (s *Service) askLocal(query string) (*MessageAnswer, error) {
+	type lookup struct{ subject, predicate string }
+	lookups := make([]lookup, 0, 3)
+	if subj, pred, ok := ParseQuestion(query); ok {
+		lookups = append(lookups, lookup{subj, pred})
+		if t := strings.Title(subj); t != subj {
+			lookups = append(lookups, lookup{t, pred})
+		}
+	}
+	lookups = append(lookups, lookup{strings.ToLower(normalizeQuestion(query)), AnswerPredicate})
+
+	for _, l := range lookups {
+		facts, err := s.queryFacts(l.subject, l.predicate)
+		if err != nil {
+			return nil, err
+		}
+		if len(facts) > 0 {
+			return answerFromFacts(query, facts), nil
+		}
+	}
+	return nil, nil
+}
+
+func answerFromFacts(query string, facts []Fact) *MessageAnswer {
+	ans := &MessageAnswer{
+		Query:      query,
+		Provenance: ProvenanceLocal,
+		Confidence: 1,
+		Facts:      facts,
+	}
+	objects := make([]string, 0, len(facts))
+	for _, f := range facts {
+		objects = append(objects, f.Object)
+		c := f.Confidence
+		if c == 0 {
+			c = 1
+		}
+		if c < ans.Confidence {
+			ans.Confidence = c
+		}
+		if ans.Source == "" {
+			ans.Source = f.Source
+		}
+	}
+	ans.Answer = strings.Join(objects, ", ")
+	if ans.Source == "" {
+		ans.Source = "reasoner"
+	}
+	return ans
+}
+
+// cacheAnswer stores an external answer in the local fact store so it can
+// be answered without external providers next time.
+func (s *Service) cacheAnswer(query string, ans MessageAnswer) {
+	f := Fact{
+		Subject:    strings.ToLower(normalizeQuestion(query)),
+		Predicate:  AnswerPredicate,
+		Object:     ans.Answer,
+		Source:     ans.Source,
+		Confidence: ans.Confidence,
+	}
+	if subj, pred, ok := ParseQuestion(query); ok && !strings.Contains(ans.Answer, "\n") {
+		f.Subject, f.Predicate = subj, pred
+	}
+
+	reply, ok := <-s.Request(sarif.CreateMessage("concepts/store", f))
+	if !ok {
+		s.Log("err", "[know] caching answer: no reply from reasoner")
+	} else if reply.IsAction("err") {
+		s.Log("err", "[know] caching answer: "+reply.Text)
+	}
+}
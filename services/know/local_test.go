@@ -0,0 +1,44 @@
+// Copyright (C) 2017 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package know
+
+import "testing"
+
+func TestParseQuestion(t *testing.T) {
+	tests := []struct {
+		q          string
+		subj, pred string
+		ok         bool
+	}{
+		{"What is the capital of France?", "France", "capital", true},
+		{"who is the author of the hobbit", "hobbit", "author", true},
+		{"what is France's capital", "France", "capital", true},
+		{"population of Berlin", "Berlin", "population", true},
+		{"Berlin's population.", "Berlin", "population", true},
+		{"what is love", "", "", false},
+	}
+
+	for _, test := range tests {
+		subj, pred, ok := ParseQuestion(test.q)
+		if ok != test.ok || subj != test.subj || pred != test.pred {
+			t.Errorf("%q: expected (%q, %q, %v), got (%q, %q, %v)",
+				test.q, test.subj, test.pred, test.ok, subj, pred, ok)
+		}
+	}
+}
+
+func TestAnswerFromFacts(t *testing.T) {
+	ans := answerFromFacts("q", []Fact{
+		{Subject: "a", Predicate: "b", Object: "x"},
+		{Subject: "a", Predicate: "b", Object: "y", Source: "wolfram", Confidence: 0.8},
+	})
+	if ans.Answer != "x, y" {
+		t.Errorf("unexpected answer %q", ans.Answer)
+	}
+	if ans.Confidence != 0.8 || ans.Source != "wolfram" || ans.Provenance != ProvenanceLocal {
+		t.Errorf("unexpected provenance: %+v", ans)
+	}
+}
@@ -3,7 +3,8 @@
 // Use of this source code is governed by an MIT-style
 // license that can be found in the LICENSE file.
 
-// Service know answers questions by asking multiple knowledge providers.
+// Service know answers questions from the local fact store or by asking
+// multiple external knowledge providers.
 package know
 
 import (
@@ -22,6 +23,7 @@ var Module = &services.Module{
 
 type Config struct {
 	WolframApiKey string
+	CacheAnswers  bool
 }
 
 type Dependencies struct {
@@ -38,6 +40,7 @@ func NewService(deps *Dependencies) *Service {
 	s := &Service{
 		Client: deps.Client,
 	}
+	s.cfg.CacheAnswers = true
 	deps.Config.Get(&s.cfg)
 	if s.cfg.WolframApiKey != "" {
 		know.Wolfram.SetApiKey(s.cfg.WolframApiKey)
@@ -54,10 +57,22 @@ func (s *Service) Enable() error {
 
 func (s *Service) Disable() error { return nil }
 
+const (
+	ProvenanceLocal    = "local"
+	ProvenanceExternal = "external"
+
+	// externalConfidence is assigned to answers from external providers,
+	// which do not report a confidence on their own.
+	externalConfidence = 0.8
+)
+
 type MessageAnswer struct {
-	Query  string `json:"query"`
-	Answer string `json:"answer"`
-	Source string
+	Query      string  `json:"query"`
+	Answer     string  `json:"answer"`
+	Source     string  `json:"source,omitempty"`
+	Provenance string  `json:"provenance,omitempty"`
+	Confidence float64 `json:"confidence,omitempty"`
+	Facts      []Fact  `json:"facts,omitempty"`
 }
 
 func (m MessageAnswer) String() string {
@@ -75,6 +90,16 @@ func (m MessageAnswer) String() string {
 func (s *Service) handleQuery(msg sarif.Message) {
 	query := msg.Text
 
+	// Try our own facts first
+	local, err := s.askLocal(query)
+	if err != nil {
+		s.Log("err", "[know] local query: "+err.Error())
+	}
+	if local != nil {
+		s.Reply(msg, sarif.CreateMessage("knowledge/answer", local))
+		return
+	}
+
 	// Query and wait for first answer
 	answers, errs := know.Ask(query)
 	ans, ok := <-answers
@@ -98,10 +123,15 @@ func (s *Service) handleQuery(msg sarif.Message) {
 
 	// Send answer.
 	pl := MessageAnswer{
-		ans.Question,
-		ans.Answer,
-		ans.Provider,
+		Query:      ans.Question,
+		Answer:     ans.Answer,
+		Source:     ans.Provider,
+		Provenance: ProvenanceExternal,
+		Confidence: externalConfidence,
 	}
 	s.Reply(msg, sarif.CreateMessage("knowledge/answer", pl))
-	return
+
+	if s.cfg.CacheAnswers {
+		go s.cacheAnswer(query, pl)
+	}
 }
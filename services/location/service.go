@@ -49,6 +49,18 @@ func (s *Service) Enable() error {
 	s.Subscribe("location/list", "", s.handleLocationList)
 	s.Subscribe("location/fence/create", "", s.handleGeofenceCreate)
 	s.Subscribe("location/import", "", s.handleLocationImport)
+
+	s.RegisterSchema(sarif.ActionSchema{
+		Action:      "location/update",
+		Description: "Store a new location of the user.",
+		Request:     sarif.SchemaOf(Location{}),
+	})
+	s.RegisterSchema(sarif.ActionSchema{
+		Action:      "location/last",
+		Description: "Find the last location matching a filter.",
+		Request:     &sarif.Schema{Type: "object"},
+		Response:    sarif.SchemaOf(Location{}),
+	})
 	return nil
 }
 
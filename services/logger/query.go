@@ -0,0 +1,250 @@
+// Copyright (C) 2019 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package logger
+
+import (
+	"container/heap"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sarifsystems/sarif/sarif"
+)
+
+// tailExpiry is how long a log/tail subscription stays active without
+// being renewed.
+const tailExpiry = 10 * time.Minute
+
+type Filter struct {
+	After  time.Time `json:"after,omitempty"`
+	Before time.Time `json:"before,omitempty"`
+	Source string    `json:"source,omitempty"`
+	Action string    `json:"action,omitempty"`
+	Text   string    `json:"text,omitempty"`
+	Limit  int       `json:"limit,omitempty"`
+}
+
+func (f Filter) Matches(lm *LogMessage) bool {
+	if !f.After.IsZero() && lm.Time.Before(f.After) {
+		return false
+	}
+	if !f.Before.IsZero() && !lm.Time.Before(f.Before) {
+		return false
+	}
+	if f.Source != "" && lm.Source != f.Source {
+		return false
+	}
+	if f.Action != "" && !lm.IsAction(f.Action) {
+		return false
+	}
+	if f.Text != "" {
+		text := strings.ToLower(f.Text)
+		if !strings.Contains(strings.ToLower(lm.Text), text) &&
+			!strings.Contains(strings.ToLower(string(lm.Payload.Raw)), text) {
+			return false
+		}
+	}
+	return true
+}
+
+// recentEntries keeps the most recent entries up to a limit in a min-heap,
+// so that a query only needs memory for its result.
+type recentEntries struct {
+	limit   int
+	entries []*LogMessage
+	ids     map[string]bool
+}
+
+func (r *recentEntries) Len() int           { return len(r.entries) }
+func (r *recentEntries) Less(i, j int) bool { return r.entries[i].Time.Before(r.entries[j].Time) }
+func (r *recentEntries) Swap(i, j int)      { r.entries[i], r.entries[j] = r.entries[j], r.entries[i] }
+func (r *recentEntries) Push(x interface{}) { r.entries = append(r.entries, x.(*LogMessage)) }
+func (r *recentEntries) Pop() interface{} {
+	lm := r.entries[len(r.entries)-1]
+	r.entries = r.entries[0 : len(r.entries)-1]
+	return lm
+}
+
+func (r *recentEntries) Full() bool {
+	return len(r.entries) >= r.limit
+}
+
+// Oldest returns the time of the oldest kept entry.
+func (r *recentEntries) Oldest() time.Time {
+	return r.entries[0].Time
+}
+
+// Add keeps an entry if it is newer than the oldest one, which is dropped
+// if the limit is reached. Entries that are already kept are ignored.
+func (r *recentEntries) Add(lm *LogMessage) {
+	if lm.Id != "" && r.ids[lm.Id] {
+		return
+	}
+	if !r.Full() {
+		heap.Push(r, lm)
+	} else if lm.Time.After(r.Oldest()) {
+		delete(r.ids, r.entries[0].Id)
+		r.entries[0] = lm
+		heap.Fix(r, 0)
+	} else {
+		return
+	}
+	if lm.Id != "" {
+		r.ids[lm.Id] = true
+	}
+}
+
+// Sorted returns the kept entries in chronological order.
+func (r *recentEntries) Sorted() []*LogMessage {
+	sort.SliceStable(r.entries, r.Less)
+	return r.entries
+}
+
+// Query searches all log files for matching entries and returns the most
+// recent ones up to the limit in chronological order. Segments are read
+// newest first, older ones are skipped as soon as the limit is reached.
+func (s *Service) Query(f Filter) ([]*LogMessage, error) {
+	if f.Limit <= 0 {
+		f.Limit = 100
+	}
+
+	found := &recentEntries{
+		limit:   f.Limit,
+		entries: make([]*LogMessage, 0),
+		ids:     make(map[string]bool),
+	}
+	for _, path := range s.fileTargets() {
+		segs, err := listSegments(path)
+		if err != nil {
+			return nil, err
+		}
+		for i := len(segs) - 1; i >= 0; i-- {
+			seg := segs[i]
+			if !f.After.IsZero() && !seg.End.IsZero() && seg.End.Before(f.After) {
+				continue
+			}
+			if !f.Before.IsZero() && seg.Start.After(f.Before) {
+				continue
+			}
+			if found.Full() && !seg.End.IsZero() && !seg.End.After(found.Oldest()) {
+				break
+			}
+			err := readSegment(seg.Path, func(lm *LogMessage) error {
+				if f.Matches(lm) {
+					found.Add(lm)
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return found.Sorted(), nil
+}
+
+type queryResult struct {
+	Entries []*LogMessage `json:"entries"`
+}
+
+func formatEntry(lm *LogMessage) string {
+	text := lm.Text
+	if text == "" {
+		text = lm.Action
+	}
+	return lm.Time.Local().Format("2006-01-02 15:04:05") + " " + lm.Source + ": " + text
+}
+
+func (r queryResult) String() string {
+	if len(r.Entries) == 0 {
+		return "No log entries found."
+	}
+	lines := make([]string, len(r.Entries))
+	for i, lm := range r.Entries {
+		lines[i] = formatEntry(lm)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (s *Service) handleQuery(msg sarif.Message) {
+	var f Filter
+	if err := msg.DecodePayload(&f); err != nil {
+		s.ReplyBadRequest(msg, err)
+		return
+	}
+	if f.Text == "" {
+		f.Text = msg.Text
+	}
+
+	entries, err := s.Query(f)
+	if err != nil {
+		s.ReplyInternalError(msg, err)
+		return
+	}
+	s.Reply(msg, sarif.CreateMessage("log/found", queryResult{entries}))
+}
+
+type tail struct {
+	Filter
+	Request sarif.Message
+	Expires time.Time
+}
+
+type tailStatus struct {
+	Filter
+	Expires time.Time `json:"expires"`
+}
+
+func (t tailStatus) String() string {
+	return "Tailing log until " + t.Expires.Local().Format("15:04:05") + "."
+}
+
+func (s *Service) handleTail(msg sarif.Message) {
+	if msg.Source == "" {
+		s.ReplyBadRequest(msg, errors.New("Tailing requires a source to send entries to."))
+		return
+	}
+	if msg.IsAction("log/tail/stop") {
+		s.mutex.Lock()
+		delete(s.tails, msg.Source)
+		s.mutex.Unlock()
+		s.Reply(msg, sarif.CreateMessage("log/tail/stopped", nil))
+		return
+	}
+
+	var f Filter
+	if err := msg.DecodePayload(&f); err != nil {
+		s.ReplyBadRequest(msg, err)
+		return
+	}
+	t := &tail{
+		Filter:  f,
+		Request: msg,
+		Expires: time.Now().Add(tailExpiry),
+	}
+	s.mutex.Lock()
+	s.tails[msg.Source] = t
+	s.mutex.Unlock()
+	s.Reply(msg, sarif.CreateMessage("log/tailing", tailStatus{f, t.Expires}))
+}
+
+// forwardTails sends a new entry to all matching tail subscribers.
+// Must be called with the mutex held.
+func (s *Service) forwardTails(lm *LogMessage) {
+	for src, t := range s.tails {
+		if lm.Time.After(t.Expires) {
+			delete(s.tails, src)
+			continue
+		}
+		if !t.Matches(lm) {
+			continue
+		}
+		entry := sarif.CreateMessage("log/entry", lm)
+		entry.Text = formatEntry(lm)
+		go s.Reply(t.Request, entry)
+	}
+}
@@ -0,0 +1,269 @@
+// Copyright (C) 2019 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package logger
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// segmentTime is the suffix format of rotated segments, marking the time
+// the segment was closed.
+const segmentTime = "20060102T150405.000"
+
+// segmentWriter appends log lines to a file and rotates it into
+// timestamped, gzipped segments once it grows too large or old.
+type segmentWriter struct {
+	path string
+	cfg  *Config
+	log  func(v ...interface{})
+
+	f      *os.File
+	size   int64
+	opened time.Time
+}
+
+// firstEntryTime reads the time of the first entry in a log file.
+func firstEntryTime(path string) (time.Time, bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return time.Time{}, false
+	}
+	defer f.Close()
+	line, err := bufio.NewReader(f).ReadBytes('\n')
+	if err != nil && len(line) == 0 {
+		return time.Time{}, false
+	}
+	var lm LogMessage
+	if err := json.Unmarshal(line, &lm); err != nil || lm.Time.IsZero() {
+		return time.Time{}, false
+	}
+	return lm.Time, true
+}
+
+func (w *segmentWriter) open(now time.Time) error {
+	f, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.f, w.size, w.opened = f, info.Size(), now
+	if t, ok := firstEntryTime(w.path); ok {
+		w.opened = t
+	}
+	return nil
+}
+
+func (w *segmentWriter) needsRotation(now time.Time, n int) bool {
+	if w.size == 0 {
+		return false
+	}
+	if w.cfg.RotateSize > 0 && w.size+int64(n) > w.cfg.RotateSize {
+		return true
+	}
+	if d := w.cfg.rotateInterval(); d > 0 && now.Sub(w.opened) >= d {
+		return true
+	}
+	return false
+}
+
+func (w *segmentWriter) Write(line []byte, now time.Time) error {
+	if w.f == nil {
+		if err := w.open(now); err != nil {
+			return err
+		}
+	}
+	if w.needsRotation(now, len(line)) {
+		if err := w.rotate(now); err != nil {
+			return err
+		}
+	}
+
+	n, err := w.f.Write(line)
+	w.size += int64(n)
+	return err
+}
+
+func (w *segmentWriter) rotate(now time.Time) error {
+	if err := w.f.Close(); err != nil {
+		return err
+	}
+	w.f = nil
+	segment := w.path + "." + now.UTC().Format(segmentTime)
+	if err := os.Rename(w.path, segment); err != nil {
+		return err
+	}
+	go func() {
+		if w.cfg.Compress {
+			if err := compressSegment(segment); err != nil {
+				w.log("[log] compress error:", err)
+			}
+		}
+		if err := pruneSegments(w.path, w.cfg.MaxSegments); err != nil {
+			w.log("[log] prune error:", err)
+		}
+	}()
+	return w.open(now)
+}
+
+func (w *segmentWriter) Close() error {
+	if w.f == nil {
+		return nil
+	}
+	err := w.f.Close()
+	w.f = nil
+	return err
+}
+
+// compressSegment gzips a rotated segment and removes the original.
+func compressSegment(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp := path + ".gz.tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	if _, err := io.Copy(zw, in); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path+".gz"); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+type segment struct {
+	Path  string
+	Start time.Time
+	End   time.Time
+}
+
+// listSegments returns all rotated segments of a log file in chronological
+// order, followed by the current file itself.
+func listSegments(path string) ([]segment, error) {
+	matches, err := filepath.Glob(path + ".*")
+	if err != nil {
+		return nil, err
+	}
+	byEnd := make(map[time.Time]string)
+	for _, m := range matches {
+		suffix := strings.TrimPrefix(m, path+".")
+		if strings.HasSuffix(suffix, ".tmp") {
+			continue
+		}
+		gz := strings.HasSuffix(suffix, ".gz")
+		end, err := time.Parse(segmentTime, strings.TrimSuffix(suffix, ".gz"))
+		if err != nil {
+			continue
+		}
+		// While compressing, both versions exist and the original is complete.
+		if _, ok := byEnd[end]; ok && gz {
+			continue
+		}
+		byEnd[end] = m
+	}
+
+	segs := make([]segment, 0, len(byEnd)+1)
+	for end, p := range byEnd {
+		segs = append(segs, segment{Path: p, End: end})
+	}
+	sort.Slice(segs, func(i, j int) bool { return segs[i].End.Before(segs[j].End) })
+	for i := 1; i < len(segs); i++ {
+		segs[i].Start = segs[i-1].End
+	}
+	cur := segment{Path: path}
+	if len(segs) > 0 {
+		cur.Start = segs[len(segs)-1].End
+	}
+	if _, err := os.Stat(path); err == nil {
+		segs = append(segs, cur)
+	}
+	return segs, nil
+}
+
+// pruneSegments removes the oldest segments beyond the given number.
+func pruneSegments(path string, max int) error {
+	if max <= 0 {
+		return nil
+	}
+	segs, err := listSegments(path)
+	if err != nil {
+		return err
+	}
+	rotated := make([]segment, 0)
+	for _, seg := range segs {
+		if seg.Path != path {
+			rotated = append(rotated, seg)
+		}
+	}
+	for i := 0; i < len(rotated)-max; i++ {
+		if err := os.Remove(rotated[i].Path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// readSegment calls f for each entry of a plain or gzipped segment.
+func readSegment(path string, f func(lm *LogMessage) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if strings.HasSuffix(path, ".gz") {
+		zr, err := gzip.NewReader(file)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		var lm LogMessage
+		if err := json.Unmarshal(sc.Bytes(), &lm); err != nil {
+			// Skip partially written or foreign lines.
+			continue
+		}
+		if err := f(&lm); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
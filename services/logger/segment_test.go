@@ -0,0 +1,114 @@
+// Copyright (C) 2019 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package logger
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/sarifsystems/sarif/sarif"
+)
+
+func TestRotateAndQuery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sarif-logger-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "default.log")
+
+	s := &Service{
+		Cfg: Config{
+			Actions:    map[string]string{"log": path},
+			RotateSize: 300,
+		},
+		writers: make(map[string]*segmentWriter),
+		tails:   make(map[string]*tail),
+	}
+	w := &segmentWriter{path: path, cfg: &s.Cfg, log: t.Log}
+
+	start := time.Date(2019, 5, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 10; i++ {
+		msg := sarif.CreateMessage("log/info", nil)
+		msg.Source = "dev" + []string{"a", "b"}[i%2]
+		msg.Text = "entry " + strconv.Itoa(i)
+		raw, _ := json.Marshal(LogMessage{start.Add(time.Duration(i) * time.Minute), msg})
+		if err := w.Write(append(raw, '\n'), start.Add(time.Duration(i)*time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Close()
+
+	segs, err := listSegments(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segs) < 3 {
+		t.Fatalf("expected log to be rotated, got %d segments", len(segs))
+	}
+	if err := compressSegment(segs[0].Path); err != nil {
+		t.Fatal(err)
+	}
+
+	all, err := s.Query(Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 10 {
+		t.Fatalf("expected 10 entries, got %d", len(all))
+	}
+	for i, lm := range all {
+		if exp := "entry " + strconv.Itoa(i); lm.Text != exp {
+			t.Errorf("expected %q, got %q", exp, lm.Text)
+		}
+	}
+
+	found, err := s.Query(Filter{
+		After:  start.Add(2 * time.Minute),
+		Before: start.Add(8 * time.Minute),
+		Source: "deva",
+		Limit:  2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 2 || found[0].Text != "entry 4" || found[1].Text != "entry 6" {
+		t.Errorf("unexpected query result: %v", found)
+	}
+
+	recent, err := s.Query(Filter{Limit: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recent) != 3 || recent[0].Text != "entry 7" || recent[2].Text != "entry 9" {
+		t.Errorf("unexpected query result: %v", recent)
+	}
+}
+
+func TestRecentEntries(t *testing.T) {
+	start := time.Date(2019, 5, 1, 12, 0, 0, 0, time.UTC)
+	r := &recentEntries{limit: 3, ids: make(map[string]bool)}
+	for _, i := range []int{4, 1, 8, 2, 9, 8, 6, 0, 7} {
+		msg := sarif.Message{Id: "id" + strconv.Itoa(i), Text: "entry " + strconv.Itoa(i)}
+		r.Add(&LogMessage{start.Add(time.Duration(i) * time.Minute), msg})
+		if len(r.entries) > 3 {
+			t.Fatal("limit exceeded:", len(r.entries))
+		}
+	}
+
+	found := r.Sorted()
+	if len(found) != 3 || found[0].Text != "entry 7" || found[1].Text != "entry 8" || found[2].Text != "entry 9" {
+		t.Errorf("unexpected entries: %v", found)
+	}
+	if len(r.ids) != 3 {
+		t.Errorf("unexpected ids: %v", r.ids)
+	}
+}
@@ -27,6 +27,20 @@ var Module = &services.Module{
 
 type Config struct {
 	Actions map[string]string
+
+	// RotateSize is the size in bytes after which a log file is rotated.
+	RotateSize int64
+	// RotateInterval is the maximum age of a log file before rotation.
+	RotateInterval string
+	// MaxSegments is the number of rotated segments to keep, 0 keeps all.
+	MaxSegments int
+	// Compress gzips rotated segments.
+	Compress bool
+}
+
+func (cfg *Config) rotateInterval() time.Duration {
+	d, _ := time.ParseDuration(cfg.RotateInterval)
+	return d
 }
 
 type Dependencies struct {
@@ -39,13 +53,22 @@ type Service struct {
 	Cfg    Config
 	Config services.Config
 
-	mutex sync.Mutex
+	mutex   sync.Mutex
+	writers map[string]*segmentWriter
+	tails   map[string]*tail
 }
 
 func NewService(deps *Dependencies) *Service {
 	s := &Service{
 		Client: deps.Client,
 		Config: deps.Config,
+		Cfg: Config{
+			RotateSize:     10 * 1024 * 1024,
+			RotateInterval: "168h",
+			Compress:       true,
+		},
+		writers: make(map[string]*segmentWriter),
+		tails:   make(map[string]*tail),
 	}
 	return s
 }
@@ -66,6 +89,11 @@ func (s *Service) Enable() error {
 		}
 	}
 	s.Config.Get(&s.Cfg)
+	if _, err := time.ParseDuration(s.Cfg.RotateInterval); s.Cfg.RotateInterval != "" && err != nil {
+		return err
+	}
+	s.Subscribe("log/query", "", s.handleQuery)
+	s.Subscribe("log/tail", "", s.handleTail)
 	for action, target := range s.Cfg.Actions {
 		s.Subscribe(action, "", s.handleLog)
 		if target != "" && target != "-" {
@@ -77,12 +105,37 @@ func (s *Service) Enable() error {
 	return nil
 }
 
+func (s *Service) Disable() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, w := range s.writers {
+		w.Close()
+	}
+	return nil
+}
+
+// fileTargets returns all distinct log files.
+func (s *Service) fileTargets() []string {
+	files := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, target := range s.Cfg.Actions {
+		if target != "" && target != "-" && !seen[target] {
+			seen[target] = true
+			files = append(files, target)
+		}
+	}
+	return files
+}
+
 type LogMessage struct {
 	Time time.Time `json:"time"`
 	sarif.Message
 }
 
 func (s *Service) handleLog(msg sarif.Message) {
+	if msg.IsAction("log/query") || msg.IsAction("log/tail") {
+		return
+	}
 	targets := make(map[string]struct{})
 	for action, target := range s.Cfg.Actions {
 		if msg.IsAction(action) {
@@ -95,29 +148,28 @@ func (s *Service) handleLog(msg sarif.Message) {
 
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
+	raw, _ := json.Marshal(lm)
 	for target := range targets {
 		if target == "" {
 			continue
 		}
-		raw, _ := json.Marshal(lm)
-		if err := s.writeTarget(target, string(raw)); err != nil {
+		if err := s.writeTarget(target, raw, lm.Time); err != nil {
 			log.Println("[log] write error:", err)
 		}
 	}
+	s.forwardTails(&lm)
 }
 
-func (s *Service) writeTarget(target, out string) error {
+func (s *Service) writeTarget(target string, out []byte, now time.Time) error {
 	if target == "-" {
-		fmt.Println(out)
+		fmt.Println(string(out))
 		return nil
 	}
 
-	f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
-	if err != nil {
-		return err
-	}
-	if _, err := fmt.Fprintln(f, out); err != nil {
-		return err
+	w, ok := s.writers[target]
+	if !ok {
+		w = &segmentWriter{path: target, cfg: &s.Cfg, log: log.Println}
+		s.writers[target] = w
 	}
-	return f.Close()
+	return w.Write(append(out, '\n'), now)
 }
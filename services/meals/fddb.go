@@ -6,6 +6,7 @@
 package meals
 
 import (
+	"strconv"
 	"time"
 
 	"github.com/sarifsystems/sarif/pkg/fddb"
@@ -41,6 +42,7 @@ func (s *Service) FetchFddb(day time.Time) error {
 		}
 
 		p := &Product{
+			Id:    "fddb-" + strconv.FormatInt(int64(el.Item.Id), 10),
 			RefId: int64(el.Item.Id),
 			Name:  el.Item.Description.FullName(),
 
@@ -57,23 +59,20 @@ func (s *Service) FetchFddb(day time.Time) error {
 				Protein:       Weight(data.ProteinGram) * Gram,
 			},
 		}
-
-		db := s.DB.Where(Product{RefId: p.RefId}).Assign(p)
-		if err := db.FirstOrCreate(&p).Error; err != nil {
+		if err := s.PutProduct(p); err != nil {
 			return err
 		}
 
 		srv := &Serving{
+			Id:           "fddb-" + strconv.FormatInt(int64(el.Uid), 10),
 			RefId:        int64(el.Uid),
 			Name:         el.Item.Description.FullName(),
 			AmountWeight: Weight(data.ServingAmount) * Gram,
 			Time:         time.Unix(el.Date, 0),
 
-			ProductId: p.Id,
-			Product:   p,
+			Product: p,
 		}
-		db = s.DB.Where(Serving{RefId: srv.RefId}).Assign(srv)
-		if err := db.FirstOrCreate(&srv).Error; err != nil {
+		if err := s.PutServing(srv); err != nil {
 			return err
 		}
 	}
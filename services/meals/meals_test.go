@@ -0,0 +1,236 @@
+// Copyright (C) 2019 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package meals
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/sarifsystems/sarif/sarif"
+	storeservice "github.com/sarifsystems/sarif/services/store"
+	"github.com/sarifsystems/sarif/transports/sfproto"
+
+	_ "github.com/sarifsystems/sarif/services/store/bolt"
+)
+
+type testConfig struct {
+	data []byte
+	dir  string
+}
+
+func (c *testConfig) Exists() bool { return c.data != nil }
+func (c *testConfig) Set(v interface{}) error {
+	var err error
+	c.data, err = json.Marshal(v)
+	return err
+}
+func (c *testConfig) Get(v interface{}) (error, bool) {
+	if c.data == nil {
+		return nil, false
+	}
+	return json.Unmarshal(c.data, v), true
+}
+func (c *testConfig) Dir() string { return c.dir }
+
+// newTestService starts a meals service with a store on a local broker and
+// returns it together with a client for requests.
+func newTestService(t *testing.T) (*Service, sarif.Client, func()) {
+	dir, err := ioutil.TempDir("", "sarif-meals-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	broker := sfproto.NewBroker()
+	storeClient, err := broker.NewClient(sarif.ClientInfo{Name: "store"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := storeservice.NewService(&storeservice.Dependencies{
+		Config: &testConfig{dir: dir},
+		Client: storeClient,
+	})
+	if err := st.Enable(); err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := broker.NewClient(sarif.ClientInfo{Name: "meals"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewService(&Dependencies{Config: &testConfig{dir: dir}, Client: client})
+	if err := s.Enable(); err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := broker.NewClient(sarif.ClientInfo{Name: "user"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, user, func() { os.RemoveAll(dir) }
+}
+
+func request(t *testing.T, c sarif.Client, action string, p interface{}) sarif.Message {
+	select {
+	case reply := <-c.Request(sarif.CreateMessage(action, p)):
+		return reply
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reply to", action)
+	}
+	return sarif.Message{}
+}
+
+func testProduct() *Product {
+	return &Product{
+		Name:          "Oat Flakes",
+		ServingWeight: 40 * Gram,
+		Stats: Stats{
+			Weight:  100 * Gram,
+			Energy:  400 * Kcal,
+			Protein: 13 * Gram,
+		},
+	}
+}
+
+func TestPutProductKeepsCreatedAt(t *testing.T) {
+	s, _, cleanup := newTestService(t)
+	defer cleanup()
+
+	p := testProduct()
+	p.Id = "fddb-1"
+	if err := s.PutProduct(p); err != nil {
+		t.Fatal(err)
+	}
+	created := p.CreatedAt
+	if created.IsZero() {
+		t.Fatal("creation time was not set")
+	}
+
+	// A sync creates the product again without creation time
+	again := testProduct()
+	again.Id = "fddb-1"
+	if err := s.PutProduct(again); err != nil {
+		t.Fatal(err)
+	}
+	var stored Product
+	if err := s.Store.Get(again.Key(), &stored); err != nil {
+		t.Fatal(err)
+	}
+	if !stored.CreatedAt.Equal(created) {
+		t.Errorf("creation time changed from %v to %v", created, stored.CreatedAt)
+	}
+	if stored.UpdatedAt.Before(created) {
+		t.Error("update time was not set:", stored.UpdatedAt)
+	}
+}
+
+func TestPutServing(t *testing.T) {
+	s, _, cleanup := newTestService(t)
+	defer cleanup()
+
+	p := testProduct()
+	if err := s.PutProduct(p); err != nil {
+		t.Fatal(err)
+	}
+
+	day := time.Date(2019, 5, 10, 12, 0, 0, 0, time.Local)
+	for i, d := range []time.Duration{26 * time.Hour, 0, 2 * time.Hour} {
+		sv := &Serving{
+			Name:            p.Name,
+			AmountWeight:    Weight(i+1) * 50 * Gram,
+			Time:            day.Add(d),
+			Product:         p,
+			CalculatedStats: &Stats{},
+		}
+		if err := s.PutServing(sv); err != nil {
+			t.Fatal(err)
+		}
+		if sv.Id == "" || sv.ProductId != p.Id {
+			t.Errorf("unexpected serving: %+v", sv)
+		}
+	}
+
+	servings, err := s.scanServings(ServingFilter{
+		After:  day.Add(-time.Hour),
+		Before: day.Add(24 * time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(servings) != 2 {
+		t.Fatalf("expected 2 servings, got %d", len(servings))
+	}
+	if !servings[0].Time.Equal(day) || !servings[1].Time.Equal(day.Add(2*time.Hour)) {
+		t.Errorf("unexpected order: %v, %v", servings[0].Time, servings[1].Time)
+	}
+	if servings[0].AmountWeight != 100*Gram || servings[0].Product == nil || servings[0].Product.Name != p.Name {
+		t.Errorf("unexpected serving: %+v", servings[0])
+	}
+	if servings[0].CalculatedStats != nil {
+		t.Error("calculated stats were persisted")
+	}
+
+	all, err := s.scanServings(ServingFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 3 {
+		t.Errorf("expected 3 servings, got %d", len(all))
+	}
+}
+
+func TestStats(t *testing.T) {
+	s, user, cleanup := newTestService(t)
+	defer cleanup()
+
+	p := testProduct()
+	if err := s.PutProduct(p); err != nil {
+		t.Fatal(err)
+	}
+	day := time.Date(2019, 5, 10, 12, 0, 0, 0, time.Local)
+	for _, tm := range []time.Time{day, day.Add(time.Hour), day.AddDate(0, 0, 1)} {
+		if err := s.PutServing(&Serving{AmountWeight: 50 * Gram, Time: tm, Product: p}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reply := request(t, user, "meal/stats", ServingFilter{
+		After:    dayStart(day),
+		Before:   dayStart(day).AddDate(0, 0, 2),
+		Interval: "day",
+	})
+	var stats ServingStats
+	if err := reply.DecodePayload(&stats); err != nil || reply.Action != "meal/stats" {
+		t.Fatal("unexpected reply", reply, err)
+	}
+	if len(stats.Servings) != 3 || stats.Energy != 600*Kcal || stats.Protein != 19.5*Gram {
+		t.Errorf("unexpected stats: %d servings, %v", len(stats.Servings), stats.Stats)
+	}
+	if len(stats.Periods) != 2 {
+		t.Fatalf("unexpected periods: %+v", stats.Periods)
+	}
+	first, second := stats.Periods[0], stats.Periods[1]
+	if !first.Start.Equal(dayStart(day)) || first.Servings != 2 || first.Energy != 400*Kcal {
+		t.Errorf("unexpected first period: %+v", first)
+	}
+	if !second.Start.Equal(dayStart(day).AddDate(0, 0, 1)) || second.Servings != 1 || second.Energy != 200*Kcal {
+		t.Errorf("unexpected second period: %+v", second)
+	}
+
+	// Week totals start on monday
+	reply = request(t, user, "meal/stats", ServingFilter{
+		After:    dayStart(day).AddDate(0, 0, -7),
+		Before:   dayStart(day).AddDate(0, 0, 7),
+		Interval: "week",
+	})
+	stats = ServingStats{}
+	reply.DecodePayload(&stats)
+	if len(stats.Periods) != 1 || stats.Periods[0].Start.Weekday() != time.Monday || stats.Periods[0].Servings != 3 {
+		t.Errorf("unexpected week periods: %+v", stats.Periods)
+	}
+}
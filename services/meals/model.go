@@ -5,7 +5,10 @@
 
 package meals
 
-import "time"
+import (
+	"fmt"
+	"time"
+)
 
 type Stats struct {
 	Weight Weight `json:"weight,omitempty"`
@@ -65,7 +68,7 @@ func (s *Stats) Add(o Stats) {
 }
 
 type Product struct {
-	Id    int64  `json:"-"`
+	Id    string `json:"id,omitempty"`
 	RefId int64  `json:"ref_id,omitempty"`
 	Name  string `json:"name,omitempty"`
 	Code  string `json:"code,omitempty"`
@@ -74,12 +77,12 @@ type Product struct {
 	ServingVolume Volume `json:"serving_volume,omitempty"`
 	Stats
 
-	CreatedAt time.Time `json:"-"`
-	UpdatedAt time.Time `json:"-"`
+	CreatedAt time.Time `json:"created_at,omitempty"`
+	UpdatedAt time.Time `json:"updated_at,omitempty"`
 }
 
-func (p Product) TableName() string {
-	return "meals_products"
+func (p Product) Key() string {
+	return "meal_products/" + p.Id
 }
 
 func (p Product) Servings(n float64) Stats {
@@ -94,22 +97,30 @@ func (p Product) Servings(n float64) Stats {
 }
 
 type Serving struct {
-	Id           int64     `json:"-"`
+	Id           string    `json:"id,omitempty"`
 	RefId        int64     `json:"ref_id,omitempty"`
 	Name         string    `json:"name"`
 	AmountWeight Weight    `json:"amount_weight"`
 	AmountVolume Volume    `json:"amount_volume"`
-	Time         time.Time `json:"time" sql:"index"`
+	Time         time.Time `json:"time"`
+	Code         string    `json:"code,omitempty"`
 
-	ProductId int64    `json:"-"`
+	ProductId string   `json:"product_id,omitempty"`
 	Product   *Product `json:"product,omitempty"`
 
-	Size            float64 `json:"size,omitempty" sql:"-"`
+	Size            float64 `json:"size,omitempty"`
 	CalculatedStats *Stats  `json:"stats,omitempty"`
 }
 
-func (s Serving) TableName() string {
-	return "meals_servings"
+func (s Serving) Key() string {
+	return "meal_servings/" + s.Time.UTC().Format(time.RFC3339Nano) + "/" + s.Id
+}
+
+func (s Serving) String() string {
+	if s.CalculatedStats == nil {
+		return s.Name
+	}
+	return fmt.Sprintf("%s (%s)", s.Name, s.CalculatedStats.Energy.StringKcal())
 }
 
 func (s *Serving) Stats() Stats {
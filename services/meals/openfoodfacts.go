@@ -0,0 +1,204 @@
+// Copyright (C) 2019 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package meals
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	"github.com/sarifsystems/sarif/pkg/openfoodfacts"
+	"github.com/sarifsystems/sarif/sarif"
+)
+
+func convertOpenFoodFacts(op *openfoodfacts.Product) *Product {
+	p := &Product{
+		Id:   "off-" + op.Code,
+		Code: op.Code,
+		Name: op.FullName(),
+
+		Stats: Stats{
+			Energy:        Energy(op.EnergyKJ) * Kilojoule,
+			Fat:           Weight(op.Fat) * Gram,
+			Carbohydrates: Weight(op.Carbohydrates) * Gram,
+			Sugar:         Weight(op.Sugars) * Gram,
+			Protein:       Weight(op.Proteins) * Gram,
+			Salt:          Weight(op.Salt) * Gram,
+		},
+	}
+
+	serving := op.ServingQuantity
+	if serving <= 0 {
+		serving = 100
+	}
+	if op.IsLiquid() {
+		p.Stats.Volume = 100 * Millilitre
+		p.ServingVolume = Volume(serving) * Millilitre
+	} else {
+		p.Stats.Weight = 100 * Gram
+		p.ServingWeight = Weight(serving) * Gram
+	}
+	return p
+}
+
+// importProgressInterval is the number of products between progress
+// replies of a running import.
+const importProgressInterval = 10000
+
+// ImportOpenFoodFacts reads an Open Food Facts CSV or JSONL dump and adds
+// all products with a barcode, name and energy value to the database.
+// Malformed entries are skipped and counted. If progress is not nil, it is
+// called regularly with the intermediate result.
+func (s *Service) ImportOpenFoodFacts(path, format string, progress func(importResult)) (importResult, error) {
+	res := importResult{Path: path}
+	f, err := os.Open(path)
+	if err != nil {
+		return res, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return res, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	if format == "" {
+		format = openfoodfacts.DetectFormat(path)
+	}
+	dump, err := openfoodfacts.NewReader(r, format)
+	if err != nil {
+		return res, err
+	}
+
+	batch := make([]*Product, 0, productBatchSize)
+	flush := func() error {
+		if err := s.Products.PutProducts(batch); err != nil {
+			return err
+		}
+		for range batch {
+			res.Products++
+			if progress != nil && res.Products%importProgressInterval == 0 {
+				progress(res)
+			}
+		}
+		batch = batch[:0]
+		return nil
+	}
+
+	for {
+		op, err := dump.Next()
+		if err == io.EOF {
+			return res, flush()
+		}
+		if _, ok := err.(*openfoodfacts.RecordError); ok {
+			res.Skipped++
+			continue
+		}
+		if err != nil {
+			return res, err
+		}
+		if op.Code == "" || op.Name == "" || op.EnergyKJ <= 0 {
+			continue
+		}
+		batch = append(batch, convertOpenFoodFacts(op))
+		if len(batch) == productBatchSize {
+			if err := flush(); err != nil {
+				return res, err
+			}
+		}
+	}
+}
+
+// importPath resolves the path of a dump inside the configured import
+// directory.
+func (s *Service) importPath(name string) (string, error) {
+	if s.cfg.ImportDir == "" {
+		return "", errors.New("No import directory configured.")
+	}
+	dir, err := filepath.EvalSymlinks(s.cfg.ImportDir)
+	if err != nil {
+		return "", err
+	}
+	path := name
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	if path, err = filepath.EvalSymlinks(path); err != nil {
+		return "", err
+	}
+	if rel, err := filepath.Rel(dir, path); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", errors.New(name + " is outside of the import directory.")
+	}
+	return path, nil
+}
+
+type importPayload struct {
+	Path   string `json:"path"`
+	Format string `json:"format,omitempty"`
+}
+
+type importResult struct {
+	Path     string `json:"path"`
+	Products int    `json:"products"`
+	Skipped  int    `json:"skipped,omitempty"`
+}
+
+func (r importResult) String() string {
+	s := fmt.Sprintf("Imported %d products from %s", r.Products, r.Path)
+	if r.Skipped > 0 {
+		s += fmt.Sprintf(", skipped %d malformed entries", r.Skipped)
+	}
+	return s + "."
+}
+
+func (s *Service) handleProductImport(msg sarif.Message) {
+	var p importPayload
+	if err := msg.DecodePayload(&p); err != nil {
+		s.ReplyBadRequest(msg, err)
+		return
+	}
+	if p.Path == "" {
+		p.Path = msg.Text
+	}
+	if p.Path == "" {
+		s.ReplyBadRequest(msg, errors.New("Please specify the path to a product dump."))
+		return
+	}
+	path, err := s.importPath(p.Path)
+	if err != nil {
+		s.ReplyBadRequest(msg, err)
+		return
+	}
+	if !atomic.CompareAndSwapInt32(&s.importing, 0, 1) {
+		s.ReplyBadRequest(msg, errors.New("Another import is still running."))
+		return
+	}
+
+	s.Reply(msg, sarif.CreateMessage("meal/product/import/started", importResult{Path: p.Path}))
+	go func() {
+		defer atomic.StoreInt32(&s.importing, 0)
+		res, err := s.ImportOpenFoodFacts(path, p.Format, func(res importResult) {
+			res.Path = p.Path
+			s.Reply(msg, sarif.CreateMessage("meal/product/import/progress", res))
+		})
+		res.Path = p.Path
+		if err != nil {
+			s.ReplyInternalError(msg, fmt.Errorf("import failed after %d products: %v", res.Products, err))
+			return
+		}
+		s.Reply(msg, sarif.CreateMessage("meal/product/imported", res))
+	}()
+}
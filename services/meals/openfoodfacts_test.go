@@ -0,0 +1,79 @@
+// Copyright (C) 2019 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package meals
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sarifsystems/sarif/sarif"
+)
+
+const testDump = `{"code":"3017620422003","product_name":"Nutella","brands":"Ferrero","serving_quantity":"15","nutriments":{"energy_100g":2252}}
+{"code":"4000417025005","product_name":"Ritter Sport","serving_quantity":"","nutriments":{"energy-kcal_100g":"500"}}
+{"code":"5449000000996","product_name":
+{"code":"0000000000000","product_name":"No energy"}
+`
+
+func TestProductImport(t *testing.T) {
+	s, user, cleanup := newTestService(t)
+	defer cleanup()
+
+	reply := request(t, user, "meal/product/import", importPayload{Path: "dump.jsonl"})
+	if reply.Action != "err/badrequest" {
+		t.Error("expected import without directory to fail, got", reply)
+	}
+
+	dir, err := ioutil.TempDir("", "sarif-meals-import-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s.cfg.ImportDir = filepath.Join(dir, "imports")
+	if err := os.Mkdir(s.cfg.ImportDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "secret.jsonl"), []byte(testDump), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(s.cfg.ImportDir, "dump.jsonl"), []byte(testDump), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"../secret.jsonl", filepath.Join(dir, "secret.jsonl"), "missing.jsonl"} {
+		if reply := request(t, user, "meal/product/import", importPayload{Path: path}); reply.Action != "err/badrequest" {
+			t.Errorf("expected import of %s to fail, got %v", path, reply)
+		}
+	}
+
+	replies := user.Request(sarif.CreateMessage("meal/product/import", importPayload{Path: "dump.jsonl"}))
+	for _, action := range []string{"meal/product/import/started", "meal/product/imported"} {
+		select {
+		case reply = <-replies:
+		case <-time.After(5 * time.Second):
+			t.Fatal("no reply", action)
+		}
+		if reply.Action != action {
+			t.Fatalf("expected %s, got %v", action, reply)
+		}
+	}
+	var res importResult
+	reply.DecodePayload(&res)
+	if res.Products != 2 || res.Skipped != 1 || res.Path != "dump.jsonl" {
+		t.Errorf("unexpected result: %+v", res)
+	}
+
+	p, err := s.Products.LookupCode("4000417025005")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.ServingWeight != 100*Gram {
+		t.Errorf("unexpected product: %+v", p)
+	}
+}
@@ -0,0 +1,233 @@
+// Copyright (C) 2019 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package meals
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/sarifsystems/sarif/pkg/util"
+	"github.com/sarifsystems/sarif/sarif"
+	"github.com/sarifsystems/sarif/services/schema/store"
+)
+
+// ProductDatabase stores products and finds them by barcode or name.
+type ProductDatabase interface {
+	PutProduct(p *Product) error
+	PutProducts(ps []*Product) error
+	LookupCode(code string) (*Product, error)
+	Search(name string, limit int) ([]RankedProduct, error)
+}
+
+type RankedProduct struct {
+	Product
+	Score float64 `json:"score"`
+}
+
+// storeProductDB keeps products in the store service, together with a
+// barcode index and a word index over the product names.
+type storeProductDB struct {
+	Store *store.Store
+}
+
+func NewStoreProductDB(st *store.Store) ProductDatabase {
+	return &storeProductDB{st}
+}
+
+func nameIndexKeys(p *Product) []string {
+	keys := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, w := range util.NormalizeWords(p.Name) {
+		if !seen[w] {
+			seen[w] = true
+			keys = append(keys, "meal_product_names/"+w+"/"+p.Id)
+		}
+	}
+	return keys
+}
+
+// productBatchSize limits the number of products read or written with a
+// single store request.
+const productBatchSize = 500
+
+func (db *storeProductDB) PutProduct(p *Product) error {
+	return db.PutProducts([]*Product{p})
+}
+
+// PutProducts stores several products at once. The previous versions are
+// fetched with a single batch, so that their index entries can be removed.
+func (db *storeProductDB) PutProducts(ps []*Product) error {
+	for len(ps) > productBatchSize {
+		if err := db.putProducts(ps[0:productBatchSize]); err != nil {
+			return err
+		}
+		ps = ps[productBatchSize:]
+	}
+	return db.putProducts(ps)
+}
+
+func (db *storeProductDB) putProducts(ps []*Product) error {
+	if len(ps) == 0 {
+		return nil
+	}
+	now := time.Now()
+	ids := make([]string, 0, len(ps))
+	for _, p := range ps {
+		if p.Id == "" {
+			p.Id = sarif.GenerateId()
+		} else {
+			ids = append(ids, p.Id)
+		}
+		p.UpdatedAt = now
+	}
+	olds, err := db.getProducts(ids)
+	if err != nil {
+		return err
+	}
+
+	cmds := make([]store.Command, 0)
+	for _, p := range ps {
+		if old, ok := olds[p.Id]; ok {
+			if p.CreatedAt.IsZero() {
+				p.CreatedAt = old.CreatedAt
+			}
+			for _, key := range nameIndexKeys(old) {
+				cmds = append(cmds, store.Command{Type: "del", Key: key})
+			}
+			if old.Code != "" && old.Code != p.Code {
+				cmds = append(cmds, store.Command{Type: "del", Key: "meal_product_codes/" + old.Code})
+			}
+		}
+		if p.CreatedAt.IsZero() {
+			p.CreatedAt = p.UpdatedAt
+		}
+		// Later duplicates in the same batch replace this version
+		olds[p.Id] = p
+
+		cmds = append(cmds, store.Command{Type: "put", Key: p.Key(), Value: p})
+		for _, key := range nameIndexKeys(p) {
+			cmds = append(cmds, store.Command{Type: "put", Key: key, Value: p.Id})
+		}
+		if p.Code != "" {
+			cmds = append(cmds, store.Command{Type: "put", Key: "meal_product_codes/" + p.Code, Value: p.Id})
+		}
+	}
+
+	var results []interface{}
+	return db.Store.Batch(cmds, &results)
+}
+
+func (db *storeProductDB) getProduct(id string) (*Product, error) {
+	var p Product
+	if err := db.Store.Get(Product{Id: id}.Key(), &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// getProducts fetches several products by id with batched requests.
+// Missing products are left out.
+func (db *storeProductDB) getProducts(ids []string) (map[string]*Product, error) {
+	products := make(map[string]*Product, len(ids))
+	for start := 0; start < len(ids); start += productBatchSize {
+		end := start + productBatchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		cmds := make([]store.Command, 0, end-start)
+		for _, id := range ids[start:end] {
+			cmds = append(cmds, store.Command{Type: "get", Key: Product{Id: id}.Key()})
+		}
+		var docs []*store.Document
+		if err := db.Store.Batch(cmds, &docs); err != nil {
+			return nil, err
+		}
+		for _, doc := range docs {
+			if doc == nil {
+				continue
+			}
+			var p Product
+			if err := json.Unmarshal(doc.Value, &p); err != nil {
+				return nil, err
+			}
+			products[p.Id] = &p
+		}
+	}
+	return products, nil
+}
+
+func (db *storeProductDB) LookupCode(code string) (*Product, error) {
+	var id string
+	if err := db.Store.Get("meal_product_codes/"+code, &id); err != nil {
+		return nil, err
+	}
+	return db.getProduct(id)
+}
+
+// candidatePrefix shortens a query word, so that the index scan also finds
+// misspelled or inflected names.
+func candidatePrefix(w string) string {
+	r := []rune(w)
+	if len(r) > 3 {
+		r = r[0:3]
+	}
+	return string(r)
+}
+
+func (db *storeProductDB) Search(name string, limit int) ([]RankedProduct, error) {
+	words := util.NormalizeWords(name)
+	ids := make(map[string]bool)
+	for _, w := range words {
+		err := db.Store.ScanAll("meal_product_names/"+candidatePrefix(w), store.Scan{}, func(key string, raw json.RawMessage) error {
+			var id string
+			if err := json.Unmarshal(raw, &id); err != nil {
+				return err
+			}
+			ids[id] = true
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	candidates := make([]string, 0, len(ids))
+	for id := range ids {
+		candidates = append(candidates, id)
+	}
+	products, err := db.getProducts(candidates)
+	if err != nil {
+		return nil, err
+	}
+
+	ranked := make([]RankedProduct, 0)
+	for _, p := range products {
+		if score := matchScore(words, p.Name); score >= minMatchScore {
+			ranked = append(ranked, RankedProduct{*p, score})
+		}
+	}
+
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if ranked[i].Score == ranked[j].Score {
+			return ranked[i].Name < ranked[j].Name
+		}
+		return ranked[i].Score > ranked[j].Score
+	})
+	if limit > 0 && len(ranked) > limit {
+		ranked = ranked[0:limit]
+	}
+	return ranked, nil
+}
+
+const minMatchScore = 0.6
+
+// matchScore rates how well a product name matches the query words.
+// Additional unmatched words in the name slightly lower the score.
+func matchScore(query []string, name string) float64 {
+	score, unmatched := util.MatchWords(query, name)
+	return score - 0.02*float64(unmatched)
+}
@@ -0,0 +1,165 @@
+// Copyright (C) 2019 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package meals
+
+import (
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sarifsystems/sarif/pkg/util"
+	"github.com/sarifsystems/sarif/sarif"
+	"github.com/sarifsystems/sarif/services/schema/store"
+)
+
+func TestMatchScore(t *testing.T) {
+	tests := []struct {
+		Query string
+		Name  string
+		Score float64
+	}{
+		{"oat flakes", "Oat Flakes", 1},
+		{"Oat-Flakes", "oat flakes", 1},
+		{"oat", "Oat Flakes", 0.98},
+		{"fla", "Oat Flakes", 0.88},
+		{"oats", "Oat Flakes", 0.655},
+		{"oat flakes", "Oat", 0.5},
+		{"milk", "Oat Flakes", -0.04},
+		{"", "Oat Flakes", 0},
+		{"oat", "", 0},
+	}
+	for _, test := range tests {
+		score := matchScore(util.NormalizeWords(test.Query), test.Name)
+		if math.Abs(score-test.Score) > 1e-9 {
+			t.Errorf("matchScore(%q, %q) = %v, expected %v", test.Query, test.Name, score, test.Score)
+		}
+	}
+}
+
+func TestSearch(t *testing.T) {
+	s, _, cleanup := newTestService(t)
+	defer cleanup()
+
+	for _, name := range []string{"Oat Flakes", "Oat Milk", "Whole Milk", "Chocolate Oat Cookies"} {
+		if err := s.PutProduct(&Product{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		Query string
+		Names []string
+	}{
+		{"oat milk", []string{"Oat Milk"}},
+		{"milk", []string{"Oat Milk", "Whole Milk"}},
+		{"oat", []string{"Oat Flakes", "Oat Milk", "Chocolate Oat Cookies"}},
+		{"oatz", []string{"Oat Flakes", "Oat Milk", "Chocolate Oat Cookies"}},
+		{"flakes", []string{"Oat Flakes"}},
+		{"rice", []string{}},
+	}
+	for _, test := range tests {
+		ps, err := s.Products.Search(test.Query, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := make([]string, len(ps))
+		for i, p := range ps {
+			names[i] = p.Name
+		}
+		if len(names) != len(test.Names) {
+			t.Errorf("Search(%q) = %v, expected %v", test.Query, names, test.Names)
+			continue
+		}
+		for i := range names {
+			if names[i] != test.Names[i] {
+				t.Errorf("Search(%q) = %v, expected %v", test.Query, names, test.Names)
+				break
+			}
+		}
+	}
+
+	// Renaming a product updates the name index
+	ps, _ := s.Products.Search("whole milk", 1)
+	if len(ps) != 1 {
+		t.Fatal("expected a match, got", ps)
+	}
+	p := ps[0].Product
+	p.Name = "Skimmed Milk"
+	if err := s.PutProduct(&p); err != nil {
+		t.Fatal(err)
+	}
+	if ps, _ := s.Products.Search("whole", 10); len(ps) != 0 {
+		t.Error("expected old name to be removed, got", ps)
+	}
+	if ps, _ := s.Products.Search("skimmed", 10); len(ps) != 1 || ps[0].Id != p.Id {
+		t.Error("expected renamed product, got", ps)
+	}
+
+	if ps, _ := s.Products.Search("oat", 2); len(ps) != 2 {
+		t.Error("expected limit to apply, got", ps)
+	}
+}
+
+func TestPutProducts(t *testing.T) {
+	s, user, cleanup := newTestService(t)
+	defer cleanup()
+
+	var mu sync.Mutex
+	requests := 0
+	user.Subscribe("store", "", func(msg sarif.Message) {
+		mu.Lock()
+		requests++
+		mu.Unlock()
+	})
+	time.Sleep(10 * time.Millisecond)
+
+	existing := &Product{Id: "p1", Name: "Whole Milk", Code: "111"}
+	if err := s.PutProduct(existing); err != nil {
+		t.Fatal(err)
+	}
+	created := existing.CreatedAt
+
+	ps := []*Product{
+		{Id: "p1", Name: "Skimmed Milk", Code: "222"},
+		{Id: "p2", Name: "Oat Flakes"},
+		{Id: "p2", Name: "Spelt Flakes"},
+		{Name: "Rye Bread"},
+	}
+	if err := s.Products.PutProducts(ps); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	mu.Lock()
+	if requests != 4 {
+		t.Errorf("expected one get and one put batch per call, got %d requests", requests)
+	}
+	mu.Unlock()
+
+	if !ps[0].CreatedAt.Equal(created) {
+		t.Error("expected creation time to be kept, got", ps[0].CreatedAt)
+	}
+	if ps[3].Id == "" {
+		t.Error("expected id to be assigned")
+	}
+	for query, expected := range map[string]int{
+		"whole milk":   0,
+		"skimmed milk": 1,
+		"oat":          0,
+		"spelt":        1,
+		"rye bread":    1,
+	} {
+		if found, err := s.Products.Search(query, 10); err != nil || len(found) != expected {
+			t.Errorf("Search(%q) = %v, expected %d results", query, found, expected)
+		}
+	}
+	if _, err := s.Products.LookupCode("111"); err != store.ErrNotFound {
+		t.Error("expected old code to be removed, got", err)
+	}
+	if p, err := s.Products.LookupCode("222"); err != nil || p.Id != "p1" {
+		t.Error("expected new code, got", p, err)
+	}
+}
@@ -7,16 +7,17 @@
 package meals
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/jinzhu/gorm"
 	"github.com/sarifsystems/sarif/pkg/util"
 	"github.com/sarifsystems/sarif/sarif"
 	"github.com/sarifsystems/sarif/services"
+	"github.com/sarifsystems/sarif/services/schema/store"
 )
 
 var Module = &services.Module{
@@ -31,39 +32,43 @@ type Config struct {
 		Username string
 		Password string
 	}
+
+	// ImportDir is the directory that product dumps can be imported from.
+	ImportDir string
 }
 
 type Dependencies struct {
-	DB     *gorm.DB
 	Config services.Config
 	Client sarif.Client
 }
 
 type Service struct {
 	cfg Config
-	DB  *gorm.DB
 	sarif.Client
+	Store    *store.Store
+	Products ProductDatabase
+
+	importing int32
 }
 
 func NewService(deps *Dependencies) *Service {
 	s := &Service{
-		DB:     deps.DB,
 		Client: deps.Client,
+		Store:  store.New(deps.Client),
 	}
+	s.Products = NewStoreProductDB(s.Store)
 	deps.Config.Get(&s.cfg)
 	return s
 }
 
 func (s *Service) Enable() error {
-	if err := s.DB.AutoMigrate(&Product{}, &Serving{}).Error; err != nil {
-		return err
-	}
-
 	if s.cfg.FDDB.ApiKey != "" {
 		go s.FddbLoop()
 	}
 
 	s.Subscribe("meal/product/new", "", s.handleProductNew)
+	s.Subscribe("meal/product/search", "", s.handleProductSearch)
+	s.Subscribe("meal/product/import", "", s.handleProductImport)
 	s.Subscribe("meal/record", "", s.handleServingRecord)
 	s.Subscribe("meal/stats", "", s.handleStats)
 	s.Subscribe("meal/fetch_fddb", "", s.handleFetchFddb)
@@ -86,7 +91,7 @@ func (s *Service) handleProductNew(msg sarif.Message) {
 		return
 	}
 
-	if err := s.DB.Save(&p).Error; err != nil {
+	if err := s.PutProduct(&p); err != nil {
 		s.ReplyInternalError(msg, err)
 		return
 	}
@@ -107,25 +112,40 @@ func (s *Service) handleServingRecord(msg sarif.Message) {
 	if sv.Name == "" {
 		sv.Name = name
 	}
+	if sv.Code == "" && isBarcode(sv.Name) {
+		sv.Code, sv.Name = sv.Name, ""
+	}
+	if sv.Product == nil && sv.Code != "" {
+		p, err := s.Products.LookupCode(sv.Code)
+		if err == store.ErrNotFound {
+			s.ReplyBadRequest(msg, errors.New("No product with barcode "+sv.Code+" found."))
+			return
+		} else if err != nil {
+			s.ReplyInternalError(msg, err)
+			return
+		}
+		sv.Product = p
+		if sv.Size == 0 {
+			sv.Size = 1
+		}
+	}
 	if sv.Product == nil {
 		ps, err := s.findProduct(sv.Name)
 		if err != nil {
 			s.ReplyBadRequest(msg, err)
 			return
 		}
-
-		if len(ps) > 1 {
-			pList := ""
-			for _, p := range ps {
-				pList += "\n- " + p.Name
-			}
-			s.ReplyBadRequest(msg, fmt.Errorf("%d products named %s found.%s", len(ps), sv.Name, pList))
-			return
-		}
-		if len(ps) == 1 {
-			sv.Product = &ps[0]
+		if len(ps) > 0 {
+			sv.Product = &ps[0].Product
 		}
 	}
+	if sv.Product == nil {
+		s.ReplyBadRequest(msg, errors.New("No product named "+sv.Name+" found."))
+		return
+	}
+	if sv.Name == "" {
+		sv.Name = sv.Product.Name
+	}
 	if sv.AmountWeight <= 0 {
 		sv.AmountWeight = Weight(sv.Size) * sv.Product.ServingWeight
 	}
@@ -140,30 +160,100 @@ func (s *Service) handleServingRecord(msg sarif.Message) {
 		sv.Time = time.Now()
 	}
 
-	if err := s.DB.Save(&sv).Error; err != nil {
+	if err := s.PutServing(&sv); err != nil {
 		s.ReplyInternalError(msg, err)
 		return
 	}
+	sv.Stats()
 
 	s.Reply(msg, sarif.CreateMessage("meal/serving/recorded", &sv))
 }
 
-func (s *Service) findProduct(name string) ([]Product, error) {
-	words := strings.Split(name, " ")
-	var ps []Product
+// PutProduct stores a product, assigning a new id if necessary.
+func (s *Service) PutProduct(p *Product) error {
+	return s.Products.PutProduct(p)
+}
+
+// PutServing stores a serving, assigning a new id if necessary.
+func (s *Service) PutServing(sv *Serving) error {
+	if sv.Id == "" {
+		sv.Id = sarif.GenerateId()
+	}
+	if sv.Product != nil {
+		sv.ProductId = sv.Product.Id
+	}
+	sv.CalculatedStats = nil
+	_, err := s.Store.Put(sv.Key(), sv)
+	return err
+}
+
+// findProduct returns all products matching the name, best match first.
+func (s *Service) findProduct(name string) ([]RankedProduct, error) {
 	if name == "" {
-		return ps, errors.New("No name specified.")
+		return nil, errors.New("No name specified.")
+	}
+	return s.Products.Search(name, 10)
+}
+
+type productList []RankedProduct
+
+func (ps productList) String() string {
+	if len(ps) == 0 {
+		return "No products found."
 	}
-	q := s.DB
-	for _, w := range words {
-		q = q.Where("name LIKE ?", "%"+w+"%")
+	str := fmt.Sprintf("Found %d products:", len(ps))
+	for _, p := range ps {
+		str += fmt.Sprintf("\n- %s (%.0f%%)", p.Name, p.Score*100)
 	}
+	return str
+}
 
-	if err := q.Find(&ps).Error; err != nil {
-		return ps, err
+func (s *Service) handleProductSearch(msg sarif.Message) {
+	var p Product
+	if err := msg.DecodePayload(&p); err != nil {
+		s.ReplyBadRequest(msg, err)
+		return
+	}
+	if p.Name == "" {
+		p.Name = msg.Text
 	}
 
-	return ps, nil
+	if p.Code != "" || isBarcode(p.Name) {
+		code := p.Code
+		if code == "" {
+			code = p.Name
+		}
+		found, err := s.Products.LookupCode(code)
+		if err == store.ErrNotFound {
+			s.Reply(msg, sarif.CreateMessage("meal/product/found", productList{}))
+			return
+		} else if err != nil {
+			s.ReplyInternalError(msg, err)
+			return
+		}
+		s.Reply(msg, sarif.CreateMessage("meal/product/found", productList{{*found, 1}}))
+		return
+	}
+
+	ps, err := s.findProduct(p.Name)
+	if err != nil {
+		s.ReplyBadRequest(msg, err)
+		return
+	}
+	s.Reply(msg, sarif.CreateMessage("meal/product/found", productList(ps)))
+}
+
+// isBarcode checks if s looks like an EAN/UPC barcode.
+func isBarcode(s string) bool {
+	if len(s) < 8 || len(s) > 14 {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
 }
 
 var sizeNames = map[string]float64{
@@ -190,51 +280,106 @@ func splitSizeName(s string) (float64, string) {
 type ServingFilter struct {
 	After  time.Time `json:"after"`
 	Before time.Time `json:"before"`
+
+	// Interval groups the totals by "day" or "week".
+	Interval string `json:"interval,omitempty"`
+}
+
+type PeriodStats struct {
+	Start    time.Time `json:"start"`
+	Servings int       `json:"servings"`
+	Stats
 }
 
 type ServingStats struct {
-	Servings []*Serving `json:"servings,omitempty"`
+	Servings []*Serving    `json:"servings,omitempty"`
+	Periods  []PeriodStats `json:"periods,omitempty"`
 	Stats
 }
 
 func (s ServingStats) String() string {
-	return fmt.Sprintf("%d servings totalling %v.", len(s.Servings), s.Stats.Energy.StringKcal())
+	str := fmt.Sprintf("%d servings totalling %v.", len(s.Servings), s.Stats.Energy.StringKcal())
+	if len(s.Periods) > 1 {
+		for _, p := range s.Periods {
+			str += fmt.Sprintf("\n- %s: %v", p.Start.Format("2006-01-02"), p.Energy.StringKcal())
+		}
+	}
+	return str
 }
 
-func (s *Service) handleStats(msg sarif.Message) {
-	f := ServingFilter{
-		After:  time.Now().Truncate(24 * time.Hour).Add(5 * time.Hour),
-		Before: time.Now().Truncate(24 * time.Hour).Add(29 * time.Hour),
+// dayStart returns the start of the nutrition day, which begins at 5am.
+func dayStart(t time.Time) time.Time {
+	t = t.Add(-5 * time.Hour)
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 5, 0, 0, 0, t.Location())
+}
+
+func periodStart(t time.Time, interval string) time.Time {
+	day := dayStart(t)
+	if interval == "week" {
+		offset := (int(day.Weekday()) + 6) % 7
+		return day.AddDate(0, 0, -offset)
+	}
+	return day
+}
+
+func (s *Service) scanServings(f ServingFilter) ([]*Serving, error) {
+	p := store.Scan{}
+	if !f.After.IsZero() {
+		p.Start = f.After.UTC().Format(time.RFC3339Nano)
+	}
+	if !f.Before.IsZero() {
+		p.End = f.Before.UTC().Format(time.RFC3339Nano)
 	}
+
+	servings := make([]*Serving, 0)
+	err := s.Store.ScanAll("meal_servings", p, func(key string, raw json.RawMessage) error {
+		var sv Serving
+		if err := json.Unmarshal(raw, &sv); err != nil {
+			return err
+		}
+		servings = append(servings, &sv)
+		return nil
+	})
+	return servings, err
+}
+
+func (s *Service) handleStats(msg sarif.Message) {
+	var f ServingFilter
 	if err := msg.DecodePayload(&f); err != nil {
 		s.ReplyBadRequest(msg, err)
 		return
 	}
+	if f.After.IsZero() && f.Before.IsZero() {
+		f.After = periodStart(time.Now(), f.Interval)
+		if f.Interval == "week" {
+			f.Before = f.After.AddDate(0, 0, 7)
+		} else {
+			f.Before = f.After.AddDate(0, 0, 1)
+		}
+	}
 
-	var servings []*Serving
-	if err := s.DB.Preload("Product").Scopes(applyFilter(f)).Order("time ASC").Preload("Product").Find(&servings).Error; err != nil {
-		s.ReplyBadRequest(msg, err)
+	servings, err := s.scanServings(f)
+	if err != nil {
+		s.ReplyInternalError(msg, err)
 		return
 	}
 
 	var stats ServingStats
 	stats.Servings = servings
 	for _, sv := range servings {
-		stats.Add(sv.Stats())
-	}
-	s.Reply(msg, sarif.CreateMessage("meal/stats", stats))
-}
+		svStats := sv.Stats()
+		stats.Add(svStats)
 
-func applyFilter(f ServingFilter) func(*gorm.DB) *gorm.DB {
-	return func(db *gorm.DB) *gorm.DB {
-		if !f.After.IsZero() {
-			db = db.Where("time > ?", f.After)
+		start := periodStart(sv.Time.Local(), f.Interval)
+		if n := len(stats.Periods); n == 0 || !stats.Periods[n-1].Start.Equal(start) {
+			stats.Periods = append(stats.Periods, PeriodStats{Start: start})
 		}
-		if !f.Before.IsZero() {
-			db = db.Where("time < ?", f.Before)
-		}
-		return db
+		period := &stats.Periods[len(stats.Periods)-1]
+		period.Servings++
+		period.Add(svStats)
 	}
+	s.Reply(msg, sarif.CreateMessage("meal/stats", stats))
 }
 
 func (s *Service) handleFetchFddb(msg sarif.Message) {
@@ -0,0 +1,402 @@
+// Copyright (C) 2014 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package mpd
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/fhs/gompd/mpd"
+	"github.com/sarifsystems/sarif/pkg/schema"
+	"github.com/sarifsystems/sarif/sarif"
+)
+
+// maxTextSongs limits the number of songs listed in text replies.
+const maxTextSongs = 20
+
+type Song struct {
+	Id       int    `json:"id,omitempty"`
+	Pos      int    `json:"pos"`
+	File     string `json:"file"`
+	Artist   string `json:"artist,omitempty"`
+	Album    string `json:"album,omitempty"`
+	Title    string `json:"title,omitempty"`
+	Duration int    `json:"duration,omitempty"`
+}
+
+func songFromAttrs(a mpd.Attrs) Song {
+	s := Song{
+		File:   a["file"],
+		Artist: a["Artist"],
+		Album:  a["Album"],
+		Title:  a["Title"],
+	}
+	s.Id, _ = strconv.Atoi(a["Id"])
+	s.Pos, _ = strconv.Atoi(a["Pos"])
+	s.Duration, _ = strconv.Atoi(a["Time"])
+	return s
+}
+
+func (s Song) String() string {
+	if s.Title == "" {
+		return s.File
+	}
+	if s.Artist == "" {
+		return s.Title
+	}
+	return s.Title + " by " + s.Artist
+}
+
+type SongList struct {
+	Name    string `json:"name,omitempty"`
+	Current int    `json:"current"`
+	Songs   []Song `json:"songs"`
+}
+
+func newSongList(attrs []mpd.Attrs) SongList {
+	l := SongList{Current: -1, Songs: make([]Song, len(attrs))}
+	for i, a := range attrs {
+		l.Songs[i] = songFromAttrs(a)
+	}
+	return l
+}
+
+func (l SongList) Text() string {
+	if len(l.Songs) == 0 {
+		return "No songs found."
+	}
+
+	s := fmt.Sprintf("%d songs", len(l.Songs))
+	if l.Name != "" {
+		s = l.Name + ": " + s
+	}
+	for i, song := range l.Songs {
+		if i >= maxTextSongs {
+			s += fmt.Sprintf("\n... and %d more", len(l.Songs)-i)
+			break
+		}
+		marker := "  "
+		if song.Id != 0 && song.Id == l.Current {
+			marker = "> "
+		}
+		s += fmt.Sprintf("\n%s%d. %s", marker, i+1, song)
+	}
+	return s
+}
+
+type Status struct {
+	schema.MusicInfo
+	State       string `json:"state"`
+	Random      bool   `json:"random"`
+	Repeat      bool   `json:"repeat"`
+	QueueLength int    `json:"queue_length"`
+}
+
+// Query selects songs from the library. Query searches all tags, the other
+// fields match a single tag. Matching is case insensitive.
+type Query struct {
+	Query  string `json:"query,omitempty"`
+	Artist string `json:"artist,omitempty"`
+	Album  string `json:"album,omitempty"`
+	Title  string `json:"title,omitempty"`
+	Genre  string `json:"genre,omitempty"`
+	Uri    string `json:"uri,omitempty"`
+
+	Clear bool `json:"clear,omitempty"`
+	Play  bool `json:"play,omitempty"`
+}
+
+func (q Query) IsEmpty() bool {
+	return q.Query == "" && q.Artist == "" && q.Album == "" &&
+		q.Title == "" && q.Genre == "" && q.Uri == ""
+}
+
+func (q Query) args() []string {
+	args := make([]string, 0)
+	add := func(tag, v string) {
+		if v != "" {
+			args = append(args, tag, v)
+		}
+	}
+	add("any", q.Query)
+	add("artist", q.Artist)
+	add("album", q.Album)
+	add("title", q.Title)
+	add("genre", q.Genre)
+	return args
+}
+
+func (s *Service) handleMpdStatus(msg sarif.Message) {
+	t, err := s.fetchTrack()
+	if err != nil {
+		s.ReplyInternalError(msg, err)
+		return
+	}
+
+	st := Status{MusicInfo: t.Info, State: t.State}
+	err = s.do(func(c *mpd.Client) error {
+		status, err := c.Status()
+		st.Random = status["random"] == "1"
+		st.Repeat = status["repeat"] == "1"
+		st.QueueLength, _ = strconv.Atoi(status["playlistlength"])
+		return err
+	})
+	if err != nil {
+		s.ReplyInternalError(msg, err)
+		return
+	}
+	s.Reply(msg, sarif.CreateMessage("mpd/status", st))
+}
+
+func (s *Service) handleQueue(msg sarif.Message) {
+	if msg.IsAction("mpd/queue/clear") {
+		if err := s.do(func(c *mpd.Client) error { return c.Clear() }); err != nil {
+			s.ReplyInternalError(msg, err)
+			return
+		}
+		s.Reply(msg, sarif.CreateMessage("ack/"+msg.Action, nil))
+		return
+	}
+
+	var l SongList
+	err := s.do(func(c *mpd.Client) error {
+		attrs, err := c.PlaylistInfo(-1, -1)
+		if err != nil {
+			return err
+		}
+		l = newSongList(attrs)
+		status, err := c.Status()
+		l.Current, _ = strconv.Atoi(status["songid"])
+		return err
+	})
+	if err != nil {
+		s.ReplyInternalError(msg, err)
+		return
+	}
+	l.Name = "Queue"
+	s.Reply(msg, sarif.CreateMessage("mpd/queue", l))
+}
+
+func (s *Service) handleSearch(msg sarif.Message) {
+	var q Query
+	msg.DecodePayload(&q)
+	if q.Query == "" {
+		q.Query = msg.Text
+	}
+	if len(q.args()) == 0 {
+		s.ReplyBadRequest(msg, errors.New("Please specify what to search for."))
+		return
+	}
+
+	var l SongList
+	err := s.do(func(c *mpd.Client) error {
+		attrs, err := c.Search(q.args()...)
+		l = newSongList(attrs)
+		return err
+	})
+	if err != nil {
+		s.ReplyInternalError(msg, err)
+		return
+	}
+	s.Reply(msg, sarif.CreateMessage("mpd/songs", l))
+}
+
+func (s *Service) handleAdd(msg sarif.Message) {
+	var q Query
+	msg.DecodePayload(&q)
+	if q.IsEmpty() {
+		q.Uri = msg.Text
+	}
+	s.replyAdded(msg, q)
+}
+
+// handlePlay resumes playback or, if given a query, replaces the queue
+// with the matching songs, e.g. "play something by Radiohead".
+func (s *Service) handlePlay(msg sarif.Message) {
+	var q Query
+	msg.DecodePayload(&q)
+	if q.IsEmpty() {
+		s.handleSimple(s.play)(msg)
+		return
+	}
+
+	q.Clear, q.Play = true, true
+	s.replyAdded(msg, q)
+}
+
+func (s *Service) replyAdded(msg sarif.Message, q Query) {
+	n, err := s.add(q)
+	if err != nil {
+		s.ReplyInternalError(msg, err)
+		return
+	}
+	if n == 0 {
+		s.ReplyBadRequest(msg, errors.New("No matching songs found."))
+		return
+	}
+
+	reply := sarif.CreateMessage("ack/"+msg.Action, map[string]int{"added": n})
+	reply.Text = fmt.Sprintf("Added %d songs.", n)
+	s.Reply(msg, reply)
+}
+
+// add appends the songs matching the query to the queue
+// and returns how many were added.
+func (s *Service) add(q Query) (n int, err error) {
+	err = s.do(func(c *mpd.Client) error {
+		uris := make([]string, 0)
+		if q.Uri != "" {
+			uris = append(uris, q.Uri)
+		}
+		if args := q.args(); len(args) > 0 {
+			songs, err := c.Search(args...)
+			if err != nil {
+				return err
+			}
+			for _, song := range songs {
+				uris = append(uris, song["file"])
+			}
+		}
+		if len(uris) == 0 {
+			return nil
+		}
+
+		if q.Clear {
+			if err := c.Clear(); err != nil {
+				return err
+			}
+		}
+		first := -1
+		for _, uri := range uris {
+			id, err := c.AddID(uri, -1)
+			if err != nil {
+				return err
+			}
+			if first < 0 {
+				first = id
+			}
+			n++
+		}
+		if q.Play {
+			return c.PlayID(first)
+		}
+		return nil
+	})
+	return n, err
+}
+
+type Playlist struct {
+	Name     string `json:"name"`
+	Modified string `json:"modified,omitempty"`
+}
+
+type Playlists []Playlist
+
+func (ps Playlists) Text() string {
+	if len(ps) == 0 {
+		return "No playlists found."
+	}
+	names := make([]string, len(ps))
+	for i, p := range ps {
+		names[i] = p.Name
+	}
+	return "Playlists: " + strings.Join(names, ", ")
+}
+
+type playlistPayload struct {
+	Name     string `json:"name,omitempty"`
+	Playlist string `json:"playlist,omitempty"`
+	Play     bool   `json:"play,omitempty"`
+}
+
+// handlePlaylist manages stored playlists:
+// mpd/playlist/list, mpd/playlist/show/<name>, mpd/playlist/load/<name>,
+// mpd/playlist/save/<name> and mpd/playlist/delete/<name>.
+func (s *Service) handlePlaylist(msg sarif.Message) {
+	parts := strings.SplitN(msg.ActionSuffix("mpd/playlist"), "/", 2)
+	cmd := parts[0]
+	var p playlistPayload
+	msg.DecodePayload(&p)
+	name := p.Name
+	if name == "" {
+		name = p.Playlist
+	}
+	if len(parts) > 1 {
+		name = parts[1]
+	}
+	if cmd == "" {
+		cmd = "list"
+	}
+	if cmd != "list" && name == "" {
+		s.ReplyBadRequest(msg, errors.New("Please specify a playlist name."))
+		return
+	}
+
+	var reply sarif.Message
+	err := s.do(func(c *mpd.Client) error {
+		switch cmd {
+		case "list":
+			attrs, err := c.ListPlaylists()
+			if err != nil {
+				return err
+			}
+			ps := make(Playlists, len(attrs))
+			for i, a := range attrs {
+				ps[i] = Playlist{a["playlist"], a["Last-Modified"]}
+			}
+			reply = sarif.CreateMessage("mpd/playlists", ps)
+		case "show":
+			attrs, err := c.PlaylistContents(name)
+			if err != nil {
+				return err
+			}
+			l := newSongList(attrs)
+			l.Name = name
+			reply = sarif.CreateMessage("mpd/songs", l)
+		case "load":
+			if p.Play {
+				if err := c.Clear(); err != nil {
+					return err
+				}
+			}
+			if err := c.PlaylistLoad(name, -1, -1); err != nil {
+				return err
+			}
+			if p.Play {
+				if err := c.Play(0); err != nil {
+					return err
+				}
+			}
+			reply = sarif.CreateMessage("ack/"+msg.Action, nil)
+		case "save":
+			if err := c.PlaylistSave(name); err != nil {
+				return err
+			}
+			reply = sarif.CreateMessage("ack/"+msg.Action, nil)
+		case "delete":
+			if err := c.PlaylistRemove(name); err != nil {
+				return err
+			}
+			reply = sarif.CreateMessage("ack/"+msg.Action, nil)
+		default:
+			return errUnknownCommand
+		}
+		return nil
+	})
+	if err == errUnknownCommand {
+		s.ReplyBadRequest(msg, errors.New("Unknown playlist command: "+cmd))
+		return
+	}
+	if err != nil {
+		s.ReplyInternalError(msg, err)
+		return
+	}
+	s.Reply(msg, reply)
+}
+
+var errUnknownCommand = errors.New("unknown command")
@@ -0,0 +1,194 @@
+// Copyright (C) 2014 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package mpd
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/fhs/gompd/mpd"
+	"github.com/sarifsystems/sarif/pkg/schema"
+	"github.com/sarifsystems/sarif/sarif"
+)
+
+// track is the playback state of the current song, used to decide which
+// music/* events to publish and when to scrobble.
+type track struct {
+	Id      string
+	State   string
+	Info    schema.MusicInfo
+	Played  time.Duration
+	Resumed time.Time
+}
+
+// statusSource is the part of the MPD client needed to read the player
+// state, so that the conversion can be tested without a server.
+type statusSource interface {
+	Status() (mpd.Attrs, error)
+	CurrentSong() (mpd.Attrs, error)
+}
+
+func (s *Service) fetchTrack() (t track, err error) {
+	err = s.do(func(c *mpd.Client) (err error) {
+		t, err = readTrack(c)
+		return err
+	})
+	return t, err
+}
+
+// readTrack converts the current status and song of MPD to a track.
+func readTrack(c statusSource) (t track, err error) {
+	status, err := c.Status()
+	if err != nil {
+		return t, err
+	}
+	song, err := c.CurrentSong()
+	if err != nil {
+		return t, err
+	}
+
+	t.Id = status["songid"]
+	t.State = status["state"]
+	t.Info = schema.MusicInfo{
+		IsPlaying: t.State == "play",
+		Player:    playerName,
+
+		Artist: song["Artist"],
+		Album:  song["Album"],
+		Track:  song["Title"],
+	}
+	if t.Info.Track == "" {
+		t.Info.Track = song["file"]
+	}
+	t.Info.Duration, _ = strconv.Atoi(song["Time"])
+	if elapsed, err := strconv.ParseFloat(status["elapsed"], 64); err == nil {
+		t.Info.Position = int(elapsed)
+	}
+	if vol, err := strconv.Atoi(status["volume"]); err == nil && vol > 0 {
+		t.Info.Volume = vol
+	}
+	return t, nil
+}
+
+// watch listens for player changes on a separate idle connection
+// and reconnects with a backoff if MPD goes away.
+func (s *Service) watch() {
+	backoff := time.Second
+	for s.isWatching() {
+		w, err := mpd.NewWatcher(s.Cfg.network(), s.Cfg.Address, s.Cfg.Password, "player", "mixer")
+		if err != nil {
+			s.Log("err/internal", "[mpd] watch: "+err.Error())
+			time.Sleep(backoff)
+			if backoff < 5*time.Minute {
+				backoff *= 2
+			}
+			continue
+		}
+
+		backoff = time.Second
+		s.update()
+		err = s.watchEvents(w)
+		w.Close()
+		if err != nil {
+			s.Log("err/internal", "[mpd] watch: "+err.Error())
+		}
+	}
+}
+
+func (s *Service) watchEvents(w *mpd.Watcher) error {
+	keepalive := time.NewTicker(time.Minute)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case <-w.Event:
+			s.update()
+		case err := <-w.Error:
+			return err
+		case <-keepalive.C:
+			if !s.isWatching() {
+				return nil
+			}
+		}
+	}
+}
+
+func (s *Service) isWatching() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.watching
+}
+
+func (s *Service) update() {
+	t, err := s.fetchTrack()
+	if err != nil {
+		s.Log("err/internal", "[mpd] status: "+err.Error())
+		return
+	}
+
+	ch := nextTrack(s.current, t, time.Now())
+	s.current = ch.Current
+	if ch.Scrobble != nil {
+		s.Publish(sarif.CreateMessage("music/scrobble", *ch.Scrobble))
+	}
+	if ch.State != "" {
+		s.publishState(ch.State, ch.Current)
+	}
+}
+
+// trackChange describes the transition between two player states.
+type trackChange struct {
+	Current track
+	// Scrobble is the previous track, if it was played long enough.
+	Scrobble *schema.MusicInfo
+	// State is "started", "changed", "stopped" or empty if playback
+	// did not change.
+	State string
+}
+
+// nextTrack carries the play time over from the previous state of the
+// same track and decides which events to publish.
+func nextTrack(prev, t track, now time.Time) (ch trackChange) {
+	if prev.State == "play" {
+		prev.Played += now.Sub(prev.Resumed)
+	}
+
+	if t.Id == prev.Id {
+		t.Info.Time = prev.Info.Time
+		t.Played = prev.Played
+	} else {
+		t.Info.Time = now.Add(-time.Duration(t.Info.Position) * time.Second)
+	}
+	if t.State == "play" {
+		t.Resumed = now
+	}
+
+	// Scrobble when leaving a track, stopping restarts it from the beginning.
+	if t.Id != prev.Id || (t.State == "stop" && prev.State != "stop") {
+		if prev.Info.ShouldScrobble(prev.Played) {
+			info := prev.Info
+			ch.Scrobble = &info
+		}
+		t.Played = 0
+	}
+	ch.Current = t
+
+	switch {
+	case t.Info.IsPlaying && !prev.Info.IsPlaying:
+		ch.State = "started"
+	case t.Info.IsPlaying && t.Id != prev.Id:
+		ch.State = "changed"
+	case !t.Info.IsPlaying && prev.Info.IsPlaying:
+		ch.State = "stopped"
+	}
+	return ch
+}
+
+func (s *Service) publishState(action string, t track) {
+	s.active.Update(t.Info)
+	s.Publish(sarif.CreateMessage("mpd/playback/"+action, t.Info))
+	s.Publish(sarif.CreateMessage("music/"+action, t.Info))
+}
@@ -0,0 +1,132 @@
+// Copyright (C) 2014 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package mpd
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/fhs/gompd/mpd"
+	"github.com/sarifsystems/sarif/pkg/schema"
+)
+
+type fakeStatus struct {
+	status, song mpd.Attrs
+	err          error
+}
+
+func (f fakeStatus) Status() (mpd.Attrs, error)      { return f.status, f.err }
+func (f fakeStatus) CurrentSong() (mpd.Attrs, error) { return f.song, f.err }
+
+func TestReadTrack(t *testing.T) {
+	tests := []struct {
+		Status, Song mpd.Attrs
+		Expect       track
+	}{
+		{
+			mpd.Attrs{"songid": "3", "state": "play", "elapsed": "12.7", "volume": "80"},
+			mpd.Attrs{"Artist": "Radiohead", "Album": "OK Computer", "Title": "Airbag", "Time": "284", "file": "a.mp3"},
+			track{Id: "3", State: "play", Info: schema.MusicInfo{
+				IsPlaying: true, Player: playerName,
+				Artist: "Radiohead", Album: "OK Computer", Track: "Airbag",
+				Duration: 284, Position: 12, Volume: 80,
+			}},
+		},
+		{
+			mpd.Attrs{"songid": "4", "state": "pause", "volume": "-1"},
+			mpd.Attrs{"file": "untagged.ogg"},
+			track{Id: "4", State: "pause", Info: schema.MusicInfo{
+				Player: playerName, Track: "untagged.ogg",
+			}},
+		},
+		{
+			mpd.Attrs{"state": "stop"},
+			mpd.Attrs{},
+			track{State: "stop", Info: schema.MusicInfo{Player: playerName}},
+		},
+	}
+
+	for _, test := range tests {
+		got, err := readTrack(fakeStatus{status: test.Status, song: test.Song})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.Expect {
+			t.Errorf("readTrack(%v, %v)\n got %+v\nwant %+v", test.Status, test.Song, got, test.Expect)
+		}
+	}
+
+	if _, err := readTrack(fakeStatus{err: errors.New("closed")}); err == nil {
+		t.Error("expected error to be returned")
+	}
+}
+
+func TestNextTrack(t *testing.T) {
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	song := func(id, state string, played time.Duration) track {
+		return track{
+			Id:      id,
+			State:   state,
+			Info:    schema.MusicInfo{IsPlaying: state == "play", Track: "Song " + id, Duration: 200},
+			Played:  played,
+			Resumed: now.Add(-time.Minute),
+		}
+	}
+
+	tests := []struct {
+		Name     string
+		Prev, T  track
+		State    string
+		Scrobble string
+		Played   time.Duration
+	}{
+		{"initial stop", track{}, song("1", "stop", 0), "", "", 0},
+		{"start", song("1", "stop", 0), song("1", "play", 0), "started", "", 0},
+		{"keep playing", song("1", "play", 0), song("1", "play", 0), "", "", time.Minute},
+		{"pause", song("1", "play", 0), song("1", "pause", 0), "stopped", "", time.Minute},
+		{"resume", song("1", "pause", time.Minute), song("1", "play", 0), "started", "", time.Minute},
+		{"skip early", song("1", "play", 0), song("2", "play", 0), "changed", "", 0},
+		{"next after half", song("1", "play", 45*time.Second), song("2", "play", 0), "changed", "Song 1", 0},
+		{"stop after half", song("1", "play", 45*time.Second), song("1", "stop", 0), "stopped", "Song 1", 0},
+		{"change while paused", song("1", "pause", 0), song("2", "pause", 0), "", "", 0},
+	}
+
+	for _, test := range tests {
+		ch := nextTrack(test.Prev, test.T, now)
+		if ch.State != test.State {
+			t.Errorf("%s: expected state %q, got %q", test.Name, test.State, ch.State)
+		}
+		scrobbled := ""
+		if ch.Scrobble != nil {
+			scrobbled = ch.Scrobble.Track
+		}
+		if scrobbled != test.Scrobble {
+			t.Errorf("%s: expected scrobble %q, got %q", test.Name, test.Scrobble, scrobbled)
+		}
+		if ch.Current.Played != test.Played {
+			t.Errorf("%s: expected played %v, got %v", test.Name, test.Played, ch.Current.Played)
+		}
+		if test.T.State == "play" && !ch.Current.Resumed.Equal(now) {
+			t.Errorf("%s: expected resume time to be updated", test.Name)
+		}
+	}
+}
+
+func TestNextTrackStartTime(t *testing.T) {
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	cur := track{Id: "1", State: "play", Info: schema.MusicInfo{IsPlaying: true, Position: 30}}
+	ch := nextTrack(track{}, cur, now)
+	if expected := now.Add(-30 * time.Second); !ch.Current.Info.Time.Equal(expected) {
+		t.Errorf("expected start time %v, got %v", expected, ch.Current.Info.Time)
+	}
+
+	cur.Info.Position = 90
+	ch = nextTrack(ch.Current, cur, now.Add(time.Minute))
+	if expected := now.Add(-30 * time.Second); !ch.Current.Info.Time.Equal(expected) {
+		t.Errorf("expected start time to be kept, got %v", ch.Current.Info.Time)
+	}
+}
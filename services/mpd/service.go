@@ -7,50 +7,161 @@
 package mpd
 
 import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
 	"github.com/fhs/gompd/mpd"
+	"github.com/sarifsystems/sarif/pkg/schema"
 	"github.com/sarifsystems/sarif/sarif"
 	"github.com/sarifsystems/sarif/services"
 )
 
+const playerName = "mpd"
+
 var Module = &services.Module{
 	Name:        "mpd",
 	Version:     "1.0",
 	NewInstance: NewService,
 }
 
+type Config struct {
+	// Address is either host:port or the path of a unix socket.
+	Address  string
+	Password string
+}
+
+func (c Config) network() string {
+	if strings.HasPrefix(c.Address, "/") {
+		return "unix"
+	}
+	return "tcp"
+}
+
 type Dependencies struct {
+	Config services.Config
 	Client sarif.Client
 }
 
 type Service struct {
-	Mpd *mpd.Client
+	Cfg    Config
+	Config services.Config
+	Mpd    *mpd.Client
 	sarif.Client
+
+	mutex    sync.Mutex
+	watching bool
+	current  track
+	active   schema.ActiveMusicPlayer
 }
 
 func NewService(deps *Dependencies) *Service {
 	s := &Service{
+		Config: deps.Config,
 		Client: deps.Client,
+		Cfg: Config{
+			Address: "localhost:6600",
+		},
 	}
 	return s
 }
 
 func (s *Service) Enable() (err error) {
-	s.Mpd, err = mpd.Dial("tcp", "localhost:6600")
-	if err != nil {
+	s.Config.Get(&s.Cfg)
+
+	if err := s.do(func(c *mpd.Client) error { return nil }); err != nil {
 		return err
 	}
+	s.watching = true
+	go s.watch()
+
+	s.Subscribe("music/play", "", s.handleMusic(s.play))
+	s.Subscribe("music/pause", "", s.handleMusic(s.pause))
+	s.Subscribe("music/next", "", s.handleMusic(s.next))
+	s.Subscribe("music/prev", "", s.handleMusic(s.prev))
+	s.Subscribe("music/seek", "", s.handleMusic(s.seek))
+	s.Subscribe("music/volume", "", s.handleMusic(s.volume))
+	s.Subscribe("music/status", "", s.handleStatus)
+	s.Subscribe("music/started", "", s.active.HandleEvent)
+	s.Subscribe("music/changed", "", s.active.HandleEvent)
 
-	s.Subscribe("mpd/play", "", s.handleSimple(s.play))
+	s.Subscribe("mpd/play", "", s.handlePlay)
 	s.Subscribe("mpd/pause", "", s.handleSimple(s.pause))
-	s.Subscribe("mpd/next", "", s.handleSimple(s.Mpd.Next))
-	s.Subscribe("mpd/prev", "", s.handleSimple(s.Mpd.Previous))
+	s.Subscribe("mpd/next", "", s.handleSimple(s.next))
+	s.Subscribe("mpd/prev", "", s.handleSimple(s.prev))
+	s.Subscribe("mpd/status", "", s.handleMpdStatus)
+	s.Subscribe("mpd/queue", "", s.handleQueue)
+	s.Subscribe("mpd/search", "", s.handleSearch)
+	s.Subscribe("mpd/add", "", s.handleAdd)
+	s.Subscribe("mpd/playlist", "", s.handlePlaylist)
+
+	return nil
+}
 
+func (s *Service) Disable() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.watching = false
+	if s.Mpd != nil {
+		err := s.Mpd.Close()
+		s.Mpd = nil
+		return err
+	}
 	return nil
 }
 
-func (s *Service) handleSimple(f func() error) func(sarif.Message) {
+func (s *Service) dial() (*mpd.Client, error) {
+	return mpd.DialAuthenticated(s.Cfg.network(), s.Cfg.Address, s.Cfg.Password)
+}
+
+// do runs f on the shared MPD connection, which is reestablished
+// if the server closed it in the meantime.
+func (s *Service) do(f func(c *mpd.Client) error) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.Mpd != nil && s.Mpd.Ping() != nil {
+		s.Mpd.Close()
+		s.Mpd = nil
+	}
+	if s.Mpd == nil {
+		c, err := s.dial()
+		if err != nil {
+			return err
+		}
+		s.Mpd = c
+	}
+	return f(s.Mpd)
+}
+
+// isTarget reports whether a music/* request is meant for this player.
+// Requests without an explicit player are handled by the most recently
+// active player of all backends.
+func (s *Service) isTarget(cmd schema.MusicCommand) bool {
+	return s.active.Target(cmd) == playerName
+}
+
+func (s *Service) handleMusic(f func(schema.MusicCommand) error) func(sarif.Message) {
+	return func(msg sarif.Message) {
+		cmd := schema.DecodeMusicCommand(msg)
+		if !s.isTarget(cmd) {
+			return
+		}
+
+		if err := f(cmd); err != nil {
+			s.ReplyInternalError(msg, err)
+			return
+		}
+		s.Reply(msg, sarif.CreateMessage("ack/"+msg.Action, nil))
+	}
+}
+
+func (s *Service) handleSimple(f func(schema.MusicCommand) error) func(sarif.Message) {
 	return func(msg sarif.Message) {
-		if err := f(); err != nil {
+		if err := f(schema.MusicCommand{}); err != nil {
 			s.ReplyInternalError(msg, err)
 			return
 		}
@@ -58,10 +169,76 @@ func (s *Service) handleSimple(f func() error) func(sarif.Message) {
 	}
 }
 
-func (s *Service) play() error {
-	return s.Mpd.Pause(false)
+func (s *Service) handleStatus(msg sarif.Message) {
+	cmd := schema.DecodeMusicCommand(msg)
+	if !s.isTarget(cmd) {
+		return
+	}
+
+	t, err := s.fetchTrack()
+	if err != nil {
+		s.ReplyInternalError(msg, err)
+		return
+	}
+	s.Reply(msg, sarif.CreateMessage("music/status", t.Info))
+}
+
+func (s *Service) play(cmd schema.MusicCommand) error {
+	return s.do(func(c *mpd.Client) error {
+		status, err := c.Status()
+		if err != nil {
+			return err
+		}
+		if status["state"] == "stop" {
+			return c.Play(-1)
+		}
+		return c.Pause(false)
+	})
+}
+
+func (s *Service) pause(cmd schema.MusicCommand) error {
+	return s.do(func(c *mpd.Client) error {
+		return c.Pause(true)
+	})
+}
+
+func (s *Service) next(cmd schema.MusicCommand) error {
+	return s.do(func(c *mpd.Client) error {
+		return c.Next()
+	})
+}
+
+func (s *Service) prev(cmd schema.MusicCommand) error {
+	return s.do(func(c *mpd.Client) error {
+		return c.Previous()
+	})
 }
 
-func (s *Service) pause() error {
-	return s.Mpd.Pause(true)
+func (s *Service) seek(cmd schema.MusicCommand) error {
+	return s.do(func(c *mpd.Client) error {
+		return c.SeekCur(time.Duration(cmd.Position)*time.Second, cmd.Relative)
+	})
+}
+
+func (s *Service) volume(cmd schema.MusicCommand) error {
+	return s.do(func(c *mpd.Client) error {
+		vol := cmd.Volume
+		if cmd.Relative {
+			attrs, err := c.Status()
+			if err != nil {
+				return err
+			}
+			cur, _ := strconv.Atoi(attrs["volume"])
+			vol += cur
+			if vol < 0 {
+				vol = 0
+			} else if vol > 100 {
+				vol = 100
+			}
+		}
+		if vol < 0 || vol > 100 {
+			return errors.New("Volume must be between 0 and 100")
+		}
+		return c.SetVolume(vol)
+	})
 }
@@ -79,6 +79,7 @@ func (s *Service) Enable() error {
 	s.Subscribe("natural/parse", "", s.handleNaturalParse)
 	s.Subscribe("natural/learn", "", s.handleNaturalLearn)
 	s.Subscribe("natural/phrases", "", s.handleNaturalPhrases)
+	s.Subscribe("natural/rules", "", s.handleNaturalRules)
 	s.Subscribe("", "user", s.handleUserMessage)
 
 	s.Cfg.Address = "sir"
@@ -188,6 +189,10 @@ func (s *Service) handleNaturalPhrases(msg sarif.Message) {
 	})
 }
 
+func (s *Service) handleNaturalRules(msg sarif.Message) {
+	s.Reply(msg, sarif.CreateMessage("natural/rules", s.regular.Rules()))
+}
+
 func (s *Service) AnnotateReply(msg sarif.Message) sarif.Message {
 	mu := sync.Mutex{}
 	fin := make(chan bool)
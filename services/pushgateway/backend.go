@@ -0,0 +1,93 @@
+// Copyright (C) 2019 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package pushgateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/maddevsio/fcm"
+)
+
+const (
+	TypeFCM         = "fcm"
+	TypeWebPush     = "webpush"
+	TypeUnifiedPush = "unifiedpush"
+
+	maxNotificationText = 1024
+)
+
+// ErrGone is returned by backends if the push subscription of a client no
+// longer exists.
+var ErrGone = errors.New("push subscription expired")
+
+// Registration describes how a client is woken up.
+type Registration struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	// Token is the FCM registration token.
+	Token string `json:"token,omitempty"`
+	// Endpoint is the Web Push or UnifiedPush url.
+	Endpoint string       `json:"endpoint,omitempty"`
+	Keys     *WebPushKeys `json:"keys,omitempty"`
+}
+
+// WebPushKeys are the keys of a browser PushSubscription.
+type WebPushKeys struct {
+	P256dh string `json:"p256dh"`
+	Auth   string `json:"auth"`
+}
+
+// Notification is sent to the client, which then fetches the full
+// messages with push/fetch.
+type Notification struct {
+	Id     string `json:"id"`
+	Action string `json:"action"`
+	Text   string `json:"text,omitempty"`
+}
+
+// Backend delivers notifications to a push service.
+type Backend interface {
+	Notify(r Registration, n Notification) error
+}
+
+type fcmBackend struct {
+	fcm *fcm.FCM
+}
+
+func (b fcmBackend) Notify(r Registration, n Notification) error {
+	_, err := b.fcm.Send(fcm.Message{
+		Data: map[string]string{
+			"id":     n.Id,
+			"action": n.Action,
+		},
+		RegistrationIDs:  []string{r.Token},
+		ContentAvailable: true,
+		Priority:         fcm.PriorityHigh,
+	})
+	return err
+}
+
+// UnifiedPush posts notifications to UnifiedPush distributors and
+// ntfy-style HTTP endpoints.
+type UnifiedPush struct {
+	Client *http.Client
+}
+
+func (u *UnifiedPush) Notify(r Registration, n Notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", r.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return doPush(u.Client, req)
+}
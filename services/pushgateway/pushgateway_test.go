@@ -0,0 +1,308 @@
+// Copyright (C) 2019 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package pushgateway
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sarifsystems/sarif/sarif"
+	storeservice "github.com/sarifsystems/sarif/services/store"
+	"github.com/sarifsystems/sarif/transports/sfproto"
+
+	_ "github.com/sarifsystems/sarif/services/store/bolt"
+)
+
+type testConfig struct {
+	dir string
+}
+
+func (testConfig) Exists() bool                    { return false }
+func (testConfig) Set(v interface{}) error         { return nil }
+func (testConfig) Get(v interface{}) (error, bool) { return nil, false }
+func (c testConfig) Dir() string                   { return c.dir }
+
+// decryptWebPush is the user agent side of encryptWebPush.
+func decryptWebPush(t *testing.T, body []byte, uaPrivate []byte, uaPublic, auth []byte) []byte {
+	salt := body[0:16]
+	rs := binary.BigEndian.Uint32(body[16:20])
+	idlen := int(body[20])
+	asPublic := body[21 : 21+idlen]
+	ciphertext := body[21+idlen:]
+	if rs != webPushRecordSize {
+		t.Error("unexpected record size", rs)
+	}
+
+	curve := elliptic.P256()
+	asX, asY := elliptic.Unmarshal(curve, asPublic)
+	sx, _ := curve.ScalarMult(asX, asY, uaPrivate)
+	ecdhSecret := make([]byte, 32)
+	sxb := sx.Bytes()
+	copy(ecdhSecret[32-len(sxb):], sxb)
+
+	keyInfo := append([]byte("WebPush: info\x00"), uaPublic...)
+	keyInfo = append(keyInfo, asPublic...)
+	ikm := hkdf(auth, ecdhSecret, keyInfo, 32)
+	cek := hkdf(salt, ikm, []byte("Content-Encoding: aes128gcm\x00"), 16)
+	nonce := hkdf(salt, ikm, []byte("Content-Encoding: nonce\x00"), 12)
+
+	block, _ := aes.NewCipher(cek)
+	gcm, _ := cipher.NewGCM(block)
+	plain, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain[len(plain)-1] != 2 {
+		t.Fatal("missing record delimiter")
+	}
+	return plain[0 : len(plain)-1]
+}
+
+func TestWebPushEncryption(t *testing.T) {
+	uaPrivate, x, y, err := elliptic.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uaPublic := elliptic.Marshal(elliptic.P256(), x, y)
+	auth := make([]byte, 16)
+	rand.Read(auth)
+
+	plain := []byte(`{"id":"abc","action":"push/test"}`)
+	body, err := encryptWebPush(plain, b64.EncodeToString(uaPublic), b64.EncodeToString(auth)+"==")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dec := decryptWebPush(t, body, uaPrivate, uaPublic, auth); !bytes.Equal(dec, plain) {
+		t.Errorf("unexpected plaintext: %s", dec)
+	}
+}
+
+func TestVapidAuthorization(t *testing.T) {
+	k, err := GenerateVapidKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	k2, err := ParseVapidKey(k.PrivateString())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k2.PublicString() != k.PublicString() {
+		t.Fatal("parsed key does not match")
+	}
+
+	authz, err := k.Authorization("https://push.example.com/send/123", "mailto:me@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(authz, "vapid t=") || !strings.HasSuffix(authz, ", k="+k.PublicString()) {
+		t.Fatal("unexpected header", authz)
+	}
+	jwt := strings.TrimSuffix(strings.TrimPrefix(authz, "vapid t="), ", k="+k.PublicString())
+	parts := strings.Split(jwt, ".")
+	if len(parts) != 3 {
+		t.Fatal("invalid jwt", jwt)
+	}
+
+	claims, _ := b64.DecodeString(parts[1])
+	var c struct {
+		Aud string `json:"aud"`
+		Exp int64  `json:"exp"`
+		Sub string `json:"sub"`
+	}
+	if err := json.Unmarshal(claims, &c); err != nil {
+		t.Fatal(err)
+	}
+	if c.Aud != "https://push.example.com" || c.Sub != "mailto:me@example.com" || c.Exp < time.Now().Unix() {
+		t.Errorf("unexpected claims: %+v", c)
+	}
+
+	sig, _ := b64.DecodeString(parts[2])
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	r, s := new(big.Int).SetBytes(sig[0:32]), new(big.Int).SetBytes(sig[32:])
+	if !ecdsa.Verify(&k2.PublicKey, hash[:], r, s) {
+		t.Error("invalid signature")
+	}
+}
+
+func TestRegistration(t *testing.T) {
+	keys := &WebPushKeys{"p256dh", "auth"}
+	tests := []struct {
+		p   RegisterPayload
+		typ string
+		ok  bool
+	}{
+		{RegisterPayload{Token: "token"}, TypeFCM, true},
+		{RegisterPayload{Endpoint: "https://push.example.com", Keys: keys}, TypeWebPush, true},
+		{RegisterPayload{Endpoint: "https://ntfy.example.com/topic"}, TypeUnifiedPush, true},
+		{RegisterPayload{}, TypeFCM, false},
+		{RegisterPayload{Type: TypeWebPush, Endpoint: "https://push.example.com"}, TypeWebPush, false},
+		{RegisterPayload{Type: "pigeon"}, "pigeon", false},
+	}
+	for _, test := range tests {
+		r, err := test.p.Registration()
+		if r.Type != test.typ || (err == nil) != test.ok {
+			t.Errorf("%+v: expected %s/%v, got %s/%v", test.p, test.typ, test.ok, r.Type, err)
+		}
+	}
+}
+
+func expectMessage(t *testing.T, ch chan sarif.Message) sarif.Message {
+	select {
+	case msg := <-ch:
+		return msg
+	case <-time.After(3 * time.Second):
+		t.Fatal("no message received")
+	}
+	return sarif.Message{}
+}
+
+func TestService(t *testing.T) {
+	notified := make(chan Notification, 10)
+	push := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/gone" {
+			w.WriteHeader(http.StatusGone)
+			return
+		}
+		var n Notification
+		json.NewDecoder(r.Body).Decode(&n)
+		notified <- n
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer push.Close()
+
+	dir, err := ioutil.TempDir("", "pushgateway")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	broker := sfproto.NewBroker()
+	storeClient, err := broker.NewClient(sarif.ClientInfo{Name: "store"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := storeservice.NewService(&storeservice.Dependencies{
+		Config: testConfig{dir},
+		Client: storeClient,
+	})
+	if err := st.Enable(); err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := broker.NewClient(sarif.ClientInfo{Name: "pushgateway"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewService(&Dependencies{
+		Config: testConfig{dir},
+		Client: client,
+	})
+	if err := s.Enable(); err != nil {
+		t.Fatal(err)
+	}
+
+	phone, err := broker.NewClient(sarif.ClientInfo{Name: "phone"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan sarif.Message, 10)
+	phone.Subscribe("", "self", func(msg sarif.Message) {
+		if msg.CorrId != "" && !strings.HasPrefix(msg.Action, "push/") {
+			received <- msg
+		}
+	})
+
+	reply, ok := <-phone.Request(sarif.CreateMessage("push/vapid", nil))
+	var key map[string]string
+	reply.DecodePayload(&key)
+	if !ok || key["public_key"] != s.vapid.PublicString() {
+		t.Fatal("unexpected vapid reply", reply.Action, reply.Text)
+	}
+
+	reply, ok = <-phone.Request(sarif.CreateMessage("push/register", RegisterPayload{
+		Endpoint: push.URL + "/topic",
+	}))
+	if !ok || reply.Action != "push/registered" {
+		t.Fatal("unexpected register reply", reply.Action, reply.Text)
+	}
+
+	// Messages to the client are queued and announced
+	other, err := broker.NewClient(sarif.ClientInfo{Name: "other"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, text := range []string{"first", "second"} {
+		msg := sarif.CreateMessage("event/test", nil)
+		msg.Destination = "push/phone"
+		msg.Text = text
+		other.Publish(msg)
+		if n := <-notified; n.Action != "event/test" || n.Text != text {
+			t.Errorf("unexpected notification: %+v", n)
+		}
+	}
+
+	// Fetch with confirmation keeps the messages queued
+	reply, ok = <-phone.Request(sarif.CreateMessage("push/fetch", FetchPayload{Confirm: true}))
+	var fetched FetchedPayload
+	reply.DecodePayload(&fetched)
+	if !ok || fetched.NumMessages != 2 {
+		t.Fatal("unexpected fetch reply", reply.Action, reply.Text)
+	}
+	texts := make(map[string]string)
+	for i := 0; i < 2; i++ {
+		msg := expectMessage(t, received)
+		texts[msg.CorrId] = msg.Text
+	}
+	if texts[fetched.Ids[0]] != "first" || texts[fetched.Ids[1]] != "second" {
+		t.Error("unexpected messages", texts)
+	}
+
+	// Acknowledge the first, the second is delivered again
+	reply, ok = <-phone.Request(sarif.CreateMessage("push/fetch", FetchPayload{Ack: fetched.Ids[0:1]}))
+	reply.DecodePayload(&fetched)
+	if !ok || fetched.NumMessages != 1 {
+		t.Fatal("unexpected fetch reply", reply.Action, reply.Text)
+	}
+	if msg := expectMessage(t, received); msg.Text != "second" {
+		t.Error("unexpected message", msg.Text)
+	}
+
+	reply, ok = <-phone.Request(sarif.CreateMessage("push/fetch", nil))
+	reply.DecodePayload(&fetched)
+	if !ok || fetched.NumMessages != 0 {
+		t.Fatal("expected empty queue", reply.Action, reply.Text)
+	}
+
+	// Expired subscriptions are removed
+	reply, ok = <-phone.Request(sarif.CreateMessage("push/register", RegisterPayload{
+		Endpoint: push.URL + "/gone",
+	}))
+	if !ok || reply.Action != "push/registered" {
+		t.Fatal("unexpected register reply", reply.Action, reply.Text)
+	}
+	msg := sarif.CreateMessage("event/test", nil)
+	msg.Destination = "push/phone"
+	other.Publish(msg)
+	time.Sleep(100 * time.Millisecond)
+	if _, ok := s.registration("phone"); ok {
+		t.Error("expected registration to be removed")
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright (C) 2019 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package pushgateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sarifsystems/sarif/sarif"
+	"github.com/sarifsystems/sarif/services/schema/store"
+)
+
+const queueCollection = "push_queue"
+
+type queuedMessage struct {
+	Key     string        `json:"-"`
+	Message sarif.Message `json:"message"`
+	Time    time.Time     `json:"time"`
+}
+
+// queue keeps the messages of each client in the store until they are
+// fetched and acknowledged. Keys are ordered by arrival.
+type queue struct {
+	Store *store.Store
+	Size  int
+}
+
+func queuePrefix(client string) string {
+	return url.QueryEscape(client) + "/"
+}
+
+func (q *queue) Push(client string, msg sarif.Message) error {
+	now := time.Now()
+	key := queuePrefix(client) + fmt.Sprintf("%019d-%s", now.UnixNano(), msg.Id)
+	if _, err := q.Store.Put(queueCollection+"/"+key, queuedMessage{
+		Message: msg,
+		Time:    now,
+	}); err != nil {
+		return err
+	}
+
+	if q.Size <= 0 {
+		return nil
+	}
+	msgs, err := q.List(client)
+	if err != nil || len(msgs) <= q.Size {
+		return err
+	}
+	return q.Remove(msgs[0 : len(msgs)-q.Size])
+}
+
+// List returns all queued messages of a client, oldest first.
+func (q *queue) List(client string) ([]queuedMessage, error) {
+	msgs := make([]queuedMessage, 0)
+	err := q.Store.ScanAll(queueCollection+"/"+queuePrefix(client), store.Scan{}, func(key string, v json.RawMessage) error {
+		var qm queuedMessage
+		if err := json.Unmarshal(v, &qm); err != nil {
+			return err
+		}
+		qm.Key = key
+		msgs = append(msgs, qm)
+		return nil
+	})
+	return msgs, err
+}
+
+func (q *queue) Remove(msgs []queuedMessage) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	cmds := make([]store.Command, len(msgs))
+	for i, qm := range msgs {
+		cmds[i] = store.Command{
+			Type: "del",
+			Key:  queueCollection + "/" + qm.Key,
+		}
+	}
+	var res interface{}
+	return q.Store.Batch(cmds, &res)
+}
+
+// Ack removes the messages with the given ids from the queue of a client.
+func (q *queue) Ack(client string, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	msgs, err := q.List(client)
+	if err != nil {
+		return err
+	}
+	acked := make([]queuedMessage, 0)
+	for _, qm := range msgs {
+		for _, id := range ids {
+			if strings.HasSuffix(qm.Key, "-"+id) {
+				acked = append(acked, qm)
+				break
+			}
+		}
+	}
+	return q.Remove(acked)
+}
@@ -3,17 +3,20 @@
 // Use of this source code is governed by an MIT-style
 // license that can be found in the LICENSE file.
 
-// Service pushgateway provides notifications to FCM
+// Service pushgateway queues messages for mobile and browser clients and
+// wakes them up through FCM, Web Push or UnifiedPush.
 package pushgateway
 
 import (
 	"errors"
 	"fmt"
 	"strings"
+	"sync"
 
 	"github.com/maddevsio/fcm"
 	"github.com/sarifsystems/sarif/sarif"
 	"github.com/sarifsystems/sarif/services"
+	"github.com/sarifsystems/sarif/services/schema/store"
 )
 
 var Module = &services.Module{
@@ -30,21 +33,32 @@ type Dependencies struct {
 type Config struct {
 	FirebaseToken string
 
-	Clients map[string]string
-}
+	// VapidPrivateKey identifies the gateway to Web Push services.
+	// It is generated on first start.
+	VapidPrivateKey string
+	VapidSubject    string
+
+	// QueueSize is the maximum number of messages kept per client.
+	QueueSize int
 
-type client struct {
-	Name  string
-	Token string
-	Queue []sarif.Message
+	// Clients maps client names to FCM tokens. It is migrated to
+	// Registrations on start.
+	Clients map[string]string `json:",omitempty"`
+
+	Registrations map[string]Registration
 }
 
 type Service struct {
 	Config Config
 	cfg    services.Config
 	sarif.Client
-	fcm     *fcm.FCM
-	clients map[string]*client
+
+	Backends map[string]Backend
+
+	vapid      VapidKey
+	queue      *queue
+	mu         sync.Mutex
+	subscribed map[string]bool
 }
 
 func NewService(deps *Dependencies) *Service {
@@ -57,21 +71,111 @@ func NewService(deps *Dependencies) *Service {
 
 func (s *Service) Enable() (err error) {
 	s.Config.Clients = make(map[string]string)
+	s.Config.Registrations = make(map[string]Registration)
+	s.Config.VapidSubject = "mailto:sarif@localhost"
+	s.Config.QueueSize = 100
 	s.cfg.Get(&s.Config)
-	s.fcm = fcm.NewFCM(s.Config.FirebaseToken)
-	s.clients = make(map[string]*client, 0)
+
+	changed := len(s.Config.Clients) > 0
+	for name, token := range s.Config.Clients {
+		s.Config.Registrations[name] = Registration{
+			Name:  name,
+			Type:  TypeFCM,
+			Token: token,
+		}
+	}
+	s.Config.Clients = nil
+
+	if s.Config.VapidPrivateKey == "" {
+		if s.vapid, err = GenerateVapidKey(); err != nil {
+			return err
+		}
+		s.Config.VapidPrivateKey = s.vapid.PrivateString()
+		changed = true
+	} else if s.vapid, err = ParseVapidKey(s.Config.VapidPrivateKey); err != nil {
+		return err
+	}
+	if changed {
+		s.cfg.Set(s.Config)
+	}
+
+	if s.Backends == nil {
+		s.Backends = map[string]Backend{
+			TypeWebPush: &WebPush{
+				Key:     s.vapid,
+				Subject: s.Config.VapidSubject,
+			},
+			TypeUnifiedPush: &UnifiedPush{},
+		}
+		if s.Config.FirebaseToken != "" {
+			s.Backends[TypeFCM] = fcmBackend{fcm.NewFCM(s.Config.FirebaseToken)}
+		}
+	}
+	s.queue = &queue{
+		Store: store.New(s.Client),
+		Size:  s.Config.QueueSize,
+	}
+	s.subscribed = make(map[string]bool)
 
 	s.Subscribe("push/register", "", s.handlePushRegister)
+	s.Subscribe("push/unregister", "", s.handlePushUnregister)
 	s.Subscribe("push/fetch", "", s.handlePushFetch)
-	for client, token := range s.Config.Clients {
-		s.initClient(client, token)
+	s.Subscribe("push/ack", "", s.handlePushAck)
+	s.Subscribe("push/vapid", "", s.handlePushVapid)
+	s.Subscribe("", "user", s.handleIncoming)
+	for name := range s.Config.Registrations {
+		s.initClient(name)
 	}
 	return nil
 }
 
 type RegisterPayload struct {
-	Name  string `json:"name,omitempty"`
-	Token string `json:"token"`
+	Name string `json:"name,omitempty"`
+	Type string `json:"type,omitempty"`
+	// Token is the FCM registration token.
+	Token string `json:"token,omitempty"`
+	// Endpoint and Keys are taken from a browser PushSubscription or a
+	// UnifiedPush distributor.
+	Endpoint string       `json:"endpoint,omitempty"`
+	Keys     *WebPushKeys `json:"keys,omitempty"`
+}
+
+func (p RegisterPayload) Registration() (Registration, error) {
+	r := Registration{
+		Name:     p.Name,
+		Type:     p.Type,
+		Token:    p.Token,
+		Endpoint: p.Endpoint,
+		Keys:     p.Keys,
+	}
+	if r.Type == "" {
+		switch {
+		case r.Endpoint != "" && r.Keys != nil:
+			r.Type = TypeWebPush
+		case r.Endpoint != "":
+			r.Type = TypeUnifiedPush
+		default:
+			r.Type = TypeFCM
+		}
+	}
+
+	switch r.Type {
+	case TypeFCM:
+		if r.Token == "" {
+			return r, errors.New("No token given")
+		}
+	case TypeWebPush:
+		if r.Endpoint == "" || r.Keys == nil || r.Keys.P256dh == "" || r.Keys.Auth == "" {
+			return r, errors.New("Web Push needs an endpoint and keys")
+		}
+	case TypeUnifiedPush:
+		if r.Endpoint == "" {
+			return r, errors.New("No endpoint given")
+		}
+	default:
+		return r, fmt.Errorf("unknown push type %q", r.Type)
+	}
+	return r, nil
 }
 
 func (s *Service) handlePushRegister(msg sarif.Message) {
@@ -80,81 +184,193 @@ func (s *Service) handlePushRegister(msg sarif.Message) {
 		s.ReplyBadRequest(msg, err)
 		return
 	}
+	if p.Name == "" {
+		p.Name = msg.Source
+	}
+	r, err := p.Registration()
+	if err != nil {
+		s.ReplyBadRequest(msg, err)
+		return
+	}
+	if s.Backends[r.Type] == nil {
+		s.ReplyBadRequest(msg, fmt.Errorf("push type %q is not configured", r.Type))
+		return
+	}
+
+	s.mu.Lock()
+	s.Config.Registrations[r.Name] = r
+	s.cfg.Set(s.Config)
+	s.mu.Unlock()
+
+	s.initClient(r.Name)
+	s.Reply(msg, sarif.CreateMessage("push/registered", r))
+}
+
+type UnregisterPayload struct {
+	Name string `json:"name,omitempty"`
+}
 
-	if p.Token == "" {
-		s.ReplyBadRequest(msg, errors.New("No token given"))
+func (s *Service) handlePushUnregister(msg sarif.Message) {
+	var p UnregisterPayload
+	if err := msg.DecodePayload(&p); err != nil {
+		s.ReplyBadRequest(msg, err)
 		return
 	}
 	if p.Name == "" {
 		p.Name = msg.Source
 	}
+	s.unregister(p.Name)
+	s.Reply(msg, sarif.CreateMessage("push/unregistered", p))
+}
+
+func (s *Service) handlePushVapid(msg sarif.Message) {
+	s.Reply(msg, sarif.CreateMessage("push/vapid/key", map[string]string{
+		"public_key": s.vapid.PublicString(),
+	}))
+}
 
-	s.Config.Clients[p.Name] = p.Token
-	s.initClient(p.Name, p.Token)
+func (s *Service) registration(name string) (Registration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.Config.Registrations[name]
+	return r, ok
+}
+
+func (s *Service) unregister(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.Config.Registrations[name]; !ok {
+		return
+	}
+	delete(s.Config.Registrations, name)
 	s.cfg.Set(s.Config)
-	s.Reply(msg, sarif.CreateMessage("push/registered", nil))
+}
+
+func (s *Service) initClient(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.subscribed[name] {
+		return
+	}
+	s.subscribed[name] = true
+	s.Subscribe("", "push/"+name, s.handleIncoming)
 }
 
 func (s *Service) handleIncoming(msg sarif.Message) {
+	if msg.Destination == "user" {
+		s.mu.Lock()
+		regs := make([]Registration, 0, len(s.Config.Registrations))
+		for _, r := range s.Config.Registrations {
+			regs = append(regs, r)
+		}
+		s.mu.Unlock()
+
+		for _, r := range regs {
+			s.deliver(r, msg)
+		}
+		return
+	}
+
 	name := strings.TrimPrefix(msg.Destination, "push/")
-	client := s.clients[name]
-	if client == nil {
+	r, ok := s.registration(name)
+	if !ok {
 		s.Log("err/internal", "unknown client '"+name+"'")
 		return
 	}
+	s.deliver(r, msg)
+}
 
-	client.Queue = append(client.Queue, msg)
-	if len(client.Queue) > 30 {
-		client.Queue = client.Queue[1:]
+func (s *Service) deliver(r Registration, msg sarif.Message) {
+	if err := s.queue.Push(r.Name, msg); err != nil {
+		s.Log("err/internal", "push queue error: "+err.Error())
+		return
 	}
 
-	data := map[string]string{
-		"id":     msg.Id,
-		"action": msg.Action,
+	backend := s.Backends[r.Type]
+	if backend == nil {
+		s.Log("err/internal", "no push backend for '"+r.Name+"' ("+r.Type+")")
+		return
 	}
-	_, err := s.fcm.Send(fcm.Message{
-		Data:             data,
-		RegistrationIDs:  []string{client.Token},
-		ContentAvailable: true,
-		Priority:         fcm.PriorityHigh,
-	})
-	if err != nil {
-		s.Log("err/internal", "fcm error: "+err.Error())
+
+	n := Notification{
+		Id:     msg.Id,
+		Action: msg.Action,
+		Text:   msg.Text,
+	}
+	if len(n.Text) > maxNotificationText {
+		n.Text = n.Text[0:maxNotificationText-3] + "..."
+	}
+	err := backend.Notify(r, n)
+	if err == ErrGone {
+		s.Log("info", "push subscription of '"+r.Name+"' expired")
+		s.unregister(r.Name)
+	} else if err != nil {
+		s.Log("err/internal", r.Type+" error: "+err.Error())
 	}
 }
 
-func (s *Service) initClient(name, token string) {
-	if client := s.clients[name]; client != nil {
-		client.Token = token
-		return
-	}
+type FetchPayload struct {
+	Name string `json:"name,omitempty"`
+	// Ack lists ids of previously fetched messages that were processed.
+	Ack []string `json:"ack,omitempty"`
+	// Confirm keeps fetched messages queued until they are acknowledged.
+	Confirm bool `json:"confirm,omitempty"`
+}
 
-	s.clients[name] = &client{
-		Name:  name,
-		Token: token,
-		Queue: make([]sarif.Message, 0),
-	}
-	s.Subscribe("", "push/"+name, s.handleIncoming)
-	s.Subscribe("", "user", s.handleIncoming)
+type FetchedPayload struct {
+	NumMessages int      `json:"num_messages"`
+	Ids         []string `json:"ids"`
 }
 
-func (s *Service) handlePushFetch(msg sarif.Message) {
-	name := strings.TrimPrefix(msg.Destination, "push/fetch/")
+func (s *Service) clientName(msg sarif.Message, action, name string) (string, error) {
+	if suffix := msg.ActionSuffix(action); suffix != "" {
+		name = suffix
+	}
 	if name == "" {
 		name = msg.Source
 	}
+	if _, ok := s.registration(name); !ok {
+		return name, fmt.Errorf("unknown client %q", name)
+	}
+	return name, nil
+}
+
+func (s *Service) handlePushFetch(msg sarif.Message) {
+	var p FetchPayload
+	if err := msg.DecodePayload(&p); err != nil {
+		s.ReplyBadRequest(msg, err)
+		return
+	}
+	name, err := s.clientName(msg, "push/fetch", p.Name)
+	if err != nil {
+		s.ReplyBadRequest(msg, err)
+		return
+	}
 
-	client := s.clients[name]
-	if client == nil {
-		s.ReplyBadRequest(msg, fmt.Errorf("unknown client %q", name))
+	if err := s.queue.Ack(name, p.Ack); err != nil {
+		s.ReplyInternalError(msg, err)
 		return
 	}
+	msgs, err := s.queue.List(name)
+	if err != nil {
+		s.ReplyInternalError(msg, err)
+		return
+	}
+	if !p.Confirm {
+		if err := s.queue.Remove(msgs); err != nil {
+			s.ReplyInternalError(msg, err)
+			return
+		}
+	}
 
-	s.Reply(msg, sarif.CreateMessage("push/fetched", map[string]int{
-		"num_messages": len(client.Queue),
-	}))
+	fetched := FetchedPayload{len(msgs), make([]string, len(msgs))}
+	for i, qm := range msgs {
+		fetched.Ids[i] = qm.Message.Id
+	}
+	s.Reply(msg, sarif.CreateMessage("push/fetched", fetched))
 
-	for _, queued := range client.Queue {
+	for _, qm := range msgs {
+		queued := qm.Message
 		if queued.CorrId == "" {
 			queued.CorrId = queued.Id
 		}
@@ -163,5 +379,27 @@ func (s *Service) handlePushFetch(msg sarif.Message) {
 		s.Publish(queued)
 	}
 
-	client.Queue = make([]sarif.Message, 0)
+}
+
+type AckPayload struct {
+	Name string   `json:"name,omitempty"`
+	Ids  []string `json:"ids"`
+}
+
+func (s *Service) handlePushAck(msg sarif.Message) {
+	var p AckPayload
+	if err := msg.DecodePayload(&p); err != nil {
+		s.ReplyBadRequest(msg, err)
+		return
+	}
+	name, err := s.clientName(msg, "push/ack", p.Name)
+	if err != nil {
+		s.ReplyBadRequest(msg, err)
+		return
+	}
+	if err := s.queue.Ack(name, p.Ids); err != nil {
+		s.ReplyInternalError(msg, err)
+		return
+	}
+	s.Reply(msg, sarif.CreateMessage("push/acked", p))
 }
@@ -0,0 +1,246 @@
+// Copyright (C) 2019 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package pushgateway
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	webPushTTL        = 24 * time.Hour
+	webPushRecordSize = 4096
+	vapidExpiry       = 12 * time.Hour
+)
+
+var b64 = base64.RawURLEncoding
+
+// decodeBase64 accepts both padded and unpadded, standard and url-safe
+// base64, since browsers are not consistent about it.
+func decodeBase64(s string) ([]byte, error) {
+	s = string(bytes.TrimRight([]byte(s), "="))
+	if b, err := b64.DecodeString(s); err == nil {
+		return b, nil
+	}
+	return base64.RawStdEncoding.DecodeString(s)
+}
+
+// VapidKey is the key pair identifying the application server to push
+// services (RFC 8292).
+type VapidKey struct {
+	*ecdsa.PrivateKey
+}
+
+func GenerateVapidKey() (VapidKey, error) {
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	return VapidKey{k}, err
+}
+
+// ParseVapidKey reads a base64url encoded private key.
+func ParseVapidKey(private string) (VapidKey, error) {
+	d, err := decodeBase64(private)
+	if err != nil {
+		return VapidKey{}, err
+	}
+	if len(d) != 32 {
+		return VapidKey{}, errors.New("invalid VAPID private key")
+	}
+	k := new(ecdsa.PrivateKey)
+	k.Curve = elliptic.P256()
+	k.D = new(big.Int).SetBytes(d)
+	k.X, k.Y = k.Curve.ScalarBaseMult(d)
+	return VapidKey{k}, nil
+}
+
+func (k VapidKey) PrivateString() string {
+	d := make([]byte, 32)
+	kd := k.D.Bytes()
+	copy(d[32-len(kd):], kd)
+	return b64.EncodeToString(d)
+}
+
+// PublicString returns the public key as used for the applicationServerKey
+// of a browser subscription.
+func (k VapidKey) PublicString() string {
+	return b64.EncodeToString(elliptic.Marshal(elliptic.P256(), k.X, k.Y))
+}
+
+// Authorization returns the value of the Authorization header for a push
+// endpoint.
+func (k VapidKey) Authorization(endpoint, subject string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	header := b64.EncodeToString([]byte(`{"typ":"JWT","alg":"ES256"}`))
+	claims, err := json.Marshal(map[string]interface{}{
+		"aud": u.Scheme + "://" + u.Host,
+		"exp": time.Now().Add(vapidExpiry).Unix(),
+		"sub": subject,
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := header + "." + b64.EncodeToString(claims)
+
+	hash := sha256.Sum256([]byte(unsigned))
+	r, s, err := ecdsa.Sign(rand.Reader, k.PrivateKey, hash[:])
+	if err != nil {
+		return "", err
+	}
+	sig := make([]byte, 64)
+	rb, sb := r.Bytes(), s.Bytes()
+	copy(sig[32-len(rb):32], rb)
+	copy(sig[64-len(sb):], sb)
+
+	jwt := unsigned + "." + b64.EncodeToString(sig)
+	return "vapid t=" + jwt + ", k=" + k.PublicString(), nil
+}
+
+// hkdf implements HKDF-SHA-256 (RFC 5869) for outputs of up to 32 bytes.
+func hkdf(salt, ikm, info []byte, length int) []byte {
+	mac := hmac.New(sha256.New, salt)
+	mac.Write(ikm)
+	prk := mac.Sum(nil)
+
+	mac = hmac.New(sha256.New, prk)
+	mac.Write(info)
+	mac.Write([]byte{1})
+	return mac.Sum(nil)[0:length]
+}
+
+// encryptWebPush encrypts a payload for a subscription with the aes128gcm
+// content encoding (RFC 8188, RFC 8291).
+func encryptWebPush(plaintext []byte, p256dh, auth string) ([]byte, error) {
+	uaPublic, err := decodeBase64(p256dh)
+	if err != nil {
+		return nil, err
+	}
+	authSecret, err := decodeBase64(auth)
+	if err != nil {
+		return nil, err
+	}
+	curve := elliptic.P256()
+	uaX, uaY := elliptic.Unmarshal(curve, uaPublic)
+	if uaX == nil {
+		return nil, errors.New("invalid p256dh key")
+	}
+
+	asPrivate, asX, asY, err := elliptic.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	asPublic := elliptic.Marshal(curve, asX, asY)
+	sx, _ := curve.ScalarMult(uaX, uaY, asPrivate)
+	ecdhSecret := make([]byte, 32)
+	sxb := sx.Bytes()
+	copy(ecdhSecret[32-len(sxb):], sxb)
+
+	keyInfo := append([]byte("WebPush: info\x00"), uaPublic...)
+	keyInfo = append(keyInfo, asPublic...)
+	ikm := hkdf(authSecret, ecdhSecret, keyInfo, 32)
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	cek := hkdf(salt, ikm, []byte("Content-Encoding: aes128gcm\x00"), 16)
+	nonce := hkdf(salt, ikm, []byte("Content-Encoding: nonce\x00"), 12)
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	// A single record, terminated by the last record delimiter.
+	padded := append(append([]byte{}, plaintext...), 2)
+	if len(padded)+gcm.Overhead() > webPushRecordSize {
+		return nil, errors.New("web push payload too large")
+	}
+
+	header := make([]byte, 16+4+1)
+	copy(header, salt)
+	binary.BigEndian.PutUint32(header[16:], webPushRecordSize)
+	header[20] = byte(len(asPublic))
+	header = append(header, asPublic...)
+	return gcm.Seal(header, nonce, padded, nil), nil
+}
+
+// WebPush delivers notifications to browsers and other W3C Push API
+// subscribers.
+type WebPush struct {
+	Key     VapidKey
+	Subject string
+	Client  *http.Client
+}
+
+func (w *WebPush) Notify(r Registration, n Notification) error {
+	if r.Keys == nil {
+		return errors.New("web push subscription without keys")
+	}
+	payload, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	body, err := encryptWebPush(payload, r.Keys.P256dh, r.Keys.Auth)
+	if err != nil {
+		return err
+	}
+	authz, err := w.Key.Authorization(r.Endpoint, w.Subject)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", r.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", authz)
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("TTL", strconv.Itoa(int(webPushTTL/time.Second)))
+	req.Header.Set("Urgency", "high")
+	return doPush(w.Client, req)
+}
+
+// doPush sends a request to a push endpoint and interprets the status.
+func doPush(c *http.Client, req *http.Request) error {
+	if c == nil {
+		c = http.DefaultClient
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return ErrGone
+	case resp.StatusCode >= 300:
+		return fmt.Errorf("push endpoint returned %s", resp.Status)
+	}
+	return nil
+}
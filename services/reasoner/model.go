@@ -8,19 +8,25 @@ package reasoner
 import (
 	"strings"
 	"time"
+
+	"github.com/sarifsystems/sarif/pkg/sparql"
 )
 
 type Fact struct {
-	Id int64 `json:"-"`
-
 	Subject   string `json:"subject"`
 	Predicate string `json:"predicate"`
 	Object    string `json:"object"`
 
-	SubjectType   string    `json:"subject_type" sql:"-"`
-	PredicateType string    `json:"predicate_type" sql:"-"`
-	ObjectType    string    `json:"object_type" sql:"-"`
-	UpdatedAt     time.Time `json:"updated_at,omitempty" sql:"index"`
+	SubjectType   string    `json:"subject_type"`
+	PredicateType string    `json:"predicate_type"`
+	ObjectType    string    `json:"object_type"`
+	UpdatedAt     time.Time `json:"updated_at,omitempty"`
+
+	// Source records where the fact originated from, e.g. "user" or the
+	// name of an external knowledge provider. Confidence ranges from 0 to 1,
+	// zero meaning unknown.
+	Source     string  `json:"source,omitempty"`
+	Confidence float64 `json:"confidence,omitempty"`
 }
 
 func (f Fact) String() string {
@@ -67,6 +73,47 @@ func (f *Fact) FillMissingTypes() {
 	}
 }
 
+// Statement converts the fact into an RDF statement, expanding prefixed
+// names with the common prefixes.
+func (f Fact) Statement() sparql.Statement {
+	f.FillMissingTypes()
+	return sparql.Statement{
+		Subject:   toResource(f.Subject, f.SubjectType),
+		Predicate: toResource(f.Predicate, f.PredicateType),
+		Object:    toResource(f.Object, f.ObjectType),
+	}
+}
+
+// FactFromStatement converts an RDF statement into a fact, abbreviating
+// URIs with the common prefixes.
+func FactFromStatement(st sparql.Statement) *Fact {
+	f := &Fact{}
+	f.Subject, f.SubjectType = fromResource(st.Subject)
+	f.Predicate, f.PredicateType = fromResource(st.Predicate)
+	f.Object, f.ObjectType = fromResource(st.Object)
+	return f
+}
+
+func toResource(v, t string) sparql.Resource {
+	if t != "uri" {
+		return sparql.Literal(v)
+	}
+	if strings.HasPrefix(v, "_:") {
+		return sparql.Blank(v[2:])
+	}
+	return sparql.URI(ExpandNamespacePrefix(v, sparql.CommonPrefixes))
+}
+
+func fromResource(r sparql.Resource) (string, string) {
+	switch r.Type {
+	case sparql.TypeURI:
+		return AddNamespacePrefix(r.Value, sparql.CommonPrefixes), "uri"
+	case sparql.TypeBlank:
+		return "_:" + r.Value, "uri"
+	}
+	return r.Value, "literal"
+}
+
 func GetLabelMappings(fs []*Fact) (map[string]string, []string) {
 	mappings := make(map[string]string)
 	for _, f := range fs {
@@ -0,0 +1,70 @@
+// Copyright (C) 2017 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package reasoner
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sarifsystems/sarif/pkg/sparql"
+)
+
+func TestFactStatement(t *testing.T) {
+	f := Fact{Subject: "dbpedia:Paris", Predicate: "rdfs:label", Object: "Paris"}
+	st := f.Statement()
+	if st.Subject.Value != "http://dbpedia.org/resource/Paris" || st.Subject.Type != sparql.TypeURI {
+		t.Errorf("unexpected subject: %+v", st.Subject)
+	}
+	if st.Object.Type != sparql.TypeLiteral || st.Object.Value != "Paris" {
+		t.Errorf("unexpected object: %+v", st.Object)
+	}
+
+	back := FactFromStatement(st)
+	if back.Subject != f.Subject || back.Predicate != f.Predicate || back.Object != f.Object {
+		t.Errorf("roundtrip mismatch: %+v", back)
+	}
+
+	if got := ExpandNamespacePrefix("sarif://schema/x", sparql.CommonPrefixes); got != "sarif://schema/x" {
+		t.Errorf("expanded full URI: %s", got)
+	}
+}
+
+func TestScanPrefix(t *testing.T) {
+	s, p, o := sparql.URI("s"), sparql.URI("p"), sparql.Literal("o")
+	tests := []struct {
+		s, p, o  *sparql.Resource
+		expected string
+	}{
+		{&s, &p, nil, "spo::<s>::<p>::"},
+		{&s, nil, &o, `osp::"o"::<s>::`},
+		{nil, &p, &o, `pos::<p>::"o"::`},
+		{nil, nil, &o, `osp::"o"::`},
+		{nil, nil, nil, "spo::"},
+	}
+	for _, test := range tests {
+		if got := scanPrefix(test.s, test.p, test.o); got != test.expected {
+			t.Errorf("expected %s, got %s", test.expected, got)
+		}
+	}
+
+	for _, key := range indexKeys(sparql.Statement{Subject: s, Predicate: p, Object: o}) {
+		if !strings.Contains(key, "<s>") || !strings.Contains(key, `"o"`) {
+			t.Errorf("unexpected index key %s", key)
+		}
+	}
+}
+
+func TestAssignURIs(t *testing.T) {
+	f := Fact{Subject: "my car", Predicate: "color", Object: "red"}
+	f.FillMissingTypes()
+	labels := assignURIs(&f)
+	if f.Subject != "sarif:my_car" || f.Predicate != "sarif:color" || f.Object != "red" {
+		t.Errorf("unexpected fact: %+v", f)
+	}
+	if len(labels) != 2 || labels[0].Object != "my car" || labels[1].Object != "color" {
+		t.Errorf("unexpected labels: %+v", labels)
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright (C) 2017 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package reasoner
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sarifsystems/sarif/pkg/sparql"
+	"github.com/sarifsystems/sarif/sarif"
+)
+
+type sparqlPayload struct {
+	Query string `json:"query"`
+}
+
+type bindingsPayload struct {
+	*sparql.SelectResponse
+}
+
+func (p bindingsPayload) Text() string {
+	if len(p.Results.Bindings) == 0 {
+		return "No results."
+	}
+	lines := make([]string, 0, len(p.Results.Bindings))
+	for _, row := range p.Results.Bindings {
+		cols := make([]string, 0, len(p.Head.Vars))
+		for _, v := range p.Head.Vars {
+			if b, ok := row[v]; ok {
+				val, _ := fromResource(b)
+				cols = append(cols, v+"="+val)
+			}
+		}
+		lines = append(lines, strings.Join(cols, ", "))
+	}
+	return strings.Join(lines, "\n")
+}
+
+type descriptionPayload struct {
+	Description sparql.Description `json:"description"`
+}
+
+func (p descriptionPayload) Text() string {
+	if len(p.Description) == 0 {
+		return "Nothing found."
+	}
+	stmts := make([]sparql.Statement, 0)
+	for subj, preds := range p.Description {
+		s := sparql.URI(subj)
+		if strings.HasPrefix(subj, "_:") {
+			s = sparql.Blank(subj[2:])
+		}
+		for pred, objs := range preds {
+			for _, o := range objs {
+				stmts = append(stmts, sparql.Statement{Subject: s, Predicate: sparql.URI(pred), Object: o})
+			}
+		}
+	}
+	sort.Slice(stmts, func(i, j int) bool { return stmts[i].String() < stmts[j].String() })
+	var buf bytes.Buffer
+	sparql.WriteTurtle(&buf, stmts, sparql.CommonPrefixes)
+	return strings.TrimSpace(buf.String())
+}
+
+// HandleSparql runs a SPARQL SELECT or DESCRIBE query against the local
+// triple store.
+func (s *Service) HandleSparql(msg sarif.Message) {
+	var p sparqlPayload
+	if err := msg.DecodePayload(&p); err != nil {
+		s.ReplyBadRequest(msg, err)
+		return
+	}
+	if p.Query == "" {
+		p.Query = msg.Text
+	}
+	if p.Query == "" {
+		s.ReplyBadRequest(msg, errors.New("No query given"))
+		return
+	}
+
+	result, err := s.Local.Exec(p.Query)
+	if err != nil {
+		s.ReplyBadRequest(msg, err)
+		return
+	}
+	switch r := result.(type) {
+	case *sparql.SelectResponse:
+		s.Reply(msg, sarif.CreateMessage("concepts/bindings", bindingsPayload{r}))
+	case sparql.Description:
+		s.Reply(msg, sarif.CreateMessage("concepts/description", descriptionPayload{r}))
+	}
+}
+
+type rdfPayload struct {
+	Format string `json:"format,omitempty"`
+	Data   string `json:"data,omitempty"`
+	Count  int    `json:"count,omitempty"`
+}
+
+func (p rdfPayload) Text() string {
+	if p.Data != "" {
+		return p.Data
+	}
+	return "Imported " + pluralize(p.Count, "statement") + "."
+}
+
+func pluralize(n int, s string) string {
+	if n == 1 {
+		return "1 " + s
+	}
+	return strconv.Itoa(n) + " " + s + "s"
+}
+
+// HandleImport adds the statements of a Turtle or N-Triples document to
+// the triple store.
+func (s *Service) HandleImport(msg sarif.Message) {
+	var p rdfPayload
+	if err := msg.DecodePayload(&p); err != nil {
+		s.ReplyBadRequest(msg, err)
+		return
+	}
+	if p.Data == "" {
+		p.Data = msg.Text
+	}
+
+	stmts, _, err := sparql.ParseTurtle(strings.NewReader(p.Data))
+	if err != nil {
+		s.ReplyBadRequest(msg, err)
+		return
+	}
+	if err := s.Triples.Add(stmts...); err != nil {
+		s.ReplyInternalError(msg, err)
+		return
+	}
+	s.Reply(msg, sarif.CreateMessage("concepts/imported", rdfPayload{Count: len(stmts)}))
+}
+
+// HandleExport dumps the triple store as Turtle or N-Triples.
+func (s *Service) HandleExport(msg sarif.Message) {
+	var p rdfPayload
+	if err := msg.DecodePayload(&p); err != nil {
+		s.ReplyBadRequest(msg, err)
+		return
+	}
+
+	stmts, err := s.Triples.Match(nil, nil, nil)
+	if err != nil {
+		s.ReplyInternalError(msg, err)
+		return
+	}
+
+	var buf bytes.Buffer
+	switch p.Format {
+	case "", "turtle":
+		p.Format = "turtle"
+		err = sparql.WriteTurtle(&buf, stmts, sparql.CommonPrefixes)
+	case "ntriples":
+		err = sparql.WriteNTriples(&buf, stmts)
+	default:
+		s.ReplyBadRequest(msg, errors.New("Unknown format: "+p.Format))
+		return
+	}
+	if err != nil {
+		s.ReplyInternalError(msg, err)
+		return
+	}
+	p.Data = buf.String()
+	p.Count = len(stmts)
+	s.Reply(msg, sarif.CreateMessage("concepts/exported", p))
+}
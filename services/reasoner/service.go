@@ -7,13 +7,14 @@
 package reasoner
 
 import (
+	"errors"
 	"strconv"
 	"strings"
 
-	"github.com/jinzhu/gorm"
 	"github.com/sarifsystems/sarif/pkg/sparql"
 	"github.com/sarifsystems/sarif/sarif"
 	"github.com/sarifsystems/sarif/services"
+	"github.com/sarifsystems/sarif/services/schema/store"
 )
 
 var Module = &services.Module{
@@ -23,41 +24,46 @@ var Module = &services.Module{
 }
 
 type Dependencies struct {
-	DB     *gorm.DB
 	Client sarif.Client
 }
 
 type Service struct {
-	DB *gorm.DB
+	Triples *TripleStore
+	Local   *sparql.LocalEndpoint
 	sarif.Client
 }
 
 func NewService(deps *Dependencies) *Service {
 	s := &Service{
-		DB:     deps.DB,
-		Client: deps.Client,
+		Triples: NewTripleStore(store.New(deps.Client), "triples"),
+		Client:  deps.Client,
 	}
+	s.Local = sparql.NewLocalEndpoint(s.Triples)
 	return s
 }
 
 func (s *Service) Enable() error {
-	createIndizes := !s.DB.HasTable(&Fact{})
-	if err := s.DB.AutoMigrate(&Fact{}).Error; err != nil {
-		return err
-	}
-	if createIndizes {
-		if err := s.DB.Model(&Fact{}).AddIndex("subject_predicate", "subject", "predicate").Error; err != nil {
-			return err
-		}
-		if err := s.DB.Model(&Fact{}).AddIndex("predicate_object", "predicate", "object").Error; err != nil {
-			return err
-		}
-	}
-
 	s.Subscribe("concepts/query", "", s.HandleQuery)
+	s.Subscribe("concepts/query_local", "", s.HandleQueryLocal)
 	s.Subscribe("concepts/query_external", "", s.HandleQueryExternal)
+	s.Subscribe("concepts/sparql", "", s.HandleSparql)
 	s.Subscribe("concepts/store", "", s.HandleStore)
+	s.Subscribe("concepts/import", "", s.HandleImport)
+	s.Subscribe("concepts/export", "", s.HandleExport)
 	s.Subscribe("concept", "", s.HandleStore)
+
+	s.RegisterSchema(sarif.ActionSchema{
+		Action:      "concepts/query",
+		Description: "Find facts matching a pattern.",
+		Request:     sarif.SchemaOf(Fact{}),
+		Response:    sarif.SchemaOf(resultPayload{}),
+	})
+	s.RegisterSchema(sarif.ActionSchema{
+		Action:      "concepts/store",
+		Description: "Store a fact.",
+		Request:     sarif.SchemaOf(Fact{}),
+		Response:    sarif.SchemaOf(Fact{}),
+	})
 	return nil
 }
 
@@ -96,8 +102,8 @@ func (s *Service) HandleQuery(msg sarif.Message) {
 		return
 	}
 
-	var facts []*Fact
-	if err := s.DB.Where(f).Limit(100).Find(&facts).Error; err != nil {
+	facts, err := s.Triples.Find(f, 100)
+	if err != nil {
 		s.ReplyInternalError(msg, err)
 		return
 	}
@@ -112,6 +118,24 @@ func (s *Service) HandleQuery(msg sarif.Message) {
 	}))
 }
 
+func (s *Service) findLabels(field string, values []string) ([]*Fact, error) {
+	results := make([]*Fact, 0)
+	for _, v := range values {
+		f := Fact{Predicate: "rdfs:label", PredicateType: "uri"}
+		if field == "subject" {
+			f.Subject, f.SubjectType = v, "uri"
+		} else {
+			f.Object, f.ObjectType = v, "literal"
+		}
+		fs, err := s.Triples.Find(f, 0)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, fs...)
+	}
+	return results, nil
+}
+
 func (s *Service) InterpretLiterals(f Fact) (Fact, error) {
 	f.FillMissingTypes()
 	literals := make([]string, 0)
@@ -128,12 +152,7 @@ func (s *Service) InterpretLiterals(f Fact) (Fact, error) {
 		return f, nil
 	}
 
-	var results []*Fact
-	err := s.DB.
-		Where("predicate = ?", "rdfs:label").
-		Where("object IN (?)", literals).
-		Find(&results).
-		Error
+	results, err := s.findLabels("object", literals)
 	if err != nil {
 		return f, err
 	}
@@ -148,12 +167,42 @@ func (s *Service) InterpretLiterals(f Fact) (Fact, error) {
 	return f, nil
 }
 
+// assignURIs replaces literal subjects and predicates, which RDF does not
+// allow, with generated URIs labeled by the literal.
+func assignURIs(f *Fact) []*Fact {
+	labels := make([]*Fact, 0)
+	label := func(v string) string {
+		uri := CreateURIFromLiteral(v)
+		labels = append(labels, &Fact{
+			Subject:       uri,
+			SubjectType:   "uri",
+			Predicate:     "rdfs:label",
+			PredicateType: "uri",
+			Object:        v,
+			ObjectType:    "literal",
+			Source:        f.Source,
+		})
+		return uri
+	}
+	if f.SubjectType == "literal" {
+		f.Subject, f.SubjectType = label(f.Subject), "uri"
+	}
+	if f.PredicateType == "literal" {
+		f.Predicate, f.PredicateType = label(f.Predicate), "uri"
+	}
+	return labels
+}
+
 func (s *Service) HandleStore(msg sarif.Message) {
 	var f Fact
 	if err := msg.DecodePayload(&f); err != nil {
 		s.ReplyBadRequest(msg, err)
 		return
 	}
+	if f.Subject == "" || f.Predicate == "" || f.Object == "" {
+		s.ReplyBadRequest(msg, errors.New("Fact needs subject, predicate and object"))
+		return
+	}
 
 	f, err := s.InterpretLiterals(f)
 	if err != nil {
@@ -161,30 +210,28 @@ func (s *Service) HandleStore(msg sarif.Message) {
 		return
 	}
 
-	db := s.DB.Where(Fact{Subject: f.Subject, Predicate: f.Predicate})
-	db = s.DB.Assign(f)
-	if err := db.FirstOrCreate(&f).Error; err != nil {
+	labels := assignURIs(&f)
+	if err := s.Triples.Put(append(labels, &f)...); err != nil {
 		s.ReplyInternalError(msg, err)
 		return
 	}
 	s.Reply(msg, sarif.CreateMessage("concepts/stored", &f))
 }
 
-func (s *Service) HandleQueryExternal(msg sarif.Message) {
+func (s *Service) queryEndpoint(msg sarif.Message, q *sparql.Query) ([]*Fact, bool) {
 	var f Fact
 	if err := msg.DecodePayload(&f); err != nil {
 		s.ReplyBadRequest(msg, err)
-		return
+		return nil, false
 	}
 	facts := []*Fact{&f}
 	FillVariables(facts)
 
 	var r sparql.ResourceResponse
-	q := sparql.DBPedia.Query()
 	q = BuildQuery(q, facts)
 	if err := q.Exec(&r); err != nil {
 		s.ReplyInternalError(msg, err)
-		return
+		return nil, false
 	}
 
 	result := ApplyBindings(facts, r.Results.Bindings, sparql.CommonPrefixes)
@@ -192,11 +239,24 @@ func (s *Service) HandleQueryExternal(msg sarif.Message) {
 		ToJsonLd(result),
 		result,
 	}))
+	return result, true
+}
+
+func (s *Service) HandleQueryLocal(msg sarif.Message) {
+	s.queryEndpoint(msg, s.Local.Query())
+}
+
+func (s *Service) HandleQueryExternal(msg sarif.Message) {
+	result, ok := s.queryEndpoint(msg, sparql.DBPedia.Query())
+	if !ok {
+		return
+	}
 
 	for _, f := range result {
-		if err := s.DB.FirstOrCreate(&f, &f).Error; err != nil {
-			s.Log("err", "[reasoner] error updating external fact: "+err.Error())
-		}
+		f.Source = "dbpedia"
+	}
+	if err := s.Triples.Put(result...); err != nil {
+		s.Log("err", "[reasoner] error updating external facts: "+err.Error())
 	}
 }
 
@@ -206,12 +266,7 @@ func (s *Service) AddLabelFacts(fs []*Fact) ([]*Fact, error) {
 		return fs, nil
 	}
 
-	var results []*Fact
-	err := s.DB.
-		Where("predicate = ?", "rdfs:label").
-		Where("subject IN (?)", missing).
-		Find(&results).
-		Error
+	results, err := s.findLabels("subject", missing)
 	if err != nil {
 		return fs, err
 	}
@@ -232,9 +287,22 @@ func FillVariables(fs []*Fact) {
 	}
 }
 
+// queryTerm formats a fact value for use in a SPARQL query.
+func queryTerm(v, t string) string {
+	if strings.HasPrefix(v, "?") || t == "uri" {
+		return v
+	}
+	return strconv.Quote(v)
+}
+
 func BuildQuery(q *sparql.Query, facts []*Fact) *sparql.Query {
 	for _, f := range facts {
-		q = q.Where(f.Subject, f.Predicate, f.Object)
+		f.FillMissingTypes()
+		q = q.Where(
+			queryTerm(f.Subject, f.SubjectType),
+			queryTerm(f.Predicate, f.PredicateType),
+			queryTerm(f.Object, f.ObjectType),
+		)
 		if f.Predicate == "rdfs:label" {
 			q = q.FilterLang(f.Object, "EN")
 		}
@@ -280,6 +348,18 @@ func ApplyBindings(fs []*Fact, bs []sparql.Row, ns map[string]string) []*Fact {
 	return result
 }
 
+// ExpandNamespacePrefix is the inverse of AddNamespacePrefix.
+func ExpandNamespacePrefix(s string, ns map[string]string) string {
+	i := strings.Index(s, ":")
+	if i < 0 || strings.HasPrefix(s[i+1:], "//") {
+		return s
+	}
+	if uri, ok := ns[s[0:i]]; ok {
+		return uri + s[i+1:]
+	}
+	return s
+}
+
 func AddNamespacePrefix(s string, ns map[string]string) string {
 	for prefix, uri := range ns {
 		if strings.HasPrefix(s, uri) {
@@ -0,0 +1,194 @@
+// Copyright (C) 2017 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package reasoner
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/sarifsystems/sarif/pkg/sparql"
+	"github.com/sarifsystems/sarif/services/schema/store"
+)
+
+// batchSize limits the number of statements written per store request.
+const batchSize = 100
+
+var errLimitReached = errors.New("limit reached")
+
+// TripleStore keeps RDF statements in a collection of the sarif store,
+// indexed by subject, predicate and object.
+type TripleStore struct {
+	Store      *store.Store
+	Collection string
+}
+
+func NewTripleStore(st *store.Store, collection string) *TripleStore {
+	return &TripleStore{
+		Store:      st,
+		Collection: collection,
+	}
+}
+
+type storedTriple struct {
+	sparql.Statement
+	Source     string    `json:"source,omitempty"`
+	Confidence float64   `json:"confidence,omitempty"`
+	UpdatedAt  time.Time `json:"updated_at,omitempty"`
+}
+
+// indexKeys returns the SPO, POS and OSP keys of a statement.
+func indexKeys(st sparql.Statement) []string {
+	s, p, o := st.Subject.String(), st.Predicate.String(), st.Object.String()
+	return []string{
+		"spo::" + s + "::" + p + "::" + o,
+		"pos::" + p + "::" + o + "::" + s,
+		"osp::" + o + "::" + s + "::" + p,
+	}
+}
+
+// scanPrefix picks the index that covers the most bound terms of a pattern.
+func scanPrefix(s, p, o *sparql.Resource) string {
+	switch {
+	case s != nil && p != nil && o != nil:
+		return "spo::" + s.String() + "::" + p.String() + "::" + o.String()
+	case s != nil && p != nil:
+		return "spo::" + s.String() + "::" + p.String() + "::"
+	case s != nil && o != nil:
+		return "osp::" + o.String() + "::" + s.String() + "::"
+	case s != nil:
+		return "spo::" + s.String() + "::"
+	case p != nil && o != nil:
+		return "pos::" + p.String() + "::" + o.String() + "::"
+	case p != nil:
+		return "pos::" + p.String() + "::"
+	case o != nil:
+		return "osp::" + o.String() + "::"
+	}
+	return "spo::"
+}
+
+func (t *TripleStore) write(cmd string, triples []storedTriple) error {
+	for len(triples) > 0 {
+		n := len(triples)
+		if n > batchSize {
+			n = batchSize
+		}
+		cmds := make([]store.Command, 0, 3*n)
+		for _, tr := range triples[0:n] {
+			for _, key := range indexKeys(tr.Statement) {
+				c := store.Command{Type: cmd, Key: t.Collection + "/" + key}
+				if cmd == "put" {
+					c.Value = tr
+				}
+				cmds = append(cmds, c)
+			}
+		}
+		var result interface{}
+		if err := t.Store.Batch(cmds, &result); err != nil {
+			return err
+		}
+		triples = triples[n:]
+	}
+	return nil
+}
+
+// Add stores plain RDF statements.
+func (t *TripleStore) Add(stmts ...sparql.Statement) error {
+	triples := make([]storedTriple, len(stmts))
+	now := time.Now()
+	for i, st := range stmts {
+		triples[i] = storedTriple{Statement: st, UpdatedAt: now}
+	}
+	return t.write("put", triples)
+}
+
+// Put stores facts along with their provenance.
+func (t *TripleStore) Put(facts ...*Fact) error {
+	triples := make([]storedTriple, len(facts))
+	for i, f := range facts {
+		if f.UpdatedAt.IsZero() {
+			f.UpdatedAt = time.Now()
+		}
+		triples[i] = storedTriple{
+			Statement:  f.Statement(),
+			Source:     f.Source,
+			Confidence: f.Confidence,
+			UpdatedAt:  f.UpdatedAt,
+		}
+	}
+	return t.write("put", triples)
+}
+
+// Remove deletes the statements from all indexes.
+func (t *TripleStore) Remove(stmts ...sparql.Statement) error {
+	triples := make([]storedTriple, len(stmts))
+	for i, st := range stmts {
+		triples[i] = storedTriple{Statement: st}
+	}
+	return t.write("del", triples)
+}
+
+func (t *TripleStore) scan(s, p, o *sparql.Resource, limit int, fn func(storedTriple)) error {
+	n := 0
+	err := t.Store.ScanAll(t.Collection+"/"+scanPrefix(s, p, o), store.Scan{}, func(key string, v json.RawMessage) error {
+		var tr storedTriple
+		if err := json.Unmarshal(v, &tr); err != nil {
+			return err
+		}
+		if !tr.Matches(s, p, o) {
+			return nil
+		}
+		fn(tr)
+		n++
+		if limit > 0 && n >= limit {
+			return errLimitReached
+		}
+		return nil
+	})
+	if err == errLimitReached {
+		return nil
+	}
+	return err
+}
+
+// Match implements sparql.Graph.
+func (t *TripleStore) Match(s, p, o *sparql.Resource) ([]sparql.Statement, error) {
+	stmts := make([]sparql.Statement, 0)
+	err := t.scan(s, p, o, 0, func(tr storedTriple) {
+		stmts = append(stmts, tr.Statement)
+	})
+	return stmts, err
+}
+
+// Find returns the facts matching the pattern, empty fields matching
+// anything.
+func (t *TripleStore) Find(f Fact, limit int) ([]*Fact, error) {
+	f.FillMissingTypes()
+	var s, p, o *sparql.Resource
+	if f.Subject != "" {
+		r := toResource(f.Subject, f.SubjectType)
+		s = &r
+	}
+	if f.Predicate != "" {
+		r := toResource(f.Predicate, f.PredicateType)
+		p = &r
+	}
+	if f.Object != "" {
+		r := toResource(f.Object, f.ObjectType)
+		o = &r
+	}
+
+	facts := make([]*Fact, 0)
+	err := t.scan(s, p, o, limit, func(tr storedTriple) {
+		fact := FactFromStatement(tr.Statement)
+		fact.Source = tr.Source
+		fact.Confidence = tr.Confidence
+		fact.UpdatedAt = tr.UpdatedAt
+		facts = append(facts, fact)
+	})
+	return facts, err
+}
@@ -7,11 +7,13 @@
 package scheduler
 
 import (
+	"errors"
 	"math/rand"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/sarifsystems/sarif/pkg/schema"
 	"github.com/sarifsystems/sarif/pkg/util"
 	"github.com/sarifsystems/sarif/sarif"
 	"github.com/sarifsystems/sarif/services"
@@ -49,6 +51,22 @@ func (s *Scheduler) Enable() error {
 	if err := s.Subscribe("schedule", "", s.handle); err != nil {
 		return err
 	}
+
+	s.RegisterSchema(sarif.ActionSchema{
+		Action:      "schedule",
+		Description: "Schedule a message or reminder.",
+		Request:     sarif.SchemaOf(ScheduleMessage{}),
+		Response:    sarif.SchemaOf(Task{}),
+	})
+	snooze := sarif.SchemaOf(SnoozePayload{})
+	snooze.Required = []string{"task"}
+	s.RegisterSchema(sarif.ActionSchema{
+		Action:      "schedule/snooze",
+		Description: "Postpone a finished task.",
+		Request:     snooze,
+		Response:    sarif.SchemaOf(Task{}),
+	})
+
 	go s.simpleCron()
 	go func() {
 		time.Sleep(5 * time.Second)
@@ -76,6 +94,11 @@ func futureTime(t time.Time) time.Time {
 }
 
 func (s *Scheduler) handle(msg sarif.Message) {
+	if msg.IsAction("schedule/snooze") {
+		s.handleSnooze(msg)
+		return
+	}
+
 	var t ScheduleMessage
 	if err := msg.DecodePayload(&t); err != nil {
 		s.ReplyBadRequest(msg, err)
@@ -172,8 +195,67 @@ func (s *Scheduler) taskFinished() {
 	if _, err := s.Store.Put(t.Key(), &t); err != nil {
 		s.Log("err/internal", "could not store finished task: "+err.Error())
 	}
-	s.Publish(s.nextTask.Reply)
+	s.Publish(withSnooze(t))
+	go s.recalculateTimer()
+}
+
+type SnoozePayload struct {
+	Task     string `json:"task"`
+	Duration string `json:"duration"`
+}
+
+// withSnooze offers to snooze reminders that are shown to the user.
+func withSnooze(t Task) sarif.Message {
+	msg := t.Reply
+	if !msg.IsAction("notify") && !msg.IsAction("schedule/finished") {
+		return msg
+	}
+
+	var p map[string]interface{}
+	if err := msg.DecodePayload(&p); err != nil {
+		return t.Reply
+	}
+	if p == nil {
+		p = make(map[string]interface{})
+	}
+	actions, _ := p["actions"].([]interface{})
+	p["actions"] = append(actions, schema.Action{
+		Name:    "Snooze 10 min",
+		Reply:   "schedule/snooze",
+		Payload: SnoozePayload{t.Key(), "10m"},
+	})
+	if err := msg.EncodePayload(p); err != nil {
+		return t.Reply
+	}
+	return msg
+}
+
+func (s *Scheduler) handleSnooze(msg sarif.Message) {
+	var p SnoozePayload
+	if err := msg.DecodePayload(&p); err != nil || p.Task == "" {
+		s.ReplyBadRequest(msg, errors.New("Please specify the task to snooze."))
+		return
+	}
+	dur, err := util.ParseDuration(p.Duration)
+	if err != nil {
+		s.ReplyBadRequest(msg, err)
+		return
+	}
+
+	var t Task
+	if err := s.Store.Get(p.Task, &t); err != nil {
+		s.ReplyInternalError(msg, err)
+		return
+	}
+	t.Time = time.Now().Add(dur)
+	t.Finished = false
+	if _, err := s.Store.Put(t.Key(), &t); err != nil {
+		s.ReplyInternalError(msg, err)
+		return
+	}
+
 	go s.recalculateTimer()
+	s.Reply(msg, sarif.CreateMessage("schedule/created", t))
 }
 
 func (s *Scheduler) simpleCron() {
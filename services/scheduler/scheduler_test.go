@@ -0,0 +1,173 @@
+// Copyright (C) 2014 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package scheduler
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/sarifsystems/sarif/pkg/schema"
+	"github.com/sarifsystems/sarif/sarif"
+	storeservice "github.com/sarifsystems/sarif/services/store"
+	"github.com/sarifsystems/sarif/transports/sfproto"
+
+	_ "github.com/sarifsystems/sarif/services/store/bolt"
+)
+
+type testConfig struct {
+	data []byte
+	dir  string
+}
+
+func (c *testConfig) Exists() bool { return c.data != nil }
+func (c *testConfig) Set(v interface{}) error {
+	var err error
+	c.data, err = json.Marshal(v)
+	return err
+}
+func (c *testConfig) Get(v interface{}) (error, bool) {
+	if c.data == nil {
+		return nil, false
+	}
+	return json.Unmarshal(c.data, v), true
+}
+func (c *testConfig) Dir() string { return c.dir }
+
+func TestWithSnooze(t *testing.T) {
+	task := Task{Time: time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)}
+	tests := []struct {
+		Name    string
+		Action  string
+		Payload string
+		Actions int
+		Keep    bool
+	}{
+		{"reminder", "schedule/finished", "", 1, false},
+		{"null payload", "notify", "null", 1, false},
+		{"notification", "notify", `{"title":"Tea","actions":[{"name":"Done"}]}`, 2, false},
+		{"other action", "light/on", "", 0, true},
+		{"array payload", "notify", `["not","an","object"]`, 0, true},
+		{"string payload", "notify", `"tea"`, 0, true},
+	}
+
+	for _, test := range tests {
+		task.Reply = sarif.Message{Action: test.Action, Text: "Tea is ready."}
+		if test.Payload != "" {
+			task.Reply.Payload.Raw = []byte(test.Payload)
+		}
+		msg := withSnooze(task)
+		if test.Keep {
+			if string(msg.Payload.Raw) != string(task.Reply.Payload.Raw) {
+				t.Errorf("%s: expected payload to be kept, got %s", test.Name, msg.Payload)
+			}
+			continue
+		}
+
+		var p struct {
+			Title   string          `json:"title"`
+			Actions []schema.Action `json:"actions"`
+		}
+		if err := msg.DecodePayload(&p); err != nil {
+			t.Fatal(test.Name, err)
+		}
+		if len(p.Actions) != test.Actions {
+			t.Errorf("%s: expected %d actions, got %v", test.Name, test.Actions, p.Actions)
+			continue
+		}
+		snooze := p.Actions[len(p.Actions)-1]
+		if snooze.Reply != "schedule/snooze" {
+			t.Errorf("%s: unexpected snooze action %+v", test.Name, snooze)
+		}
+		if test.Name == "notification" && p.Title != "Tea" {
+			t.Errorf("%s: expected other fields to be kept, got %s", test.Name, msg.Payload)
+		}
+	}
+}
+
+func TestSnooze(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sarif-scheduler-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	broker := sfproto.NewBroker()
+	storeClient, err := broker.NewClient(sarif.ClientInfo{Name: "store"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := storeservice.NewService(&storeservice.Dependencies{
+		Config: &testConfig{dir: dir},
+		Client: storeClient,
+	})
+	if err := st.Enable(); err != nil {
+		t.Fatal(err)
+	}
+	client, err := broker.NewClient(sarif.ClientInfo{Name: "scheduler"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewService(&Dependencies{Client: client})
+	if err := s.Subscribe("schedule", "", s.handle); err != nil {
+		t.Fatal(err)
+	}
+	user, err := broker.NewClient(sarif.ClientInfo{Name: "user"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := func(p interface{}) sarif.Message {
+		select {
+		case reply := <-user.Request(sarif.CreateMessage("schedule/snooze", p)):
+			return reply
+		case <-time.After(time.Second):
+			t.Fatal("no reply to snooze")
+		}
+		return sarif.Message{}
+	}
+
+	finished := Task{
+		Time:     time.Now().Add(-time.Minute),
+		Reply:    sarif.Message{Action: "schedule/finished", Text: "Tea is ready."},
+		Finished: true,
+	}
+	if _, err := s.Store.Put(finished.Key(), &finished); err != nil {
+		t.Fatal(err)
+	}
+
+	// Snooze as offered in the notification
+	var offered struct {
+		Actions []schema.Action `json:"actions"`
+	}
+	if err := withSnooze(finished).DecodePayload(&offered); err != nil || len(offered.Actions) != 1 {
+		t.Fatal("expected snooze action, got", offered, err)
+	}
+	reply := request(offered.Actions[0].Payload)
+	var snoozed Task
+	if err := reply.DecodePayload(&snoozed); err != nil || reply.Action != "schedule/created" {
+		t.Fatal("unexpected reply", reply, err)
+	}
+	if d := snoozed.Time.Sub(time.Now()); d < 9*time.Minute || d > 10*time.Minute {
+		t.Errorf("expected task to be postponed by 10 minutes, got %v", d)
+	}
+	var stored Task
+	if err := s.Store.Get(snoozed.Key(), &stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.Finished || stored.Reply.Text != "Tea is ready." {
+		t.Errorf("unexpected stored task: %+v", stored)
+	}
+
+	if reply := request(SnoozePayload{}); !reply.IsAction("err/badrequest") {
+		t.Error("expected missing task to be rejected, got", reply)
+	}
+	if reply := request(SnoozePayload{finished.Key(), "soon"}); !reply.IsAction("err/badrequest") {
+		t.Error("expected invalid duration to be rejected, got", reply)
+	}
+}
@@ -6,7 +6,9 @@
 package store
 
 import (
+	"encoding/json"
 	"errors"
+	"strings"
 
 	"github.com/sarifsystems/sarif/sarif"
 )
@@ -88,6 +90,76 @@ func (s *Store) Scan(key string, p Scan, result interface{}) error {
 	return reply.DecodePayload(result)
 }
 
+type docsPayload struct {
+	Keys   []string          `json:"keys"`
+	Values []json.RawMessage `json:"values"`
+}
+
+// ScanAll pages through all documents matching the scan and calls f for
+// each of them. A limit in p is used as page size.
+func (s *Store) ScanAll(key string, p Scan, f func(key string, value json.RawMessage) error) error {
+	collection, prefix := key, ""
+	if i := strings.Index(key, "/"); i >= 0 {
+		collection, prefix = key[0:i], key[i+1:]
+	}
+	if p.Prefix == "" {
+		p.Prefix = prefix
+	}
+	if p.Prefix != "" {
+		if p.Start == "" {
+			p.Start = p.Prefix
+		}
+		if p.End == "" {
+			p.End = p.Prefix + "~~~~~"
+		}
+		p.Prefix = ""
+	}
+	if p.Limit <= 0 {
+		p.Limit = 100
+	}
+	p.Only = ""
+
+	last := ""
+	for {
+		var page docsPayload
+		if err := s.Scan(collection, p, &page); err != nil {
+			return err
+		}
+		n := 0
+		for i, k := range page.Keys {
+			if last != "" && k == last {
+				continue
+			}
+			n++
+			if err := f(k, page.Values[i]); err != nil {
+				return err
+			}
+		}
+		if n == 0 || len(page.Keys) < p.Limit {
+			return nil
+		}
+
+		last = page.Keys[len(page.Keys)-1]
+		if p.Reverse {
+			p.End = last
+		} else {
+			p.Start = last
+		}
+	}
+}
+
+// Collections lists all collections of the store.
+func (s *Store) Collections() ([]string, error) {
+	var cols []string
+	req := sarif.CreateMessage("store/collections", nil)
+	req.Destination = s.StoreName
+	reply, ok := <-s.client.Request(req)
+	if err := checkErr(reply, ok); err != nil {
+		return nil, err
+	}
+	return cols, reply.DecodePayload(&cols)
+}
+
 type Command struct {
 	Type  string      `json:"type"`
 	Key   string      `json:"key"`
@@ -82,6 +82,17 @@ func (s *Store) Get(collection, key string) (*store.Document, error) {
 	return doc, err
 }
 
+func (s *Store) Collections() ([]string, error) {
+	cols := make([]string, 0)
+	err := s.DB.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			cols = append(cols, string(name))
+			return nil
+		})
+	})
+	return cols, err
+}
+
 type boltCursor struct {
 	Collection string
 	First      bool
@@ -98,4 +98,13 @@ func TestBoltStore(t *testing.T) {
 	if doc != nil {
 		t.Errorf("expected no document, got %+v", doc)
 	}
+
+	// List collections
+	cols, err := st.Collections()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cols) != 1 || cols[0] != "default" {
+		t.Error("unexpected collections:", cols)
+	}
 }
@@ -73,6 +73,37 @@ func (s *Store) Scan(collection, min, max string, reverse bool) (store.Cursor, e
 	return nil, errors.New("not supported yet by driver")
 }
 
+// Collections lists all indices, except for the hidden system indices.
+func (s *Store) Collections() ([]string, error) {
+	req := esapi.CatIndicesRequest{
+		Format: "json",
+		H:      []string{"index"},
+		S:      []string{"index"},
+	}
+	res, err := req.Do(context.Background(), s.Client)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return nil, fmt.Errorf("Error listing indices: %s", res.Status())
+	}
+
+	var indices []struct {
+		Index string `json:"index"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&indices); err != nil {
+		return nil, err
+	}
+	cols := make([]string, 0, len(indices))
+	for _, idx := range indices {
+		if !strings.HasPrefix(idx.Index, ".") {
+			cols = append(cols, idx.Index)
+		}
+	}
+	return cols, nil
+}
+
 func toID(key string) string {
 	return strings.Replace(key, "/", "__", -1)
 }
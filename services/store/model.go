@@ -28,6 +28,12 @@ type Store interface {
 	Scan(collection, min, max string, reverse bool) (Cursor, error)
 }
 
+// CollectionLister is implemented by drivers that can enumerate their
+// collections.
+type CollectionLister interface {
+	Collections() ([]string, error)
+}
+
 type Cursor interface {
 	Next() *Document
 	Close() error
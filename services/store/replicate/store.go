@@ -66,3 +66,10 @@ func (s *Store) Put(doc *store.Document) (*store.Document, error) {
 
 	return s.Replica.Put(doc)
 }
+
+func (s *Store) Collections() ([]string, error) {
+	if l, ok := s.Store.(store.CollectionLister); ok {
+		return l.Collections()
+	}
+	return nil, errors.New("not supported yet by driver")
+}
@@ -73,6 +73,7 @@ func (s *Service) Enable() (err error) {
 	s.Subscribe("store/del", "", s.handleDel)
 	s.Subscribe("store/scan", "", s.handleScan)
 	s.Subscribe("store/batch", "", s.handleBatch)
+	s.Subscribe("store/collections", "", s.handleCollections)
 	return nil
 }
 
@@ -165,6 +166,20 @@ func (s *Service) handleDel(msg sarif.Message) {
 	s.Publish(sarif.CreateMessage("store/deleted/"+collection+"/"+key, nil))
 }
 
+func (s *Service) handleCollections(msg sarif.Message) {
+	l, ok := s.Store.(CollectionLister)
+	if !ok {
+		s.ReplyBadRequest(msg, errors.New("Store driver "+s.Cfg.Driver+" cannot list collections."))
+		return
+	}
+	cols, err := l.Collections()
+	if err != nil {
+		s.ReplyInternalError(msg, err)
+		return
+	}
+	s.Reply(msg, sarif.CreateMessage("store/collections", cols))
+}
+
 type BatchCommand struct {
 	Type  string          `json:"type"`
 	Key   string          `json:"key"`
@@ -0,0 +1,430 @@
+// Copyright (C) 2019 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vdir
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sarifsystems/sarif/pkg/schema"
+	"github.com/xconstruct/vdir"
+)
+
+type Event struct {
+	schema.Thing
+
+	Uid         string    `json:"uid,omitempty"`
+	Summary     string    `json:"summary,omitempty"`
+	Description string    `json:"description,omitempty"`
+	Location    string    `json:"location,omitempty"`
+	Categories  []string  `json:"category,omitempty"`
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	AllDay      bool      `json:"all_day,omitempty"`
+	RRule       string    `json:"rrule,omitempty"`
+	Calendar    string    `json:"calendar,omitempty"`
+
+	RecurrenceId time.Time   `json:"recurrence_id,omitempty"`
+	ExDates      []time.Time `json:"-"`
+	Path         string      `json:"-"`
+}
+
+func (e Event) String() string {
+	if e.AllDay {
+		return e.Start.Local().Format("Mon Jan 2") + ": " + e.Summary
+	}
+	return e.Start.Local().Format("Mon Jan 2 15:04") + ": " + e.Summary
+}
+
+func (e Event) Duration() time.Duration {
+	if e.End.IsZero() {
+		return 0
+	}
+	return e.End.Sub(e.Start)
+}
+
+// Instances expands a recurring event into all occurrences that overlap
+// with the range between from and to.
+func (e *Event) Instances(from, to time.Time) ([]Event, error) {
+	dur := e.Duration()
+	if e.RRule == "" {
+		if overlaps(e.Start, e.End, from, to) {
+			return []Event{*e}, nil
+		}
+		return nil, nil
+	}
+
+	r, err := ParseRecurrence(e.RRule, e.Start.Location())
+	if err != nil {
+		return nil, err
+	}
+	instances := make([]Event, 0)
+	for _, t := range r.Occurrences(e.Start, dur, from, to, e.ExDates) {
+		inst := *e
+		inst.Start = t
+		inst.End = t.Add(dur)
+		inst.RecurrenceId = t
+		instances = append(instances, inst)
+	}
+	return instances, nil
+}
+
+type Todo struct {
+	schema.Thing
+
+	Uid         string    `json:"uid,omitempty"`
+	Summary     string    `json:"summary,omitempty"`
+	Description string    `json:"description,omitempty"`
+	Due         time.Time `json:"due,omitempty"`
+	Status      string    `json:"status,omitempty"`
+	Priority    int       `json:"priority,omitempty"`
+	Completed   time.Time `json:"completed,omitempty"`
+	Calendar    string    `json:"calendar,omitempty"`
+
+	Path string `json:"-"`
+}
+
+func (t Todo) IsDone() bool {
+	return t.Status == "COMPLETED" || t.Status == "CANCELLED"
+}
+
+func (t Todo) String() string {
+	s := "[ ] "
+	if t.IsDone() {
+		s = "[x] "
+	}
+	s += t.Summary
+	if !t.Due.IsZero() {
+		s += " (due " + t.Due.Local().Format("Mon Jan 2 15:04") + ")"
+	}
+	return s
+}
+
+const (
+	icalDateTime    = "20060102T150405"
+	icalDateTimeUTC = "20060102T150405Z"
+	icalDate        = "20060102"
+)
+
+// parseDateTime parses an iCalendar DATE or DATE-TIME value, either in UTC,
+// in the given TZID or as floating time in the default location.
+func parseDateTime(v, tzid string, def *time.Location) (time.Time, bool, error) {
+	loc := def
+	if loc == nil {
+		loc = time.Local
+	}
+	if tzid != "" {
+		if l, err := time.LoadLocation(tzid); err == nil {
+			loc = l
+		}
+	}
+
+	switch {
+	case len(v) == len(icalDate):
+		t, err := time.ParseInLocation(icalDate, v, loc)
+		return t, true, err
+	case strings.HasSuffix(v, "Z"):
+		t, err := time.Parse(icalDateTimeUTC, v)
+		return t, false, err
+	}
+	t, err := time.ParseInLocation(icalDateTime, v, loc)
+	return t, false, err
+}
+
+// parseDuration parses an iCalendar duration such as "-PT15M" or "P1DT2H".
+func parseDuration(v string) (time.Duration, error) {
+	orig := v
+	sign := time.Duration(1)
+	if strings.HasPrefix(v, "-") {
+		sign = -1
+		v = v[1:]
+	}
+	v = strings.TrimPrefix(v, "+")
+	if !strings.HasPrefix(v, "P") {
+		return 0, errors.New("invalid duration: " + orig)
+	}
+	v = v[1:]
+
+	var d time.Duration
+	inTime := false
+	num := ""
+	for _, c := range v {
+		if c >= '0' && c <= '9' {
+			num += string(c)
+			continue
+		}
+		if c == 'T' {
+			inTime = true
+			continue
+		}
+		n, err := strconv.Atoi(num)
+		if err != nil {
+			return 0, errors.New("invalid duration: " + orig)
+		}
+		num = ""
+		switch {
+		case c == 'W':
+			d += time.Duration(n) * 7 * 24 * time.Hour
+		case c == 'D':
+			d += time.Duration(n) * 24 * time.Hour
+		case c == 'H' && inTime:
+			d += time.Duration(n) * time.Hour
+		case c == 'M' && inTime:
+			d += time.Duration(n) * time.Minute
+		case c == 'S' && inTime:
+			d += time.Duration(n) * time.Second
+		default:
+			return 0, errors.New("invalid duration: " + orig)
+		}
+	}
+	return sign * d, nil
+}
+
+// textValue joins a structured value back into its original text.
+func textValue(cl *vdir.ContentLine) string {
+	parts := make([]string, len(cl.Value))
+	for i, v := range cl.Value {
+		parts[i] = strings.Join(v, ",")
+	}
+	return strings.Join(parts, ";")
+}
+
+func lineTime(cl *vdir.ContentLine) (time.Time, bool, error) {
+	return parseDateTime(textValue(cl), cl.Params["TZID"].GetText(), nil)
+}
+
+// ReadCalendar reads all events and todos of an iCalendar file.
+func ReadCalendar(r io.Reader) ([]*Event, []*Todo, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	// The decoder needs a trailing line break to terminate the last line.
+	data = append(bytes.Replace(data, []byte("\r\n"), []byte("\n"), -1), '\n')
+
+	cal, err := vdir.NewDecoder(bytes.NewReader(data)).ReadObject()
+	if err != nil {
+		return nil, nil, err
+	}
+	if !strings.EqualFold(cal.Profile, "VCALENDAR") {
+		return nil, nil, errors.New("not a calendar: " + cal.Profile)
+	}
+
+	events := make([]*Event, 0)
+	todos := make([]*Todo, 0)
+	for _, o := range cal.Objects {
+		switch strings.ToUpper(o.Profile) {
+		case "VEVENT":
+			e, err := parseEvent(o)
+			if err != nil {
+				return nil, nil, err
+			}
+			events = append(events, e)
+		case "VTODO":
+			t, err := parseTodo(o)
+			if err != nil {
+				return nil, nil, err
+			}
+			todos = append(todos, t)
+		}
+	}
+	return events, todos, nil
+}
+
+func parseEvent(o *vdir.Object) (*Event, error) {
+	e := &Event{}
+	var dur time.Duration
+	for _, cl := range o.Properties {
+		var err error
+		switch strings.ToUpper(cl.Name) {
+		case "UID":
+			e.Uid = textValue(cl)
+		case "SUMMARY":
+			e.Summary = textValue(cl)
+		case "DESCRIPTION":
+			e.Description = textValue(cl)
+		case "LOCATION":
+			e.Location = textValue(cl)
+		case "CATEGORIES":
+			for _, v := range cl.Value {
+				e.Categories = append(e.Categories, v...)
+			}
+		case "DTSTART":
+			e.Start, e.AllDay, err = lineTime(cl)
+		case "DTEND":
+			e.End, _, err = lineTime(cl)
+		case "DURATION":
+			dur, err = parseDuration(textValue(cl))
+		case "RRULE":
+			e.RRule = textValue(cl)
+		case "RECURRENCE-ID":
+			e.RecurrenceId, _, err = lineTime(cl)
+		case "EXDATE":
+			tzid := cl.Params["TZID"].GetText()
+			for _, v := range cl.Value {
+				for _, s := range v {
+					t, _, err := parseDateTime(s, tzid, nil)
+					if err != nil {
+						return nil, err
+					}
+					e.ExDates = append(e.ExDates, t)
+				}
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("event %s: %s: %v", e.Uid, cl.Name, err)
+		}
+	}
+	if e.Start.IsZero() {
+		return nil, errors.New("event " + e.Uid + " has no start")
+	}
+	if e.End.IsZero() {
+		if dur == 0 && e.AllDay {
+			dur = 24 * time.Hour
+		}
+		e.End = e.Start.Add(dur)
+	}
+	e.SchemaContext = "http://www.w3.org/2002/12/cal/ical#"
+	e.SchemaType = "Vevent"
+	e.SchemaId = "sarif://vdir/event/" + e.Uid
+	e.SchemaLabel = e.Summary
+	return e, nil
+}
+
+func parseTodo(o *vdir.Object) (*Todo, error) {
+	t := &Todo{}
+	for _, cl := range o.Properties {
+		var err error
+		switch strings.ToUpper(cl.Name) {
+		case "UID":
+			t.Uid = textValue(cl)
+		case "SUMMARY":
+			t.Summary = textValue(cl)
+		case "DESCRIPTION":
+			t.Description = textValue(cl)
+		case "STATUS":
+			t.Status = strings.ToUpper(textValue(cl))
+		case "PRIORITY":
+			t.Priority, err = strconv.Atoi(textValue(cl))
+		case "DUE":
+			t.Due, _, err = lineTime(cl)
+		case "COMPLETED":
+			t.Completed, _, err = lineTime(cl)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("todo %s: %s: %v", t.Uid, cl.Name, err)
+		}
+	}
+	t.SchemaContext = "http://www.w3.org/2002/12/cal/ical#"
+	t.SchemaType = "Vtodo"
+	t.SchemaId = "sarif://vdir/todo/" + t.Uid
+	t.SchemaLabel = t.Summary
+	return t, nil
+}
+
+func textLine(name, v string) *vdir.ContentLine {
+	return &vdir.ContentLine{Name: name, Value: vdir.StructuredValue{vdir.Value{v}}}
+}
+
+func timeLine(name string, t time.Time, allDay bool) *vdir.ContentLine {
+	if allDay {
+		cl := textLine(name, t.Format(icalDate))
+		cl.Params = map[string]vdir.Value{"VALUE": {"DATE"}}
+		return cl
+	}
+	return textLine(name, t.UTC().Format(icalDateTimeUTC))
+}
+
+func newCalendarObject() *vdir.Object {
+	return &vdir.Object{
+		Profile: "VCALENDAR",
+		Properties: []*vdir.ContentLine{
+			textLine("VERSION", "2.0"),
+			textLine("PRODID", "-//sarif//vdir//EN"),
+		},
+	}
+}
+
+// eventObject converts a new event into an iCalendar VEVENT.
+func eventObject(e *Event) *vdir.Object {
+	o := &vdir.Object{Profile: "VEVENT"}
+	add := func(cl *vdir.ContentLine) {
+		o.Properties = append(o.Properties, cl)
+	}
+	add(textLine("UID", e.Uid))
+	add(timeLine("DTSTAMP", time.Now(), false))
+	add(timeLine("DTSTART", e.Start, e.AllDay))
+	if !e.End.IsZero() {
+		add(timeLine("DTEND", e.End, e.AllDay))
+	}
+	add(textLine("SUMMARY", e.Summary))
+	if e.Description != "" {
+		add(textLine("DESCRIPTION", e.Description))
+	}
+	if e.Location != "" {
+		add(textLine("LOCATION", e.Location))
+	}
+	if len(e.Categories) > 0 {
+		add(&vdir.ContentLine{Name: "CATEGORIES", Value: vdir.StructuredValue{e.Categories}})
+	}
+	if e.RRule != "" {
+		rule := vdir.StructuredValue{}
+		for _, part := range strings.Split(e.RRule, ";") {
+			rule = append(rule, strings.Split(part, ","))
+		}
+		add(&vdir.ContentLine{Name: "RRULE", Value: rule})
+	}
+	return o
+}
+
+// todoObject converts a todo into an iCalendar VTODO.
+func todoObject(t *Todo) *vdir.Object {
+	o := &vdir.Object{Profile: "VTODO"}
+	add := func(cl *vdir.ContentLine) {
+		o.Properties = append(o.Properties, cl)
+	}
+	add(textLine("UID", t.Uid))
+	add(timeLine("DTSTAMP", time.Now(), false))
+	add(textLine("SUMMARY", t.Summary))
+	if t.Description != "" {
+		add(textLine("DESCRIPTION", t.Description))
+	}
+	if !t.Due.IsZero() {
+		add(timeLine("DUE", t.Due, false))
+	}
+	if t.Priority > 0 {
+		add(textLine("PRIORITY", strconv.Itoa(t.Priority)))
+	}
+	add(textLine("STATUS", t.Status))
+	if !t.Completed.IsZero() {
+		add(timeLine("COMPLETED", t.Completed, false))
+	}
+	return o
+}
+
+// WriteCalendar encodes components into a single iCalendar file.
+func WriteCalendar(w io.Writer, components ...*vdir.Object) error {
+	cal := newCalendarObject()
+	cal.Objects = components
+	return vdir.NewEncoder(w).WriteObject(cal)
+}
+
+// setProperty replaces all properties of the given name in an object.
+func setProperty(o *vdir.Object, cl *vdir.ContentLine) {
+	props := o.Properties[:0]
+	for _, p := range o.Properties {
+		if !strings.EqualFold(p.Name, cl.Name) {
+			props = append(props, p)
+		}
+	}
+	o.Properties = append(props, cl)
+}
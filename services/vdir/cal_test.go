@@ -0,0 +1,130 @@
+// Copyright (C) 2019 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vdir
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testCalendar = `BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//test//EN
+BEGIN:VEVENT
+UID:weekly@test
+DTSTART:20190902T080000Z
+DTEND:20190902T090000Z
+SUMMARY:Standup\, daily
+RRULE:FREQ=WEEKLY;BYDAY=MO,WE;COUNT=5
+EXDATE:20190904T080000Z
+END:VEVENT
+BEGIN:VEVENT
+UID:allday@test
+DTSTART;VALUE=DATE:20190910
+SUMMARY:Holiday
+END:VEVENT
+BEGIN:VTODO
+UID:todo@test
+SUMMARY:Buy milk
+DUE:20190903T170000Z
+STATUS:NEEDS-ACTION
+END:VTODO
+END:VCALENDAR`
+
+func TestReadCalendar(t *testing.T) {
+	events, todos, err := ReadCalendar(strings.NewReader(testCalendar))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || len(todos) != 1 {
+		t.Fatalf("expected 2 events and 1 todo, got %d and %d", len(events), len(todos))
+	}
+
+	e := events[0]
+	if e.Summary != "Standup, daily" {
+		t.Errorf("unexpected summary %q", e.Summary)
+	}
+	if e.Duration() != time.Hour {
+		t.Errorf("unexpected duration %s", e.Duration())
+	}
+
+	from := time.Date(2019, 9, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2019, 10, 1, 0, 0, 0, 0, time.UTC)
+	insts, err := e.Instances(from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []string{"2019-09-02", "2019-09-09", "2019-09-11", "2019-09-16"}
+	if len(insts) != len(exp) {
+		t.Fatalf("expected %d instances, got %v", len(exp), insts)
+	}
+	for i, inst := range insts {
+		if got := inst.Start.Format("2006-01-02"); got != exp[i] {
+			t.Errorf("instance %d: expected %s, got %s", i, exp[i], got)
+		}
+	}
+
+	if !events[1].AllDay || events[1].Duration() != 24*time.Hour {
+		t.Errorf("expected all-day event, got %v", events[1])
+	}
+	if todos[0].Summary != "Buy milk" || todos[0].IsDone() {
+		t.Errorf("unexpected todo %v", todos[0])
+	}
+}
+
+func TestRecurrence(t *testing.T) {
+	start := time.Date(2019, 1, 31, 10, 0, 0, 0, time.UTC)
+	tests := map[string][]string{
+		"FREQ=MONTHLY;COUNT=4":               {"2019-01-31", "2019-03-31", "2019-05-31", "2019-07-31"},
+		"FREQ=MONTHLY;BYDAY=-1FR;COUNT=3":    {"2019-01-31", "2019-02-22", "2019-03-29"},
+		"FREQ=DAILY;INTERVAL=10;COUNT=3":     {"2019-01-31", "2019-02-10", "2019-02-20"},
+		"FREQ=YEARLY;UNTIL=20210201T000000Z": {"2019-01-31", "2020-01-31", "2021-01-31"},
+	}
+	from := start
+	to := start.AddDate(5, 0, 0)
+	for rule, exp := range tests {
+		r, err := ParseRecurrence(rule, time.UTC)
+		if err != nil {
+			t.Fatal(rule, err)
+		}
+		occs := r.Occurrences(start, time.Hour, from, to, nil)
+		got := make([]string, len(occs))
+		for i, o := range occs {
+			got[i] = o.Format("2006-01-02")
+		}
+		if strings.Join(got, ",") != strings.Join(exp, ",") {
+			t.Errorf("%s: expected %v, got %v", rule, exp, got)
+		}
+	}
+}
+
+func TestWriteCalendar(t *testing.T) {
+	e := &Event{
+		Uid:     "new@test",
+		Summary: "Dentist",
+		Start:   time.Date(2019, 9, 5, 14, 0, 0, 0, time.UTC),
+		End:     time.Date(2019, 9, 5, 15, 0, 0, 0, time.UTC),
+		RRule:   "FREQ=WEEKLY;BYDAY=TH,FR",
+	}
+	var buf bytes.Buffer
+	if err := WriteCalendar(&buf, eventObject(e)); err != nil {
+		t.Fatal(err)
+	}
+
+	events, _, err := ReadCalendar(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(events))
+	}
+	got := events[0]
+	if got.Uid != e.Uid || got.Summary != e.Summary || !got.Start.Equal(e.Start) || got.RRule != e.RRule {
+		t.Errorf("expected %v, got %v", e, got)
+	}
+}
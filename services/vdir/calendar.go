@@ -0,0 +1,499 @@
+// Copyright (C) 2019 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vdir
+
+import (
+	"bytes"
+	"errors"
+	"hash/fnv"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sarifsystems/sarif/pkg/util"
+	"github.com/sarifsystems/sarif/sarif"
+	"github.com/xconstruct/vdir"
+)
+
+// reminderHorizon is how far ahead reminders are handed to the scheduler.
+// Reminders are refreshed every hour, so it only needs some overlap.
+const reminderHorizon = 26 * time.Hour
+
+func (s *Service) calendarName(path string) string {
+	dir := filepath.Dir(path)
+	if filepath.Clean(dir) == filepath.Clean(s.cfg.CalDir) {
+		return ""
+	}
+	return filepath.Base(dir)
+}
+
+func (s *Service) loadCalendars() error {
+	events := make(map[string]*Event)
+	todos := make(map[string]*Todo)
+	err := filepath.Walk(s.cfg.CalDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || strings.HasPrefix(info.Name(), ".") || !strings.HasSuffix(info.Name(), ".ics") {
+			return nil
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		evs, tds, err := ReadCalendar(f)
+		if err != nil {
+			// A single broken file should not hide the rest of the calendar.
+			s.Log("warn", "[vdir] reading "+path+": "+err.Error())
+			return nil
+		}
+
+		cal := s.calendarName(path)
+		for _, e := range evs {
+			e.Path, e.Calendar = path, cal
+			key := e.Uid
+			if !e.RecurrenceId.IsZero() {
+				key += "/" + e.RecurrenceId.UTC().Format(icalDateTimeUTC)
+			}
+			events[key] = e
+		}
+		for _, t := range tds {
+			t.Path, t.Calendar = path, cal
+			todos[t.Uid] = t
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	s.events = events
+	s.todos = todos
+	s.mutex.Unlock()
+	return nil
+}
+
+// Agenda returns all event instances overlapping with the given range,
+// sorted by their start.
+func (s *Service) Agenda(from, to time.Time, calendar string) ([]Event, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	instances := make([]Event, 0)
+	for _, e := range s.events {
+		if calendar != "" && e.Calendar != calendar {
+			continue
+		}
+		if !e.RecurrenceId.IsZero() {
+			// Modified instances of a recurring event
+			if overlaps(e.Start, e.End, from, to) {
+				instances = append(instances, *e)
+			}
+			continue
+		}
+		insts, err := e.Instances(from, to)
+		if err != nil {
+			s.Log("warn", "[vdir] event "+e.Uid+": "+err.Error())
+			continue
+		}
+		for _, inst := range insts {
+			key := e.Uid + "/" + inst.Start.UTC().Format(icalDateTimeUTC)
+			if _, overridden := s.events[key]; overridden && e.RRule != "" {
+				continue
+			}
+			instances = append(instances, inst)
+		}
+	}
+
+	sort.Slice(instances, func(i, j int) bool {
+		return instances[i].Start.Before(instances[j].Start)
+	})
+	return instances, nil
+}
+
+type AgendaFilter struct {
+	After    time.Time `json:"after,omitempty"`
+	Before   time.Time `json:"before,omitempty"`
+	Calendar string    `json:"calendar,omitempty"`
+}
+
+type agendaPayload struct {
+	After  time.Time `json:"after"`
+	Before time.Time `json:"before"`
+	Events []Event   `json:"events"`
+}
+
+func (a agendaPayload) String() string {
+	if len(a.Events) == 0 {
+		return "No events until " + a.Before.Local().Format("Mon Jan 2 15:04") + "."
+	}
+	lines := make([]string, len(a.Events))
+	for i, e := range a.Events {
+		lines[i] = e.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (s *Service) handleAgenda(msg sarif.Message) {
+	var f AgendaFilter
+	if err := msg.DecodePayload(&f); err != nil {
+		s.ReplyBadRequest(msg, err)
+		return
+	}
+	if f.After.IsZero() {
+		f.After = time.Now()
+	}
+	if f.Before.IsZero() {
+		f.Before = f.After.Add(7 * 24 * time.Hour)
+	}
+
+	events, err := s.Agenda(f.After, f.Before, f.Calendar)
+	if err != nil {
+		s.ReplyInternalError(msg, err)
+		return
+	}
+	s.Reply(msg, sarif.CreateMessage("vdir/agenda", agendaPayload{f.After, f.Before, events}))
+}
+
+func (s *Service) writeComponent(calendar, uid string, o *vdir.Object) (string, error) {
+	dir, err := collectionDir(s.cfg.CalDir, calendar, s.cfg.DefaultCalendar)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := WriteCalendar(&buf, o); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, uid+".ics")
+	if _, err := os.Stat(path); err == nil {
+		return "", errors.New("Calendar entry " + uid + " already exists.")
+	}
+	return path, writeFile(path, buf.Bytes())
+}
+
+func (s *Service) NewEvent(e *Event) error {
+	if e.Summary == "" {
+		return errors.New("Please specify a summary for the event.")
+	}
+	if e.Start.IsZero() {
+		return errors.New("Please specify a start time for the event.")
+	}
+	if e.RRule != "" {
+		if _, err := ParseRecurrence(e.RRule, e.Start.Location()); err != nil {
+			return err
+		}
+	}
+	if e.AllDay {
+		y, m, d := e.Start.Date()
+		e.Start = time.Date(y, m, d, 0, 0, 0, 0, time.Local)
+		if e.End.IsZero() {
+			e.End = e.Start.AddDate(0, 0, 1)
+		}
+	} else if e.End.IsZero() {
+		e.End = e.Start.Add(time.Hour)
+	}
+	if e.End.Before(e.Start) {
+		return errors.New("The event ends before it starts.")
+	}
+	e.Uid = sarif.GenerateId() + "@sarif"
+
+	path, err := s.writeComponent(e.Calendar, e.Uid, eventObject(e))
+	if err != nil {
+		return err
+	}
+	e.Path = path
+	e.Calendar = s.calendarName(path)
+	e.SchemaContext = "http://www.w3.org/2002/12/cal/ical#"
+	e.SchemaType = "Vevent"
+	e.SchemaId = "sarif://vdir/event/" + e.Uid
+	e.SchemaLabel = e.Summary
+
+	s.mutex.Lock()
+	s.events[e.Uid] = e
+	s.mutex.Unlock()
+	go s.scheduleReminders()
+	return nil
+}
+
+type eventPayload struct {
+	Event
+	Time     string `json:"time,omitempty"`
+	Duration string `json:"duration,omitempty"`
+}
+
+func (s *Service) handleEvent(msg sarif.Message) {
+	if msg.IsAction("vdir/event/new") {
+		s.handleEventNew(msg)
+		return
+	}
+
+	uid := strings.TrimPrefix(msg.Action, "vdir/event/")
+	s.mutex.Lock()
+	e, ok := s.events[uid]
+	s.mutex.Unlock()
+	if !ok {
+		s.ReplyBadRequest(msg, errors.New("No event with UID "+uid+" found!"))
+		return
+	}
+	s.Reply(msg, sarif.CreateMessage("vdir/event", e))
+}
+
+func (s *Service) handleEventNew(msg sarif.Message) {
+	var p eventPayload
+	if err := msg.DecodePayload(&p); err != nil {
+		s.ReplyBadRequest(msg, err)
+		return
+	}
+	if p.Summary == "" {
+		p.Summary = msg.Text
+	}
+	if p.Start.IsZero() && p.Time != "" {
+		p.Start = util.ParseTime(p.Time, time.Now())
+	}
+	if p.End.IsZero() && p.Duration != "" {
+		dur, err := util.ParseDuration(p.Duration)
+		if err != nil {
+			s.ReplyBadRequest(msg, err)
+			return
+		}
+		p.End = p.Start.Add(dur)
+	}
+
+	e := p.Event
+	if err := s.NewEvent(&e); err != nil {
+		s.ReplyBadRequest(msg, err)
+		return
+	}
+	s.Reply(msg, sarif.CreateMessage("vdir/event/created", e))
+}
+
+type todoList struct {
+	Todos []*Todo `json:"todos"`
+}
+
+func (l todoList) String() string {
+	if len(l.Todos) == 0 {
+		return "Nothing to do."
+	}
+	lines := make([]string, len(l.Todos))
+	for i, t := range l.Todos {
+		lines[i] = t.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// OpenTodos returns all unfinished todos, ordered by due date.
+func (s *Service) OpenTodos() []*Todo {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	todos := make([]*Todo, 0)
+	for _, t := range s.todos {
+		if !t.IsDone() {
+			todos = append(todos, t)
+		}
+	}
+	sort.Slice(todos, func(i, j int) bool {
+		a, b := todos[i], todos[j]
+		if a.Due.IsZero() != b.Due.IsZero() {
+			return b.Due.IsZero()
+		}
+		if !a.Due.Equal(b.Due) {
+			return a.Due.Before(b.Due)
+		}
+		return a.Summary < b.Summary
+	})
+	return todos
+}
+
+func (s *Service) NewTodo(t *Todo) error {
+	if t.Summary == "" {
+		return errors.New("Please specify a summary for the todo.")
+	}
+	t.Uid = sarif.GenerateId() + "@sarif"
+	t.Status = "NEEDS-ACTION"
+	path, err := s.writeComponent(t.Calendar, t.Uid, todoObject(t))
+	if err != nil {
+		return err
+	}
+	t.Path = path
+	t.Calendar = s.calendarName(path)
+	t.SchemaContext = "http://www.w3.org/2002/12/cal/ical#"
+	t.SchemaType = "Vtodo"
+	t.SchemaId = "sarif://vdir/todo/" + t.Uid
+	t.SchemaLabel = t.Summary
+
+	s.mutex.Lock()
+	s.todos[t.Uid] = t
+	s.mutex.Unlock()
+	return nil
+}
+
+// CompleteTodo marks a todo as completed in its original file, keeping all
+// other properties intact.
+func (s *Service) CompleteTodo(uid string) (*Todo, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	t, ok := s.todos[uid]
+	if !ok {
+		return nil, errors.New("No todo with UID " + uid + " found!")
+	}
+
+	raw, err := ioutil.ReadFile(t.Path)
+	if err != nil {
+		return nil, err
+	}
+	raw = append(bytes.Replace(raw, []byte("\r\n"), []byte("\n"), -1), '\n')
+	cal, err := vdir.NewDecoder(bytes.NewReader(raw)).ReadObject()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	found := false
+	for _, o := range cal.Objects {
+		if !strings.EqualFold(o.Profile, "VTODO") {
+			continue
+		}
+		for _, cl := range o.PropertyMap()["UID"] {
+			if textValue(cl) == uid {
+				found = true
+				setProperty(o, textLine("STATUS", "COMPLETED"))
+				setProperty(o, timeLine("COMPLETED", now, false))
+				setProperty(o, timeLine("LAST-MODIFIED", now, false))
+			}
+		}
+	}
+	if !found {
+		return nil, errors.New("Todo " + uid + " not found in " + t.Path)
+	}
+
+	var buf bytes.Buffer
+	if err := vdir.NewEncoder(&buf).WriteObject(cal); err != nil {
+		return nil, err
+	}
+	if err := writeFile(t.Path, buf.Bytes()); err != nil {
+		return nil, err
+	}
+	t.Status = "COMPLETED"
+	t.Completed = now
+	return t, nil
+}
+
+func (s *Service) handleTodo(msg sarif.Message) {
+	switch {
+	case msg.IsAction("vdir/todo/list"):
+		s.Reply(msg, sarif.CreateMessage("vdir/todo/list", todoList{s.OpenTodos()}))
+
+	case msg.IsAction("vdir/todo/new"):
+		var t Todo
+		if err := msg.DecodePayload(&t); err != nil {
+			s.ReplyBadRequest(msg, err)
+			return
+		}
+		if t.Summary == "" {
+			t.Summary = msg.Text
+		}
+		if err := s.NewTodo(&t); err != nil {
+			s.ReplyBadRequest(msg, err)
+			return
+		}
+		s.Reply(msg, sarif.CreateMessage("vdir/todo/created", t))
+
+	case msg.IsAction("vdir/todo/done"):
+		uid := msg.ActionSuffix("vdir/todo/done")
+		t, err := s.CompleteTodo(uid)
+		if err != nil {
+			s.ReplyBadRequest(msg, err)
+			return
+		}
+		s.Reply(msg, sarif.CreateMessage("vdir/todo/completed", t))
+
+	default:
+		uid := strings.TrimPrefix(msg.Action, "vdir/todo/")
+		s.mutex.Lock()
+		t, ok := s.todos[uid]
+		s.mutex.Unlock()
+		if !ok {
+			s.ReplyBadRequest(msg, errors.New("No todo with UID "+uid+" found!"))
+			return
+		}
+		s.Reply(msg, sarif.CreateMessage("vdir/todo", t))
+	}
+}
+
+type scheduleMessage struct {
+	Time  string        `json:"time"`
+	Reply sarif.Message `json:"reply"`
+}
+
+// reminderTime spreads reminders of simultaneous events by a few
+// microseconds, since the scheduler stores tasks by their time.
+func reminderTime(key string, t time.Time) time.Time {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return t.Add(time.Duration(h.Sum32()%1000000) * time.Microsecond)
+}
+
+// scheduleReminders hands reminders for all upcoming events to the
+// scheduler, which publishes them as calendar/upcoming.
+func (s *Service) scheduleReminders() {
+	before := 15 * time.Minute
+	if s.cfg.RemindBefore != "" {
+		d, err := util.ParseDuration(s.cfg.RemindBefore)
+		if err != nil {
+			s.Log("err", "[vdir] invalid RemindBefore: "+err.Error())
+			return
+		}
+		before = d
+	}
+
+	now := time.Now()
+	events, err := s.Agenda(now, now.Add(reminderHorizon), "")
+	if err != nil {
+		s.Log("err", "[vdir] scheduling reminders: "+err.Error())
+		return
+	}
+	for _, e := range events {
+		if e.AllDay {
+			continue
+		}
+		at := e.Start.Add(-before)
+		if at.Before(now) {
+			continue
+		}
+		key := e.Uid + "/" + e.Start.UTC().Format(icalDateTimeUTC)
+		s.mutex.Lock()
+		done := s.reminders[key]
+		s.reminders[key] = true
+		s.mutex.Unlock()
+		if done {
+			continue
+		}
+
+		reply := sarif.CreateMessage("calendar/upcoming", e)
+		reply.Text = "Upcoming at " + e.Start.Local().Format("15:04") + ": " + e.Summary
+		if e.Location != "" {
+			reply.Text += " (" + e.Location + ")"
+		}
+		s.Publish(sarif.CreateMessage("schedule", scheduleMessage{
+			Time:  reminderTime(key, at).Format(time.RFC3339Nano),
+			Reply: reply,
+		}))
+	}
+}
+
+func (s *Service) reminderLoop() {
+	for {
+		s.scheduleReminders()
+		time.Sleep(time.Hour)
+	}
+}
@@ -32,6 +32,70 @@ type Card struct {
 	IMPP []TypedResource `json:"hasInstantMessaging,omitempty"`
 }
 
+// FillSchema sets the linked data annotations of the card.
+func (c *Card) FillSchema() {
+	c.SchemaContext = "http://www.w3.org/2006/vcard/ns#"
+	c.SchemaType = "Individual"
+	c.SchemaId = "sarif://vdir/card/" + c.Uid
+	c.SchemaLabel = c.FormattedName
+}
+
+// Copy returns a deep copy of the card, so that changes to the copy, such
+// as decoding a payload on top of it, do not alter the original.
+func (c Card) Copy() *Card {
+	c.Name = Name{
+		FamilyName:        copyStrings(c.Name.FamilyName),
+		GivenName:         copyStrings(c.Name.GivenName),
+		AdditionalNames:   copyStrings(c.Name.AdditionalNames),
+		HonorificNames:    copyStrings(c.Name.HonorificNames),
+		HonorificSuffixes: copyStrings(c.Name.HonorificSuffixes),
+	}
+	c.NickName = copyStrings(c.NickName)
+	c.Categories = copyStrings(c.Categories)
+	if c.Addresses != nil {
+		addrs := make([]Address, len(c.Addresses))
+		for i, a := range c.Addresses {
+			a.Type = copyStrings(a.Type)
+			addrs[i] = a
+		}
+		c.Addresses = addrs
+	}
+	c.Telephones = copyTypedValues(c.Telephones)
+	c.Email = copyTypedValues(c.Email)
+	c.Url = copyTypedResources(c.Url)
+	c.IMPP = copyTypedResources(c.IMPP)
+	return &c
+}
+
+func copyStrings(s []string) []string {
+	if s == nil {
+		return nil
+	}
+	return append([]string{}, s...)
+}
+
+func copyTypedValues(vs []TypedValue) []TypedValue {
+	if vs == nil {
+		return nil
+	}
+	c := make([]TypedValue, len(vs))
+	for i, v := range vs {
+		c[i] = TypedValue{copyStrings(v.Type), v.Value}
+	}
+	return c
+}
+
+func copyTypedResources(rs []TypedResource) []TypedResource {
+	if rs == nil {
+		return nil
+	}
+	c := make([]TypedResource, len(rs))
+	for i, r := range rs {
+		c[i] = TypedResource{copyStrings(r.Type), r.Value}
+	}
+	return c
+}
+
 func (c Card) String() string {
 	return "vCard of " + c.FormattedName
 }
@@ -0,0 +1,398 @@
+// Copyright (C) 2019 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vdir
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sarifsystems/sarif/pkg/util"
+	"github.com/sarifsystems/sarif/sarif"
+	"github.com/xconstruct/vdir"
+)
+
+const minCardScore = 0.6
+
+type RankedCard struct {
+	*Card
+	Score float64 `json:"score"`
+}
+
+func digits(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, s)
+}
+
+func wordScore(query []string, text string) float64 {
+	score, _ := util.MatchWords(query, text)
+	return score
+}
+
+// cardScore returns the best match of a query against the names, emails,
+// phone numbers and organization of a card.
+func cardScore(query string, c *Card) float64 {
+	q := strings.ToLower(strings.TrimSpace(query))
+	words := util.NormalizeWords(q)
+	best := 0.0
+	try := func(score float64) {
+		if score > best {
+			best = score
+		}
+	}
+
+	try(wordScore(words, c.FormattedName))
+	names := strings.Join(c.Name.GivenName, " ") + " " + strings.Join(c.Name.FamilyName, " ")
+	try(wordScore(words, names))
+	for _, nick := range c.NickName {
+		try(wordScore(words, nick))
+	}
+	try(0.95 * wordScore(words, c.Org))
+
+	for _, email := range c.Email {
+		addr := strings.ToLower(email.Value)
+		if addr == q {
+			try(1)
+		} else if len(q) >= 3 && strings.Contains(addr, q) {
+			try(0.9)
+		}
+	}
+	if qd := digits(q); len(qd) >= 3 && len(qd) >= len(q)/2 {
+		// Compare without trunk prefixes, so that national and
+		// international notations match.
+		qd = strings.TrimLeft(qd, "0")
+		for _, tel := range c.Telephones {
+			td := strings.TrimLeft(digits(tel.Value), "0")
+			if strings.HasSuffix(td, qd) || (len(td) >= 6 && strings.HasSuffix(qd, td)) {
+				try(1)
+			} else if strings.Contains(td, qd) {
+				try(0.8)
+			}
+		}
+	}
+	return best
+}
+
+// SearchCards finds contacts matching a query, ranked by their score.
+func (s *Service) SearchCards(query string, limit int) []RankedCard {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	ranked := make([]RankedCard, 0)
+	for _, ci := range s.cards {
+		if score := cardScore(query, ci.Card); score >= minCardScore {
+			ranked = append(ranked, RankedCard{ci.Card, score})
+		}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if ranked[i].Score == ranked[j].Score {
+			return ranked[i].FormattedName < ranked[j].FormattedName
+		}
+		return ranked[i].Score > ranked[j].Score
+	})
+	if limit > 0 && len(ranked) > limit {
+		ranked = ranked[0:limit]
+	}
+	return ranked
+}
+
+type cardSearch struct {
+	Query string `json:"query"`
+	Limit int    `json:"limit,omitempty"`
+}
+
+type cardList struct {
+	Query string       `json:"query"`
+	Cards []RankedCard `json:"cards"`
+}
+
+func (l cardList) String() string {
+	if len(l.Cards) == 0 {
+		return "No contacts found for '" + l.Query + "'."
+	}
+	lines := make([]string, len(l.Cards))
+	for i, c := range l.Cards {
+		lines[i] = c.FormattedName
+		if len(c.Email) > 0 {
+			lines[i] += " <" + c.Email[0].Value + ">"
+		}
+		if len(c.Telephones) > 0 {
+			lines[i] += " " + c.Telephones[0].Value
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (s *Service) handleCardSearch(msg sarif.Message) {
+	var p cardSearch
+	if err := msg.DecodePayload(&p); err != nil {
+		s.ReplyBadRequest(msg, err)
+		return
+	}
+	if p.Query == "" {
+		p.Query = msg.Text
+	}
+	if p.Query == "" {
+		s.ReplyBadRequest(msg, errors.New("Please specify a name, email or phone number to search for."))
+		return
+	}
+	if p.Limit <= 0 {
+		p.Limit = 10
+	}
+
+	s.Reply(msg, sarif.CreateMessage("vdir/card/found", cardList{p.Query, s.SearchCards(p.Query, p.Limit)}))
+}
+
+// vcard contains the properties that are managed by sarif. When updating a
+// card, only these are replaced and all others are kept as they are.
+type vcard struct {
+	Profile       string          `vdir:"vcard,profile"`
+	Version       string          `vdir:"version"`
+	Uid           string          `vdir:"uid"`
+	FormattedName string          `vdir:"fn"`
+	Name          Name            `vdir:"n"`
+	NickName      []string        `vdir:"nickname"`
+	Birthday      string          `vdir:"bday"`
+	Addresses     []Address       `vdir:"adr"`
+	Telephones    []TypedValue    `vdir:"tel"`
+	Email         []TypedValue    `vdir:"email"`
+	Url           []TypedResource `vdir:"url"`
+	Title         string          `vdir:"title"`
+	Role          string          `vdir:"role"`
+	Org           string          `vdir:"org"`
+	Categories    []string        `vdir:"categories"`
+	Note          string          `vdir:"note"`
+	IMPP          []TypedResource `vdir:"impp"`
+	Rev           string          `vdir:"rev"`
+}
+
+var managedCardProps = []string{
+	"UID", "FN", "N", "NICKNAME", "BDAY", "ADR", "TEL", "EMAIL", "URL",
+	"TITLE", "ROLE", "ORG", "CATEGORIES", "NOTE", "IMPP", "REV",
+}
+
+// cardObject converts a card into a VCARD, merging it into the properties
+// of an existing card if given.
+func cardObject(c *Card, base *vdir.Object) (*vdir.Object, error) {
+	vc := vcard{
+		Version:       "3.0",
+		Uid:           c.Uid,
+		FormattedName: c.FormattedName,
+		Name:          c.Name,
+		NickName:      c.NickName,
+		Birthday:      c.Birthday,
+		Addresses:     c.Addresses,
+		Telephones:    c.Telephones,
+		Email:         c.Email,
+		Url:           c.Url,
+		Title:         c.Title,
+		Role:          c.Role,
+		Org:           c.Org,
+		Categories:    c.Categories,
+		Note:          c.Note,
+		IMPP:          c.IMPP,
+		Rev:           c.Rev,
+	}
+	o := &vdir.Object{}
+	if err := vdir.ToObject(&vc, o); err != nil {
+		return nil, err
+	}
+	if base == nil {
+		return o, nil
+	}
+
+	props := make([]*vdir.ContentLine, 0)
+	for _, cl := range base.Properties {
+		managed := false
+		for _, name := range managedCardProps {
+			managed = managed || strings.EqualFold(cl.Name, name)
+		}
+		if !managed {
+			props = append(props, cl)
+		}
+	}
+	for _, cl := range o.Properties {
+		if !strings.EqualFold(cl.Name, "VERSION") {
+			props = append(props, cl)
+		}
+	}
+	base.Properties = props
+	return base, nil
+}
+
+func readCardObject(path string) (*vdir.Object, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	raw = append(bytes.Replace(raw, []byte("\r\n"), []byte("\n"), -1), '\n')
+	return vdir.NewDecoder(bytes.NewReader(raw)).ReadObject()
+}
+
+func writeCardObject(path string, o *vdir.Object) error {
+	var buf bytes.Buffer
+	if err := vdir.NewEncoder(&buf).WriteObject(o); err != nil {
+		return err
+	}
+	return writeFile(path, buf.Bytes())
+}
+
+// cardPayload accepts a card together with some simpler fields for
+// creating contacts from natural messages.
+type cardPayload struct {
+	Card
+	FullName string `json:"name,omitempty"`
+	Phone    string `json:"phone,omitempty"`
+	Mail     string `json:"email,omitempty"`
+}
+
+func (p *cardPayload) apply() {
+	if p.FormattedName == "" {
+		p.FormattedName = p.FullName
+	}
+	if p.Phone != "" {
+		p.Telephones = append(p.Telephones, TypedValue{Value: p.Phone})
+	}
+	if p.Mail != "" {
+		p.Email = append(p.Email, TypedValue{Value: p.Mail})
+	}
+	if len(p.Name.GivenName) == 0 && len(p.Name.FamilyName) == 0 && p.FormattedName != "" {
+		parts := strings.Fields(p.FormattedName)
+		if len(parts) > 1 {
+			p.Name.GivenName = []string{strings.Join(parts[0:len(parts)-1], " ")}
+			p.Name.FamilyName = []string{parts[len(parts)-1]}
+		} else {
+			p.Name.GivenName = parts
+		}
+	}
+}
+
+func (s *Service) NewCard(c *Card, addressbook string) (*CardInfo, error) {
+	if c.FormattedName == "" {
+		return nil, errors.New("Please specify a name for the contact.")
+	}
+	dir, err := collectionDir(s.cfg.CardDir, addressbook, s.cfg.DefaultAddressbook)
+	if err != nil {
+		return nil, err
+	}
+	c.Uid = sarif.GenerateId() + "@sarif"
+	c.Rev = time.Now().UTC().Format(icalDateTimeUTC)
+	c.FillSchema()
+
+	path := filepath.Join(dir, c.Uid+".vcf")
+	if _, err := os.Stat(path); err == nil {
+		return nil, errors.New("Card " + c.Uid + " already exists.")
+	}
+	o, err := cardObject(c, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeCardObject(path, o); err != nil {
+		return nil, err
+	}
+
+	ci := CardInfo{Id: c.Uid, Path: path, Card: c}
+	s.mutex.Lock()
+	s.cards[ci.Id] = ci
+	s.mutex.Unlock()
+	return &ci, nil
+}
+
+// UpdateCard writes a changed card back to its file.
+func (s *Service) UpdateCard(c *Card) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	ci, ok := s.cards[c.Uid]
+	if !ok {
+		return errors.New("No card with UID " + c.Uid + " found!")
+	}
+
+	base, err := readCardObject(ci.Path)
+	if err != nil {
+		return err
+	}
+	c.Rev = time.Now().UTC().Format(icalDateTimeUTC)
+	c.FillSchema()
+	o, err := cardObject(c, base)
+	if err != nil {
+		return err
+	}
+	if err := writeCardObject(ci.Path, o); err != nil {
+		return err
+	}
+	ci.Card = c
+	s.cards[c.Uid] = ci
+	return nil
+}
+
+func (s *Service) handleCardNew(msg sarif.Message) {
+	var p struct {
+		cardPayload
+		Addressbook string `json:"addressbook,omitempty"`
+	}
+	if err := msg.DecodePayload(&p); err != nil {
+		s.ReplyBadRequest(msg, err)
+		return
+	}
+	if p.FormattedName == "" && p.FullName == "" {
+		p.FullName = msg.Text
+	}
+	p.apply()
+
+	ci, err := s.NewCard(&p.Card, p.Addressbook)
+	if err != nil {
+		s.ReplyBadRequest(msg, err)
+		return
+	}
+	s.Reply(msg, sarif.CreateMessage("vdir/card/created", ci.Card))
+}
+
+func (s *Service) handleCardUpdate(msg sarif.Message) {
+	uid := msg.ActionSuffix("vdir/card/update")
+	var ref struct {
+		Uid string `json:"uid"`
+	}
+	if err := msg.DecodePayload(&ref); err != nil {
+		s.ReplyBadRequest(msg, err)
+		return
+	}
+	if uid == "" {
+		uid = ref.Uid
+	}
+
+	s.mutex.Lock()
+	ci, ok := s.cards[uid]
+	s.mutex.Unlock()
+	if !ok {
+		s.ReplyBadRequest(msg, errors.New("No card with UID "+uid+" found!"))
+		return
+	}
+
+	// Decode the changes on top of a copy of the current card. The copy
+	// has to be deep, since decoding reuses the backing arrays of slices.
+	p := cardPayload{Card: *ci.Card.Copy()}
+	if err := msg.DecodePayload(&p); err != nil {
+		s.ReplyBadRequest(msg, err)
+		return
+	}
+	p.apply()
+	p.Uid = uid
+
+	if err := s.UpdateCard(&p.Card); err != nil {
+		s.ReplyInternalError(msg, err)
+		return
+	}
+	s.Reply(msg, sarif.CreateMessage("vdir/card/updated", p.Card))
+}
@@ -0,0 +1,112 @@
+// Copyright (C) 2019 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vdir
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/xconstruct/vdir"
+)
+
+const testCard = `BEGIN:VCARD
+VERSION:3.0
+UID:anna@test
+FN:Anna Schmidt
+N:Schmidt;Anna;;;
+ORG:Example Corp
+EMAIL;TYPE=work:anna.schmidt@example.com
+TEL;TYPE=cell:+49 151 1234567
+X-CUSTOM:keep me
+END:VCARD
+`
+
+func TestCardScore(t *testing.T) {
+	var c Card
+	if err := vdir.NewDecoder(strings.NewReader(testCard)).Decode(&c); err != nil {
+		t.Fatal(err)
+	}
+
+	matches := []string{"Anna", "anna schmidt", "Ana", "Schmid", "example corp", "anna.schmidt@example.com", "0151 1234567"}
+	for _, q := range matches {
+		if score := cardScore(q, &c); score < minCardScore {
+			t.Errorf("expected %q to match, got score %f", q, score)
+		}
+	}
+	misses := []string{"Bob", "Hannes Müller", "999"}
+	for _, q := range misses {
+		if score := cardScore(q, &c); score >= minCardScore {
+			t.Errorf("expected %q not to match, got score %f", q, score)
+		}
+	}
+}
+
+func TestCardUpdateKeepsUnknownProperties(t *testing.T) {
+	base, err := vdir.NewDecoder(strings.NewReader(testCard)).ReadObject()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var c Card
+	if err := vdir.FromObject(&c, base); err != nil {
+		t.Fatal(err)
+	}
+	c.Note = "Met at the conference"
+	c.Telephones = append(c.Telephones, TypedValue{Type: []string{"home"}, Value: "030 987654"})
+
+	o, err := cardObject(&c, base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := vdir.NewEncoder(&buf).WriteObject(o); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, exp := range []string{"X-CUSTOM:keep me", "NOTE:Met at the conference", "030 987654", "FN:Anna Schmidt"} {
+		if !strings.Contains(out, exp) {
+			t.Errorf("expected %q in output:\n%s", exp, out)
+		}
+	}
+	if n := strings.Count(out, "FN:"); n != 1 {
+		t.Errorf("expected a single FN, got %d", n)
+	}
+
+	var got Card
+	if err := vdir.NewDecoder(&buf).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Uid != "anna@test" || len(got.Telephones) != 2 || len(got.Name.GivenName) != 1 {
+		t.Errorf("unexpected card after round trip: %+v", got)
+	}
+}
+
+func TestCardCopy(t *testing.T) {
+	var c Card
+	if err := vdir.NewDecoder(strings.NewReader(testCard)).Decode(&c); err != nil {
+		t.Fatal(err)
+	}
+
+	// Decoding into the copy must not touch the original card
+	p := cardPayload{Card: *c.Copy()}
+	raw := []byte(`{"hasTelephone": [{"@type": ["home"], "hasValue": "030 987654"}], "hasName": {"given-name": ["Annie"]}, "phone": "0170 1111"}`)
+	if err := json.Unmarshal(raw, &p); err != nil {
+		t.Fatal(err)
+	}
+	p.apply()
+	p.Email[0].Type[0] = "home"
+
+	if len(c.Telephones) != 1 || c.Telephones[0].Value != "+49 151 1234567" || c.Telephones[0].Type[0] != "cell" {
+		t.Errorf("original telephones changed: %+v", c.Telephones)
+	}
+	if c.Name.GivenName[0] != "Anna" || c.Email[0].Type[0] != "work" {
+		t.Errorf("original card changed: %+v", c)
+	}
+	if len(p.Telephones) != 2 || p.Telephones[0].Value != "030 987654" || p.Name.GivenName[0] != "Annie" {
+		t.Errorf("unexpected updated card: %+v", p.Card)
+	}
+}
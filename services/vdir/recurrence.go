@@ -0,0 +1,303 @@
+// Copyright (C) 2019 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vdir
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxOccurrences limits the expansion of unbounded or very dense rules.
+const maxOccurrences = 10000
+
+type weekdayNum struct {
+	N       int
+	Weekday time.Weekday
+}
+
+var weekdays = map[string]time.Weekday{
+	"SU": time.Sunday,
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+}
+
+// Recurrence is a subset of the RFC 5545 RRULE supporting the frequencies
+// DAILY to YEARLY with INTERVAL, COUNT, UNTIL, BYDAY, BYMONTHDAY and BYMONTH.
+type Recurrence struct {
+	Freq       string
+	Interval   int
+	Count      int
+	Until      time.Time
+	ByDay      []weekdayNum
+	ByMonthDay []int
+	ByMonth    []int
+}
+
+func parseIntList(s string) ([]int, error) {
+	list := make([]int, 0)
+	for _, v := range strings.Split(s, ",") {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, n)
+	}
+	return list, nil
+}
+
+// ParseRecurrence parses a rule such as "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,FR".
+// Times in UNTIL without zone are interpreted in loc.
+func ParseRecurrence(rule string, loc *time.Location) (*Recurrence, error) {
+	r := &Recurrence{Interval: 1}
+	for _, part := range strings.Split(rule, ";") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		key, val := strings.ToUpper(kv[0]), strings.ToUpper(kv[1])
+		var err error
+		switch key {
+		case "FREQ":
+			r.Freq = val
+		case "INTERVAL":
+			r.Interval, err = strconv.Atoi(val)
+		case "COUNT":
+			r.Count, err = strconv.Atoi(val)
+		case "UNTIL":
+			r.Until, _, err = parseDateTime(val, "", loc)
+		case "BYMONTHDAY":
+			r.ByMonthDay, err = parseIntList(val)
+		case "BYMONTH":
+			r.ByMonth, err = parseIntList(val)
+		case "BYDAY":
+			for _, d := range strings.Split(val, ",") {
+				if len(d) < 2 {
+					return nil, errors.New("invalid BYDAY: " + val)
+				}
+				wd, ok := weekdays[d[len(d)-2:]]
+				if !ok {
+					return nil, errors.New("invalid BYDAY: " + val)
+				}
+				wn := weekdayNum{Weekday: wd}
+				if num := d[0 : len(d)-2]; num != "" {
+					if wn.N, err = strconv.Atoi(num); err != nil {
+						return nil, err
+					}
+				}
+				r.ByDay = append(r.ByDay, wn)
+			}
+		}
+		if err != nil {
+			return nil, errors.New("invalid " + key + ": " + err.Error())
+		}
+	}
+
+	switch r.Freq {
+	case "DAILY", "WEEKLY", "MONTHLY", "YEARLY":
+	default:
+		return nil, errors.New("unsupported recurrence frequency: " + r.Freq)
+	}
+	if r.Interval < 1 {
+		r.Interval = 1
+	}
+	return r, nil
+}
+
+func containsInt(list []int, n int) bool {
+	for _, v := range list {
+		if v == n {
+			return true
+		}
+	}
+	return false
+}
+
+func daysIn(year int, month time.Month, loc *time.Location) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, loc).Day()
+}
+
+// monthDays expands the BYDAY and BYMONTHDAY parts for a single month and
+// returns the matching days of the month.
+func (r *Recurrence) monthDays(year int, month time.Month, start time.Time) []int {
+	loc := start.Location()
+	n := daysIn(year, month, loc)
+	days := make([]int, 0)
+
+	if len(r.ByMonthDay) > 0 {
+		for _, d := range r.ByMonthDay {
+			if d < 0 {
+				d = n + d + 1
+			}
+			if d >= 1 && d <= n {
+				days = append(days, d)
+			}
+		}
+	}
+
+	if len(r.ByDay) > 0 {
+		byDay := make([]int, 0)
+		first := time.Date(year, month, 1, 0, 0, 0, 0, loc).Weekday()
+		for _, wd := range r.ByDay {
+			offset := (int(wd.Weekday) - int(first) + 7) % 7
+			matches := make([]int, 0)
+			for d := 1 + offset; d <= n; d += 7 {
+				matches = append(matches, d)
+			}
+			if wd.N > 0 && wd.N <= len(matches) {
+				byDay = append(byDay, matches[wd.N-1])
+			} else if wd.N < 0 && -wd.N <= len(matches) {
+				byDay = append(byDay, matches[len(matches)+wd.N])
+			} else if wd.N == 0 {
+				byDay = append(byDay, matches...)
+			}
+		}
+		if len(r.ByMonthDay) == 0 {
+			days = byDay
+		} else {
+			filtered := make([]int, 0)
+			for _, d := range days {
+				if containsInt(byDay, d) {
+					filtered = append(filtered, d)
+				}
+			}
+			days = filtered
+		}
+	}
+
+	if len(r.ByMonthDay) == 0 && len(r.ByDay) == 0 && start.Day() <= n {
+		days = append(days, start.Day())
+	}
+	sort.Ints(days)
+	return days
+}
+
+// candidates returns all possible occurrences in the i-th period after start.
+func (r *Recurrence) candidates(start time.Time, i int) []time.Time {
+	loc := start.Location()
+	h, m, s := start.Clock()
+	at := func(y int, mo time.Month, d int) time.Time {
+		return time.Date(y, mo, d, h, m, s, 0, loc)
+	}
+	step := i * r.Interval
+	list := make([]time.Time, 0)
+
+	switch r.Freq {
+	case "DAILY":
+		t := start.AddDate(0, 0, step)
+		list = append(list, t)
+	case "WEEKLY":
+		offset := (int(start.Weekday()) + 6) % 7 // weeks start on monday
+		monday := at(start.Year(), start.Month(), start.Day()-offset+7*step)
+		if len(r.ByDay) == 0 {
+			list = append(list, monday.AddDate(0, 0, offset))
+		}
+		for d := 0; d < 7; d++ {
+			t := monday.AddDate(0, 0, d)
+			for _, wd := range r.ByDay {
+				if t.Weekday() == wd.Weekday {
+					list = append(list, t)
+					break
+				}
+			}
+		}
+	case "MONTHLY":
+		first := time.Date(start.Year(), start.Month()+time.Month(step), 1, 0, 0, 0, 0, loc)
+		for _, d := range r.monthDays(first.Year(), first.Month(), start) {
+			list = append(list, at(first.Year(), first.Month(), d))
+		}
+	case "YEARLY":
+		year := start.Year() + step
+		months := r.ByMonth
+		if len(months) == 0 {
+			months = []int{int(start.Month())}
+		}
+		for _, mo := range months {
+			for _, d := range r.monthDays(year, time.Month(mo), start) {
+				list = append(list, at(year, time.Month(mo), d))
+			}
+		}
+	}
+
+	filtered := list[:0]
+	for _, t := range list {
+		if len(r.ByMonth) > 0 && !containsInt(r.ByMonth, int(t.Month())) {
+			continue
+		}
+		if r.Freq == "DAILY" && len(r.ByDay) > 0 {
+			match := false
+			for _, wd := range r.ByDay {
+				match = match || wd.Weekday == t.Weekday()
+			}
+			if !match {
+				continue
+			}
+		}
+		if r.Freq == "DAILY" && len(r.ByMonthDay) > 0 && !containsInt(r.ByMonthDay, t.Day()) {
+			continue
+		}
+		filtered = append(filtered, t)
+	}
+	sort.Slice(filtered, func(i, j int) bool { return filtered[i].Before(filtered[j]) })
+	return filtered
+}
+
+// overlaps checks if an event overlaps with a time range. Events without
+// duration overlap if they start inside the range.
+func overlaps(start, end, from, to time.Time) bool {
+	return start.Before(to) && (end.After(from) || !start.Before(from))
+}
+
+// Occurrences returns the start times of all occurrences that start before
+// end and that do not end before from, given an event duration.
+// The first occurrence is always start itself. Excluded times are skipped,
+// but still count towards COUNT.
+func (r *Recurrence) Occurrences(start time.Time, dur time.Duration, from, end time.Time, exclude []time.Time) []time.Time {
+	occs := make([]time.Time, 0)
+	excluded := func(t time.Time) bool {
+		for _, ex := range exclude {
+			if ex.Equal(t) {
+				return true
+			}
+		}
+		return false
+	}
+	add := func(t time.Time) {
+		if !excluded(t) && overlaps(t, t.Add(dur), from, end) {
+			occs = append(occs, t)
+		}
+	}
+
+	add(start)
+	n := 1
+	// Periods may have no matching day at all, e.g. monthly on the 31st,
+	// so the number of iterated periods is bounded separately.
+	for i := 0; i < 10*maxOccurrences && n < maxOccurrences; i++ {
+		for _, t := range r.candidates(start, i) {
+			if !t.After(start) {
+				continue
+			}
+			if r.Count > 0 && n >= r.Count {
+				return occs
+			}
+			if !r.Until.IsZero() && t.After(r.Until) {
+				return occs
+			}
+			if !t.Before(end) {
+				return occs
+			}
+			add(t)
+			n++
+		}
+	}
+	return occs
+}
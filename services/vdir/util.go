@@ -5,9 +5,57 @@
 
 package vdir
 
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
 type CardInfo struct {
 	Id   string
 	Path string
 
 	Card *Card
 }
+
+// collectionDir finds the directory to write new entries of a calendar or
+// addressbook to. vdirsyncer keeps each collection in its own subdirectory,
+// so without an explicit or default collection the first one is used.
+func collectionDir(root, name, def string) (string, error) {
+	if root == "" {
+		return "", errors.New("No vdir directory configured.")
+	}
+	if name == "" {
+		name = def
+	}
+	if name != "" {
+		dir := filepath.Join(root, filepath.Base(name))
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			return "", errors.New("Collection " + name + " not found.")
+		}
+		return dir, nil
+	}
+
+	infos, err := ioutil.ReadDir(root)
+	if err != nil {
+		return "", err
+	}
+	for _, info := range infos {
+		if info.IsDir() && !strings.HasPrefix(info.Name(), ".") {
+			return filepath.Join(root, info.Name()), nil
+		}
+	}
+	return root, nil
+}
+
+// writeFile atomically replaces a file in the vdir, so that vdirsyncer
+// never sees partial writes.
+func writeFile(path string, data []byte) error {
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -11,6 +11,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/sarifsystems/sarif/sarif"
 	"github.com/sarifsystems/sarif/services"
@@ -26,6 +27,13 @@ var Module = &services.Module{
 type Config struct {
 	CardDir string
 	CalDir  string
+
+	// DefaultAddressbook is the subdirectory of CardDir new cards are written to.
+	DefaultAddressbook string
+	// DefaultCalendar is the subdirectory of CalDir new entries are written to.
+	DefaultCalendar string
+	// RemindBefore is how long before an event calendar/upcoming is sent.
+	RemindBefore string
 }
 
 type Dependencies struct {
@@ -37,14 +45,21 @@ type Service struct {
 	cfg Config
 	sarif.Client
 
-	cards map[string]CardInfo
+	mutex     sync.Mutex
+	cards     map[string]CardInfo
+	events    map[string]*Event
+	todos     map[string]*Todo
+	reminders map[string]bool
 }
 
 func NewService(deps *Dependencies) *Service {
 	s := &Service{
 		Client: deps.Client,
 
-		cards: make(map[string]CardInfo),
+		cards:     make(map[string]CardInfo),
+		events:    make(map[string]*Event),
+		todos:     make(map[string]*Todo),
+		reminders: make(map[string]bool),
 	}
 	deps.Config.Get(&s.cfg)
 	return s
@@ -52,62 +67,104 @@ func NewService(deps *Dependencies) *Service {
 
 func (s *Service) Enable() error {
 	s.Subscribe("vdir/card", "", s.HandleCard)
+	s.Subscribe("vdir/agenda", "", s.handleAgenda)
+	s.Subscribe("vdir/event", "", s.handleEvent)
+	s.Subscribe("vdir/todo", "", s.handleTodo)
 	if err := s.ReloadFiles(); err != nil {
 		s.Log("err", "[vdir] reloading files: "+err.Error())
 	}
+	if s.cfg.CalDir != "" {
+		go s.reminderLoop()
+	}
+	if err := s.watch(); err != nil {
+		s.Log("err", "[vdir] watching files: "+err.Error())
+	}
 	return nil
 }
 
 func (s *Service) ReloadFiles() error {
 	if s.cfg.CardDir != "" {
-		if err := filepath.Walk(s.cfg.CardDir, s.loadCard); err != nil {
+		if err := s.loadCards(); err != nil {
+			return err
+		}
+	}
+	if s.cfg.CalDir != "" {
+		if err := s.loadCalendars(); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (s *Service) loadCard(path string, info os.FileInfo, err error) error {
+func (s *Service) loadCards() error {
+	cards := make(map[string]CardInfo)
+	err := filepath.Walk(s.cfg.CardDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		if strings.HasPrefix(info.Name(), ".") {
+			return nil
+		}
+		if !strings.HasSuffix(info.Name(), ".vcf") {
+			return nil
+		}
+
+		card, err := readCard(path)
+		if err != nil {
+			s.Log("warn", "[vdir] reading "+path+": "+err.Error())
+			return nil
+		}
+		cards[card.Uid] = CardInfo{
+			Id:   card.Uid,
+			Path: path,
+			Card: card,
+		}
+		return nil
+	})
 	if err != nil {
 		return err
 	}
-	if info.IsDir() {
-		return nil
-	}
-	if strings.HasPrefix(info.Name(), ".") {
-		return nil
-	}
-	if !strings.HasSuffix(info.Name(), ".vcf") {
-		return nil
-	}
 
+	s.mutex.Lock()
+	s.cards = cards
+	s.mutex.Unlock()
+	return nil
+}
+
+func readCard(path string) (*Card, error) {
 	var card Card
 	f, err := os.Open(path)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer f.Close()
 	if err := vdir.NewDecoder(f).Decode(&card); err != nil {
-		return err
+		return nil, err
 	}
-
-	card.SchemaContext = "http://www.w3.org/2006/vcard/ns#"
-	card.SchemaType = "Individual"
-	card.SchemaId = "sarif://vdir/card/" + card.Uid
-	card.SchemaLabel = card.FormattedName
-
-	ci := CardInfo{
-		Id:   card.Uid,
-		Path: path,
-		Card: &card,
-	}
-	s.cards[ci.Id] = ci
-	return nil
+	card.FillSchema()
+	return &card, nil
 }
 
 func (s *Service) HandleCard(msg sarif.Message) {
+	switch {
+	case msg.IsAction("vdir/card/search"):
+		s.handleCardSearch(msg)
+		return
+	case msg.IsAction("vdir/card/new"):
+		s.handleCardNew(msg)
+		return
+	case msg.IsAction("vdir/card/update"):
+		s.handleCardUpdate(msg)
+		return
+	}
+
 	uid := strings.TrimPrefix(msg.Action, "vdir/card/")
+	s.mutex.Lock()
 	c, ok := s.cards[uid]
+	s.mutex.Unlock()
 	if !ok {
 		s.ReplyBadRequest(msg, errors.New("No card with with UID "+uid+" found!"))
 		return
@@ -0,0 +1,111 @@
+// Copyright (C) 2019 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vdir
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchDelay collects bursts of changes, e.g. by a vdirsyncer run, into a
+// single reload.
+const watchDelay = time.Second
+
+func addWatchDirs(w *fsnotify.Watcher, root string) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if path != root && strings.HasPrefix(info.Name(), ".") {
+			return filepath.SkipDir
+		}
+		return w.Add(path)
+	})
+}
+
+// watch reloads cards and calendars when they are changed externally.
+func (s *Service) watch() error {
+	if s.cfg.CardDir == "" && s.cfg.CalDir == "" {
+		return nil
+	}
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	for _, dir := range []string{s.cfg.CardDir, s.cfg.CalDir} {
+		if dir == "" {
+			continue
+		}
+		if err := addWatchDirs(w, dir); err != nil {
+			w.Close()
+			return err
+		}
+	}
+	go s.handleWatchEvents(w)
+	return nil
+}
+
+func (s *Service) handleWatchEvents(w *fsnotify.Watcher) {
+	defer w.Close()
+	var timer <-chan time.Time
+	cards, cals := false, false
+	for {
+		select {
+		case ev, ok := <-w.Events:
+			if !ok {
+				return
+			}
+			if ev.Op&fsnotify.Create != 0 {
+				if info, err := os.Stat(ev.Name); err == nil && info.IsDir() {
+					addWatchDirs(w, ev.Name)
+				}
+			}
+			name := filepath.Base(ev.Name)
+			if strings.HasPrefix(name, ".") {
+				continue
+			}
+			switch filepath.Ext(name) {
+			case ".vcf":
+				cards = true
+			case ".ics":
+				cals = true
+			default:
+				continue
+			}
+			if timer == nil {
+				timer = time.After(watchDelay)
+			}
+
+		case err, ok := <-w.Errors:
+			if !ok {
+				return
+			}
+			s.Log("err", "[vdir] watching files: "+err.Error())
+
+		case <-timer:
+			timer = nil
+			if cards {
+				if err := s.loadCards(); err != nil {
+					s.Log("err", "[vdir] reloading cards: "+err.Error())
+				}
+			}
+			if cals {
+				if err := s.loadCalendars(); err != nil {
+					s.Log("err", "[vdir] reloading calendars: "+err.Error())
+				}
+				go s.scheduleReminders()
+			}
+			cards, cals = false, false
+		}
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright (C) 2019 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/sarifsystems/sarif/sarif"
+)
+
+func TestTokenAuthentication(t *testing.T) {
+	_, broker, srv := newTestServer(t)
+	defer srv.Close()
+
+	// Minimal stand-in for the auth service
+	auth, err := broker.NewClient(sarif.ClientInfo{Name: "auth"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth.Subscribe("auth/validate", "self", func(msg sarif.Message) {
+		var p struct {
+			Token string `json:"token"`
+		}
+		msg.DecodePayload(&p)
+		if p.Token != "token/std:secret" {
+			auth.Reply(msg, sarif.Message{Action: "auth/invalid"})
+			return
+		}
+		auth.Reply(msg, sarif.CreateMessage("auth/valid", map[string]interface{}{
+			"id":     "abc",
+			"scopes": []string{"ping"},
+		}))
+	})
+
+	// Other devices may not vouch for tokens
+	impostor, err := broker.NewClient(sarif.ClientInfo{Name: "impostor"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	impostor.Subscribe("auth/validate", "auth", func(msg sarif.Message) {
+		impostor.Reply(msg, sarif.CreateMessage("auth/valid", map[string]interface{}{
+			"id": "evil",
+		}))
+	})
+
+	cases := []struct {
+		Token, Action string
+		Status        int
+	}{
+		{"token/std:secret", "ping", 200},
+		{"token/std:secret", "location/update", 401},
+		{"token/std:wrong", "ping", 401},
+	}
+	for _, c := range cases {
+		resp, err := http.PostForm(srv.URL+REST_URL+c.Action, url.Values{
+			"authtoken": {c.Token},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != c.Status {
+			t.Errorf("%s %s: expected %d, got %s", c.Token, c.Action, c.Status, resp.Status)
+		}
+	}
+}
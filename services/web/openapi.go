@@ -0,0 +1,234 @@
+// Copyright (C) 2019 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sarifsystems/sarif/sarif"
+)
+
+const (
+	OPENAPI_URL = "/openapi.json"
+
+	// discoverWait is how long replies to a discovery are collected before
+	// the OpenAPI document is generated.
+	discoverWait = 500 * time.Millisecond
+)
+
+// discover asks all clients for their actions and schemas. Replies are
+// collected by handleDiscovered.
+func (s *Server) discover() {
+	if err := s.Client.Publish(sarif.CreateMessage("proto/discover", nil)); err != nil {
+		s.Client.Log("warn", "discover error: "+err.Error())
+	}
+}
+
+func (s *Server) handleDiscovered(msg sarif.Message) {
+	var info sarif.DiscoverInfo
+	if err := msg.DecodePayload(&info); err != nil {
+		return
+	}
+
+	s.schemaMutex.Lock()
+	defer s.schemaMutex.Unlock()
+	for _, schema := range info.Schemas {
+		s.schemas[schema.Action] = schema
+	}
+}
+
+// actionSchema finds the schema of the most specific action that handles
+// the given one.
+func (s *Server) actionSchema(action string) (sarif.ActionSchema, bool) {
+	s.schemaMutex.Lock()
+	defer s.schemaMutex.Unlock()
+
+	msg := sarif.Message{Action: action}
+	var best sarif.ActionSchema
+	found := false
+	for a, schema := range s.schemas {
+		if msg.IsAction(a) && (!found || len(a) > len(best.Action)) {
+			best, found = schema, true
+		}
+	}
+	return best, found
+}
+
+func (s *Server) requestSchema(action string) *sarif.Schema {
+	schema, _ := s.actionSchema(action)
+	return schema.Request
+}
+
+// validate checks the payload of a message against the registered schema
+// of its action.
+func (s *Server) validate(msg sarif.Message) error {
+	schema := s.requestSchema(msg.Action)
+	if schema == nil {
+		return nil
+	}
+	var v interface{}
+	if err := msg.DecodePayload(&v); err != nil {
+		return err
+	}
+	if v == nil && schema.Type == "object" {
+		v = map[string]interface{}{}
+	}
+	return schema.Validate(v)
+}
+
+// parseRequest creates a message from a REST request and validates it.
+// On failure, an error is written to the response.
+func (s *Server) parseRequest(w http.ResponseWriter, req *http.Request, urlPrefix string) (sarif.Message, bool) {
+	msg, err := requestToMessage(req, urlPrefix, s.requestSchema)
+	if err == nil {
+		err = s.validate(msg)
+	}
+	if err != nil {
+		s.Client.Log("warn", "REST bad request: "+err.Error())
+		w.WriteHeader(400)
+		fmt.Fprintln(w, "Bad request:", err)
+		return msg, false
+	}
+	return msg, true
+}
+
+func (s *Server) handleOpenAPI(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+	user, _ := s.authenticate(w, req)
+	if user == nil {
+		return
+	}
+
+	s.discover()
+	time.Sleep(discoverWait)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.openAPIDocument(user))
+}
+
+func operationId(prefix, action string) string {
+	return prefix + "_" + strings.Replace(action, "/", "_", -1)
+}
+
+// openAPIDocument describes all actions with a known schema that the
+// client is allowed to publish.
+func (s *Server) openAPIDocument(user *apiUser) map[string]interface{} {
+	s.schemaMutex.Lock()
+	schemas := make([]sarif.ActionSchema, 0, len(s.schemas))
+	for _, schema := range s.schemas {
+		if user.IsAllowed(sarif.Message{Action: schema.Action}) {
+			schemas = append(schemas, schema)
+		}
+	}
+	s.schemaMutex.Unlock()
+	sort.Slice(schemas, func(i, j int) bool {
+		return schemas[i].Action < schemas[j].Action
+	})
+
+	paths := make(map[string]interface{})
+	for _, schema := range schemas {
+		request := schema.Request
+		if request == nil {
+			request = &sarif.Schema{Type: "object"}
+		}
+		response := schema.Response
+		if response == nil {
+			response = &sarif.Schema{}
+		}
+		body := map[string]interface{}{
+			"content": map[string]interface{}{
+				"application/json":                  map[string]interface{}{"schema": request},
+				"application/x-www-form-urlencoded": map[string]interface{}{"schema": request},
+			},
+		}
+		tags := []string{strings.Split(schema.Action, "/")[0]}
+
+		paths[REST_URL+schema.Action] = map[string]interface{}{
+			"post": map[string]interface{}{
+				"operationId": operationId("publish", schema.Action),
+				"summary":     schema.Description,
+				"tags":        tags,
+				"requestBody": body,
+				"responses": map[string]interface{}{
+					"200": map[string]interface{}{
+						"description": "Id of the published message",
+						"content": map[string]interface{}{
+							"text/plain": map[string]interface{}{
+								"schema": &sarif.Schema{Type: "string"},
+							},
+						},
+					},
+					"400": map[string]interface{}{"description": "Invalid payload"},
+					"401": map[string]interface{}{"description": "Not authorized"},
+				},
+			},
+		}
+
+		paths[REQUEST_URL+"/"+schema.Action] = map[string]interface{}{
+			"post": map[string]interface{}{
+				"operationId": operationId("request", schema.Action),
+				"summary":     schema.Description,
+				"tags":        tags,
+				"requestBody": body,
+				"responses": map[string]interface{}{
+					"200": map[string]interface{}{
+						"description": "The reply",
+						"content": map[string]interface{}{
+							"application/json": map[string]interface{}{
+								"schema": &sarif.Schema{
+									Type: "object",
+									Properties: map[string]*sarif.Schema{
+										"id":     {Type: "string"},
+										"action": {Type: "string"},
+										"src":    {Type: "string"},
+										"dst":    {Type: "string"},
+										"corr":   {Type: "string"},
+										"text":   {Type: "string"},
+										"p":      response,
+									},
+								},
+							},
+						},
+					},
+					"400": map[string]interface{}{"description": "Invalid payload"},
+					"401": map[string]interface{}{"description": "Not authorized"},
+					"504": map[string]interface{}{"description": "No reply received"},
+				},
+			},
+		}
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.0",
+		"info": map[string]interface{}{
+			"title":   "sarif",
+			"version": "0",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"securitySchemes": map[string]interface{}{
+				"bearer": map[string]interface{}{
+					"type":   "http",
+					"scheme": "bearer",
+				},
+				"authtoken": map[string]interface{}{
+					"type": "apiKey",
+					"in":   "query",
+					"name": "authtoken",
+				},
+			},
+		},
+		"security": []interface{}{
+			map[string]interface{}{"bearer": []string{}},
+			map[string]interface{}{"authtoken": []string{}},
+		},
+	}
+}
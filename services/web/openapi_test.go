@@ -0,0 +1,149 @@
+// Copyright (C) 2019 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sarifsystems/sarif/sarif"
+)
+
+type testPayload struct {
+	Name  string    `json:"name"`
+	Count int       `json:"count"`
+	Tags  []string  `json:"tags"`
+	When  time.Time `json:"when,omitempty"`
+}
+
+func TestOpenAPI(t *testing.T) {
+	_, broker, srv := newTestServer(t)
+	defer srv.Close()
+
+	svc, err := broker.NewClient(sarif.ClientInfo{Name: "testservice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan sarif.Message, 10)
+	svc.Subscribe("thing/create", "", func(msg sarif.Message) { received <- msg })
+	svc.Subscribe("location/update", "", func(msg sarif.Message) {})
+	svc.RegisterSchema(sarif.ActionSchema{
+		Action:      "thing/create",
+		Description: "Create a thing.",
+		Request:     sarif.SchemaOf(testPayload{}),
+		Response:    sarif.SchemaOf(testPayload{}),
+	})
+	svc.RegisterSchema(sarif.ActionSchema{
+		Action:  "location/update",
+		Request: &sarif.Schema{Type: "object"},
+	})
+
+	// Document only lists the actions the client may publish
+	var doc struct {
+		OpenAPI string `json:"openapi"`
+		Paths   map[string]struct {
+			Post struct {
+				Summary     string `json:"summary"`
+				RequestBody struct {
+					Content map[string]struct {
+						Schema sarif.Schema `json:"schema"`
+					} `json:"content"`
+				} `json:"requestBody"`
+			} `json:"post"`
+		} `json:"paths"`
+	}
+	resp, err := http.Get(srv.URL + OPENAPI_URL + "?authtoken=adminkey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	op, ok := doc.Paths["/api/v0/thing/create"]
+	if doc.OpenAPI != "3.0.0" || !ok || op.Post.Summary != "Create a thing." {
+		t.Fatalf("unexpected document: %+v", doc)
+	}
+	if _, ok := doc.Paths["/request/thing/create"]; !ok {
+		t.Error("expected request path")
+	}
+	schema := op.Post.RequestBody.Content["application/json"].Schema
+	if schema.Properties["count"] == nil || schema.Properties["count"].Type != "integer" {
+		t.Errorf("unexpected request schema: %+v", schema)
+	}
+
+	resp, err = http.Get(srv.URL + OPENAPI_URL + "?authtoken=widgetkey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc.Paths = nil
+	json.NewDecoder(resp.Body).Decode(&doc)
+	resp.Body.Close()
+	if _, ok := doc.Paths["/api/v0/location/update"]; !ok || len(doc.Paths) != 2 {
+		t.Errorf("unexpected paths for widget: %v", doc.Paths)
+	}
+
+	// Form values are converted and validated
+	resp, err = http.PostForm(srv.URL+"/api/v0/thing/create", url.Values{
+		"authtoken": {"adminkey"},
+		"name":      {"42"},
+		"count":     {"3"},
+		"tags":      {"a", "b"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatal("unexpected status", resp.Status)
+	}
+	msg := <-received
+	var p testPayload
+	if err := msg.DecodePayload(&p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Name != "42" || p.Count != 3 || len(p.Tags) != 2 {
+		t.Errorf("unexpected payload: %+v", p)
+	}
+
+	invalid := []*http.Request{}
+	req, _ := http.NewRequest("POST", srv.URL+"/api/v0/thing/create?authtoken=adminkey", strings.NewReader("count=many"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	invalid = append(invalid, req)
+	req, _ = http.NewRequest("POST", srv.URL+"/api/v0/thing/create/special?authtoken=adminkey", bytes.NewReader([]byte(`{"when": "tomorrow"}`)))
+	req.Header.Set("Content-Type", "application/json")
+	invalid = append(invalid, req)
+	for _, req := range invalid {
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != 400 {
+			t.Error(req.URL.Path, "expected bad request, got", resp.Status)
+		}
+	}
+
+	req, _ = http.NewRequest("POST", srv.URL+"/api/v0/thing/create?authtoken=adminkey", bytes.NewReader([]byte(`{"name": "json", "tags": ["x"]}`)))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatal("unexpected status", resp.Status)
+	}
+	msg = <-received
+	if err := msg.DecodePayload(&p); err != nil || p.Name != "json" {
+		t.Errorf("unexpected payload: %+v", p)
+	}
+}
@@ -6,6 +6,7 @@
 package web
 
 import (
+	"encoding/json"
 	"net/http"
 	"strconv"
 	"strings"
@@ -15,6 +16,12 @@ import (
 )
 
 func RequestToMessage(req *http.Request, urlPrefix string) (sarif.Message, error) {
+	return requestToMessage(req, urlPrefix, nil)
+}
+
+// requestToMessage creates a message from a request. If a schema is known
+// for the action, form values are converted to the types it expects.
+func requestToMessage(req *http.Request, urlPrefix string, schemas func(action string) *sarif.Schema) (sarif.Message, error) {
 	msg := sarif.Message{
 		Id: sarif.GenerateId(),
 	}
@@ -28,24 +35,44 @@ func RequestToMessage(req *http.Request, urlPrefix string) (sarif.Message, error
 	if urlPrefix != "" && strings.HasPrefix(req.URL.Path, urlPrefix) {
 		msg.Action = strings.TrimPrefix(req.URL.Path, urlPrefix)
 	}
+	if msg.Action == "" {
+		msg.Action = req.Form.Get("_action")
+	}
 
-	if err := parseForm(req, &msg); err != nil {
+	var schema *sarif.Schema
+	if schemas != nil {
+		schema = schemas(msg.Action)
+	}
+	if err := parseForm(req, &msg, schema); err != nil {
 		return msg, err
 	}
 
+	// A JSON body replaces the payload from the form values.
+	if strings.HasPrefix(req.Header.Get("Content-Type"), "application/json") {
+		var pl interface{}
+		if err := json.NewDecoder(req.Body).Decode(&pl); err != nil {
+			return msg, err
+		}
+		if err := msg.EncodePayload(pl); err != nil {
+			return msg, err
+		}
+	}
+
 	return msg, nil
 }
 
-func parseForm(req *http.Request, msg *sarif.Message) error {
+func parseForm(req *http.Request, msg *sarif.Message, schema *sarif.Schema) error {
 	pl := make(map[string]interface{})
 	for k, v := range req.Form {
-		if k == "authtoken" {
+		if k == "authtoken" || k == "_action" || k == "_timeout" {
 			continue
 		}
 		if k == "_device" {
 			msg.Destination = v[0]
 		} else if k == "text" {
 			msg.Text = strings.Join(v, "\n")
+		} else if prop := schemaProperty(schema, k); prop != nil {
+			pl[k] = coerceFormValue(v, prop)
 		} else if len(v) == 1 {
 			pl[k] = parseFormValue(v[0])
 		} else if k == "_device" {
@@ -59,6 +86,46 @@ func parseForm(req *http.Request, msg *sarif.Message) error {
 	return nil
 }
 
+func schemaProperty(s *sarif.Schema, name string) *sarif.Schema {
+	if s == nil {
+		return nil
+	}
+	if prop, ok := s.Properties[name]; ok {
+		return prop
+	}
+	return s.AdditionalProperties
+}
+
+// coerceFormValue converts form values to the type given by the schema.
+// Values that cannot be converted are kept as strings and rejected during
+// validation.
+func coerceFormValue(v []string, s *sarif.Schema) interface{} {
+	switch s.Type {
+	case "array":
+		items := make([]interface{}, len(v))
+		for i, item := range v {
+			items[i] = item
+			if s.Items != nil {
+				items[i] = coerceFormValue([]string{item}, s.Items)
+			}
+		}
+		return items
+	case "string":
+		return v[0]
+	case "integer", "number":
+		if f, err := strconv.ParseFloat(v[0], 64); err == nil {
+			return f
+		}
+	case "boolean":
+		if b, err := strconv.ParseBool(v[0]); err == nil {
+			return b
+		}
+	case "":
+		return parseFormValue(v[0])
+	}
+	return v[0]
+}
+
 func parseFormValue(s string) interface{} {
 	if v, err := strconv.Atoi(s); err == nil {
 		return v
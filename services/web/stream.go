@@ -0,0 +1,153 @@
+// Copyright (C) 2019 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/sarifsystems/sarif/sarif"
+)
+
+const (
+	streamKeepAlive   = 30 * time.Second
+	maxRequestTimeout = 30 * time.Second
+)
+
+// handleStream sends all messages matching the given action and device to
+// the client as Server-Sent Events.
+func (s *Server) handleStream(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+	if req.Method != "GET" {
+		w.WriteHeader(405)
+		fmt.Fprintln(w, "Method not allowed")
+		return
+	}
+	user, _ := s.authenticate(w, req)
+	if user == nil {
+		return
+	}
+
+	action, device := req.FormValue("action"), req.FormValue("device")
+	if !user.IsAllowed(sarif.Message{Action: action}) {
+		w.WriteHeader(401)
+		fmt.Fprintf(w, "'%s' is not authorized to watch '%s'", user.Name, action)
+		s.Client.Log("warn", "stream '"+user.Name+"' is not authorized to watch "+action)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(500)
+		fmt.Fprintln(w, "Streaming not supported")
+		return
+	}
+
+	// Each stream gets its own client, so that its subscriptions end
+	// with the connection.
+	client, err := s.ClientFactory.NewClient(sarif.ClientInfo{
+		Name: "web/" + user.Name + "/stream/" + sarif.GenerateId(),
+	})
+	if err != nil {
+		s.Client.Log("error", "could not create client: "+err.Error())
+		w.WriteHeader(500)
+		fmt.Fprintln(w, "Internal error creating client")
+		return
+	}
+	defer client.Disconnect()
+
+	msgs := make(chan sarif.Message, 32)
+	done := make(chan struct{})
+	defer close(done)
+	client.Subscribe(action, device, func(msg sarif.Message) {
+		select {
+		case msgs <- msg:
+		case <-done:
+		}
+	})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(200)
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	ctx := req.Context()
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case msg := <-msgs:
+			data, err := json.Marshal(msg)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "id: %s\ndata: %s\n\n", msg.Id, data)
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": ping\n\n")
+		case <-ctx.Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// handleRequest publishes a message and waits for the first reply, which
+// is returned as JSON.
+func (s *Server) handleRequest(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+	if req.Method != "POST" {
+		w.WriteHeader(405)
+		fmt.Fprintln(w, "Method not allowed")
+		return
+	}
+	user, client := s.authenticate(w, req)
+	if user == nil {
+		return
+	}
+
+	msg, ok := s.parseRequest(w, req, REQUEST_URL+"/")
+	if !ok {
+		return
+	}
+	if msg.Action == "" {
+		w.WriteHeader(400)
+		fmt.Fprintln(w, "Bad request: no action given")
+		return
+	}
+	if !user.IsAllowed(msg) {
+		w.WriteHeader(401)
+		fmt.Fprintf(w, "'%s' is not authorized to publish '%s'", user.Name, msg.Action)
+		s.Client.Log("warn", "REST '"+user.Name+"' is not authorized to publish on "+msg.Action)
+		return
+	}
+
+	timeout := maxRequestTimeout
+	if t, err := strconv.ParseFloat(req.FormValue("_timeout"), 64); err == nil && t > 0 {
+		if d := time.Duration(t * float64(time.Second)); d < timeout {
+			timeout = d
+		}
+	}
+
+	var reply sarif.Message
+	ok = false
+	select {
+	case reply, ok = <-client.Request(msg):
+	case <-time.After(timeout):
+	case <-req.Context().Done():
+		return
+	}
+	if !ok {
+		w.WriteHeader(504)
+		fmt.Fprintln(w, "No reply received")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reply)
+}
@@ -0,0 +1,176 @@
+// Copyright (C) 2019 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sarifsystems/sarif/sarif"
+	"github.com/sarifsystems/sarif/transports/sfproto"
+)
+
+type testConfig struct{}
+
+func (testConfig) Exists() bool                    { return true }
+func (testConfig) Set(v interface{}) error         { return nil }
+func (testConfig) Get(v interface{}) (error, bool) { return nil, true }
+func (testConfig) Dir() string                     { return "" }
+
+func newTestServer(t *testing.T) (*Server, *sfproto.Broker, *httptest.Server) {
+	broker := sfproto.NewBroker()
+	client, err := broker.NewClient(sarif.ClientInfo{Name: "web"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New(&Dependencies{
+		Config:        testConfig{},
+		ClientFactory: broker,
+		Client:        client,
+	})
+	s.cfg.ApiKeys = map[string]string{
+		"admin":  "adminkey",
+		"widget": "widgetkey",
+	}
+	s.cfg.AllowedActions = map[string][]string{
+		"widget": {"ping", "location/update"},
+	}
+
+	s.Client.Subscribe("proto/discovered", "self", s.handleDiscovered)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(REST_URL, s.handleRestPublish)
+	mux.HandleFunc(OPENAPI_URL, s.handleOpenAPI)
+	mux.HandleFunc(STREAM_URL, s.handleStream)
+	mux.HandleFunc(REQUEST_URL, s.handleRequest)
+	mux.HandleFunc(REQUEST_URL+"/", s.handleRequest)
+	return s, broker, httptest.NewServer(mux)
+}
+
+func TestStream(t *testing.T) {
+	_, broker, srv := newTestServer(t)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/stream?action=location&authtoken=widgetkey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 401 {
+		t.Error("expected widget to be rejected, got", resp.Status)
+	}
+
+	resp, err = http.Get(srv.URL + "/stream?action=location/update&authtoken=widgetkey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); resp.StatusCode != 200 || ct != "text/event-stream" {
+		t.Fatal("unexpected response", resp.Status, ct)
+	}
+	r := bufio.NewReader(resp.Body)
+	if line, _ := r.ReadString('\n'); line != ": connected\n" {
+		t.Fatalf("unexpected line %q", line)
+	}
+
+	other, err := broker.NewClient(sarif.ClientInfo{Name: "other"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		other.Publish(sarif.CreateMessage("location/changed", nil))
+		msg := sarif.CreateMessage("location/update/gps", nil)
+		msg.Text = "moved"
+		other.Publish(msg)
+	}()
+
+	var data string
+	for data == "" {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasPrefix(line, "data: ") {
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+	var msg sarif.Message
+	if err := json.Unmarshal([]byte(data), &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Action != "location/update/gps" || msg.Text != "moved" {
+		t.Errorf("unexpected event: %s", data)
+	}
+}
+
+func TestRequest(t *testing.T) {
+	_, broker, srv := newTestServer(t)
+	defer srv.Close()
+
+	echo, err := broker.NewClient(sarif.ClientInfo{Name: "echo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	echo.Subscribe("echo", "", func(msg sarif.Message) {
+		var p map[string]interface{}
+		msg.DecodePayload(&p)
+		reply := sarif.CreateMessage("echoed", p)
+		reply.Text = msg.Text
+		echo.Reply(msg, reply)
+	})
+
+	resp, err := http.PostForm(srv.URL+"/request/echo", url.Values{
+		"authtoken": {"widgetkey"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 401 {
+		t.Error("expected widget to be rejected, got", resp.Status)
+	}
+
+	resp, err = http.PostForm(srv.URL+"/request", url.Values{
+		"authtoken": {"adminkey"},
+		"_action":   {"echo"},
+		"text":      {"hello"},
+		"value":     {"42"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	var reply sarif.Message
+	if err := json.Unmarshal(body, &reply); err != nil {
+		t.Fatal(err, string(body))
+	}
+	var p map[string]interface{}
+	reply.DecodePayload(&p)
+	if reply.Action != "echoed" || reply.Text != "hello" || p["value"] != 42.0 {
+		t.Errorf("unexpected reply: %s", body)
+	}
+
+	resp, err = http.PostForm(srv.URL+"/request/nobody/listens", url.Values{
+		"authtoken": {"adminkey"},
+		"_timeout":  {"0.1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 504 {
+		t.Error("expected timeout, got", resp.Status)
+	}
+}
@@ -13,6 +13,8 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/sarifsystems/sarif/sarif"
@@ -20,7 +22,11 @@ import (
 )
 
 const (
-	REST_URL = "/api/v0/"
+	REST_URL    = "/api/v0/"
+	STREAM_URL  = "/stream"
+	REQUEST_URL = "/request"
+
+	authTimeout = 5 * time.Second
 )
 
 var Module = &services.Module{
@@ -33,6 +39,9 @@ type Config struct {
 	Interface      string
 	ApiKeys        map[string]string
 	AllowedActions map[string][]string
+	// AuthDevice is the device id of the auth service that validates
+	// tokens. Defaults to the auth service next to this one.
+	AuthDevice string `json:",omitempty"`
 }
 
 type Dependencies struct {
@@ -46,8 +55,12 @@ type Server struct {
 	cfg           Config
 	ClientFactory sarif.ClientFactory
 	apiClients    map[string]sarif.Client
+	apiMutex      sync.Mutex
 	Client        sarif.Client
 	websocket     websocket.Upgrader
+
+	schemas     map[string]sarif.ActionSchema
+	schemaMutex sync.Mutex
 }
 
 func GenerateApiKey() (string, error) {
@@ -91,6 +104,7 @@ func New(deps *Dependencies) *Server {
 			WriteBufferSize: 2014,
 			CheckOrigin:     func(r *http.Request) bool { return true },
 		},
+		schemas: make(map[string]sarif.ActionSchema),
 	}
 	return s
 }
@@ -99,6 +113,13 @@ func (s *Server) Enable() error {
 	dir := s.Config.Dir() + "/web"
 	http.Handle("/", http.FileServer(http.Dir(dir)))
 	http.HandleFunc(REST_URL, s.handleRestPublish)
+	http.HandleFunc(STREAM_URL, s.handleStream)
+	http.HandleFunc(REQUEST_URL, s.handleRequest)
+	http.HandleFunc(REQUEST_URL+"/", s.handleRequest)
+	http.HandleFunc(OPENAPI_URL, s.handleOpenAPI)
+
+	s.Client.Subscribe("proto/discovered", "self", s.handleDiscovered)
+	s.discover()
 
 	go func() {
 		s.Client.Log("info", "listening on "+s.cfg.Interface)
@@ -132,6 +153,8 @@ func parseAuthorizationHeader(h string) string {
 }
 
 func (s *Server) getApiClientByName(name string) (sarif.Client, error) {
+	s.apiMutex.Lock()
+	defer s.apiMutex.Unlock()
 	client, ok := s.apiClients[name]
 	if ok {
 		return client, nil
@@ -147,7 +170,27 @@ func (s *Server) getApiClientByName(name string) (sarif.Client, error) {
 	return client, nil
 }
 
-func (s *Server) checkAuthentication(req *http.Request) string {
+// apiUser is a client authenticated by a static API key or a token of the
+// auth service.
+type apiUser struct {
+	Name string
+	// Scopes restricts the actions the user may publish, nil allows all.
+	Scopes []string
+}
+
+func (u *apiUser) IsAllowed(msg sarif.Message) bool {
+	if u.Scopes == nil {
+		return true
+	}
+	for _, action := range u.Scopes {
+		if msg.IsAction(action) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) checkAuthentication(req *http.Request) *apiUser {
 	// Get authorization token.
 	token := ""
 	if auth := req.Header.Get("Authorization"); auth != "" {
@@ -156,62 +199,114 @@ func (s *Server) checkAuthentication(req *http.Request) string {
 	if token == "" && req.FormValue("authtoken") != "" {
 		token = req.FormValue("authtoken")
 	}
+	if token == "" {
+		return nil
+	}
 
 	// Find client to API key.
 	for name, stored := range s.cfg.ApiKeys {
 		if token == stored {
-			return name
+			return &apiUser{name, s.cfg.AllowedActions[name]}
 		}
 	}
-	return ""
+	return s.validateToken(token)
 }
 
-func (s *Server) clientIsAllowed(client string, msg sarif.Message) bool {
-	allowed, ok := s.cfg.AllowedActions[client]
-	if !ok {
-		return true
+// authDevice returns the device id of the auth service, by default its
+// sibling on the same server, e.g. "sarif/auth" for "sarif/web".
+func (s *Server) authDevice() string {
+	if s.cfg.AuthDevice != "" {
+		return s.cfg.AuthDevice
 	}
-	for _, action := range allowed {
-		if msg.IsAction(action) {
-			return true
-		}
+	id := s.Client.DeviceId()
+	if i := strings.LastIndex(id, "/"); i >= 0 {
+		return id[:i+1] + "auth"
 	}
-	return false
+	return "auth"
 }
 
-func (s *Server) handleRestPublish(w http.ResponseWriter, req *http.Request) {
-	defer req.Body.Close()
-	s.Client.Log("debug", "new REST request: "+req.URL.Path)
+// validateToken asks the auth service for the scopes of a token. Replies
+// of other devices are ignored.
+func (s *Server) validateToken(token string) *apiUser {
+	device := s.authDevice()
+	req := sarif.CreateMessage("auth/validate", map[string]string{
+		"token": token,
+	})
+	req.Destination = device
+	replies := s.Client.Request(req)
+	timeout := time.After(authTimeout)
+	for {
+		select {
+		case reply, ok := <-replies:
+			if !ok {
+				return nil
+			}
+			if reply.Source != device {
+				s.Client.Log("warn", "ignoring token validation of "+reply.Source)
+				continue
+			}
+			if reply.Action != "auth/valid" {
+				return nil
+			}
+			var t struct {
+				Id     string   `json:"id"`
+				Scopes []string `json:"scopes"`
+			}
+			if err := reply.DecodePayload(&t); err != nil || t.Id == "" {
+				return nil
+			}
+			if len(t.Scopes) == 0 {
+				t.Scopes = nil
+			}
+			return &apiUser{"token/" + t.Id, t.Scopes}
+		case <-timeout:
+			return nil
+		}
+	}
+}
 
-	// Check authentication.
-	name := s.checkAuthentication(req)
-	if name == "" {
+// authenticate checks the credentials of a request and returns the user
+// and its sarif client. On failure, an error is written to the response and
+// nil is returned.
+func (s *Server) authenticate(w http.ResponseWriter, req *http.Request) (*apiUser, sarif.Client) {
+	user := s.checkAuthentication(req)
+	if user == nil {
 		w.WriteHeader(401)
 		fmt.Fprintln(w, "Not authorized")
 		s.Client.Log("info", "authentication failed for "+req.RemoteAddr)
-		return
+		return nil, nil
 	}
-	s.Client.Log("info", "authenticated "+req.RemoteAddr+" for "+name)
-	client, err := s.getApiClientByName(name)
+	s.Client.Log("info", "authenticated "+req.RemoteAddr+" for "+user.Name)
+	client, err := s.getApiClientByName(user.Name)
 	if err != nil {
 		s.Client.Log("error", "could not create client: "+err.Error())
 		w.WriteHeader(500)
 		fmt.Fprintln(w, "Internal error creating client")
+		return nil, nil
+	}
+	return user, client
+}
+
+func (s *Server) handleRestPublish(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+	s.Client.Log("debug", "new REST request: "+req.URL.Path)
+
+	// Check authentication.
+	user, client := s.authenticate(w, req)
+	if user == nil {
+		return
 	}
 
 	// Create message from form values.
-	msg, err := RequestToMessage(req, REST_URL)
-	if err != nil {
-		s.Client.Log("warn", "REST bad request: "+err.Error())
-		w.WriteHeader(400)
-		fmt.Fprintln(w, "Bad request:", err)
+	msg, ok := s.parseRequest(w, req, REST_URL)
+	if !ok {
 		return
 	}
 
-	if !s.clientIsAllowed(name, msg) {
+	if !user.IsAllowed(msg) {
 		w.WriteHeader(401)
-		fmt.Fprintf(w, "'%s' is not authorized to publish '%s'", name, msg.Action)
-		s.Client.Log("warn", "REST '"+name+"' is not authorized to publish on "+msg.Action)
+		fmt.Fprintf(w, "'%s' is not authorized to publish '%s'", user.Name, msg.Action)
+		s.Client.Log("warn", "REST '"+user.Name+"' is not authorized to publish on "+msg.Action)
 		return
 	}
 
@@ -0,0 +1,60 @@
+// Copyright (C) 2017 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package xmpp
+
+import "strings"
+
+// User describes a JID that may talk to the bot.
+type User struct {
+	// Name is used for the sarif client ("xmpp/<name>") and in presence
+	// events. Defaults to the bare JID.
+	Name string
+	Role string
+}
+
+// Room is a multi-user chat the bot joins. It only reacts to messages
+// mentioning its nick, with the permissions of Role.
+type Room struct {
+	Jid      string
+	Password string
+	Role     string
+}
+
+// lookupUser finds the user entry of a bare JID. Entries of the form
+// "*@domain" match every JID of a domain.
+func (cfg Config) lookupUser(jid string) (User, bool) {
+	jid = strings.ToLower(jid)
+	if u, ok := cfg.Users[jid]; ok {
+		return u, true
+	}
+	if i := strings.Index(jid, "@"); i >= 0 {
+		if u, ok := cfg.Users["*"+jid[i:]]; ok {
+			return u, true
+		}
+	}
+	return User{}, false
+}
+
+// allowed reports whether a role may publish the action. Roles list
+// action prefixes, "*" permits every action.
+func (cfg Config) allowed(role, action string) bool {
+	for _, p := range cfg.Roles[role] {
+		if p == "*" || action == p || strings.HasPrefix(action, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// unrestricted reports whether a role may publish any action.
+func (cfg Config) unrestricted(role string) bool {
+	for _, p := range cfg.Roles[role] {
+		if p == "*" {
+			return true
+		}
+	}
+	return false
+}
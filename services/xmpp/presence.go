@@ -0,0 +1,110 @@
+// Copyright (C) 2017 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package xmpp
+
+import (
+	"strings"
+	"time"
+
+	"github.com/agl/xmpp-client/xmpp"
+	"github.com/sarifsystems/sarif/sarif"
+)
+
+const (
+	StatusOnline  = "online"
+	StatusAway    = "away"
+	StatusOffline = "offline"
+)
+
+// Presence is published as presence/changed/<name>/<status> whenever the
+// status of a known contact changes.
+type Presence struct {
+	Jid     string    `json:"jid"`
+	Name    string    `json:"name"`
+	Status  string    `json:"status"`
+	Message string    `json:"message,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+func (p Presence) Text() string {
+	return p.Name + " is " + p.Status + "."
+}
+
+func presenceStatus(p *xmpp.ClientPresence) string {
+	if p.Type == "unavailable" {
+		return StatusOffline
+	}
+	switch p.Show {
+	case "away", "xa", "dnd":
+		return StatusAway
+	}
+	return StatusOnline
+}
+
+// aggregateStatus combines the status of all resources of a JID.
+func aggregateStatus(resources map[string]string) string {
+	status := StatusOffline
+	for _, s := range resources {
+		if s == StatusOnline {
+			return s
+		}
+		if s == StatusAway {
+			status = s
+		}
+	}
+	return status
+}
+
+func (c *Client) handlePresence(p *xmpp.ClientPresence) {
+	jid := xmpp.RemoveResourceFromJid(p.From)
+	if _, ok := c.findRoom(jid); ok {
+		return
+	}
+	user, ok := c.cfg.lookupUser(jid)
+	if !ok {
+		return
+	}
+
+	switch p.Type {
+	case "subscribe":
+		if err := c.xmpp.SendPresence(jid, "subscribed", ""); err != nil {
+			c.Log.Errorln("[xmpp] subscribed:", err)
+		}
+		return
+	case "", "unavailable":
+	default:
+		return
+	}
+
+	resources, ok := c.presences[jid]
+	if !ok {
+		resources = make(map[string]string)
+		c.presences[jid] = resources
+	}
+	prev := aggregateStatus(resources)
+	resource := strings.TrimPrefix(p.From, jid)
+	if status := presenceStatus(p); status == StatusOffline {
+		delete(resources, resource)
+	} else {
+		resources[resource] = status
+	}
+	curr := aggregateStatus(resources)
+	if curr == prev {
+		return
+	}
+
+	name := user.Name
+	if name == "" {
+		name = jid
+	}
+	c.Proto.Publish(sarif.CreateMessage("presence/changed/"+name+"/"+curr, Presence{
+		Jid:     jid,
+		Name:    name,
+		Status:  curr,
+		Message: p.Status,
+		Time:    time.Now(),
+	}))
+}
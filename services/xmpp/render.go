@@ -0,0 +1,150 @@
+// Copyright (C) 2017 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package xmpp
+
+import (
+	"encoding/xml"
+	"html"
+	"strings"
+
+	"github.com/sarifsystems/sarif/pkg/natural"
+	"github.com/sarifsystems/sarif/pkg/schema"
+	"github.com/sarifsystems/sarif/sarif"
+)
+
+// richMessage is a message stanza with an optional XHTML-IM (XEP-0071)
+// body and out-of-band data (XEP-0066).
+type richMessage struct {
+	XMLName xml.Name `xml:"jabber:client message"`
+	To      string   `xml:"to,attr"`
+	Type    string   `xml:"type,attr"`
+	Body    string   `xml:"body"`
+	HTML    *xhtmlIM `xml:"http://jabber.org/protocol/xhtml-im html,omitempty"`
+	OOB     []oobData
+}
+
+type xhtmlIM struct {
+	Body xhtmlBody
+}
+
+type xhtmlBody struct {
+	XMLName xml.Name `xml:"http://www.w3.org/1999/xhtml body"`
+	Inner   string   `xml:",innerxml"`
+}
+
+type oobData struct {
+	XMLName xml.Name `xml:"jabber:x:oob x"`
+	Url     string   `xml:"url"`
+	Desc    string   `xml:"desc,omitempty"`
+}
+
+type attachmentPayload struct {
+	Attachments []schema.Attachment `json:"attachments"`
+}
+
+// renderMessage converts a sarif message into a message stanza, rendering
+// attachments as rich text and links as out-of-band data.
+func renderMessage(msg sarif.Message, to, typ string) richMessage {
+	m := richMessage{
+		To:   to,
+		Type: typ,
+		Body: natural.FormatSimple(msg),
+	}
+
+	var p attachmentPayload
+	if err := msg.DecodePayload(&p); err != nil || len(p.Attachments) == 0 {
+		return m
+	}
+
+	plain := make([]string, 0)
+	rich := make([]string, 0)
+	if msg.Text != "" {
+		plain = append(plain, msg.Text)
+		rich = append(rich, escapeLines(msg.Text))
+	}
+	for _, a := range p.Attachments {
+		plain = append(plain, renderPlain(a)...)
+		rich = append(rich, renderXhtml(a)...)
+
+		if a.ImageUrl != "" {
+			m.OOB = append(m.OOB, oobData{Url: a.ImageUrl, Desc: a.Title})
+		} else if a.TitleLink != "" {
+			m.OOB = append(m.OOB, oobData{Url: a.TitleLink, Desc: a.Title})
+		}
+	}
+
+	m.Body = strings.Join(plain, "\n")
+	m.HTML = &xhtmlIM{xhtmlBody{Inner: strings.Join(rich, "<br/>")}}
+	return m
+}
+
+func escapeLines(s string) string {
+	return strings.Replace(html.EscapeString(s), "\n", "<br/>", -1)
+}
+
+func renderPlain(a schema.Attachment) []string {
+	lines := make([]string, 0)
+	if a.Pretext != "" {
+		lines = append(lines, a.Pretext)
+	}
+	if a.AuthorName != "" {
+		lines = append(lines, a.AuthorName)
+	}
+	if a.Title != "" {
+		title := a.Title
+		if a.TitleLink != "" {
+			title += " <" + a.TitleLink + ">"
+		}
+		lines = append(lines, title)
+	}
+	if a.Text != "" {
+		lines = append(lines, a.Text)
+	} else if a.Title == "" && len(a.Fields) == 0 && a.Fallback != "" {
+		lines = append(lines, a.Fallback)
+	}
+	for _, f := range a.Fields {
+		lines = append(lines, f.Title+": "+f.Value)
+	}
+	if a.Footer != "" {
+		lines = append(lines, a.Footer)
+	}
+	return lines
+}
+
+func renderXhtml(a schema.Attachment) []string {
+	link := func(text, href string) string {
+		if href == "" {
+			return text
+		}
+		return `<a href="` + html.EscapeString(href) + `">` + text + "</a>"
+	}
+
+	lines := make([]string, 0)
+	if a.Pretext != "" {
+		lines = append(lines, escapeLines(a.Pretext))
+	}
+	if a.AuthorName != "" {
+		lines = append(lines, "<em>"+link(html.EscapeString(a.AuthorName), a.AuthorLink)+"</em>")
+	}
+	if a.Title != "" {
+		lines = append(lines, "<strong>"+link(html.EscapeString(a.Title), a.TitleLink)+"</strong>")
+	}
+	if a.Text != "" {
+		lines = append(lines, escapeLines(a.Text))
+	} else if a.Title == "" && len(a.Fields) == 0 && a.Fallback != "" {
+		lines = append(lines, escapeLines(a.Fallback))
+	}
+	for _, f := range a.Fields {
+		lines = append(lines, "<strong>"+html.EscapeString(f.Title)+":</strong> "+html.EscapeString(f.Value))
+	}
+	if a.ImageUrl != "" {
+		lines = append(lines, `<img src="`+html.EscapeString(a.ImageUrl)+`" alt="`+html.EscapeString(a.Title)+`"/>`)
+	}
+	if a.Footer != "" {
+		lines = append(lines, "<em>"+html.EscapeString(a.Footer)+"</em>")
+	}
+	return lines
+}
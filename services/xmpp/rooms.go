@@ -0,0 +1,78 @@
+// Copyright (C) 2017 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package xmpp
+
+import (
+	"encoding/xml"
+	"strings"
+)
+
+// mucPresence joins a multi-user chat (XEP-0045).
+type mucPresence struct {
+	XMLName xml.Name `xml:"jabber:client presence"`
+	To      string   `xml:"to,attr"`
+	X       mucJoin
+}
+
+type mucJoin struct {
+	XMLName  xml.Name   `xml:"http://jabber.org/protocol/muc x"`
+	Password string     `xml:"password,omitempty"`
+	History  mucHistory `xml:"history"`
+}
+
+type mucHistory struct {
+	MaxStanzas int `xml:"maxstanzas,attr"`
+}
+
+func (c *Client) nick() string {
+	if c.cfg.Nick != "" {
+		return c.cfg.Nick
+	}
+	return c.cfg.User
+}
+
+func (c *Client) joinRooms() error {
+	for _, r := range c.cfg.Rooms {
+		c.Log.Infoln("[xmpp] joining room", r.Jid)
+		// Skip the room history, we do not want to react to old mentions.
+		err := c.xmpp.SendStanza(mucPresence{
+			To: r.Jid + "/" + c.nick(),
+			X:  mucJoin{Password: r.Password},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Client) findRoom(jid string) (Room, bool) {
+	for _, r := range c.cfg.Rooms {
+		if strings.EqualFold(r.Jid, jid) {
+			return r, true
+		}
+	}
+	return Room{}, false
+}
+
+// mentioned checks whether a group chat message addresses the nick, either
+// as "nick: text", "nick, text" or "@nick text", and returns the message
+// without the mention.
+func mentioned(body, nick string) (string, bool) {
+	b := strings.TrimPrefix(body, "@")
+	if nick == "" || len(b) < len(nick) || !strings.EqualFold(b[0:len(nick)], nick) {
+		return "", false
+	}
+	rest := b[len(nick):]
+	if rest == "" {
+		return "", true
+	}
+	switch rest[0] {
+	case ':', ',', ' ':
+		return strings.TrimSpace(rest[1:]), true
+	}
+	return "", false
+}
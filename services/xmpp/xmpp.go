@@ -34,10 +34,20 @@ type Config struct {
 	User     string
 	Domain   string
 	Password string
+	Nick     string
+
+	// Users maps bare JIDs to their identity. Messages from other JIDs
+	// are ignored.
+	Users map[string]User
+	// Roles maps role names to the action prefixes they may publish.
+	Roles map[string][]string
+	Rooms []Room
 }
 
 type conversation struct {
 	Remote string
+	Type   string
+	Role   string
 	Proto  sarif.Client
 	Xmpp   *Client
 }
@@ -46,8 +56,10 @@ type Client struct {
 	cfg           Config
 	Log           sfproto.Logger
 	ClientFactory sarif.ClientFactory
+	Proto         sarif.Client
 	xmpp          *xmpp.Conn
 	conversations map[string]*conversation
+	presences     map[string]map[string]string
 }
 
 func New(deps *Dependencies) *Client {
@@ -55,12 +67,28 @@ func New(deps *Dependencies) *Client {
 		Log:           deps.Log,
 		ClientFactory: deps.ClientFactory,
 		conversations: make(map[string]*conversation, 0),
+		presences:     make(map[string]map[string]string),
+	}
+	c.cfg.Roles = map[string][]string{
+		"admin": {"*"},
 	}
 	deps.Config.Get(&c.cfg)
+
+	users := make(map[string]User, len(c.cfg.Users))
+	for jid, u := range c.cfg.Users {
+		users[strings.ToLower(jid)] = u
+	}
+	c.cfg.Users = users
 	return c
 }
 
 func (c *Client) Enable() (err error) {
+	c.Proto, err = c.ClientFactory.NewClient(sarif.ClientInfo{
+		Name: "xmpp",
+	})
+	if err != nil {
+		return err
+	}
 	return c.connectXmpp()
 }
 
@@ -71,7 +99,10 @@ func (c *Client) connectXmpp() (err error) {
 		return err
 	}
 	go c.listen()
-	return c.xmpp.SignalPresence("")
+	if err := c.xmpp.SignalPresence(""); err != nil {
+		return err
+	}
+	return c.joinRooms()
 }
 
 func (c *Client) reconnectLoop() {
@@ -85,14 +116,22 @@ func (c *Client) reconnectLoop() {
 	}
 }
 
-func (cv *conversation) handleProtoMessage(msg sarif.Message) {
-	text := natural.FormatSimple(msg)
-	cv.Xmpp.Log.Debugf("[xmpp] send '%s' to '%s'", text, cv.Remote)
-	if err := cv.Xmpp.xmpp.Send(cv.Remote, text); err != nil {
+func (cv *conversation) send(msg sarif.Message) {
+	m := renderMessage(msg, cv.Remote, cv.Type)
+	cv.Xmpp.Log.Debugf("[xmpp] send '%s' to '%s'", m.Body, cv.Remote)
+	if err := cv.Xmpp.xmpp.SendStanza(m); err != nil {
 		cv.Xmpp.Log.Errorln("[xmpp] send:", err)
 	}
 }
 
+func (cv *conversation) sendText(text string) {
+	cv.send(sarif.Message{Text: text})
+}
+
+func (cv *conversation) handleProtoMessage(msg sarif.Message) {
+	cv.send(msg)
+}
+
 func (c *Client) listen() {
 	for {
 		stanza, err := c.xmpp.Next()
@@ -104,55 +143,120 @@ func (c *Client) listen() {
 		switch v := stanza.Value.(type) {
 		case *xmpp.ClientMessage:
 			c.handleChatMessage(v)
+		case *xmpp.ClientPresence:
+			c.handlePresence(v)
 		default:
 			c.Log.Debugln("[xmpp] stanza", stanza.Name, v)
 		}
 	}
 }
 
-func (c *Client) newConversation(remote string) *conversation {
-	user := xmpp.RemoveResourceFromJid(remote)
+func (c *Client) newConversation(key, name, remote, typ, role string) *conversation {
 	client, _ := c.ClientFactory.NewClient(sarif.ClientInfo{
-		Name: "xmpp/" + user,
+		Name: "xmpp/" + name,
 	})
 	cv := &conversation{
 		Remote: remote,
+		Type:   typ,
+		Role:   role,
 		Proto:  client,
 		Xmpp:   c,
 	}
 	if err := client.Subscribe("", "self", cv.handleProtoMessage); err != nil {
 		c.Log.Errorln("[xmpp] new:", err)
 	}
-	c.conversations[user] = cv
+	c.conversations[key] = cv
 	return cv
 }
 
 func (c *Client) handleChatMessage(chat *xmpp.ClientMessage) {
 	c.Log.Debugln("[xmpp] chat: ", chat)
-	if chat.Type != "chat" {
+	if chat.Body == "" || chat.Delay != nil {
 		return
 	}
-	if chat.Body == "" {
+
+	var cv *conversation
+	text := chat.Body
+	switch chat.Type {
+	case "chat":
+		jid := xmpp.RemoveResourceFromJid(chat.From)
+		user, ok := c.cfg.lookupUser(jid)
+		if !ok {
+			c.Log.Infoln("[xmpp] ignoring message from unknown JID", jid)
+			return
+		}
+		if cv, ok = c.conversations[jid]; !ok {
+			name := user.Name
+			if name == "" {
+				name = jid
+			}
+			cv = c.newConversation(jid, name, chat.From, "chat", user.Role)
+		}
+		cv.Remote = chat.From
+
+	case "groupchat":
+		jid := xmpp.RemoveResourceFromJid(chat.From)
+		room, ok := c.findRoom(jid)
+		if !ok || strings.EqualFold(strings.TrimPrefix(chat.From, jid+"/"), c.nick()) {
+			return
+		}
+		if text, ok = mentioned(chat.Body, c.nick()); !ok || text == "" {
+			return
+		}
+		if cv, ok = c.conversations[jid]; !ok {
+			cv = c.newConversation(jid, "room/"+jid, jid, "groupchat", room.Role)
+		}
+
+	default:
 		return
 	}
 
-	cv, ok := c.conversations[xmpp.RemoveResourceFromJid(chat.From)]
-	if !ok {
-		cv = c.newConversation(chat.From)
+	if strings.HasPrefix(text, ".subscribe ") {
+		action := strings.TrimPrefix(text, ".subscribe ")
+		if action == "" {
+			return
+		}
+		if !c.cfg.allowed(cv.Role, action) {
+			cv.sendText("You are not allowed to subscribe to " + action + ".")
+			return
+		}
+		if err := cv.Proto.Subscribe(action, "", cv.handleProtoMessage); err != nil {
+			c.Log.Errorln("[xmpp] subscribe:", err)
+		}
+		return
 	}
 
-	if strings.HasPrefix(chat.Body, ".subscribe ") {
-		action := strings.TrimPrefix(chat.Body, ".subscribe ")
-		if action != "" {
-			if err := cv.Proto.Subscribe(action, "", cv.handleProtoMessage); err != nil {
-				c.Log.Errorln("[xmpp] subscribe:", err)
-			}
-		}
+	if c.cfg.unrestricted(cv.Role) {
+		cv.Proto.Publish(sarif.Message{
+			Action: "natural/handle",
+			Text:   text,
+		})
 		return
 	}
+	go cv.handleRestricted(text)
+}
 
-	cv.Proto.Publish(sarif.Message{
-		Action: "natural/handle",
-		Text:   chat.Body,
+// handleRestricted parses the text and only publishes the resulting action
+// if the role of the conversation permits it.
+func (cv *conversation) handleRestricted(text string) {
+	reply, ok := <-cv.Proto.Request(sarif.Message{
+		Action: "natural/parse",
+		Text:   text,
 	})
+	var res natural.ParseResult
+	if !ok || !reply.IsAction("natural/parsed") || reply.DecodePayload(&res) != nil || len(res.Intents) == 0 {
+		cv.sendText("I didn't understand your message.")
+		return
+	}
+
+	pred := res.Intents[0]
+	if !cv.Xmpp.cfg.allowed(cv.Role, pred.Message.Action) {
+		cv.Xmpp.Log.Infoln("[xmpp] denied", pred.Message.Action, "for", cv.Remote)
+		cv.sendText("You are not allowed to do that.")
+		return
+	}
+	if pred.Message.Text == "" && pred.Type != "simple" {
+		pred.Message.Text = text
+	}
+	cv.Proto.Publish(pred.Message)
 }
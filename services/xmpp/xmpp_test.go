@@ -0,0 +1,124 @@
+// Copyright (C) 2017 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package xmpp
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	"github.com/sarifsystems/sarif/pkg/schema"
+	"github.com/sarifsystems/sarif/sarif"
+)
+
+func TestAccess(t *testing.T) {
+	cfg := Config{
+		Users: map[string]User{
+			"me@example.org":   {Role: "admin"},
+			"*@family.example": {Role: "family"},
+		},
+		Roles: map[string][]string{
+			"admin":  {"*"},
+			"family": {"music", "knowledge/query"},
+		},
+	}
+
+	if _, ok := cfg.lookupUser("stranger@example.org"); ok {
+		t.Error("stranger should not be allowed")
+	}
+	u, ok := cfg.lookupUser("Mom@family.example")
+	if !ok || u.Role != "family" {
+		t.Errorf("expected family role, got %v %v", u, ok)
+	}
+
+	tests := []struct {
+		role, action string
+		allowed      bool
+	}{
+		{"admin", "devices/shutdown", true},
+		{"family", "music/play", true},
+		{"family", "music", true},
+		{"family", "musicbox", false},
+		{"family", "knowledge/query", true},
+		{"family", "devices/shutdown", false},
+		{"unknown", "music/play", false},
+	}
+	for _, test := range tests {
+		if got := cfg.allowed(test.role, test.action); got != test.allowed {
+			t.Errorf("%s %s: expected %v", test.role, test.action, test.allowed)
+		}
+	}
+	if !cfg.unrestricted("admin") || cfg.unrestricted("family") {
+		t.Error("only admin should be unrestricted")
+	}
+}
+
+func TestMentioned(t *testing.T) {
+	tests := []struct {
+		body, text string
+		ok         bool
+	}{
+		{"sarif: what is playing?", "what is playing?", true},
+		{"Sarif, next song", "next song", true},
+		{"@sarif ping", "ping", true},
+		{"sarifsystems is great", "", false},
+		{"hey sarif", "", false},
+	}
+	for _, test := range tests {
+		text, ok := mentioned(test.body, "sarif")
+		if text != test.text || ok != test.ok {
+			t.Errorf("%q: expected (%q, %v), got (%q, %v)", test.body, test.text, test.ok, text, ok)
+		}
+	}
+}
+
+func TestRenderMessage(t *testing.T) {
+	msg := sarif.CreateMessage("mock/attachments", attachmentPayload{
+		Attachments: []schema.Attachment{{
+			Title:     "A <b> title",
+			TitleLink: "http://example.org/?a=1&b=2",
+			Fields:    []schema.AttachmentField{{Title: "Key", Value: "Value"}},
+		}},
+	})
+	msg.Text = "Look:"
+
+	m := renderMessage(msg, "me@example.org", "chat")
+	if m.Body != "Look:\nA <b> title <http://example.org/?a=1&b=2>\nKey: Value" {
+		t.Errorf("unexpected body: %q", m.Body)
+	}
+	if len(m.OOB) != 1 || m.OOB[0].Url != "http://example.org/?a=1&b=2" {
+		t.Errorf("unexpected oob data: %v", m.OOB)
+	}
+
+	out, err := xml.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := string(out)
+	for _, want := range []string{
+		`<html xmlns="http://jabber.org/protocol/xhtml-im"><body xmlns="http://www.w3.org/1999/xhtml">`,
+		`<strong><a href="http://example.org/?a=1&amp;b=2">A &lt;b&gt; title</a></strong>`,
+		`<x xmlns="jabber:x:oob"><url>http://example.org/?a=1&amp;b=2</url>`,
+	} {
+		if !strings.Contains(s, want) {
+			t.Errorf("expected %s in %s", want, s)
+		}
+	}
+
+	plain := renderMessage(sarif.Message{Text: "hi"}, "me@example.org", "chat")
+	if plain.HTML != nil || plain.Body != "hi" {
+		t.Errorf("unexpected plain message: %+v", plain)
+	}
+}
+
+func TestAggregateStatus(t *testing.T) {
+	if s := aggregateStatus(nil); s != StatusOffline {
+		t.Errorf("expected offline, got %s", s)
+	}
+	if s := aggregateStatus(map[string]string{"/a": StatusAway, "/b": StatusOnline}); s != StatusOnline {
+		t.Errorf("expected online, got %s", s)
+	}
+}
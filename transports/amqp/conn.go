@@ -7,49 +7,69 @@ package amqp
 
 import (
 	"log"
+	"sync"
 	"time"
 
 	"github.com/sarifsystems/sarif/transports/sfproto"
 	"github.com/streadway/amqp"
 )
 
+const reconnectDelay = 3 * time.Second
+
 type amqpConn struct {
 	cfg       *sfproto.NetConfig
+	mu        sync.Mutex
 	conn      *amqp.Connection
 	listeners []chan *amqp.Connection
 }
 
-func (c *amqpConn) Dial() (err error) {
-	c.conn, err = amqp.Dial(c.cfg.Address)
+func (c *amqpConn) Dial() error {
+	conn, err := amqp.Dial(c.cfg.Address)
 	if err != nil {
 		return err
 	}
 
-	go c.reconnector()
+	c.mu.Lock()
+	c.conn = conn
+	c.mu.Unlock()
+	go c.reconnector(conn)
 
 	return nil
 }
 
+// Conn returns the current connection.
+func (c *amqpConn) Conn() *amqp.Connection {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn
+}
+
 func (c *amqpConn) NotifyReconnect(listener chan *amqp.Connection) chan *amqp.Connection {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.listeners = append(c.listeners, listener)
 
 	return listener
 }
 
-func (c *amqpConn) reconnector() {
-	reason, ok := <-c.conn.NotifyClose(make(chan *amqp.Error))
+func (c *amqpConn) reconnector(conn *amqp.Connection) {
+	reason, ok := <-conn.NotifyClose(make(chan *amqp.Error, 1))
 	if !ok {
 		return
 	}
 
 	log.Printf("connection closed, reason: %v", reason)
 	for {
-		time.Sleep(3 * time.Second)
+		time.Sleep(reconnectDelay)
 		err := c.Dial()
 		if err == nil {
 			log.Printf("connection reconnected")
-			for _, l := range c.listeners {
-				l <- c.conn
+			c.mu.Lock()
+			listeners := c.listeners
+			conn := c.conn
+			c.mu.Unlock()
+			for _, l := range listeners {
+				l <- conn
 			}
 			return
 		}
@@ -8,20 +8,36 @@ package amqp
 import (
 	"encoding/json"
 	"errors"
-	"strings"
+	"log"
+	"sync"
+	"time"
 
 	"github.com/sarifsystems/sarif/sarif"
 	"github.com/sarifsystems/sarif/transports/sfproto"
 	"github.com/streadway/amqp"
 )
 
+const (
+	exchangeName = "sarif"
+	// deviceQueueTTL limits how long messages are kept for offline devices.
+	deviceQueueTTL = 24 * time.Hour
+)
+
 type connection struct {
 	conn     *connpair
+	mu       sync.Mutex
 	in       *amqp.Channel
 	out      *amqp.Channel
 	messages chan sarif.Message
-	queue    amqp.Queue
-	topics   []string
+	closed   bool
+
+	// queue receives broadcast messages while connected.
+	queue  amqp.Queue
+	topics []string
+	// device is the durable queue for messages directed to this device,
+	// which are kept while it is offline.
+	device       string
+	deviceTopics []string
 }
 
 func Dial(cfg *sfproto.NetConfig) (sarif.Connection, error) {
@@ -45,30 +61,69 @@ func newConnection(conn *connpair) (sarif.Connection, error) {
 	}
 
 	go func() {
-		ch := conn.out.NotifyReconnect(make(chan *amqp.Connection))
+		ch := conn.out.NotifyReconnect(make(chan *amqp.Connection, 1))
 		for _ = range ch {
-			aconn.setupOutgoing()
+			if err := aconn.setupOutgoing(); err != nil {
+				log.Printf("outgoing channel setup failed, reason: %v", err)
+			}
 		}
 	}()
 
 	go func() {
-		ch := conn.in.NotifyReconnect(make(chan *amqp.Connection))
+		ch := conn.in.NotifyReconnect(make(chan *amqp.Connection, 1))
 		for _ = range ch {
-			aconn.setupIncoming()
+			if err := aconn.setupIncoming(); err != nil {
+				log.Printf("incoming channel setup failed, reason: %v", err)
+			}
 		}
 	}()
 
 	return aconn, nil
 }
 
-func (c *connection) setupOutgoing() (err error) {
-	c.out, err = c.conn.out.conn.Channel()
+// watch reopens a channel that was closed by the server while the
+// connection is still alive. Closed connections are handled by the
+// reconnector.
+func (c *connection) watch(ch *amqp.Channel, conn *amqpConn, setup func() error) {
+	reason, ok := <-ch.NotifyClose(make(chan *amqp.Error, 1))
+	if !ok {
+		return
+	}
+
+	log.Printf("channel closed, reason: %v", reason)
+	for {
+		time.Sleep(reconnectDelay)
+		c.mu.Lock()
+		closed := c.closed
+		c.mu.Unlock()
+		if closed || conn.Conn().IsClosed() {
+			return
+		}
+		if err := setup(); err == nil {
+			return
+		}
+	}
+}
+
+func (c *connection) setupOutgoing() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	if c.out != nil {
+		c.out.Close()
+	}
+
+	out, err := c.conn.out.Conn().Channel()
 	if err != nil {
 		return err
 	}
+	c.out = out
+	go c.watch(out, c.conn.out, c.setupOutgoing)
 
-	err = c.out.ExchangeDeclare(
-		"sarif",
+	return out.ExchangeDeclare(
+		exchangeName,
 		"topic",
 		true,
 		false,
@@ -76,17 +131,26 @@ func (c *connection) setupOutgoing() (err error) {
 		false,
 		nil,
 	)
-
-	return err
 }
 
-func (c *connection) setupIncoming() (err error) {
-	c.in, err = c.conn.in.conn.Channel()
+func (c *connection) setupIncoming() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	if c.in != nil {
+		c.in.Close()
+	}
+
+	in, err := c.conn.in.Conn().Channel()
 	if err != nil {
 		return err
 	}
+	c.in = in
+	go c.watch(in, c.conn.in, c.setupIncoming)
 
-	c.queue, err = c.in.QueueDeclare(
+	c.queue, err = in.QueueDeclare(
 		"",
 		false,
 		false,
@@ -97,15 +161,44 @@ func (c *connection) setupIncoming() (err error) {
 	if err != nil {
 		return err
 	}
+	if err := c.bindAndConsume(c.queue.Name, c.topics); err != nil {
+		return err
+	}
 
-	for _, topic := range c.topics {
-		if err := c.in.QueueBind(c.queue.Name, topic, "sarif", false, nil); err != nil {
+	if c.device != "" {
+		if err := c.declareDeviceQueue(); err != nil {
+			return err
+		}
+		return c.bindAndConsume(deviceQueue(c.device), c.deviceTopics)
+	}
+	return nil
+}
+
+func deviceQueue(device string) string {
+	return "sarif.device." + device
+}
+
+func (c *connection) declareDeviceQueue() error {
+	_, err := c.in.QueueDeclare(
+		deviceQueue(c.device),
+		true,
+		false,
+		false,
+		false,
+		amqp.Table{"x-message-ttl": int64(deviceQueueTTL / time.Millisecond)},
+	)
+	return err
+}
+
+func (c *connection) bindAndConsume(queue string, topics []string) error {
+	for _, topic := range topics {
+		if err := c.in.QueueBind(queue, topic, exchangeName, false, nil); err != nil {
 			return err
 		}
 	}
 
 	msgs, err := c.in.Consume(
-		c.queue.Name,
+		queue,
 		"",
 		true,
 		false,
@@ -128,34 +221,46 @@ func (c *connection) setupIncoming() (err error) {
 		}
 	}()
 
-	return err
+	return nil
 }
 
 func (c *connection) Publish(msg sarif.Message) error {
 	if err := msg.IsValid(); err != nil {
 		return err
 	}
-	if c.conn == nil {
+	c.mu.Lock()
+	out := c.out
+	c.mu.Unlock()
+	if out == nil {
 		err := errors.New("not connected")
 		return err
 	}
 
+	return publish(out, msg)
+}
+
+func publish(ch *amqp.Channel, msg sarif.Message) error {
 	body, err := json.Marshal(msg)
 	if err != nil {
 		return err
 	}
 
-	topic := getTopic(msg.Action, msg.Destination)
+	p := amqp.Publishing{
+		ContentType: "application/json",
+		Body:        body,
+	}
+	if msg.Destination != "" {
+		// Directed messages may wait in a durable device queue.
+		p.DeliveryMode = amqp.Persistent
+	}
 
-	return c.out.Publish(
-		"sarif",
+	topic := getTopic(msg.Action, msg.Destination)
+	return ch.Publish(
+		exchangeName,
 		topic,
 		false,
 		false,
-		amqp.Publishing{
-			ContentType: "application/json",
-			Body:        body,
-		},
+		p,
 	)
 }
 
@@ -163,26 +268,54 @@ func (c *connection) Subscribe(src, action, dest string) error {
 	if dest == "self" {
 		dest = src
 	}
-	topic := strings.TrimLeft(getTopic(action, dest)+".#", ".")
-	if err := c.in.QueueBind(c.queue.Name, topic, "sarif", false, nil); err != nil {
+	topic := getBinding(action, dest)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.in == nil {
+		return errors.New("not connected")
+	}
+
+	if dest == "" || dest != src || (c.device != "" && c.device != src) {
+		if err := c.in.QueueBind(c.queue.Name, topic, exchangeName, false, nil); err != nil {
+			return err
+		}
+		c.topics = append(c.topics, topic)
+		return nil
+	}
+
+	if c.device == "" {
+		c.device = src
+		if err := c.declareDeviceQueue(); err != nil {
+			c.device = ""
+			return err
+		}
+		if err := c.bindAndConsume(deviceQueue(c.device), nil); err != nil {
+			c.device = ""
+			return err
+		}
+	}
+	if err := c.in.QueueBind(deviceQueue(c.device), topic, exchangeName, false, nil); err != nil {
 		return err
 	}
-	c.topics = append(c.topics, topic)
+	c.deviceTopics = append(c.deviceTopics, topic)
 
 	return nil
 }
 
 func (c *connection) Close() error {
-	if c.conn == nil {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
 		return nil
 	}
+	c.closed = true
 	if err := c.in.Close(); err != nil {
 		return err
 	}
 	if err := c.out.Close(); err != nil {
 		return err
 	}
-	c.conn = nil
 	c.in = nil
 	c.out = nil
 	return nil
@@ -0,0 +1,164 @@
+// Copyright (C) 2019 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package amqp
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+
+	"github.com/sarifsystems/sarif/sarif"
+	"github.com/sarifsystems/sarif/transports/sfproto"
+	"github.com/streadway/amqp"
+)
+
+type subscription struct {
+	Action string `json:"action,omitempty"`
+	Device string `json:"device,omitempty"`
+}
+
+// GatewayConn connects a broker to the sarif AMQP exchange, so that several
+// brokers and AMQP clients can federate through it. It is served with
+// ListenOnGateway or ListenOnBridge of the broker: messages written to it
+// are published to the exchange, subscriptions are turned into bindings of
+// its queue.
+type GatewayConn struct {
+	conn     *amqp.Connection
+	ch       *amqp.Channel
+	queue    amqp.Queue
+	messages <-chan amqp.Delivery
+	closed   chan *amqp.Error
+
+	mu       sync.Mutex
+	received []string
+	recIndex int
+}
+
+// DialGateway connects to an AMQP server and declares the exchange and
+// the queue of the broker.
+func DialGateway(cfg *sfproto.NetConfig) (*GatewayConn, error) {
+	conn, err := amqp.Dial(cfg.Address)
+	if err != nil {
+		return nil, err
+	}
+	c := &GatewayConn{
+		conn:     conn,
+		received: make([]string, 256),
+	}
+	if err := c.setup(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *GatewayConn) setup() (err error) {
+	c.ch, err = c.conn.Channel()
+	if err != nil {
+		return err
+	}
+	c.closed = c.ch.NotifyClose(make(chan *amqp.Error, 1))
+
+	err = c.ch.ExchangeDeclare(exchangeName, "topic", true, false, false, false, nil)
+	if err != nil {
+		return err
+	}
+	c.queue, err = c.ch.QueueDeclare("", false, false, true, false, nil)
+	if err != nil {
+		return err
+	}
+	c.messages, err = c.ch.Consume(c.queue.Name, "", true, false, false, false, nil)
+	return err
+}
+
+// Read returns the next message from the exchange.
+func (c *GatewayConn) Read() (sarif.Message, error) {
+	for {
+		amsg, ok := <-c.messages
+		if !ok {
+			if err, ok := <-c.closed; ok && err != nil {
+				return sarif.Message{}, err
+			}
+			return sarif.Message{}, io.EOF
+		}
+
+		var msg sarif.Message
+		if err := json.Unmarshal(amsg.Body, &msg); err != nil {
+			continue
+		}
+		c.remember(msg.Id)
+		return msg, nil
+	}
+}
+
+// Write publishes a message to the exchange, unless it was received from
+// it. Subscriptions of the broker bind the queue to the matching topics.
+func (c *GatewayConn) Write(msg sarif.Message) error {
+	switch {
+	case msg.IsAction("proto/sub"), msg.IsAction("proto/unsub"):
+		var sub subscription
+		if err := msg.DecodePayload(&sub); err != nil {
+			return nil
+		}
+		return c.bind(msg.IsAction("proto/sub"), sub)
+	case msg.IsAction("proto/subs"), msg.IsAction("proto/unsubs"):
+		var subs []subscription
+		if err := msg.DecodePayload(&subs); err != nil {
+			return nil
+		}
+		for _, sub := range subs {
+			if err := c.bind(msg.IsAction("proto/subs"), sub); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if c.wasReceived(msg.Id) {
+		return nil
+	}
+	return publish(c.ch, msg)
+}
+
+func (c *GatewayConn) bind(bind bool, sub subscription) error {
+	topic := getBinding(sub.Action, sub.Device)
+	if bind {
+		return c.ch.QueueBind(c.queue.Name, topic, exchangeName, false, nil)
+	}
+	return c.ch.QueueUnbind(c.queue.Name, topic, exchangeName, nil)
+}
+
+// Close closes the connection to the AMQP server.
+func (c *GatewayConn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *GatewayConn) String() string {
+	return "amqp " + c.queue.Name
+}
+
+// remember stores the ids of received messages, so that they are not
+// published back to the exchange when the broker passes them on.
+func (c *GatewayConn) remember(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.received[c.recIndex] = id
+	c.recIndex = (c.recIndex + 1) % len(c.received)
+}
+
+func (c *GatewayConn) wasReceived(id string) bool {
+	if id == "" {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, r := range c.received {
+		if r == id {
+			return true
+		}
+	}
+	return false
+}
@@ -9,21 +9,45 @@ import (
 	"strings"
 )
 
+// AMQP topic exchanges separate words by "." and treat "*" and "#" as
+// wildcards, so these are escaped in the individual topic parts.
+var (
+	escaper   = strings.NewReplacer("%", "%25", ".", "%2E", "*", "%2A", "#", "%23")
+	unescaper = strings.NewReplacer("%25", "%", "%2E", ".", "%2A", "*", "%23", "#")
+)
+
+// getTopic returns the routing key of a message, mirroring the sfproto
+// topic "dev/<device>/action/<action>".
 func getTopic(action, device string) string {
-	t := ""
+	parts := make([]string, 0)
 	if device != "" {
-		t += "/dev/" + device
+		parts = append(parts, "dev")
+		parts = append(parts, strings.Split(device, "/")...)
 	}
 	if action != "" {
-		t += "/action/" + action
+		parts = append(parts, "action")
+		parts = append(parts, strings.Split(action, "/")...)
+	}
+	for i, p := range parts {
+		parts[i] = escaper.Replace(p)
+	}
+	return strings.Join(parts, ".")
+}
+
+// getBinding returns the binding key of a subscription. Like in the sfproto
+// subtree, a subscription matches all subtopics and "+" matches a single
+// part.
+func getBinding(action, device string) string {
+	parts := topicParts(getTopic(action, device))
+	for i, p := range parts {
+		if p == "+" {
+			parts[i] = "*"
+		}
 	}
-	t = strings.TrimLeft(t, "/")
-	t = strings.Replace(t, "/", ".", -1)
-	return t
+	return strings.Join(append(parts, "#"), ".")
 }
 
 func fromTopic(topic string) (string, string) {
-	topic = strings.Replace(topic, ".", "/", -1)
 	action, device := "", ""
 	foundDev, foundAction := false, false
 	for _, p := range topicParts(topic) {
@@ -35,6 +59,7 @@ func fromTopic(topic string) (string, string) {
 			foundAction = true
 			continue
 		}
+		p = unescaper.Replace(p)
 		if foundAction {
 			action += "/" + p
 		} else {
@@ -46,9 +71,9 @@ func fromTopic(topic string) (string, string) {
 	return action, device
 }
 
-func topicParts(action string) []string {
-	if action == "" {
+func topicParts(topic string) []string {
+	if topic == "" {
 		return []string{}
 	}
-	return strings.Split(action, ".")
+	return strings.Split(topic, ".")
 }
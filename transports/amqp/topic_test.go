@@ -0,0 +1,83 @@
+// Copyright (C) 2019 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package amqp
+
+import (
+	"strings"
+	"testing"
+)
+
+// matchBinding implements AMQP topic exchange matching.
+func matchBinding(binding, key []string) bool {
+	if len(binding) == 0 {
+		return len(key) == 0
+	}
+	switch binding[0] {
+	case "#":
+		for i := 0; i <= len(key); i++ {
+			if matchBinding(binding[1:], key[i:]) {
+				return true
+			}
+		}
+		return false
+	case "*":
+		return len(key) > 0 && matchBinding(binding[1:], key[1:])
+	}
+	return len(key) > 0 && binding[0] == key[0] && matchBinding(binding[1:], key[1:])
+}
+
+func TestTopics(t *testing.T) {
+	tests := []struct {
+		action, device string
+		topic          string
+	}{
+		{"ping", "", "action.ping"},
+		{"location/update", "", "action.location.update"},
+		{"ping", "host.local/sarifd", "dev.host%2Elocal.sarifd.action.ping"},
+		{"", "one", "dev.one"},
+		{"weird#*%", "", "action.weird%23%2A%25"},
+	}
+
+	for _, test := range tests {
+		topic := getTopic(test.action, test.device)
+		if topic != test.topic {
+			t.Errorf("%s %s: expected %s, got %s", test.action, test.device, test.topic, topic)
+		}
+		action, device := fromTopic(topic)
+		if action != test.action || device != test.device {
+			t.Errorf("%s: decoded to %s %s", topic, action, device)
+		}
+	}
+}
+
+func TestBindings(t *testing.T) {
+	tests := []struct {
+		subAction, subDevice string
+		action, device       string
+		matches              bool
+	}{
+		{"", "", "ping", "", true},
+		{"", "", "ping", "one", true},
+		{"ping", "", "ping", "", true},
+		{"ping", "", "ping/more", "", true},
+		{"ping", "", "pingpong", "", false},
+		{"ping", "", "ping", "one", false},
+		{"ping", "one", "ping", "one", true},
+		{"ping", "one", "ping", "two", false},
+		{"", "one", "anything", "one", true},
+		{"location/+/enter", "", "location/home/enter", "", true},
+		{"location/+/enter", "", "location/home/leave", "", false},
+		{"location/+/enter", "", "location/enter", "", false},
+	}
+
+	for _, test := range tests {
+		binding := getBinding(test.subAction, test.subDevice)
+		key := getTopic(test.action, test.device)
+		if m := matchBinding(strings.Split(binding, "."), topicParts(key)); m != test.matches {
+			t.Errorf("binding %s, key %s: expected %v, got %v", binding, key, test.matches, m)
+		}
+	}
+}
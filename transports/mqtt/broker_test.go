@@ -0,0 +1,196 @@
+// Copyright (C) 2019 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package mqtt
+
+import (
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// testBroker is a minimal in-process MQTT 3.1.1 broker supporting QoS 0
+// and 1, retained messages and wildcard subscriptions.
+type testBroker struct {
+	l net.Listener
+
+	mu       sync.Mutex
+	clients  map[*testClient]struct{}
+	retained map[string]*packets.PublishPacket
+}
+
+type testClient struct {
+	conn   net.Conn
+	mu     sync.Mutex
+	subs   map[string]byte
+	nextId uint16
+}
+
+func newTestBroker(t *testing.T) *testBroker {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &testBroker{
+		l:        l,
+		clients:  make(map[*testClient]struct{}),
+		retained: make(map[string]*packets.PublishPacket),
+	}
+	go b.serve()
+	return b
+}
+
+func (b *testBroker) Address() string {
+	return "tcp://" + b.l.Addr().String()
+}
+
+func (b *testBroker) Close() {
+	b.l.Close()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for c := range b.clients {
+		c.conn.Close()
+	}
+}
+
+func (b *testBroker) serve() {
+	for {
+		conn, err := b.l.Accept()
+		if err != nil {
+			return
+		}
+		c := &testClient{conn: conn, subs: make(map[string]byte)}
+		b.mu.Lock()
+		b.clients[c] = struct{}{}
+		b.mu.Unlock()
+		go b.handle(c)
+	}
+}
+
+func (b *testBroker) handle(c *testClient) {
+	defer func() {
+		b.mu.Lock()
+		delete(b.clients, c)
+		b.mu.Unlock()
+		c.conn.Close()
+	}()
+
+	for {
+		cp, err := packets.ReadPacket(c.conn)
+		if err != nil {
+			return
+		}
+
+		switch p := cp.(type) {
+		case *packets.ConnectPacket:
+			c.write(packets.NewControlPacket(packets.Connack))
+		case *packets.SubscribePacket:
+			ack := packets.NewControlPacket(packets.Suback).(*packets.SubackPacket)
+			ack.MessageID = p.MessageID
+			b.mu.Lock()
+			for i, topic := range p.Topics {
+				c.subs[topic] = p.Qoss[i]
+				ack.ReturnCodes = append(ack.ReturnCodes, p.Qoss[i])
+			}
+			retained := make([]*packets.PublishPacket, 0)
+			for _, r := range b.retained {
+				retained = append(retained, r)
+			}
+			b.mu.Unlock()
+			c.write(ack)
+			for _, r := range retained {
+				c.deliver(r, true)
+			}
+		case *packets.UnsubscribePacket:
+			b.mu.Lock()
+			for _, topic := range p.Topics {
+				delete(c.subs, topic)
+			}
+			b.mu.Unlock()
+			ack := packets.NewControlPacket(packets.Unsuback).(*packets.UnsubackPacket)
+			ack.MessageID = p.MessageID
+			c.write(ack)
+		case *packets.PublishPacket:
+			if p.Qos > 0 {
+				ack := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+				ack.MessageID = p.MessageID
+				c.write(ack)
+			}
+			b.publish(p)
+		case *packets.PingreqPacket:
+			c.write(packets.NewControlPacket(packets.Pingresp))
+		case *packets.DisconnectPacket:
+			return
+		}
+	}
+}
+
+func (b *testBroker) publish(p *packets.PublishPacket) {
+	b.mu.Lock()
+	if p.Retain {
+		if len(p.Payload) == 0 {
+			delete(b.retained, p.TopicName)
+		} else {
+			b.retained[p.TopicName] = p
+		}
+	}
+	clients := make([]*testClient, 0, len(b.clients))
+	for c := range b.clients {
+		clients = append(clients, c)
+	}
+	b.mu.Unlock()
+
+	for _, c := range clients {
+		c.deliver(p, false)
+	}
+}
+
+// Retained returns the retained message of a topic.
+func (b *testBroker) Retained(topic string) (*packets.PublishPacket, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	p, ok := b.retained[topic]
+	return p, ok
+}
+
+func (c *testClient) deliver(p *packets.PublishPacket, retained bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	qos, ok := byte(0), false
+	for filter, q := range c.subs {
+		if _, m := match(filter, p.TopicName); m {
+			if !ok || q > qos {
+				qos = q
+			}
+			ok = true
+		}
+	}
+	if !ok {
+		return
+	}
+	if p.Qos < qos {
+		qos = p.Qos
+	}
+
+	out := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	out.TopicName = p.TopicName
+	out.Payload = p.Payload
+	out.Qos = qos
+	out.Retain = retained
+	if qos > 0 {
+		c.nextId++
+		out.MessageID = c.nextId
+	}
+	out.Write(c.conn)
+}
+
+func (c *testClient) write(p packets.ControlPacket) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p.Write(c.conn)
+}
@@ -0,0 +1,257 @@
+// Copyright (C) 2019 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// Package mqtt bridges sarif messages to and from an MQTT broker.
+package mqtt
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/sarifsystems/sarif/sarif"
+	"github.com/sarifsystems/sarif/transports/sfproto"
+)
+
+const (
+	defaultName    = "mqtt"
+	connectTimeout = 10 * time.Second
+	publishTimeout = 10 * time.Second
+	// echoTimeout is how long a published message is remembered to drop it
+	// when the MQTT broker delivers it back to an inbound rule.
+	echoTimeout = 10 * time.Second
+)
+
+// Config describes a bridge to an MQTT broker.
+type Config struct {
+	// Address of the broker, e.g. tcp://localhost:1883 or ssl://host:8883.
+	Address  string
+	ClientId string
+	Username string
+	Password string
+	// Name is the source of sarif messages received over MQTT. Messages
+	// from this source are never published back to MQTT.
+	Name string
+	// QoS is used for both subscriptions and publishing.
+	QoS byte
+
+	// Inbound rules map MQTT topics to sarif actions.
+	Inbound []Rule
+	// Outbound rules map sarif actions to MQTT topics.
+	Outbound []Rule
+}
+
+// Conn is a connection to an MQTT broker which acts as a sarif connection.
+// It can be served by a sarif broker with ListenOnConn.
+type Conn struct {
+	cfg    Config
+	client paho.Client
+
+	msgs chan sarif.Message
+	errs chan error
+	done chan struct{}
+	once sync.Once
+
+	sentLock sync.Mutex
+	sent     map[string]time.Time
+}
+
+// Dial connects to the MQTT broker and subscribes to all inbound topics.
+func Dial(cfg *Config) (*Conn, error) {
+	if cfg.Address == "" {
+		return nil, errors.New("mqtt: no address specified")
+	}
+	c := &Conn{
+		cfg:  *cfg,
+		msgs: make(chan sarif.Message, len(cfg.Outbound)+32),
+		errs: make(chan error, 1),
+		done: make(chan struct{}),
+		sent: make(map[string]time.Time),
+	}
+	if c.cfg.Name == "" {
+		c.cfg.Name = defaultName
+	}
+	if c.cfg.ClientId == "" {
+		c.cfg.ClientId = "sarif-" + sarif.GenerateId()
+	}
+
+	opts := paho.NewClientOptions().
+		AddBroker(c.cfg.Address).
+		SetClientID(c.cfg.ClientId).
+		SetUsername(c.cfg.Username).
+		SetPassword(c.cfg.Password).
+		SetCleanSession(true).
+		SetAutoReconnect(false).
+		SetConnectTimeout(connectTimeout).
+		SetDefaultPublishHandler(c.receive).
+		SetConnectionLostHandler(func(_ paho.Client, err error) {
+			c.fail(err)
+		})
+	c.client = paho.NewClient(opts)
+	if err := wait(c.client.Connect()); err != nil {
+		return nil, err
+	}
+
+	// Let the broker deliver everything the outbound rules are interested in.
+	for _, r := range c.cfg.Outbound {
+		sub := sfproto.Subscribe(r.subscription(), "")
+		sub.Source = c.cfg.Name
+		c.msgs <- sub
+	}
+
+	for _, r := range c.cfg.Inbound {
+		r = r.inbound()
+		if err := wait(c.client.Subscribe(r.Topic, c.cfg.QoS, nil)); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+func wait(t paho.Token) error {
+	if !t.WaitTimeout(publishTimeout) {
+		return errors.New("mqtt: timeout")
+	}
+	return t.Error()
+}
+
+func (c *Conn) fail(err error) {
+	select {
+	case c.errs <- err:
+	default:
+	}
+}
+
+// receive translates an MQTT message into a sarif message.
+func (c *Conn) receive(_ paho.Client, m paho.Message) {
+	if c.isEcho(m.Topic(), m.Payload()) {
+		return
+	}
+	for _, r := range c.cfg.Inbound {
+		action, ok := r.TopicToAction(m.Topic())
+		if !ok {
+			continue
+		}
+
+		msg := sarif.Message{
+			Version: sarif.VERSION,
+			Id:      sarif.GenerateId(),
+			Action:  action,
+			Source:  c.cfg.Name,
+		}
+		decodeBody(&msg, m.Payload())
+		select {
+		case c.msgs <- msg:
+		case <-c.done:
+		}
+		return
+	}
+}
+
+// decodeBody stores JSON bodies as payload and plain-text bodies as text.
+// JSON strings and scalars are also available as text.
+func decodeBody(msg *sarif.Message, body []byte) {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 {
+		return
+	}
+	if !json.Valid(body) {
+		msg.Text = string(body)
+		return
+	}
+
+	switch body[0] {
+	case '"':
+		json.Unmarshal(body, &msg.Text)
+	case '{', '[':
+		msg.Payload.Raw = body
+	default:
+		msg.Payload.Raw = body
+		msg.Text = string(body)
+	}
+}
+
+// encodeBody publishes the payload as JSON if there is one, the text
+// otherwise.
+func encodeBody(msg sarif.Message) []byte {
+	if raw := bytes.TrimSpace(msg.Payload.Raw); len(raw) > 0 && string(raw) != "null" {
+		return raw
+	}
+	return []byte(msg.Text)
+}
+
+// Read returns the next message received over MQTT.
+func (c *Conn) Read() (sarif.Message, error) {
+	select {
+	case msg := <-c.msgs:
+		return msg, nil
+	case err := <-c.errs:
+		c.Close()
+		return sarif.Message{}, err
+	case <-c.done:
+		return sarif.Message{}, io.EOF
+	}
+}
+
+// Write publishes a sarif message to all topics of matching outbound rules.
+func (c *Conn) Write(msg sarif.Message) error {
+	if msg.Source == c.cfg.Name {
+		return nil
+	}
+
+	for _, r := range c.cfg.Outbound {
+		topic, ok := r.ActionToTopic(msg.Action)
+		if !ok {
+			continue
+		}
+		body := encodeBody(msg)
+		c.remember(topic, body)
+		if err := wait(c.client.Publish(topic, c.cfg.QoS, r.Retain, body)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close disconnects from the MQTT broker.
+func (c *Conn) Close() error {
+	c.once.Do(func() {
+		close(c.done)
+		c.client.Disconnect(250)
+	})
+	return nil
+}
+
+func (c *Conn) remember(topic string, body []byte) {
+	c.sentLock.Lock()
+	defer c.sentLock.Unlock()
+
+	now := time.Now()
+	for k, t := range c.sent {
+		if now.Sub(t) > echoTimeout {
+			delete(c.sent, k)
+		}
+	}
+	c.sent[topic+"\x00"+string(body)] = now
+}
+
+func (c *Conn) isEcho(topic string, body []byte) bool {
+	c.sentLock.Lock()
+	defer c.sentLock.Unlock()
+
+	k := topic + "\x00" + string(body)
+	t, ok := c.sent[k]
+	if !ok {
+		return false
+	}
+	delete(c.sent, k)
+	return time.Since(t) <= echoTimeout
+}
@@ -0,0 +1,211 @@
+// Copyright (C) 2019 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package mqtt
+
+import (
+	"testing"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/sarifsystems/sarif/sarif"
+	"github.com/sarifsystems/sarif/transports/sfproto"
+)
+
+func TestRuleMapping(t *testing.T) {
+	tests := []struct {
+		rule   Rule
+		topic  string
+		action string
+		ok     bool
+	}{
+		{Rule{Topic: "zigbee2mqtt/+/state", Action: "sensor/+/state"}, "zigbee2mqtt/kitchen/state", "sensor/kitchen/state", true},
+		{Rule{Topic: "zigbee2mqtt/+/state", Action: "sensor/+/state"}, "zigbee2mqtt/kitchen/battery", "", false},
+		{Rule{Topic: "home/#", Action: "home/#"}, "home/living/light/set", "home/living/light/set", true},
+		{Rule{Topic: "home/+/temp", Action: "temperature"}, "home/bath/temp", "temperature", true},
+		{Rule{Topic: "a/+/b/+", Action: "x/+/+"}, "a/1/b/2", "x/1/2", true},
+		{Rule{Topic: "plain"}, "plain", "mqtt/plain", true},
+	}
+
+	for _, test := range tests {
+		action, ok := test.rule.TopicToAction(test.topic)
+		if ok != test.ok || action != test.action {
+			t.Errorf("%v: %q -> %q, %v; expected %q, %v", test.rule, test.topic, action, ok, test.action, test.ok)
+		}
+	}
+
+	r := Rule{Action: "notify/+", Topic: "sarif/notify/+/message"}
+	if topic, ok := r.ActionToTopic("notify/phone"); !ok || topic != "sarif/notify/phone/message" {
+		t.Error("unexpected topic", topic, ok)
+	}
+	if _, ok := r.ActionToTopic("notify"); ok {
+		t.Error("should not match parent action")
+	}
+	if sub := r.subscription(); sub != "notify" {
+		t.Error("unexpected subscription", sub)
+	}
+}
+
+func TestBodies(t *testing.T) {
+	var msg sarif.Message
+	decodeBody(&msg, []byte(`{"temperature": 21.5}`))
+	if msg.Payload.String() != `{"temperature": 21.5}` || msg.Text != "" {
+		t.Error("unexpected json decoding:", msg.Payload.String(), msg.Text)
+	}
+
+	msg = sarif.Message{}
+	decodeBody(&msg, []byte("ON"))
+	if msg.Payload.Raw != nil || msg.Text != "ON" {
+		t.Error("unexpected text decoding:", msg.Payload.String(), msg.Text)
+	}
+
+	msg = sarif.Message{}
+	decodeBody(&msg, []byte(`"hello"`))
+	if msg.Text != "hello" {
+		t.Error("unexpected string decoding:", msg.Text)
+	}
+
+	msg = sarif.CreateMessage("test", nil)
+	msg.Text = "only text"
+	if b := encodeBody(msg); string(b) != "only text" {
+		t.Error("unexpected text encoding:", string(b))
+	}
+}
+
+func dialTestClient(t *testing.T, addr string) paho.Client {
+	c := paho.NewClient(paho.NewClientOptions().AddBroker(addr).SetClientID("test"))
+	if err := wait(c.Connect()); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestBridge(t *testing.T) {
+	mb := newTestBroker(t)
+	defer mb.Close()
+
+	b := sfproto.NewBroker()
+	conn, err := Dial(&Config{
+		Address: mb.Address(),
+		QoS:     1,
+		Inbound: []Rule{
+			{Topic: "sensors/+/state", Action: "sensor/+/state"},
+		},
+		Outbound: []Rule{
+			{Action: "light/+/set", Topic: "home/+/light/set", Retain: true},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go b.ListenOnConn(conn)
+
+	received := make(chan sarif.Message, 10)
+	client, err := b.NewClient(sarif.ClientInfo{Name: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Subscribe("sensor", "", func(msg sarif.Message) {
+		received <- msg
+	})
+
+	ext := dialTestClient(t, mb.Address())
+	defer ext.Disconnect(0)
+	lights := make(chan paho.Message, 10)
+	if err := wait(ext.Subscribe("home/#", 1, func(_ paho.Client, m paho.Message) {
+		lights <- m
+	})); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	// MQTT -> sarif
+	if err := wait(ext.Publish("sensors/door/state", 1, false, `{"open": true}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := wait(ext.Publish("sensors/window/state", 1, false, "closed")); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []struct{ action, payload, text string }{
+		{"sensor/door/state", `{"open": true}`, ""},
+		{"sensor/window/state", "", "closed"},
+	} {
+		select {
+		case msg := <-received:
+			if msg.Action != expected.action || msg.Payload.String() != expected.payload || msg.Text != expected.text {
+				t.Errorf("unexpected message %s: %q %q", msg.Action, msg.Payload.String(), msg.Text)
+			}
+			if msg.Source != defaultName {
+				t.Error("unexpected source", msg.Source)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("did not receive", expected.action)
+		}
+	}
+
+	// sarif -> MQTT
+	client.Publish(sarif.CreateMessage("light/kitchen/set", map[string]interface{}{
+		"state": "on",
+	}))
+	client.Publish(sarif.CreateMessage("light/kitchen/other", nil))
+	select {
+	case m := <-lights:
+		if m.Topic() != "home/kitchen/light/set" || string(m.Payload()) != `{"state":"on"}` {
+			t.Errorf("unexpected mqtt message %s: %s", m.Topic(), m.Payload())
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("did not receive mqtt message")
+	}
+	select {
+	case m := <-lights:
+		t.Error("unexpected mqtt message", m.Topic())
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	p, ok := mb.Retained("home/kitchen/light/set")
+	if !ok || p.Qos != 1 {
+		t.Error("expected retained message with QoS 1")
+	}
+}
+
+func TestBridgeLoop(t *testing.T) {
+	mb := newTestBroker(t)
+	defer mb.Close()
+
+	b := sfproto.NewBroker()
+	conn, err := Dial(&Config{
+		Address:  mb.Address(),
+		Inbound:  []Rule{{Topic: "sarif/#", Action: "#"}},
+		Outbound: []Rule{{Action: "#", Topic: "sarif/#"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go b.ListenOnConn(conn)
+
+	received := make(chan sarif.Message, 10)
+	client, err := b.NewClient(sarif.ClientInfo{Name: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Subscribe("ping", "", func(msg sarif.Message) {
+		received <- msg
+	})
+	time.Sleep(50 * time.Millisecond)
+
+	client.Publish(sarif.CreateMessage("ping", nil))
+	select {
+	case <-received:
+	case <-time.After(time.Second):
+		t.Fatal("did not receive own message")
+	}
+	select {
+	case msg := <-received:
+		t.Error("message looped back from mqtt", msg.Source)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
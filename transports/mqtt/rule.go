@@ -0,0 +1,119 @@
+// Copyright (C) 2019 Constantin Schomburg <me@cschomburg.com>
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package mqtt
+
+import "strings"
+
+// Rule maps an MQTT topic pattern to a sarif action pattern. Both patterns
+// may contain the MQTT wildcards "+" (a single level) and "#" (all remaining
+// levels). The levels matched by the wildcards on one side are substituted
+// in order into the wildcards of the other side, so that the rule
+//
+//	Rule{Topic: "zigbee2mqtt/+/state", Action: "sensor/+/state"}
+//
+// maps "zigbee2mqtt/kitchen/state" to "sensor/kitchen/state".
+type Rule struct {
+	Topic  string
+	Action string
+	// Retain publishes outbound messages as retained messages.
+	Retain bool
+}
+
+// inbound returns the rule with the action defaulting to "mqtt/<topic>".
+func (r Rule) inbound() Rule {
+	if r.Action == "" {
+		r.Action = "mqtt/" + r.Topic
+	}
+	return r
+}
+
+// outbound returns the rule with the topic defaulting to the action.
+func (r Rule) outbound() Rule {
+	if r.Topic == "" {
+		r.Topic = r.Action
+	}
+	return r
+}
+
+// TopicToAction translates an MQTT topic into a sarif action.
+func (r Rule) TopicToAction(topic string) (string, bool) {
+	r = r.inbound()
+	vals, ok := match(r.Topic, topic)
+	if !ok {
+		return "", false
+	}
+	return fill(r.Action, vals), true
+}
+
+// ActionToTopic translates a sarif action into an MQTT topic.
+func (r Rule) ActionToTopic(action string) (string, bool) {
+	r = r.outbound()
+	vals, ok := match(r.Action, action)
+	if !ok {
+		return "", false
+	}
+	return fill(r.Topic, vals), true
+}
+
+// subscription returns the action prefix the broker needs to deliver to
+// match the outbound rule.
+func (r Rule) subscription() string {
+	r = r.outbound()
+	prefix := make([]string, 0)
+	for _, p := range strings.Split(r.Action, "/") {
+		if p == "+" || p == "#" {
+			break
+		}
+		prefix = append(prefix, p)
+	}
+	return strings.Join(prefix, "/")
+}
+
+// match matches a name against a pattern and returns the values of all
+// wildcards.
+func match(pattern, name string) ([]string, bool) {
+	pp := strings.Split(pattern, "/")
+	np := strings.Split(name, "/")
+	vals := make([]string, 0)
+	for i, p := range pp {
+		if p == "#" {
+			if i < len(np) {
+				vals = append(vals, strings.Join(np[i:], "/"))
+			} else {
+				vals = append(vals, "")
+			}
+			return vals, true
+		}
+		if i >= len(np) {
+			return nil, false
+		}
+		if p == "+" {
+			vals = append(vals, np[i])
+		} else if p != np[i] {
+			return nil, false
+		}
+	}
+	return vals, len(pp) == len(np)
+}
+
+// fill substitutes the wildcards of a pattern with the values in order.
+// Surplus wildcards are dropped.
+func fill(pattern string, vals []string) string {
+	parts := make([]string, 0)
+	for _, p := range strings.Split(pattern, "/") {
+		if p == "+" || p == "#" {
+			if len(vals) == 0 {
+				continue
+			}
+			p, vals = vals[0], vals[1:]
+			if p == "" {
+				continue
+			}
+		}
+		parts = append(parts, p)
+	}
+	return strings.Join(parts, "/")
+}
@@ -6,9 +6,11 @@
 package sfproto
 
 import (
+	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"io"
+	"strings"
 	"sync"
 	"time"
 
@@ -19,6 +21,16 @@ type canVerify interface {
 	IsVerified() bool
 }
 
+type hasPeerCertificate interface {
+	PeerCertificate() *x509.Certificate
+}
+
+// CertificateSerial formats the serial number of a certificate as used in
+// revocation lists.
+func CertificateSerial(cert *x509.Certificate) string {
+	return cert.SerialNumber.Text(16)
+}
+
 // Broker dispatches messages to connections based on their subscriptions.
 type Broker struct {
 	subs          *subTree
@@ -27,8 +39,11 @@ type Broker struct {
 	dupIndex      int
 	Log           Logger
 	trace         bool
-	halfOpenConns map[string]chan bool
+	halfOpenConns map[string]chan []string
 	clients       map[string]*sarif.ClientInfo
+	revoked       map[string]struct{}
+	certConns     map[*brokerConn]struct{}
+	revokedLock   sync.RWMutex
 }
 
 // NewBroker returns a new broker that dispatches messages.
@@ -40,8 +55,10 @@ func NewBroker() *Broker {
 		dupIndex:      0,
 		Log:           defaultLog,
 		trace:         false,
-		halfOpenConns: make(map[string]chan bool),
+		halfOpenConns: make(map[string]chan []string),
 		clients:       make(map[string]*sarif.ClientInfo),
+		revoked:       make(map[string]struct{}),
+		certConns:     make(map[*brokerConn]struct{}),
 	}
 }
 
@@ -86,10 +103,10 @@ func (b *Broker) checkDuplicate(id string) bool {
 
 func (b *Broker) newConn(c Conn) *brokerConn {
 	return &brokerConn{
-		c,
-		b,
-		make(map[string]struct{}, 0),
-		make(chan error),
+		Conn:   c,
+		broker: b,
+		subs:   make(map[string]struct{}, 0),
+		errs:   make(chan error),
 	}
 }
 
@@ -97,7 +114,35 @@ func (b *Broker) newConn(c Conn) *brokerConn {
 // sends outgoing messages based on its subscriptions. The call blocks until
 // an error is received, for example when the connection is closed.
 func (b *Broker) ListenOnConn(conn Conn) error {
+	return b.listenOnScopedConn(conn, "", "", nil)
+}
+
+// listenOnScopedConn listens on a connection that may only publish actions
+// within the given scopes. Empty scopes allow everything. If device is set,
+// the connection may only publish messages of that device and its clients.
+// Connections with a certificate serial are closed once it is revoked.
+func (b *Broker) listenOnScopedConn(conn Conn, device, serial string, scopes []string) error {
 	c := b.newConn(conn)
+	c.device = device
+	c.serial = serial
+	c.scopes = scopes
+	if serial != "" {
+		b.revokedLock.Lock()
+		_, revoked := b.revoked[serial]
+		if !revoked {
+			b.certConns[c] = struct{}{}
+		}
+		b.revokedLock.Unlock()
+		if revoked {
+			conn.Close()
+			return errors.New("Authentication failed: certificate of " + device + " is revoked")
+		}
+		defer func() {
+			b.revokedLock.Lock()
+			delete(b.certConns, c)
+			b.revokedLock.Unlock()
+		}()
+	}
 	go c.ListenLoop()
 	err := <-c.errs
 	c.Close()
@@ -188,14 +233,61 @@ func (b *Broker) Listen(cfg *NetConfig) error {
 	}
 }
 
+// SetRevokedCertificates replaces the serial numbers of client
+// certificates that are no longer accepted and closes their connections.
+func (b *Broker) SetRevokedCertificates(serials []string) {
+	revoked := make(map[string]struct{}, len(serials))
+	for _, serial := range serials {
+		revoked[serial] = struct{}{}
+	}
+	closing := make([]*brokerConn, 0)
+	b.revokedLock.Lock()
+	b.revoked = revoked
+	for c := range b.certConns {
+		if _, ok := revoked[c.serial]; ok {
+			delete(b.certConns, c)
+			closing = append(closing, c)
+		}
+	}
+	b.revokedLock.Unlock()
+
+	for _, c := range closing {
+		b.Log.Warnf("[broker] closing connection of %s, certificate %s is revoked", c.device, c.serial)
+		c.Conn.Close()
+	}
+}
+
+func (b *Broker) isRevoked(cert *x509.Certificate) bool {
+	if cert == nil {
+		return false
+	}
+	b.revokedLock.RLock()
+	defer b.revokedLock.RUnlock()
+	_, ok := b.revoked[CertificateSerial(cert)]
+	return ok
+}
+
 func (b *Broker) AuthenticateAndListenOnConn(auth AuthType, c Conn) error {
 	authed := false
+	var device, serial string
+	var scopes []string
 	if auth == AuthNone {
 		authed = true
 	} else {
 		if v, ok := c.(canVerify); ok {
 			authed = v.IsVerified()
 		}
+		if v, ok := c.(hasPeerCertificate); ok && authed {
+			if cert := v.PeerCertificate(); cert != nil {
+				if b.isRevoked(cert) {
+					c.Close()
+					return errors.New("Authentication failed: certificate of " + cert.Subject.CommonName + " is revoked")
+				}
+				device = cert.Subject.CommonName
+				serial = CertificateSerial(cert)
+				b.Log.Infof("[broker] %s authenticated by certificate", device)
+			}
+		}
 	}
 
 	if !authed && auth != AuthCertificate {
@@ -215,13 +307,18 @@ func (b *Broker) AuthenticateAndListenOnConn(auth AuthType, c Conn) error {
 		msg.EncodePayload(ci)
 		msg.Id = sarif.GenerateId()
 
-		confirm := make(chan bool, 1)
+		confirm := make(chan []string, 1)
 		b.halfOpenConns[msg.Id] = confirm
 		b.publish(msg)
 
 		select {
-		case <-confirm:
+		case scopes = <-confirm:
 			authed = true
+			// Scoped clients may not publish in the name of others, so
+			// that services can tell them apart from trusted clients.
+			if len(scopes) > 0 {
+				device = ci.Name
+			}
 		case <-time.After(time.Minute):
 		}
 		delete(b.halfOpenConns, msg.Id)
@@ -232,7 +329,7 @@ func (b *Broker) AuthenticateAndListenOnConn(auth AuthType, c Conn) error {
 		return errors.New("Authentication failed")
 	}
 
-	return b.ListenOnConn(c)
+	return b.listenOnScopedConn(c, device, serial, scopes)
 }
 
 // Publish publishes a message to all client connections that are subscribed
@@ -266,6 +363,9 @@ type brokerConn struct {
 	broker *Broker
 	subs   map[string]struct{}
 	errs   chan error
+	device string
+	serial string
+	scopes []string
 }
 
 func (c *brokerConn) Write(msg sarif.Message) error {
@@ -298,10 +398,45 @@ func (c *brokerConn) ListenLoop() {
 			c.errs <- err
 			return
 		}
+		if !c.isOwnSource(msg) {
+			c.broker.Log.Warnf("[broker] %s is not allowed to publish as %s", c.device, msg.Source)
+			continue
+		}
+		if !c.isAllowed(msg) {
+			c.broker.Log.Warnf("[broker] %s is not allowed to publish %s", msg.Source, msg.Action)
+			continue
+		}
 		c.Publish(msg)
 	}
 }
 
+// isAllowed checks a message against the scopes of the connection. Protocol
+// messages, logs and acknowledgements are always allowed, except for
+// authenticating other connections.
+func (c *brokerConn) isAllowed(msg sarif.Message) bool {
+	if len(c.scopes) == 0 {
+		return true
+	}
+	if msg.IsAction("proto/allow") {
+		return false
+	}
+	if msg.IsAction("proto") || msg.IsAction("log") || msg.IsAction("ack") {
+		return true
+	}
+	for _, scope := range c.scopes {
+		if msg.IsAction(scope) {
+			return true
+		}
+	}
+	return false
+}
+
+// isOwnSource checks that a connection authenticated by certificate only
+// publishes messages of its device or its clients, e.g. "laptop/tars/1".
+func (c *brokerConn) isOwnSource(msg sarif.Message) bool {
+	return c.device == "" || msg.Source == c.device || strings.HasPrefix(msg.Source, c.device+"/")
+}
+
 func (c *brokerConn) Subscribe(topic string) {
 	c.broker.subsLock.Lock()
 	defer c.broker.subsLock.Unlock()
@@ -352,7 +487,11 @@ func (c *brokerConn) Publish(msg sarif.Message) {
 		return
 	case msg.IsAction("proto/allow"):
 		if ch, ok := c.broker.halfOpenConns[msg.CorrId]; ok {
-			ch <- true
+			var allow struct {
+				Scopes []string `json:"scopes"`
+			}
+			msg.DecodePayload(&allow)
+			ch <- allow.Scopes
 		}
 	case msg.IsAction("log"):
 		if msg.IsAction("log/err") {
@@ -6,6 +6,11 @@
 package sfproto
 
 import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -299,3 +304,284 @@ func BenchmarkBrokerSingleNet(b *testing.B) {
 		}
 	}
 }
+
+func TestBrokerConnScopes(t *testing.T) {
+	unscoped := &brokerConn{}
+	scoped := &brokerConn{scopes: []string{"location"}}
+
+	tests := []struct {
+		action   string
+		unscoped bool
+		scoped   bool
+	}{
+		{"location/update", true, true},
+		{"locations", true, false},
+		{"mail/send", true, false},
+		{"proto/sub", true, true},
+		{"ack", true, true},
+		{"log/info", true, true},
+		{"proto/allow", true, false},
+	}
+	for _, test := range tests {
+		msg := sarif.Message{Action: test.action}
+		if unscoped.isAllowed(msg) != test.unscoped {
+			t.Error("unscoped", test.action, "expected", test.unscoped)
+		}
+		if scoped.isAllowed(msg) != test.scoped {
+			t.Error("scoped", test.action, "expected", test.scoped)
+		}
+	}
+}
+
+func TestBrokerScopedSource(t *testing.T) {
+	b := NewBroker()
+	auth, err := b.NewClient(sarif.ClientInfo{Name: "auth"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth.Subscribe("proto/hi", "", func(msg sarif.Message) {
+		auth.Reply(msg, sarif.CreateMessage("proto/allow", map[string][]string{
+			"scopes": {"location"},
+		}))
+	})
+	watcher, err := b.NewClient(sarif.ClientInfo{Name: "watcher"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sources := make(chan string, 3)
+	watcher.Subscribe("location", "", func(msg sarif.Message) {
+		sources <- msg.Source
+	})
+	time.Sleep(10 * time.Millisecond)
+
+	one, two := NewPipe()
+	go b.AuthenticateAndListenOnConn(AuthChallenge, one)
+	hi := sarif.CreateMessage("proto/hi", sarif.ClientInfo{Auth: "token"})
+	hi.Source = "widget"
+	two.Write(hi)
+	time.Sleep(10 * time.Millisecond)
+
+	for _, source := range []string{"other", "widget/sub", "widget"} {
+		msg := sarif.CreateMessage("location/update", nil)
+		msg.Source = source
+		two.Write(msg)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case source := <-sources:
+			if source != "widget" && source != "widget/sub" {
+				t.Error("scoped client published as", source)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("expected messages of scoped client")
+		}
+	}
+	select {
+	case source := <-sources:
+		t.Error("scoped client published as", source)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+type certConn struct {
+	Conn
+	cert *x509.Certificate
+}
+
+func (c certConn) IsVerified() bool                   { return true }
+func (c certConn) PeerCertificate() *x509.Certificate { return c.cert }
+
+func TestBrokerClosesRevokedConns(t *testing.T) {
+	b := NewBroker()
+	laptop := &x509.Certificate{SerialNumber: big.NewInt(42), Subject: pkix.Name{CommonName: "laptop"}}
+	phone := &x509.Certificate{SerialNumber: big.NewInt(43), Subject: pkix.Name{CommonName: "phone"}}
+
+	errs := make(map[string]chan error)
+	for _, cert := range []*x509.Certificate{laptop, phone} {
+		one, _ := NewPipe()
+		ch := make(chan error, 1)
+		errs[cert.Subject.CommonName] = ch
+		go func(cert *x509.Certificate) {
+			ch <- b.AuthenticateAndListenOnConn(AuthCertificate, certConn{one, cert})
+		}(cert)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	b.SetRevokedCertificates([]string{CertificateSerial(laptop)})
+	select {
+	case <-errs["laptop"]:
+	case <-time.After(time.Second):
+		t.Fatal("expected connection of revoked certificate to be closed")
+	}
+	select {
+	case err := <-errs["phone"]:
+		t.Error("expected other connection to stay open, got", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	one, _ := NewPipe()
+	if err := b.AuthenticateAndListenOnConn(AuthCertificate, certConn{one, laptop}); err == nil {
+		t.Error("expected revoked certificate to be rejected")
+	}
+}
+
+type recordingConn struct {
+	Conn
+	mu   sync.Mutex
+	msgs []sarif.Message
+}
+
+func (c *recordingConn) record(msg sarif.Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.msgs = append(c.msgs, msg)
+}
+
+func (c *recordingConn) Write(msg sarif.Message) error {
+	c.record(msg)
+	return c.Conn.Write(msg)
+}
+
+func (c *recordingConn) Read() (sarif.Message, error) {
+	msg, err := c.Conn.Read()
+	c.record(msg)
+	return msg, err
+}
+
+func TestBrokerBridgeSealed(t *testing.T) {
+	home, vps := NewBroker(), NewBroker()
+	bridge := &recordingConn{Conn: home.NewLocalConn()}
+	go vps.ListenOnBridge(bridge)
+
+	homeClient, err := home.NewClient(sarif.ClientInfo{Name: "home"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	phoneClient, err := vps.NewClient(sarif.ClientInfo{Name: "phone"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	homeClient.Subscribe("diary/add", "", func(msg sarif.Message) {
+		reply := sarif.CreateMessage("diary/added", nil)
+		reply.Text = "stored " + msg.Text
+		homeClient.Reply(msg, reply)
+	})
+	enableTrustedEncryption(t, homeClient, phoneClient)
+	time.Sleep(50 * time.Millisecond)
+
+	msg := sarif.CreateMessage("diary/add", nil)
+	msg.Destination = "home"
+	msg.Text = "dear diary"
+	select {
+	case reply := <-phoneClient.Request(msg):
+		if reply.Text != "stored dear diary" {
+			t.Errorf("unexpected reply: %+v", reply)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no reply received")
+	}
+
+	bridge.mu.Lock()
+	defer bridge.mu.Unlock()
+	sealed := make(map[string]bool)
+	for _, msg := range bridge.msgs {
+		if strings.Contains(msg.Text, "diary") || strings.Contains(msg.Payload.String(), "diary") {
+			t.Error("bridge has seen plaintext:", msg)
+		}
+		if msg.IsSealed() {
+			sealed[msg.Action] = true
+		}
+	}
+	if !sealed["diary/add"] || !sealed["diary/added"] {
+		t.Errorf("expected request and reply to be sealed, got %v", sealed)
+	}
+}
+
+// enableTrustedEncryption generates keys for the clients and pins them
+// among each other.
+func enableTrustedEncryption(t *testing.T, clients ...sarif.Client) []sarif.KeyPair {
+	keys := make([]sarif.KeyPair, len(clients))
+	for i := range clients {
+		var err error
+		if keys[i], err = sarif.GenerateKeyPair(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, c := range clients {
+		for j, other := range clients {
+			if i != j {
+				c.TrustKey(other.DeviceId(), keys[j].Public)
+			}
+		}
+		if err := c.EnableEncryption(keys[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return keys
+}
+
+func TestBrokerUntrustedKeys(t *testing.T) {
+	home, vps := NewBroker(), NewBroker()
+	bridge := &recordingConn{Conn: home.NewLocalConn()}
+	go vps.ListenOnBridge(bridge)
+
+	homeClient, err := home.NewClient(sarif.ClientInfo{Name: "home"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	phoneClient, err := vps.NewClient(sarif.ClientInfo{Name: "phone"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mallory, err := vps.NewClient(sarif.ClientInfo{Name: "mallory"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	enableTrustedEncryption(t, homeClient, phoneClient)
+
+	// Announce keys for other devices and an unpinned own key
+	fake, err := sarif.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mallory.Publish(sarif.CreateMessage("proto/keys", sarif.KeysPayload{Keys: map[string]string{
+		"home":    fake.PublicString(),
+		"mallory": fake.PublicString(),
+	}}))
+	spoofed := sarif.CreateMessage("proto/keys", sarif.KeysPayload{Keys: map[string]string{
+		"home": fake.PublicString(),
+	}})
+	spoofed.Source = "home"
+	mallory.Publish(spoofed)
+	time.Sleep(50 * time.Millisecond)
+
+	received := make(chan sarif.Message, 2)
+	homeClient.Subscribe("diary/add", "", func(msg sarif.Message) { received <- msg })
+	mallory.Subscribe("diary/add", "", func(msg sarif.Message) { received <- msg })
+	time.Sleep(10 * time.Millisecond)
+
+	for _, dest := range []string{"home", "mallory"} {
+		msg := sarif.CreateMessage("diary/add", nil)
+		msg.Destination = dest
+		msg.Text = "dear diary"
+		if err := phoneClient.Publish(msg); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case got := <-received:
+			if got.Text != "dear diary" {
+				t.Errorf("%s could not read message: %+v", dest, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("no message received by", dest)
+		}
+	}
+
+	bridge.mu.Lock()
+	defer bridge.mu.Unlock()
+	for _, msg := range bridge.msgs {
+		if msg.IsAction("diary/add") && msg.Destination == "home" && !msg.IsSealed() {
+			t.Error("expected message to home to be sealed with pinned key:", msg)
+		}
+	}
+}
@@ -91,3 +91,83 @@ func TestClientRequest(t *testing.T) {
 		t.Fatal("expected no response, got", msg)
 	}
 }
+
+func TestClientReconnect(t *testing.T) {
+	broker := NewBroker()
+	client := sarif.NewClient(sarif.ClientInfo{Name: "a"})
+	if err := client.Connect(wrap(broker.NewLocalConn())); err != nil {
+		t.Fatal(err)
+	}
+	other, err := broker.NewClient(sarif.ClientInfo{Name: "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.SetRequestTimeout(100 * time.Millisecond)
+
+	// Connecting again must not register the default handlers twice
+	client.Disconnect()
+	if err := client.Connect(wrap(broker.NewLocalConn())); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	n := 0
+	for range other.Request(sarif.Message{Action: "ping", Destination: "a"}) {
+		n++
+	}
+	if n != 1 {
+		t.Errorf("expected one ack, got %d", n)
+	}
+	n = 0
+	for range other.Request(sarif.Message{Action: "proto/discover", Destination: "a"}) {
+		n++
+	}
+	if n != 1 {
+		t.Errorf("expected one discovery reply, got %d", n)
+	}
+}
+
+func TestClientUnsubscribe(t *testing.T) {
+	broker := NewBroker()
+	client, err := broker.NewClient(sarif.ClientInfo{Name: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := broker.NewClient(sarif.ClientInfo{Name: "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fired := make(chan sarif.Message, 10)
+	client.Subscribe("event", "", func(msg sarif.Message) {
+		fired <- msg
+	})
+	other.Publish(sarif.CreateMessage("event/new", nil))
+	select {
+	case <-fired:
+	case <-time.After(time.Second):
+		t.Fatal("subscription did not fire")
+	}
+
+	if err := client.Unsubscribe("event", ""); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	other.Publish(sarif.CreateMessage("event/new", nil))
+	directed := sarif.CreateMessage("event/new", nil)
+	directed.Destination = "a"
+	other.Publish(directed)
+	select {
+	case msg := <-fired:
+		t.Error("subscription fired after unsubscribe", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	broker.subsLock.RLock()
+	n := 0
+	broker.subs.Call(topicParts(getTopic("event/new", "")), false, func(writer) { n++ })
+	broker.subsLock.RUnlock()
+	if n != 0 {
+		t.Errorf("expected broker subscription to be removed, got %d", n)
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"io/ioutil"
 	"net"
 	"net/url"
+	"os"
 	"strings"
 	"time"
 
@@ -36,8 +37,12 @@ type NetConfig struct {
 	Certificate string
 	Key         string
 	Authority   string
-	Tls         *tls.Config `json:"-"`
-	Keepalive   int         `json:",omitempty"`
+	// ClientAuthority is an additional authority that signs client
+	// certificates, e.g. the one of the auth service. It is read again for
+	// each connection, since it may be created after the listener.
+	ClientAuthority string      `json:",omitempty"`
+	Tls             *tls.Config `json:"-"`
+	Keepalive       int         `json:",omitempty"`
 }
 
 func (cfg *NetConfig) loadTlsCertificates(u *url.URL) error {
@@ -62,6 +67,7 @@ func (cfg *NetConfig) loadTlsCertificates(u *url.URL) error {
 		cfg.Tls.Certificates = []tls.Certificate{cert}
 	}
 
+	var authority []byte
 	if cfg.Authority != "" {
 		roots := x509.NewCertPool()
 		cert, err := ioutil.ReadFile(cfg.Authority)
@@ -71,11 +77,38 @@ func (cfg *NetConfig) loadTlsCertificates(u *url.URL) error {
 		roots.AppendCertsFromPEM(cert)
 		cfg.Tls.RootCAs = roots
 		cfg.Tls.ClientCAs = roots
+		authority = cert
+	}
+
+	if cfg.ClientAuthority != "" {
+		cfg.Tls.GetConfigForClient = clientAuthorityLoader(cfg.Tls, authority, cfg.ClientAuthority)
 	}
 
 	return nil
 }
 
+// clientAuthorityLoader returns a TLS callback that trusts client
+// certificates of the authority in path in addition to the given one.
+func clientAuthorityLoader(base *tls.Config, authority []byte, path string) func(*tls.ClientHelloInfo) (*tls.Config, error) {
+	return func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		cert, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		roots := x509.NewCertPool()
+		roots.AppendCertsFromPEM(authority)
+		roots.AppendCertsFromPEM(cert)
+
+		cfg := base.Clone()
+		cfg.GetConfigForClient = nil
+		cfg.ClientCAs = roots
+		return cfg, nil
+	}
+}
+
 func (cfg *NetConfig) parseUrl() (*url.URL, error) {
 	u, err := url.Parse(cfg.Address)
 	if err != nil {
@@ -7,6 +7,7 @@ package sfproto
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"net"
 	"time"
@@ -70,3 +71,13 @@ func (c *netConn) IsVerified() bool {
 
 	return false
 }
+
+// PeerCertificate returns the verified client certificate, if any.
+func (c *netConn) PeerCertificate() *x509.Certificate {
+	if tc, ok := c.conn.(*tls.Conn); ok {
+		if chains := tc.ConnectionState().VerifiedChains; len(chains) > 0 {
+			return chains[0][0]
+		}
+	}
+	return nil
+}
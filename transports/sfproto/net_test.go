@@ -6,7 +6,20 @@
 package sfproto
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
 	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -75,3 +88,230 @@ func TestNet(t *testing.T) {
 		t.Fatal("no message received")
 	}
 }
+
+func newTestCertificate(t *testing.T, tmpl, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (tls.Certificate, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+	tmpl.NotBefore = time.Now().Add(-time.Hour)
+	tmpl.NotAfter = time.Now().Add(time.Hour)
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}, cert
+}
+
+func newTestAuthority(t *testing.T, name string) (*x509.Certificate, *ecdsa.PrivateKey) {
+	ca, caCert := newTestCertificate(t, &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}, nil, nil)
+	return caCert, ca.PrivateKey.(*ecdsa.PrivateKey)
+}
+
+func TestNetCertificateRevocation(t *testing.T) {
+	// The server certificate and the enrolled client certificates are
+	// signed by different authorities, as with the auth service.
+	serverCA, serverKey := newTestAuthority(t, "server root")
+	clientCA, clientKey := newTestAuthority(t, "sarif root")
+	server, _ := newTestCertificate(t, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "broker"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, serverCA, serverKey)
+	device, deviceCert := newTestCertificate(t, &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "laptop"},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, clientCA, clientKey)
+	serverRoots := x509.NewCertPool()
+	serverRoots.AddCert(serverCA)
+	clientRoots := x509.NewCertPool()
+	clientRoots.AddCert(clientCA)
+
+	l, err := Listen(&NetConfig{
+		Address: "tcp+tls://127.0.0.1:0",
+		Tls: &tls.Config{
+			Certificates: []tls.Certificate{server},
+			ClientAuth:   tls.VerifyClientCertIfGiven,
+			ClientCAs:    clientRoots,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	b := NewBroker()
+	errs := make(chan error, 1)
+	dial := func() Conn {
+		go func() {
+			conn, err := l.Accept()
+			if err != nil {
+				errs <- err
+				return
+			}
+			errs <- b.AuthenticateAndListenOnConn(AuthCertificate, conn)
+		}()
+		conn, err := RawDial(&NetConfig{
+			Address: "tcp+tls://" + l.Addr().String(),
+			Tls: &tls.Config{
+				Certificates: []tls.Certificate{device},
+				RootCAs:      serverRoots,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn
+	}
+
+	b.SetRevokedCertificates([]string{CertificateSerial(deviceCert)})
+	conn := dial()
+	select {
+	case err := <-errs:
+		if err == nil || !strings.Contains(err.Error(), "revoked") {
+			t.Error("expected revoked certificate to be rejected, got", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("revoked certificate was accepted")
+	}
+	conn.Close()
+
+	b.SetRevokedCertificates(nil)
+	recv, err := b.NewClient(sarif.ClientInfo{Name: "recv"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pings := make(chan sarif.Message, 1)
+	recv.Subscribe("ping", "", func(msg sarif.Message) { pings <- msg })
+	time.Sleep(10 * time.Millisecond)
+
+	conn = dial()
+	defer conn.Close()
+	for _, source := range []string{"desktop", "laptopx/tars", "laptop/tars/1"} {
+		if err := conn.Write(sarif.Message{
+			Version: sarif.VERSION,
+			Id:      sarif.GenerateId(),
+			Action:  "ping",
+			Source:  source,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case msg := <-pings:
+		if msg.Source != "laptop/tars/1" {
+			t.Error("expected message of other device to be dropped, got", msg.Source)
+		}
+	case err := <-errs:
+		t.Fatal("connection closed:", err)
+	case <-time.After(time.Second):
+		t.Fatal("no message received")
+	}
+
+	if b.isRevoked(nil) {
+		t.Error("expected missing certificate not to be revoked")
+	}
+}
+
+func writePem(t *testing.T, path, typ string, der []byte) {
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNetClientAuthority(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sarif-net-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	serverCA, serverKey := newTestAuthority(t, "server root")
+	clientCA, clientKey := newTestAuthority(t, "sarif root")
+	server, _ := newTestCertificate(t, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "broker"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, serverCA, serverKey)
+	device, _ := newTestCertificate(t, &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "laptop"},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, clientCA, clientKey)
+	keyDer, err := x509.MarshalECPrivateKey(server.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePem(t, filepath.Join(dir, "server.crt"), "CERTIFICATE", server.Certificate[0])
+	writePem(t, filepath.Join(dir, "server.key"), "EC PRIVATE KEY", keyDer)
+	writePem(t, filepath.Join(dir, "server-ca.crt"), "CERTIFICATE", serverCA.Raw)
+	serverRoots := x509.NewCertPool()
+	serverRoots.AddCert(serverCA)
+
+	// The client authority does not exist yet when listening
+	l, err := Listen(&NetConfig{
+		Address:         "tcp+tls://127.0.0.1:0",
+		Certificate:     filepath.Join(dir, "server.crt"),
+		Key:             filepath.Join(dir, "server.key"),
+		Authority:       filepath.Join(dir, "server-ca.crt"),
+		ClientAuthority: filepath.Join(dir, "ca.crt"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	verified := func() bool {
+		accepted := make(chan bool, 1)
+		go func() {
+			conn, err := l.Accept()
+			if err != nil {
+				accepted <- false
+				return
+			}
+			defer conn.Close()
+			accepted <- conn.(canVerify).IsVerified()
+		}()
+		conn, err := RawDial(&NetConfig{
+			Address: "tcp+tls://" + l.Addr().String(),
+			Tls: &tls.Config{
+				Certificates: []tls.Certificate{device},
+				RootCAs:      serverRoots,
+			},
+		})
+		if err == nil {
+			defer conn.Close()
+		}
+		select {
+		case ok := <-accepted:
+			return ok
+		case <-time.After(time.Second):
+			t.Fatal("connection was not accepted")
+		}
+		return false
+	}
+
+	if verified() {
+		t.Error("expected unknown client authority to be rejected")
+	}
+	writePem(t, filepath.Join(dir, "ca.crt"), "CERTIFICATE", clientCA.Raw)
+	if !verified() {
+		t.Error("expected client certificate to be verified")
+	}
+}